@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/options"
+)
+
+var (
+	errAdminKeysProvider  = ierrors.New(409, "Keys are managed by the keys provider", "Keys are managed by the keys provider")
+	errAdminKeySources    = ierrors.New(409, "Keys can't be changed when IMGPROXY_KEY_SOURCES is set", "Keys can't be changed when IMGPROXY_KEY_SOURCES is set")
+	errAdminLastKey       = ierrors.New(409, "Can't remove the last key/salt pair", "Can't remove the last key/salt pair")
+	errAdminInsecure      = ierrors.New(409, "Signature checking is disabled", "Signature checking is disabled")
+	errAdminNotFound      = ierrors.New(404, "Not found", "Not found")
+	errAdminNoPresetsFile = ierrors.New(409, "Presets can't be changed when IMGPROXY_PRESETS_PATH is not set", "Presets can't be changed when IMGPROXY_PRESETS_PATH is not set")
+	errAdminEnvPreset     = ierrors.New(409, "Presets defined in IMGPROXY_PRESETS can't be removed", "Presets defined in IMGPROXY_PRESETS can't be removed")
+
+	adminPresetNameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// adminMutex serializes changes made through the admin API
+var adminMutex sync.Mutex
+
+type adminKeyPair struct {
+	Key  string `json:"key"`
+	Salt string `json:"salt"`
+}
+
+func startAdminServer(cancel context.CancelFunc) (*http.Server, error) {
+	r := newRouter("")
+
+	r.PanicHandler = handleAdminPanic
+
+	r.GET("/presets", withAdminSecret(handleAdminListPresets), true)
+	r.PUT("/presets/", withAdminSecret(handleAdminSetPreset), false)
+	r.DELETE("/presets/", withAdminSecret(handleAdminDeletePreset), false)
+	r.GET("/keys", withAdminSecret(handleAdminListKeys), true)
+	r.POST("/keys", withAdminSecret(handleAdminAddKey), true)
+	r.DELETE("/keys/", withAdminSecret(handleAdminDeleteKey), false)
+	r.GET("/usage", withAdminSecret(handleAdminGetUsage), true)
+	r.DELETE("/usage", withAdminSecret(handleAdminResetUsage), true)
+
+	s := &http.Server{
+		Handler:     r,
+		ReadTimeout: time.Duration(conf.ReadTimeout) * time.Second,
+	}
+
+	var err error
+
+	if isTLSEnabled() {
+		if s.TLSConfig, err = newTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := listen("admin", conf.Network, conf.AdminBind)
+	if err != nil {
+		return nil, fmt.Errorf("Can't start admin server: %s", err)
+	}
+
+	go func() {
+		var err error
+
+		logNotice("Starting admin server at %s", conf.AdminBind)
+
+		if isTLSEnabled() {
+			err = s.ServeTLS(l, "", "")
+		} else {
+			err = s.Serve(l)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			logError(err.Error())
+		}
+		cancel()
+	}()
+
+	return s, nil
+}
+
+// handleAdminPanic responds with the error message.
+// Custom error responses are not used for the admin API
+func handleAdminPanic(reqID string, rw http.ResponseWriter, r *http.Request, err error) {
+	respondWithErrorMessage(rw, handleError(reqID, r, err))
+}
+
+func withAdminSecret(h routeHandler) routeHandler {
+	authHeader := []byte(fmt.Sprintf("Bearer %s", conf.AdminSecret))
+
+	return func(reqID string, rw http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), authHeader) == 1 {
+			h(reqID, rw, r)
+		} else {
+			panic(errInvalidSecret)
+		}
+	}
+}
+
+func respondWithAdminJSON(reqID string, rw http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		panic(err)
+	}
+
+	logResponse(reqID, r, status, nil, nil, nil)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+func handleAdminListPresets(reqID string, rw http.ResponseWriter, r *http.Request) {
+	p := options.AllPresets()
+
+	res := make(map[string]string, len(p))
+	for name, opts := range p {
+		res[name] = options.FormatPreset(opts)
+	}
+
+	respondWithAdminJSON(reqID, rw, r, 200, res)
+}
+
+// handleAdminSetPreset adds or replaces the preset. The request body
+// should contain the preset value, e.g. resize:fit:300:300/quality:80
+func handleAdminSetPreset(reqID string, rw http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/presets/")
+
+	if !adminPresetNameRe.MatchString(name) {
+		panic(ierrors.New(422, fmt.Sprintf("Invalid preset name: %s", name), "Invalid preset name"))
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, 1<<20))
+	if err != nil {
+		panic(ierrors.New(400, fmt.Sprintf("Can't read request body: %s", err), "Invalid request body"))
+	}
+
+	p := make(options.Presets)
+
+	if err = options.ParsePreset(p, fmt.Sprintf("%s=%s", name, body)); err != nil {
+		panic(ierrors.New(422, err.Error(), err.Error()))
+	}
+	if err = options.CheckPresets(p); err != nil {
+		panic(ierrors.New(422, err.Error(), err.Error()))
+	}
+
+	updatePresets(func(all options.Presets) {
+		all[name] = p[name]
+	})
+
+	respondWithAdminJSON(reqID, rw, r, 200, map[string]string{name: options.FormatPreset(p[name])})
+}
+
+func handleAdminDeletePreset(reqID string, rw http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/presets/")
+
+	if _, ok := options.GetPreset(name); !ok || !adminPresetNameRe.MatchString(name) {
+		panic(errAdminNotFound)
+	}
+
+	updatePresets(func(all options.Presets) {
+		if _, ok := all[name]; !ok {
+			panic(errAdminEnvPreset)
+		}
+
+		delete(all, name)
+	})
+
+	logResponse(reqID, r, 204, nil, nil, nil)
+	rw.WriteHeader(204)
+}
+
+// updatePresets applies the change to the presets of the presets file,
+// saves them, and reloads all the presets. Presets defined in IMGPROXY_PRESETS
+// are not managed by the admin API, so they are not saved to the file
+func updatePresets(change func(options.Presets)) {
+	if len(conf.PresetsPath) == 0 {
+		panic(errAdminNoPresetsFile)
+	}
+
+	adminMutex.Lock()
+	defer adminMutex.Unlock()
+
+	p := make(options.Presets)
+
+	if err := presetFileConfig(p, conf.PresetsPath); err != nil {
+		panic(ierrors.NewUnexpected(fmt.Sprintf("Can't load presets: %s", err), 1))
+	}
+
+	change(p)
+
+	if err := savePresets(p); err != nil {
+		panic(ierrors.NewUnexpected(fmt.Sprintf("Can't save presets: %s", err), 1))
+	}
+
+	if err := reloadPresets(); err != nil {
+		panic(ierrors.NewUnexpected(fmt.Sprintf("Can't reload presets: %s", err), 1))
+	}
+}
+
+// handleAdminListKeys responds with the number of key/salt pairs.
+// The keys themselves are never exposed
+func handleAdminListKeys(reqID string, rw http.ResponseWriter, r *http.Request) {
+	keysMutex.RLock()
+	count := len(conf.Keys)
+	keysMutex.RUnlock()
+
+	respondWithAdminJSON(reqID, rw, r, 200, map[string]int{"keys": count})
+}
+
+// handleAdminAddKey adds a new key/salt pair to the beginning of the list,
+// so it becomes the primary one while the old pairs are still accepted
+func handleAdminAddKey(reqID string, rw http.ResponseWriter, r *http.Request) {
+	var pair adminKeyPair
+
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, 1<<20)).Decode(&pair); err != nil {
+		panic(ierrors.New(400, fmt.Sprintf("Can't parse request body: %s", err), "Invalid request body"))
+	}
+
+	key, err := hex.DecodeString(pair.Key)
+	if err != nil || len(key) == 0 {
+		panic(ierrors.New(422, "Key expected to be hex-encoded string", "Key expected to be hex-encoded string"))
+	}
+
+	salt, err := hex.DecodeString(pair.Salt)
+	if err != nil || len(salt) == 0 {
+		panic(ierrors.New(422, "Salt expected to be hex-encoded string", "Salt expected to be hex-encoded string"))
+	}
+
+	count := updateKeys(func(keys, salts []config.SecurityKey) ([]config.SecurityKey, []config.SecurityKey) {
+		return append([]config.SecurityKey{key}, keys...), append([]config.SecurityKey{salt}, salts...)
+	})
+
+	respondWithAdminJSON(reqID, rw, r, 201, map[string]int{"keys": count})
+}
+
+func handleAdminDeleteKey(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ind, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/keys/"))
+	if err != nil {
+		panic(errAdminNotFound)
+	}
+
+	count := updateKeys(func(keys, salts []config.SecurityKey) ([]config.SecurityKey, []config.SecurityKey) {
+		if ind < 0 || ind >= len(keys) {
+			panic(errAdminNotFound)
+		}
+		if len(keys) == 1 {
+			panic(errAdminLastKey)
+		}
+
+		newKeys := append(append([]config.SecurityKey{}, keys[:ind]...), keys[ind+1:]...)
+		newSalts := append(append([]config.SecurityKey{}, salts[:ind]...), salts[ind+1:]...)
+
+		return newKeys, newSalts
+	})
+
+	respondWithAdminJSON(reqID, rw, r, 200, map[string]int{"keys": count})
+}
+
+func handleAdminGetUsage(reqID string, rw http.ResponseWriter, r *http.Request) {
+	respondWithAdminJSON(reqID, rw, r, 200, getUsage(false))
+}
+
+// handleAdminResetUsage responds with the usage counters and resets them,
+// so the counters can be collected for billing without losing requests
+func handleAdminResetUsage(reqID string, rw http.ResponseWriter, r *http.Request) {
+	respondWithAdminJSON(reqID, rw, r, 200, getUsage(true))
+}
+
+// updateKeys applies the change to the keys and salts, saves them to the key
+// and salt files if they're used, and returns the new number of pairs
+func updateKeys(change func(keys, salts []config.SecurityKey) ([]config.SecurityKey, []config.SecurityKey)) int {
+	if conf.AllowInsecure {
+		panic(errAdminInsecure)
+	}
+	if len(conf.KeysProvider) > 0 {
+		panic(errAdminKeysProvider)
+	}
+	if len(conf.KeySources) > 0 {
+		panic(errAdminKeySources)
+	}
+
+	adminMutex.Lock()
+	defer adminMutex.Unlock()
+
+	keysMutex.RLock()
+	keys, salts := conf.Keys, conf.Salts
+	keysMutex.RUnlock()
+
+	keys, salts = change(keys, salts)
+
+	if len(conf.KeyPath) > 0 && len(conf.SaltPath) > 0 {
+		if err := saveHexKeys(conf.KeyPath, keys); err != nil {
+			panic(ierrors.NewUnexpected(fmt.Sprintf("Can't save keys: %s", err), 1))
+		}
+		if err := saveHexKeys(conf.SaltPath, salts); err != nil {
+			panic(ierrors.NewUnexpected(fmt.Sprintf("Can't save salts: %s", err), 1))
+		}
+	}
+
+	keysMutex.Lock()
+	conf.Keys, conf.Salts = keys, salts
+	keysMutex.Unlock()
+
+	return len(keys)
+}
+
+func saveHexKeys(path string, keys []config.SecurityKey) error {
+	var b strings.Builder
+
+	for _, key := range keys {
+		b.WriteString(hex.EncodeToString(key))
+		b.WriteByte('\n')
+	}
+
+	return writeFileAtomic(path, []byte(b.String()))
+}
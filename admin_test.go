@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type AdminTestSuite struct{ MainTestSuite }
+
+func (s *AdminTestSuite) TestUpdatePresetsSavesOnlyFilePresets() {
+	f, err := ioutil.TempFile("", "presets")
+	require.Nil(s.T(), err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("file=quality:70\n")
+	f.Close()
+
+	os.Setenv("IMGPROXY_PRESETS", "env=quality:80")
+	defer os.Unsetenv("IMGPROXY_PRESETS")
+
+	conf.PresetsPath = f.Name()
+	options.SetPresets(make(options.Presets))
+
+	updatePresets(func(p options.Presets) {
+		p["admin"] = options.URLOptions{options.URLOption{Name: "quality", Args: []string{"90"}}}
+	})
+
+	data, err := ioutil.ReadFile(f.Name())
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), "admin=quality:90\nfile=quality:70\n", string(data))
+
+	for _, name := range []string{"admin", "env", "file"} {
+		_, ok := options.GetPreset(name)
+		assert.True(s.T(), ok, name)
+	}
+}
+
+func (s *AdminTestSuite) TestUpdatePresetsWithoutFile() {
+	conf.PresetsPath = ""
+
+	assert.PanicsWithValue(s.T(), errAdminNoPresetsFile, func() {
+		updatePresets(func(p options.Presets) {})
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, new(AdminTestSuite))
+}
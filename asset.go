@@ -0,0 +1,156 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+)
+
+var (
+	watermark     = newAsset("watermark", getWatermarkData)
+	fallbackImage = newAsset("fallback image", getFallbackImageData)
+
+	assets = []*asset{watermark, fallbackImage}
+)
+
+// asset holds image data that is loaded at startup and can be
+// safely reloaded while requests are being processed.
+type asset struct {
+	desc string
+	load func() (*imagedata.ImageData, error)
+
+	mutex sync.RWMutex
+	data  *imagedata.ImageData
+	err   error
+}
+
+func newAsset(desc string, load func() (*imagedata.ImageData, error)) *asset {
+	return &asset{desc: desc, load: load}
+}
+
+func (a *asset) Get() *imagedata.ImageData {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.data
+}
+
+func (a *asset) Degraded() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.err != nil
+}
+
+// Status returns "loaded", "failed" or "not_configured"
+func (a *asset) Status() string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	switch {
+	case a.err != nil:
+		return "failed"
+	case a.data != nil:
+		return "loaded"
+	default:
+		return "not_configured"
+	}
+}
+
+func (a *asset) Load() error {
+	data, err := a.load()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err == nil {
+		// The previous data may still be used by in-flight requests,
+		// so we don't close it and let GC take care of it
+		a.data = data
+	}
+	a.err = err
+
+	return err
+}
+
+// Reload loads the asset again. Unlike Load, it keeps the previous data
+// and status if loading fails
+func (a *asset) Reload() error {
+	data, err := a.load()
+	if err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.data = data
+	a.err = nil
+
+	return nil
+}
+
+// Init loads the asset. When degrade mode is enabled, loading errors
+// are logged and loading is retried in background.
+func (a *asset) Init() error {
+	err := a.Load()
+	if err == nil || !conf.DegradeOnAssetsFailure {
+		return err
+	}
+
+	logWarning("Starting without %s: %s", a.desc, err)
+
+	go a.retryLoad()
+
+	return nil
+}
+
+func (a *asset) retryLoad() {
+	ticker := time.NewTicker(time.Duration(conf.AssetsRetryInterval) * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := a.Load(); err != nil {
+			logWarning("Still can't load %s: %s", a.desc, err)
+			continue
+		}
+
+		logNotice("Loaded %s", a.desc)
+		return
+	}
+}
+
+// startRefreshing reloads the asset periodically. If reloading fails,
+// the previous data is kept
+func (a *asset) startRefreshing(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if err := a.Reload(); err != nil {
+				logWarning("Can't refresh %s: %s", a.desc, err)
+			}
+		}
+	}()
+}
+
+// startFallbackImageRefreshing periodically downloads the fallback image again
+// so changes of the remote image are picked up without restarting
+func startFallbackImageRefreshing() {
+	if len(conf.FallbackImageURL) == 0 || conf.FallbackImageRefreshInterval <= 0 {
+		return
+	}
+
+	fallbackImage.startRefreshing(time.Duration(conf.FallbackImageRefreshInterval) * time.Second)
+}
+
+func degradedAssets() []string {
+	var degraded []string
+
+	for _, a := range assets {
+		if a.Degraded() {
+			degraded = append(degraded, a.desc)
+		}
+	}
+
+	return degraded
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// auditLogger writes audit events. When audit log is enabled but
+// IMGPROXY_AUDIT_LOG_PATH is not set, events are written to the main log.
+var auditLogger *logrus.Logger
+
+func initAuditLog() error {
+	if !conf.AuditLogEnabled {
+		return nil
+	}
+
+	if len(conf.AuditLogPath) == 0 {
+		auditLogger = logrus.StandardLogger()
+		return nil
+	}
+
+	f, err := os.OpenFile(conf.AuditLogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Can't open audit log: %s", err)
+	}
+
+	auditLogger = logrus.New()
+	auditLogger.SetOutput(f)
+	auditLogger.SetFormatter(&logrus.JSONFormatter{})
+
+	return nil
+}
+
+func logAuditEvent(r *http.Request, reason string, pairInd int) {
+	if auditLogger == nil {
+		return
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	fields := logrus.Fields{
+		"audit":     true,
+		"client_ip": clientIP,
+		"path":      r.RequestURI,
+		"reason":    reason,
+	}
+
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); len(forwardedFor) > 0 {
+		fields["forwarded_for"] = forwardedFor
+	}
+
+	if pairInd >= 0 {
+		fields["key_index"] = pairInd
+	}
+
+	auditLogger.WithFields(fields).Warn("Request rejected")
+}
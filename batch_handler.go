@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+)
+
+const batchRequestMaxSize = 1 << 20
+
+type batchItem struct {
+	Signature string `json:"signature"`
+	Options   string `json:"options"`
+	SourceURL string `json:"source_url"`
+}
+
+// path builds the processing URL path of the item.
+// The source URL is base64-encoded, so the signature is calculated
+// the same way as for a regular processing URL.
+func (item *batchItem) path() string {
+	signature := item.Signature
+	if len(signature) == 0 {
+		signature = "insecure"
+	}
+
+	encodedURL := base64.RawURLEncoding.EncodeToString([]byte(item.SourceURL))
+
+	if len(item.Options) == 0 {
+		return fmt.Sprintf("%s/%s/%s", conf.PathPrefix, signature, encodedURL)
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%s", conf.PathPrefix, signature, item.Options, encodedURL)
+}
+
+func handleBatch(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if newRelicEnabled {
+		var newRelicCancel context.CancelFunc
+		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
+		defer newRelicCancel()
+	}
+
+	var items []batchItem
+
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, batchRequestMaxSize)).Decode(&items); err != nil {
+		panic(ierrors.New(400, fmt.Sprintf("Can't parse batch request: %s", err), "Invalid batch request"))
+	}
+
+	if len(items) == 0 {
+		panic(ierrors.New(400, "Batch request is empty", "Invalid batch request"))
+	}
+
+	if len(items) > conf.BatchMaxSize {
+		panic(ierrors.New(
+			400,
+			fmt.Sprintf("Batch request is too big: %d images, max - %d", len(items), conf.BatchMaxSize),
+			"Invalid batch request",
+		))
+	}
+
+	mw := multipart.NewWriter(rw)
+
+	rw.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	rw.WriteHeader(200)
+
+	for i := range items {
+		if ctx.Err() != nil {
+			return
+		}
+
+		buf := responseBufPool.Get(0)
+
+		imageURL, po, err := processBatchItem(ctx, r, &items[i], buf)
+		err = writeBatchPart(reqID, r, mw, imageURL, po, buf.Bytes(), err)
+
+		responseBufPool.Put(buf)
+
+		if err != nil {
+			return
+		}
+	}
+
+	mw.Close()
+}
+
+func processBatchItem(ctx context.Context, r *http.Request, item *batchItem, w io.Writer) (imageURL string, po *options.ProcessingOptions, err error) {
+	// Processing functions panic on timeout, so we recover
+	// to respond with the error in the item's part
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			perr, ok := rerr.(error)
+			if !ok {
+				panic(rerr)
+			}
+			err = perr
+		}
+	}()
+
+	itemReq := r.WithContext(ctx)
+	itemReq.RequestURI = item.path()
+
+	if imageURL, po, err = parsePath(ctx, itemReq); err != nil {
+		return
+	}
+
+	if err = acquireProcessingSem(ctx, po.Priority); err != nil {
+		return
+	}
+	defer releaseProcessingSem(po.Priority)
+
+	if err = useNonce(itemReq, po.Nonce); err != nil {
+		return
+	}
+
+	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(po.Timeout)*time.Second)
+	defer timeoutCancel()
+
+	imgdata, _, _, downloadcancel, err := downloadImageWithRetries(ctx, imageURL)
+	defer downloadcancel()
+	if err != nil {
+		return
+	}
+
+	checkTimeout(ctx)
+
+	if shouldSkipProcessing(po, imgdata) {
+		po.Format = imgdata.Type
+		_, err = w.Write(imgdata.Data)
+		return
+	}
+
+	resolveResultFormat(po, imgdata)
+
+	processcancel, err := processing.ProcessImage(ctx, w, po, imgdata)
+	defer processcancel()
+	if err != nil {
+		return
+	}
+
+	checkTimeout(ctx)
+
+	return
+}
+
+func writeBatchPart(reqID string, r *http.Request, mw *multipart.Writer, imageURL string, po *options.ProcessingOptions, data []byte, err error) error {
+	header := make(textproto.MIMEHeader)
+
+	if err != nil {
+		ierr, ok := err.(*ierrors.Error)
+		if !ok {
+			ierr = ierrors.NewUnexpected(err.Error(), 2)
+		}
+
+		if ierr.Unexpected {
+			reportError(err, r)
+		}
+
+		logResponse(reqID, r, ierr.StatusCode, ierr, &imageURL, po)
+
+		if conf.DevelopmentErrorsMode {
+			data = []byte(ierr.Message)
+		} else {
+			data = []byte(ierr.PublicMessage)
+		}
+
+		header.Set("Status", strconv.Itoa(ierr.StatusCode))
+		header.Set("Content-Type", "text/plain")
+
+		if ierr.RetryAfter > 0 {
+			header.Set("Retry-After", strconv.Itoa(ierr.RetryAfter))
+		}
+	} else {
+		logResponse(reqID, r, 200, nil, &imageURL, po)
+
+		var contentDisposition string
+		if len(po.Filename) > 0 {
+			contentDisposition = po.Format.ContentDisposition(po.Filename)
+		} else {
+			contentDisposition = po.Format.ContentDispositionFromURL(imageURL)
+		}
+
+		header.Set("Status", "200")
+		header.Set("Content-Type", po.Format.Mime())
+		header.Set("Content-Disposition", contentDisposition)
+	}
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = part.Write(data)
+
+	return err
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+type benchmarkResult struct {
+	Duration time.Duration
+	Err      error
+}
+
+type benchmarkTask func() error
+
+// benchmark runs the `imgproxy bench` command. In the replay mode, it requests
+// the URLs from the list from a running imgproxy instance. In the local mode,
+// it processes local files through the processing pipeline directly
+func benchmark(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+
+	urlsPath := fs.String("urls", "", "path to a file with imgproxy URL paths, one per line")
+	target := fs.String("target", "http://localhost:8080", "imgproxy base URL to replay the URLs against")
+	healthSecret := fs.String("health-secret", os.Getenv("IMGPROXY_HEALTH_SECRET"), "health check secret to get libvips memory stats")
+	filesGlob := fs.String("files", "", "glob of local files to process through the pipeline")
+	optionsStr := fs.String("options", "", "processing options for local files, e.g. rs:fit:300:300/q:80")
+	concurrency := fs.Int("concurrency", 4, "number of concurrent workers")
+	repeat := fs.Int("repeat", 1, "number of passes over the URLs or files")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if (len(*urlsPath) > 0) == (len(*filesGlob) > 0) {
+		fmt.Fprintln(os.Stderr, "Either -urls or -files should be specified")
+		return 2
+	}
+
+	if *concurrency <= 0 || *repeat <= 0 {
+		fmt.Fprintln(os.Stderr, "-concurrency and -repeat should be greater than 0")
+		return 2
+	}
+
+	var (
+		tasks   []benchmarkTask
+		vipsMem func() (float64, error)
+		err     error
+	)
+
+	if len(*urlsPath) > 0 {
+		tasks, err = replayBenchmarkTasks(*urlsPath, *target)
+		vipsMem = func() (float64, error) { return remoteVipsMemHighwater(*target, *healthSecret) }
+	} else {
+		if err = initialize(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		defer shutdownFonts()
+		defer vips.Shutdown()
+
+		tasks, err = localBenchmarkTasks(*filesGlob, *optionsStr)
+		vipsMem = func() (float64, error) { return vips.GetMemHighwater(), nil }
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if len(tasks) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing to benchmark")
+		return 1
+	}
+
+	start := time.Now()
+	results := runBenchmarkTasks(tasks, *concurrency, *repeat)
+	elapsed := time.Since(start)
+
+	printBenchmarkReport(os.Stdout, results, elapsed)
+
+	if mem, err := vipsMem(); err == nil {
+		fmt.Printf("Vips memory highwater: %.1f MB\n", mem/1024/1024)
+	} else {
+		fmt.Printf("Vips memory highwater: n/a (%s)\n", err)
+	}
+
+	return 0
+}
+
+func replayBenchmarkTasks(urlsPath, target string) ([]benchmarkTask, error) {
+	f, err := os.Open(urlsPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't open URLs file: %s", err)
+	}
+	defer f.Close()
+
+	client := &http.Client{}
+	target = strings.TrimRight(target, "/")
+
+	var tasks []benchmarkTask
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		path := strings.TrimSpace(scanner.Text())
+		if len(path) == 0 || strings.HasPrefix(path, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		reqURL := target + path
+
+		tasks = append(tasks, func() error {
+			res, err := client.Get(reqURL)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+
+			io.Copy(ioutil.Discard, res.Body)
+
+			if res.StatusCode != 200 {
+				return fmt.Errorf("Status %d", res.StatusCode)
+			}
+
+			return nil
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Can't read URLs file: %s", err)
+	}
+
+	return tasks, nil
+}
+
+func localBenchmarkTasks(filesGlob, optionsStr string) ([]benchmarkTask, error) {
+	paths, err := filepath.Glob(filesGlob)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid files glob: %s", err)
+	}
+
+	var opts []string
+	if len(optionsStr) > 0 {
+		opts = strings.Split(strings.Trim(optionsStr, "/"), "/")
+	}
+
+	urlOpts, rest := options.ParseURLOptions(opts)
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("Invalid processing options: %s", strings.Join(rest, "/"))
+	}
+
+	// Apply the options once to report invalid ones before running the tasks
+	po, err := options.DefaultProcessingOptions(&options.Headers{})
+	if err != nil {
+		return nil, err
+	}
+	if err = options.ApplyProcessingOptions(po, urlOpts); err != nil {
+		return nil, fmt.Errorf("Invalid processing options: %s", err)
+	}
+
+	var tasks []benchmarkTask
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read %s: %s", path, err)
+		}
+
+		imgtype, err := checkTypeAndDimensions(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("Can't process %s: %s", path, err)
+		}
+
+		imgdata := &imagedata.ImageData{Data: data, Type: imgtype}
+
+		tasks = append(tasks, func() error {
+			po, err := options.DefaultProcessingOptions(&options.Headers{})
+			if err != nil {
+				return err
+			}
+
+			if err = options.ApplyProcessingOptions(po, urlOpts); err != nil {
+				return err
+			}
+
+			resolveResultFormat(po, imgdata)
+
+			cancel, err := processing.ProcessImage(context.Background(), ioutil.Discard, po, imgdata)
+			cancel()
+
+			return err
+		})
+	}
+
+	return tasks, nil
+}
+
+func runBenchmarkTasks(tasks []benchmarkTask, concurrency, repeat int) []benchmarkResult {
+	queue := make(chan benchmarkTask)
+	results := make([]benchmarkResult, 0, len(tasks)*repeat)
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for task := range queue {
+				start := time.Now()
+				err := task()
+				res := benchmarkResult{time.Since(start), err}
+
+				mutex.Lock()
+				results = append(results, res)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < repeat; i++ {
+		for _, task := range tasks {
+			queue <- task
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func printBenchmarkReport(w io.Writer, results []benchmarkResult, elapsed time.Duration) {
+	durations := make([]time.Duration, 0, len(results))
+	errorsCount := 0
+
+	for _, r := range results {
+		if r.Err != nil {
+			errorsCount++
+			continue
+		}
+		durations = append(durations, r.Duration)
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	fmt.Fprintf(w, "Requests: %d\n", len(results))
+	fmt.Fprintf(w, "Errors: %d\n", errorsCount)
+	fmt.Fprintf(w, "Elapsed: %s\n", elapsed)
+	fmt.Fprintf(w, "Requests per second: %.2f\n", float64(len(results))/elapsed.Seconds())
+
+	if len(durations) == 0 {
+		return
+	}
+
+	for _, p := range []float64{0.5, 0.9, 0.95, 0.99} {
+		fmt.Fprintf(w, "Latency p%g: %s\n", p*100, durations[int(p*float64(len(durations)-1))])
+	}
+
+	fmt.Fprintf(w, "Latency max: %s\n", durations[len(durations)-1])
+}
+
+func remoteVipsMemHighwater(target, healthSecret string) (float64, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(target, "/")+"/health", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if len(healthSecret) > 0 {
+		req.Header.Set("Authorization", "Bearer "+healthSecret)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	var status healthStatus
+
+	if err = json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return 0, fmt.Errorf("Can't get health status")
+	}
+
+	return status.VipsMemory.Highwater, nil
+}
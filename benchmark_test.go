@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type BenchmarkTestSuite struct{ MainTestSuite }
+
+func (s *BenchmarkTestSuite) TestLocalTasksInvalidOptions() {
+	_, err := localBenchmarkTasks("testdata/*.none", "rs:fit:100:100/plain")
+	require.NotNil(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "plain")
+
+	_, err = localBenchmarkTasks("testdata/*.none", "rs:unknown:100:100")
+	require.NotNil(s.T(), err)
+}
+
+func (s *BenchmarkTestSuite) TestLocalTasksValidOptions() {
+	tasks, err := localBenchmarkTasks("testdata/*.none", "/rs:fit:100:100/q:80/")
+	require.Nil(s.T(), err)
+	assert.Empty(s.T(), tasks)
+}
+
+func TestBenchmark(t *testing.T) {
+	suite.Run(t, new(BenchmarkTestSuite))
+}
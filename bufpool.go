@@ -13,6 +13,10 @@ func (p intSlice) Len() int           { return len(p) }
 func (p intSlice) Less(i, j int) bool { return p[i] < p[j] }
 func (p intSlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 
+// bufPool is a pool of byte buffers that calibrates itself by the sizes
+// of the returned buffers. After every IMGPROXY_BUFFER_POOL_CALIBRATION_THRESHOLD
+// returns, the pool updates the default size of new buffers and drops buffers
+// that are bigger than the 95th percentile of the observed sizes.
 type bufPool struct {
 	name        string
 	defaultSize int
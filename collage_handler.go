@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+const collageRequestMaxSize = 1 << 20
+
+const (
+	collageLayoutGrid       = "grid"
+	collageLayoutHorizontal = "horizontal"
+	collageLayoutVertical   = "vertical"
+)
+
+type collageRequest struct {
+	Layout     string      `json:"layout"`
+	Columns    int         `json:"columns"`
+	Spacing    int         `json:"spacing"`
+	Background string      `json:"background"`
+	Format     string      `json:"format"`
+	Quality    int         `json:"quality"`
+	Items      []batchItem `json:"items"`
+}
+
+// collageOptions are the validated options of the collage request
+type collageOptions struct {
+	Columns    int
+	Spacing    int
+	Background vips.Color
+	Format     imagetype.Type
+	Quality    int
+}
+
+func parseCollageRequest(req *collageRequest) (*collageOptions, error) {
+	n := len(req.Items)
+
+	if n == 0 {
+		return nil, ierrors.New(400, "Collage request is empty", "Invalid collage request")
+	}
+
+	if n > conf.CollageMaxSize {
+		return nil, ierrors.New(
+			400,
+			fmt.Sprintf("Collage request is too big: %d images, max - %d", n, conf.CollageMaxSize),
+			"Invalid collage request",
+		)
+	}
+
+	co := collageOptions{
+		Background: vips.Color{R: 255, G: 255, B: 255},
+		Format:     imagetype.JPEG,
+		Quality:    conf.Quality,
+	}
+
+	switch req.Layout {
+	case "", collageLayoutGrid:
+		if req.Columns < 0 {
+			return nil, ierrors.New(400, fmt.Sprintf("Invalid collage columns: %d", req.Columns), "Invalid collage request")
+		}
+
+		co.Columns = req.Columns
+		if co.Columns == 0 {
+			co.Columns = int(math.Ceil(math.Sqrt(float64(n))))
+		}
+		if co.Columns > n {
+			co.Columns = n
+		}
+	case collageLayoutHorizontal:
+		co.Columns = n
+	case collageLayoutVertical:
+		co.Columns = 1
+	default:
+		return nil, ierrors.New(400, fmt.Sprintf("Invalid collage layout: %s", req.Layout), "Invalid collage request")
+	}
+
+	if req.Spacing < 0 {
+		return nil, ierrors.New(400, fmt.Sprintf("Invalid collage spacing: %d", req.Spacing), "Invalid collage request")
+	}
+	co.Spacing = req.Spacing
+
+	if len(req.Background) > 0 {
+		c, err := options.ColorFromHex(req.Background)
+		if err != nil {
+			return nil, ierrors.New(400, err.Error(), "Invalid collage request")
+		}
+		co.Background = c
+	}
+
+	if len(req.Format) > 0 {
+		f, ok := imagetype.Types[req.Format]
+		if !ok || !options.FormatSupported(f) || f == imagetype.SVG {
+			return nil, ierrors.New(400, fmt.Sprintf("Invalid collage format: %s", req.Format), "Invalid collage request")
+		}
+		co.Format = f
+	}
+
+	if req.Quality < 0 || req.Quality > 100 {
+		return nil, ierrors.New(400, fmt.Sprintf("Invalid collage quality: %d", req.Quality), "Invalid collage request")
+	}
+	if req.Quality > 0 {
+		co.Quality = req.Quality
+	}
+
+	return &co, nil
+}
+
+func handleCollage(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if newRelicEnabled {
+		var newRelicCancel context.CancelFunc
+		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
+		defer newRelicCancel()
+	}
+
+	var req collageRequest
+
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, collageRequestMaxSize)).Decode(&req); err != nil {
+		panic(ierrors.New(400, fmt.Sprintf("Can't parse collage request: %s", err), "Invalid collage request"))
+	}
+
+	co, err := parseCollageRequest(&req)
+	if err != nil {
+		panic(err)
+	}
+
+	tiles := make([][]byte, len(req.Items))
+
+	for i := range req.Items {
+		buf := new(bytes.Buffer)
+
+		if err := processCollageTile(ctx, r, &req.Items[i], buf); err != nil {
+			panic(err)
+		}
+
+		tiles[i] = buf.Bytes()
+	}
+
+	checkTimeout(ctx)
+
+	width, height, err := collageSize(tiles, co)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = processing.CheckResultDimensions(width, height, 1); err != nil {
+		panic(err)
+	}
+
+	// Joining is as heavy as processing, so it takes a processing slot as well
+	if err = acquireProcessingSem(ctx, options.PriorityNormal); err != nil {
+		panic(err)
+	}
+	defer releaseProcessingSem(options.PriorityNormal)
+
+	buf := responseBufPool.Get(0)
+	defer responseBufPool.Put(buf)
+
+	if err = joinCollage(ctx, tiles, co, buf); err != nil {
+		panic(err)
+	}
+
+	rw.Header().Set("Content-Type", co.Format.Mime())
+	rw.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rw.WriteHeader(200)
+	rw.Write(buf.Bytes())
+
+	logResponse(reqID, r, 200, nil, nil, nil)
+}
+
+// processCollageTile processes a single collage item. Tiles are saved as PNG
+// so they aren't degraded before the collage is encoded
+func processCollageTile(ctx context.Context, r *http.Request, item *batchItem, w io.Writer) (err error) {
+	// Processing functions panic on timeout, so we recover
+	// to return the error
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			perr, ok := rerr.(error)
+			if !ok {
+				panic(rerr)
+			}
+			err = perr
+		}
+	}()
+
+	itemReq := r.WithContext(ctx)
+	itemReq.RequestURI = item.path()
+
+	imageURL, po, err := parsePath(ctx, itemReq)
+	if err != nil {
+		return
+	}
+
+	po.Format = imagetype.PNG
+	po.EnforceWebP = false
+
+	if err = acquireProcessingSem(ctx, po.Priority); err != nil {
+		return
+	}
+	defer releaseProcessingSem(po.Priority)
+
+	if err = useNonce(itemReq, po.Nonce); err != nil {
+		return
+	}
+
+	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(po.Timeout)*time.Second)
+	defer timeoutCancel()
+
+	imgdata, _, _, downloadcancel, err := downloadImageWithRetries(ctx, imageURL)
+	defer downloadcancel()
+	if err != nil {
+		return
+	}
+
+	checkTimeout(ctx)
+
+	processcancel, err := processing.ProcessImage(ctx, w, po, imgdata)
+	defer processcancel()
+
+	return
+}
+
+// collageSize calculates the size of the collage without loading the tiles.
+// Every grid cell has the size of the largest tile
+func collageSize(tiles [][]byte, co *collageOptions) (int, int, error) {
+	var cellWidth, cellHeight int
+
+	for _, data := range tiles {
+		meta, err := imagemeta.DecodeMeta(bytes.NewReader(data))
+		if err != nil {
+			return 0, 0, err
+		}
+
+		cellWidth = maxInt(cellWidth, meta.Width())
+		cellHeight = maxInt(cellHeight, meta.Height())
+	}
+
+	rows := (len(tiles) + co.Columns - 1) / co.Columns
+
+	width := co.Columns*cellWidth + (co.Columns-1)*co.Spacing
+	height := rows*cellHeight + (rows-1)*co.Spacing
+
+	return width, height, nil
+}
+
+func joinCollage(ctx context.Context, tiles [][]byte, co *collageOptions, w io.Writer) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	imgs := make([]*vips.Image, 0, len(tiles))
+	defer func() {
+		for _, img := range imgs {
+			img.Clear()
+		}
+	}()
+
+	for _, data := range tiles {
+		checkTimeout(ctx)
+
+		img := new(vips.Image)
+		imgs = append(imgs, img)
+
+		if err := img.Load(data, imagetype.PNG, 1, 1.0, 1); err != nil {
+			return err
+		}
+
+		if err := img.SRGBColourspace(); err != nil {
+			return err
+		}
+
+		if img.HasAlpha() {
+			if err := img.Flatten(co.Background); err != nil {
+				return err
+			}
+		}
+	}
+
+	collage := new(vips.Image)
+	defer collage.Clear()
+
+	if err := collage.Collage(imgs, co.Columns, co.Spacing, co.Background); err != nil {
+		return err
+	}
+
+	cancel, err := collage.Save(w, co.Format, co.Quality, true, false, false)
+	defer cancel()
+
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/vips"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type CollageTestSuite struct{ MainTestSuite }
+
+func (s *CollageTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.CollageMaxSize = 10
+}
+
+func (s *CollageTestSuite) collageRequest(layout string, columns, n int) *collageRequest {
+	return &collageRequest{
+		Layout:  layout,
+		Columns: columns,
+		Items:   make([]batchItem, n),
+	}
+}
+
+func (s *CollageTestSuite) TestDefaultGridColumns() {
+	co, err := parseCollageRequest(s.collageRequest("", 0, 5))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, co.Columns)
+	assert.Equal(s.T(), imagetype.JPEG, co.Format)
+	assert.Equal(s.T(), vips.Color{R: 255, G: 255, B: 255}, co.Background)
+}
+
+func (s *CollageTestSuite) TestGridColumnsLimitedBySize() {
+	co, err := parseCollageRequest(s.collageRequest("grid", 4, 2))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, co.Columns)
+}
+
+func (s *CollageTestSuite) TestStripLayouts() {
+	co, err := parseCollageRequest(s.collageRequest("horizontal", 0, 4))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 4, co.Columns)
+
+	co, err = parseCollageRequest(s.collageRequest("vertical", 0, 4))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, co.Columns)
+}
+
+func (s *CollageTestSuite) TestInvalidLayout() {
+	_, err := parseCollageRequest(s.collageRequest("circle", 0, 4))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 400, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *CollageTestSuite) TestTooBig() {
+	_, err := parseCollageRequest(s.collageRequest("grid", 0, 11))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 400, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *CollageTestSuite) TestBackgroundAndFormat() {
+	req := s.collageRequest("grid", 0, 2)
+	req.Background = "ff0000"
+	req.Format = "png"
+
+	co, err := parseCollageRequest(req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), vips.Color{R: 255, G: 0, B: 0}, co.Background)
+	assert.Equal(s.T(), imagetype.PNG, co.Format)
+}
+
+func (s *CollageTestSuite) pngTile(width, height int) []byte {
+	buf := new(bytes.Buffer)
+	require.Nil(s.T(), png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+
+	return buf.Bytes()
+}
+
+func (s *CollageTestSuite) TestCollageSize() {
+	tiles := [][]byte{s.pngTile(10, 20), s.pngTile(30, 5), s.pngTile(15, 15)}
+
+	width, height, err := collageSize(tiles, &collageOptions{Columns: 2, Spacing: 4})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 64, width)
+	assert.Equal(s.T(), 44, height)
+}
+
+func (s *CollageTestSuite) TestResponseBufPool() {
+	oldPool := responseBufPool
+	defer func() { responseBufPool = oldPool }()
+
+	responseBufPool = nil
+
+	require.Nil(s.T(), initProcessingHandler())
+	assert.NotNil(s.T(), responseBufPool)
+}
+
+func TestCollage(t *testing.T) {
+	suite.Run(t, new(CollageTestSuite))
+}
@@ -2,141 +2,109 @@ package main
 
 import (
 	"bufio"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"math"
 	"os"
-	"runtime"
-	"strconv"
+	"regexp"
 	"strings"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
 )
 
-func intEnvConfig(i *int, name string) {
-	if env, err := strconv.Atoi(os.Getenv(name)); err == nil {
-		*i = env
-	}
-}
+var errorResponseStatusRe = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
 
-func floatEnvConfig(i *float64, name string) {
-	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
-		*i = env
-	}
-}
+func errorResponsesEnvConfig(m *map[string]string, name string) error {
+	if env := os.Getenv(name); len(env) > 0 {
+		responses := make(map[string]string)
 
-func megaIntEnvConfig(f *int, name string) {
-	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
-		*f = int(env * 1000000)
-	}
-}
+		for _, part := range strings.Split(env, ",") {
+			kv := strings.SplitN(part, "=", 2)
 
-func strEnvConfig(s *string, name string) {
-	if env := os.Getenv(name); len(env) > 0 {
-		*s = env
-	}
-}
+			status := strings.TrimSpace(kv[0])
+			if len(kv) < 2 || !errorResponseStatusRe.MatchString(status) {
+				return fmt.Errorf("Invalid error response: %s", part)
+			}
 
-func strSliceEnvConfig(s *[]string, name string) {
-	if env := os.Getenv(name); len(env) > 0 {
-		parts := strings.Split(env, ",")
+			path := strings.TrimSpace(kv[1])
+			if len(path) == 0 {
+				return fmt.Errorf("Invalid error response: %s", part)
+			}
 
-		for i, p := range parts {
-			parts[i] = strings.TrimSpace(p)
+			responses[status] = path
 		}
 
-		*s = parts
-
-		return
+		*m = responses
 	}
 
-	*s = []string{}
-}
-
-func boolEnvConfig(b *bool, name string) {
-	if env, err := strconv.ParseBool(os.Getenv(name)); err == nil {
-		*b = env
-	}
+	return nil
 }
 
-func imageTypesEnvConfig(it *[]imageType, name string) {
-	*it = []imageType{}
-
+func colorProfilesEnvConfig(m *map[string]string, name string) error {
 	if env := os.Getenv(name); len(env) > 0 {
-		parts := strings.Split(env, ",")
-
-		for _, p := range parts {
-			pt := strings.TrimSpace(p)
-			if t, ok := imageTypes[pt]; ok {
-				*it = append(*it, t)
-			} else {
-				logWarning("Unknown image format to skip: %s", pt)
-			}
-		}
-	}
-}
+		profiles := make(map[string]string)
 
-func hexEnvConfig(b *[]securityKey, name string) error {
-	var err error
-
-	if env := os.Getenv(name); len(env) > 0 {
-		parts := strings.Split(env, ",")
+		for _, part := range strings.Split(env, ",") {
+			kv := strings.SplitN(part, "=", 2)
 
-		keys := make([]securityKey, len(parts))
+			if len(kv) < 2 {
+				return fmt.Errorf("Invalid color profile: %s", part)
+			}
 
-		for i, part := range parts {
-			if keys[i], err = hex.DecodeString(part); err != nil {
-				return fmt.Errorf("%s expected to be hex-encoded strings. Invalid: %s\n", name, part)
+			profileName, path := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			if len(profileName) == 0 || len(path) == 0 {
+				return fmt.Errorf("Invalid color profile: %s", part)
 			}
+
+			profiles[profileName] = path
 		}
 
-		*b = keys
+		*m = profiles
 	}
 
 	return nil
 }
 
-func hexFileConfig(b *[]securityKey, filepath string) error {
-	if len(filepath) == 0 {
-		return nil
-	}
+var remoteSignatureKeyIDRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
-	f, err := os.Open(filepath)
-	if err != nil {
-		return fmt.Errorf("Can't open file %s\n", filepath)
-	}
+func remoteSignatureKeysEnvConfig(m *map[string]string, name string) error {
+	if env := os.Getenv(name); len(env) > 0 {
+		keys := make(map[string]string)
 
-	keys := []securityKey{}
+		for _, part := range strings.Split(env, ",") {
+			kv := strings.SplitN(part, "=", 2)
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		part := scanner.Text()
+			keyID := strings.TrimSpace(kv[0])
+			if len(kv) < 2 || !remoteSignatureKeyIDRe.MatchString(keyID) {
+				return fmt.Errorf("Invalid remote signature key: %s", part)
+			}
 
-		if len(part) == 0 {
-			continue
-		}
+			key := strings.TrimSpace(kv[1])
+			if len(key) == 0 {
+				return fmt.Errorf("Invalid remote signature key: %s", part)
+			}
+
+			if _, ok := keys[keyID]; ok {
+				return fmt.Errorf("Duplicate remote signature key ID: %s", keyID)
+			}
 
-		if key, err := hex.DecodeString(part); err == nil {
-			keys = append(keys, key)
-		} else {
-			return fmt.Errorf("%s expected to contain hex-encoded strings. Invalid: %s\n", filepath, part)
+			keys[keyID] = key
 		}
-	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("Failed to read file %s: %s", filepath, err)
+		*m = keys
 	}
 
-	*b = keys
-
 	return nil
 }
 
-func presetEnvConfig(p presets, name string) error {
+func presetEnvConfig(p options.Presets, name string) error {
 	if env := os.Getenv(name); len(env) > 0 {
 		presetStrings := strings.Split(env, ",")
 
 		for _, presetStr := range presetStrings {
-			if err := parsePreset(p, presetStr); err != nil {
+			if err := options.ParsePreset(p, presetStr); err != nil {
 				return fmt.Errorf(err.Error())
 			}
 		}
@@ -145,7 +113,7 @@ func presetEnvConfig(p presets, name string) error {
 	return nil
 }
 
-func presetFileConfig(p presets, filepath string) error {
+func presetFileConfig(p options.Presets, filepath string) error {
 	if len(filepath) == 0 {
 		return nil
 	}
@@ -154,10 +122,11 @@ func presetFileConfig(p presets, filepath string) error {
 	if err != nil {
 		return fmt.Errorf("Can't open file %s\n", filepath)
 	}
+	defer f.Close()
 
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		if err := parsePreset(p, scanner.Text()); err != nil {
+		if err := options.ParsePreset(p, scanner.Text()); err != nil {
 			return fmt.Errorf(err.Error())
 		}
 	}
@@ -169,351 +138,635 @@ func presetFileConfig(p presets, filepath string) error {
 	return nil
 }
 
-type config struct {
-	Network          string
-	Bind             string
-	ReadTimeout      int
-	WriteTimeout     int
-	KeepAliveTimeout int
-	DownloadTimeout  int
-	Concurrency      int
-	MaxClients       int
+// conf points to the configuration in use
+var conf = &config.Conf
+
+func validateRoutes(routes []string) error {
+	for _, route := range routes {
+		known := false
+
+		for _, r := range knownRoutes {
+			if r == route {
+				known = true
+				break
+			}
+		}
 
-	TTL                     int
-	CacheControlPassthrough bool
+		if !known {
+			return fmt.Errorf("Unknown route: %s", route)
+		}
+	}
 
-	SoReuseport bool
+	return nil
+}
 
-	PathPrefix string
+func configure() error {
+	if errs := loadConfig(); len(errs) > 0 {
+		return errs[0]
+	}
 
-	MaxSrcDimension    int
-	MaxSrcResolution   int
-	MaxSrcFileSize     int
-	MaxAnimationFrames int
-	MaxSvgCheckBytes   int
+	return nil
+}
 
-	JpegProgressive       bool
-	PngInterlaced         bool
-	PngQuantize           bool
-	PngQuantizationColors int
-	Quality               int
-	GZipCompression       int
-	StripMetadata         bool
+// loadConfig reads the config from the environment and validates it.
+// It doesn't stop on the first problem and returns all found errors
+func loadConfig() (errs []error) {
+	keyPath := flag.String("keypath", "", "path of the file with hex-encoded key")
+	saltPath := flag.String("saltpath", "", "path of the file with hex-encoded salt")
+	presetsPath := flag.String("presets", "", "path of the file with presets")
+	flag.Parse()
 
-	EnableWebpDetection bool
-	EnforceWebp         bool
-	EnableClientHints   bool
+	var containerMemLimit int64
 
-	SkipProcessingFormats []imageType
+	config.BoolEnv(&conf.DetectContainerLimits, "IMGPROXY_DETECT_CONTAINER_LIMITS")
+	if conf.DetectContainerLimits {
+		containerMemLimit = applyContainerLimits()
+	}
 
-	UseLinearColorspace bool
-	DisableShrinkOnLoad bool
+	if port := os.Getenv("PORT"); len(port) > 0 {
+		conf.Bind = fmt.Sprintf(":%s", port)
+	}
 
-	Keys          []securityKey
-	Salts         []securityKey
-	AllowInsecure bool
-	SignatureSize int
+	config.StringEnv(&conf.Network, "IMGPROXY_NETWORK")
+	config.StringEnv(&conf.Bind, "IMGPROXY_BIND")
+	config.IntEnv(&conf.ReadTimeout, "IMGPROXY_READ_TIMEOUT")
+	config.IntEnv(&conf.WriteTimeout, "IMGPROXY_WRITE_TIMEOUT")
+	config.IntEnv(&conf.MaxTimeout, "IMGPROXY_MAX_TIMEOUT")
+	config.IntEnv(&conf.KeepAliveTimeout, "IMGPROXY_KEEP_ALIVE_TIMEOUT")
+	config.IntEnv(&conf.DownloadTimeout, "IMGPROXY_DOWNLOAD_TIMEOUT")
+	config.IntEnv(&conf.DownloadRetries, "IMGPROXY_DOWNLOAD_RETRIES")
+	config.IntEnv(&conf.DownloadRetryBackoff, "IMGPROXY_DOWNLOAD_RETRY_BACKOFF")
+	config.IntEnv(&conf.MaxDownloadsPerHost, "IMGPROXY_MAX_DOWNLOADS_PER_HOST")
+	config.IntEnv(&conf.MaxHostDownloadWait, "IMGPROXY_MAX_HOST_DOWNLOAD_WAIT")
+	config.IntEnv(&conf.Concurrency, "IMGPROXY_CONCURRENCY")
+	config.IntEnv(&conf.MaxClients, "IMGPROXY_MAX_CLIENTS")
+	config.IntEnv(&conf.LowPriorityConcurrency, "IMGPROXY_LOW_PRIORITY_CONCURRENCY")
+	config.IntEnv(&conf.MaxQueueSize, "IMGPROXY_MAX_QUEUE_SIZE")
+	config.IntEnv(&conf.MaxQueueWait, "IMGPROXY_MAX_QUEUE_WAIT")
+	config.IntEnv(&conf.QueueRetryAfter, "IMGPROXY_QUEUE_RETRY_AFTER")
+	config.IntEnv(&conf.BatchMaxSize, "IMGPROXY_BATCH_MAX_SIZE")
+	config.IntEnv(&conf.CollageMaxSize, "IMGPROXY_COLLAGE_MAX_SIZE")
+	config.BoolEnv(&conf.UploadEnabled, "IMGPROXY_ENABLE_UPLOAD")
+	config.BoolEnv(&conf.InfoEnabled, "IMGPROXY_ENABLE_INFO")
+	config.BoolEnv(&conf.PlaceholdersEnabled, "IMGPROXY_ENABLE_PLACEHOLDERS")
+	config.BoolEnv(&conf.InfoExif, "IMGPROXY_INFO_EXIF")
+	config.BoolEnv(&conf.InfoExifGPS, "IMGPROXY_INFO_EXIF_GPS")
+	config.BoolEnv(&conf.InfoPerceptualHashes, "IMGPROXY_INFO_PERCEPTUAL_HASHES")
+
+	config.IntEnv(&conf.TTL, "IMGPROXY_TTL")
+	config.BoolEnv(&conf.CacheControlPassthrough, "IMGPROXY_CACHE_CONTROL_PASSTHROUGH")
+	config.StringSliceEnv(&conf.SurrogateKeyHeaders, "IMGPROXY_SURROGATE_KEY_HEADERS")
+
+	config.BoolEnv(&conf.SoReuseport, "IMGPROXY_SO_REUSEPORT")
+
+	config.StringSliceEnv(&conf.BindRoutes, "IMGPROXY_BIND_ROUTES")
+	if err := config.BindsEnv(&conf.AdditionalBinds, "IMGPROXY_ADDITIONAL_BINDS"); err != nil {
+		errs = append(errs, err)
+	}
+
+	config.StringEnv(&conf.TLSCertPath, "IMGPROXY_TLS_CERT_PATH")
+	config.StringEnv(&conf.TLSKeyPath, "IMGPROXY_TLS_KEY_PATH")
+
+	config.StringEnv(&conf.PathPrefix, "IMGPROXY_PATH_PREFIX")
+
+	config.IntEnv(&conf.MaxSrcDimension, "IMGPROXY_MAX_SRC_DIMENSION")
+	config.MegaIntEnv(&conf.MaxSrcResolution, "IMGPROXY_MAX_SRC_RESOLUTION")
+	config.IntEnv(&conf.MaxResultDimension, "IMGPROXY_MAX_RESULT_DIMENSION")
+	config.MegaIntEnv(&conf.MaxResultResolution, "IMGPROXY_MAX_RESULT_RESOLUTION")
+	config.IntEnv(&conf.MaxSrcFileSize, "IMGPROXY_MAX_SRC_FILE_SIZE")
+	config.IntEnv(&conf.MaxSvgCheckBytes, "IMGPROXY_MAX_SVG_CHECK_BYTES")
+	config.IntEnv(&conf.MaxDecodeMemory, "IMGPROXY_MAX_DECODE_MEMORY")
 
-	Secret string
+	if _, ok := os.LookupEnv("IMGPROXY_MAX_GIF_FRAMES"); ok {
+		logWarning("`IMGPROXY_MAX_GIF_FRAMES` is deprecated and will be removed in future versions. Use `IMGPROXY_MAX_ANIMATION_FRAMES` instead")
+		config.IntEnv(&conf.MaxAnimationFrames, "IMGPROXY_MAX_GIF_FRAMES")
+	}
+	config.IntEnv(&conf.MaxAnimationFrames, "IMGPROXY_MAX_ANIMATION_FRAMES")
+	config.StringEnv(&conf.MaxAnimationFramesMode, "IMGPROXY_MAX_ANIMATION_FRAMES_MODE")
 
-	AllowOrigin string
+	config.StringSliceEnv(&conf.AllowedSources, "IMGPROXY_ALLOWED_SOURCES")
+	config.StringSliceEnv(&conf.AllowedSourceContentTypes, "IMGPROXY_ALLOWED_SOURCE_CONTENT_TYPES")
+	config.BoolEnv(&conf.DenyPrivateSourceAddresses, "IMGPROXY_DENY_PRIVATE_SOURCE_ADDRESSES")
+	if err := config.CIDRSliceEnv(&conf.AllowedSourceNetworks, "IMGPROXY_ALLOWED_SOURCE_NETWORKS"); err != nil {
+		errs = append(errs, err)
+	}
 
-	UserAgent string
+	config.BoolEnv(&conf.JpegProgressive, "IMGPROXY_JPEG_PROGRESSIVE")
+	config.BoolEnv(&conf.PngInterlaced, "IMGPROXY_PNG_INTERLACED")
+	config.BoolEnv(&conf.PngQuantize, "IMGPROXY_PNG_QUANTIZE")
+	config.IntEnv(&conf.PngQuantizationColors, "IMGPROXY_PNG_QUANTIZATION_COLORS")
+	config.IntEnv(&conf.Quality, "IMGPROXY_QUALITY")
+	config.IntEnv(&conf.GZipCompression, "IMGPROXY_GZIP_COMPRESSION")
+	config.BoolEnv(&conf.StripMetadata, "IMGPROXY_STRIP_METADATA")
 
-	IgnoreSslVerification bool
-	DevelopmentErrorsMode bool
+	config.BoolEnv(&conf.EnableWebpDetection, "IMGPROXY_ENABLE_WEBP_DETECTION")
+	config.BoolEnv(&conf.EnforceWebp, "IMGPROXY_ENFORCE_WEBP")
+	config.BoolEnv(&conf.EnableClientHints, "IMGPROXY_ENABLE_CLIENT_HINTS")
 
-	AllowedSources      []string
-	LocalFileSystemRoot string
-	S3Enabled           bool
-	S3Region            string
-	S3Endpoint          string
-	GCSEnabled          bool
-	GCSKey              string
+	config.BoolEnv(&conf.EnableContentClassification, "IMGPROXY_ENABLE_CONTENT_CLASSIFICATION")
+	config.IntEnv(&conf.PhotoQuality, "IMGPROXY_PHOTO_QUALITY")
+	if err := config.ImageTypeEnv(&conf.PhotoFormat, "IMGPROXY_PHOTO_FORMAT"); err != nil {
+		errs = append(errs, err)
+	}
+	config.IntEnv(&conf.GraphicQuality, "IMGPROXY_GRAPHIC_QUALITY")
+	if err := config.ImageTypeEnv(&conf.GraphicFormat, "IMGPROXY_GRAPHIC_FORMAT"); err != nil {
+		errs = append(errs, err)
+	}
 
-	ETagEnabled bool
+	config.ImageTypesEnv(&conf.SkipProcessingFormats, "IMGPROXY_SKIP_PROCESSING_FORMATS")
 
-	BaseURL string
+	config.BoolEnv(&conf.UseLinearColorspace, "IMGPROXY_USE_LINEAR_COLORSPACE")
+	config.BoolEnv(&conf.DisableShrinkOnLoad, "IMGPROXY_DISABLE_SHRINK_ON_LOAD")
 
-	Presets     presets
-	OnlyPresets bool
+	if err := colorProfilesEnvConfig(&conf.ColorProfiles, "IMGPROXY_COLOR_PROFILES"); err != nil {
+		errs = append(errs, err)
+	}
+	config.StringEnv(&conf.ColorProfile, "IMGPROXY_COLOR_PROFILE")
 
-	WatermarkData    string
-	WatermarkPath    string
-	WatermarkURL     string
-	WatermarkOpacity float64
+	if err := config.HexEnv(&conf.Keys, "IMGPROXY_KEY"); err != nil {
+		errs = append(errs, err)
+	}
+	if err := config.HexEnv(&conf.Salts, "IMGPROXY_SALT"); err != nil {
+		errs = append(errs, err)
+	}
+	config.IntEnv(&conf.SignatureSize, "IMGPROXY_SIGNATURE_SIZE")
+	config.StringSliceEnv(&conf.SignatureAlgorithms, "IMGPROXY_SIGNATURE_ALGORITHMS")
+	config.BoolEnv(&conf.AllowSignatureInQuery, "IMGPROXY_ALLOW_SIGNATURE_IN_QUERY")
+	config.BoolEnv(&conf.EnableQueryOptions, "IMGPROXY_ENABLE_QUERY_OPTIONS")
 
-	FallbackImageData string
-	FallbackImagePath string
-	FallbackImageURL  string
+	if err := remoteSignatureKeysEnvConfig(&conf.RemoteSignatureKeys, "IMGPROXY_REMOTE_SIGNATURE_KEYS"); err != nil {
+		errs = append(errs, err)
+	}
+	config.IntEnv(&conf.RemoteSignatureConcurrency, "IMGPROXY_REMOTE_SIGNATURE_CONCURRENCY")
+	config.IntEnv(&conf.RemoteSignatureWait, "IMGPROXY_REMOTE_SIGNATURE_WAIT")
+	config.IntEnv(&conf.RemoteSignatureCacheSize, "IMGPROXY_REMOTE_SIGNATURE_CACHE_SIZE")
+	config.IntEnv(&conf.RemoteSignatureCacheTTL, "IMGPROXY_REMOTE_SIGNATURE_CACHE_TTL")
+	config.KeySourcesEnv(&conf.KeySources, "IMGPROXY_KEY_SOURCES")
 
-	NewRelicAppName string
-	NewRelicKey     string
+	config.StringEnv(&conf.JWTSecret, "IMGPROXY_JWT_SECRET")
+	config.StringEnv(&conf.JWTPublicKeyPath, "IMGPROXY_JWT_PUBLIC_KEY_PATH")
 
-	PrometheusBind      string
-	PrometheusNamespace string
+	config.StringEnv(&conf.NonceStore, "IMGPROXY_NONCE_STORE")
+	config.BoolEnv(&conf.NonceRequired, "IMGPROXY_NONCE_REQUIRED")
+	config.IntEnv(&conf.NonceTTL, "IMGPROXY_NONCE_TTL")
+	config.IntEnv(&conf.NonceMemoryStoreSize, "IMGPROXY_NONCE_MEMORY_STORE_SIZE")
+	config.StringEnv(&conf.NonceRedisURL, "IMGPROXY_NONCE_REDIS_URL")
 
-	BugsnagKey        string
-	BugsnagStage      string
-	HoneybadgerKey    string
-	HoneybadgerEnv    string
-	SentryDSN         string
-	SentryEnvironment string
-	SentryRelease     string
+	config.BoolEnv(&conf.AuditLogEnabled, "IMGPROXY_AUDIT_LOG_ENABLE")
+	config.StringEnv(&conf.AuditLogPath, "IMGPROXY_AUDIT_LOG_PATH")
 
-	ReportDownloadingErrors bool
+	config.StringEnv(&conf.KeyPath, "IMGPROXY_KEY_PATH")
+	config.StringEnv(&conf.SaltPath, "IMGPROXY_SALT_PATH")
+	if len(*keyPath) > 0 {
+		conf.KeyPath = *keyPath
+	}
+	if len(*saltPath) > 0 {
+		conf.SaltPath = *saltPath
+	}
 
-	FreeMemoryInterval             int
-	DownloadBufferSize             int
-	GZipBufferSize                 int
-	BufferPoolCalibrationThreshold int
-}
+	config.StringEnv(&conf.KeysProvider, "IMGPROXY_KEYS_PROVIDER")
+	config.StringEnv(&conf.KeysAWSSecretID, "IMGPROXY_KEYS_AWS_SECRET_ID")
+	config.StringEnv(&conf.KeysVaultAddress, "IMGPROXY_KEYS_VAULT_ADDRESS")
+	config.StringEnv(&conf.KeysVaultToken, "IMGPROXY_KEYS_VAULT_TOKEN")
+	config.StringEnv(&conf.KeysVaultPath, "IMGPROXY_KEYS_VAULT_PATH")
+	config.IntEnv(&conf.KeysRefreshInterval, "IMGPROXY_KEYS_REFRESH_INTERVAL")
 
-var conf = config{
-	Network:                        "tcp",
-	Bind:                           ":8080",
-	ReadTimeout:                    10,
-	WriteTimeout:                   10,
-	KeepAliveTimeout:               10,
-	DownloadTimeout:                5,
-	Concurrency:                    runtime.NumCPU() * 2,
-	TTL:                            3600,
-	MaxSrcResolution:               16800000,
-	MaxAnimationFrames:             1,
-	MaxSvgCheckBytes:               32 * 1024,
-	SignatureSize:                  32,
-	PngQuantizationColors:          256,
-	Quality:                        80,
-	StripMetadata:                  true,
-	UserAgent:                      fmt.Sprintf("imgproxy/%s", version),
-	Presets:                        make(presets),
-	WatermarkOpacity:               1,
-	BugsnagStage:                   "production",
-	HoneybadgerEnv:                 "production",
-	SentryEnvironment:              "production",
-	SentryRelease:                  fmt.Sprintf("imgproxy/%s", version),
-	ReportDownloadingErrors:        true,
-	FreeMemoryInterval:             10,
-	BufferPoolCalibrationThreshold: 1024,
-}
+	config.StringEnv(&conf.Secret, "IMGPROXY_SECRET")
+	config.StringEnv(&conf.HealthSecret, "IMGPROXY_HEALTH_SECRET")
 
-func configure() error {
-	keyPath := flag.String("keypath", "", "path of the file with hex-encoded key")
-	saltPath := flag.String("saltpath", "", "path of the file with hex-encoded salt")
-	presetsPath := flag.String("presets", "", "path of the file with presets")
-	flag.Parse()
+	config.StringSliceEnv(&conf.AllowedReferers, "IMGPROXY_ALLOWED_REFERERS")
+	config.BoolEnv(&conf.AllowEmptyReferer, "IMGPROXY_ALLOW_EMPTY_REFERER")
 
-	if port := os.Getenv("PORT"); len(port) > 0 {
-		conf.Bind = fmt.Sprintf(":%s", port)
-	}
+	config.StringSliceEnv(&conf.AllowOrigin, "IMGPROXY_ALLOW_ORIGIN")
+	config.StringEnv(&conf.AllowMethods, "IMGPROXY_ALLOW_METHODS")
+	config.StringEnv(&conf.AllowHeaders, "IMGPROXY_ALLOW_HEADERS")
+	config.IntEnv(&conf.CORSMaxAge, "IMGPROXY_CORS_MAX_AGE")
 
-	strEnvConfig(&conf.Network, "IMGPROXY_NETWORK")
-	strEnvConfig(&conf.Bind, "IMGPROXY_BIND")
-	intEnvConfig(&conf.ReadTimeout, "IMGPROXY_READ_TIMEOUT")
-	intEnvConfig(&conf.WriteTimeout, "IMGPROXY_WRITE_TIMEOUT")
-	intEnvConfig(&conf.KeepAliveTimeout, "IMGPROXY_KEEP_ALIVE_TIMEOUT")
-	intEnvConfig(&conf.DownloadTimeout, "IMGPROXY_DOWNLOAD_TIMEOUT")
-	intEnvConfig(&conf.Concurrency, "IMGPROXY_CONCURRENCY")
-	intEnvConfig(&conf.MaxClients, "IMGPROXY_MAX_CLIENTS")
+	config.StringEnv(&conf.UserAgent, "IMGPROXY_USER_AGENT")
 
-	intEnvConfig(&conf.TTL, "IMGPROXY_TTL")
-	boolEnvConfig(&conf.CacheControlPassthrough, "IMGPROXY_CACHE_CONTROL_PASSTHROUGH")
+	config.BoolEnv(&conf.IgnoreSslVerification, "IMGPROXY_IGNORE_SSL_VERIFICATION")
+	config.BoolEnv(&conf.DevelopmentErrorsMode, "IMGPROXY_DEVELOPMENT_ERRORS_MODE")
 
-	boolEnvConfig(&conf.SoReuseport, "IMGPROXY_SO_REUSEPORT")
+	config.StringEnv(&conf.LocalFileSystemRoot, "IMGPROXY_LOCAL_FILESYSTEM_ROOT")
 
-	strEnvConfig(&conf.PathPrefix, "IMGPROXY_PATH_PREFIX")
+	config.BoolEnv(&conf.S3Enabled, "IMGPROXY_USE_S3")
+	config.StringEnv(&conf.S3Region, "IMGPROXY_S3_REGION")
+	config.StringEnv(&conf.S3Endpoint, "IMGPROXY_S3_ENDPOINT")
 
-	intEnvConfig(&conf.MaxSrcDimension, "IMGPROXY_MAX_SRC_DIMENSION")
-	megaIntEnvConfig(&conf.MaxSrcResolution, "IMGPROXY_MAX_SRC_RESOLUTION")
-	intEnvConfig(&conf.MaxSrcFileSize, "IMGPROXY_MAX_SRC_FILE_SIZE")
-	intEnvConfig(&conf.MaxSvgCheckBytes, "IMGPROXY_MAX_SVG_CHECK_BYTES")
+	config.BoolEnv(&conf.GCSEnabled, "IMGPROXY_USE_GCS")
+	config.StringEnv(&conf.GCSKey, "IMGPROXY_GCS_KEY")
 
-	if _, ok := os.LookupEnv("IMGPROXY_MAX_GIF_FRAMES"); ok {
-		logWarning("`IMGPROXY_MAX_GIF_FRAMES` is deprecated and will be removed in future versions. Use `IMGPROXY_MAX_ANIMATION_FRAMES` instead")
-		intEnvConfig(&conf.MaxAnimationFrames, "IMGPROXY_MAX_GIF_FRAMES")
-	}
-	intEnvConfig(&conf.MaxAnimationFrames, "IMGPROXY_MAX_ANIMATION_FRAMES")
+	config.BoolEnv(&conf.DataURIEnabled, "IMGPROXY_USE_DATA_URI")
+	config.IntEnv(&conf.MaxDataURISize, "IMGPROXY_MAX_DATA_URI_SIZE")
 
-	strSliceEnvConfig(&conf.AllowedSources, "IMGPROXY_ALLOWED_SOURCES")
+	config.BoolEnv(&conf.SFTPEnabled, "IMGPROXY_USE_SFTP")
+	config.StringEnv(&conf.SFTPUser, "IMGPROXY_SFTP_USER")
+	config.StringEnv(&conf.SFTPKeyPath, "IMGPROXY_SFTP_KEY_PATH")
+	config.StringEnv(&conf.SFTPKnownHostsPath, "IMGPROXY_SFTP_KNOWN_HOSTS_PATH")
+	config.BoolEnv(&conf.SFTPInsecureSkipHostKeyCheck, "IMGPROXY_SFTP_INSECURE_SKIP_HOST_KEY_CHECK")
+	config.StringSliceEnv(&conf.SFTPAllowedHosts, "IMGPROXY_SFTP_ALLOWED_HOSTS")
 
-	boolEnvConfig(&conf.JpegProgressive, "IMGPROXY_JPEG_PROGRESSIVE")
-	boolEnvConfig(&conf.PngInterlaced, "IMGPROXY_PNG_INTERLACED")
-	boolEnvConfig(&conf.PngQuantize, "IMGPROXY_PNG_QUANTIZE")
-	intEnvConfig(&conf.PngQuantizationColors, "IMGPROXY_PNG_QUANTIZATION_COLORS")
-	intEnvConfig(&conf.Quality, "IMGPROXY_QUALITY")
-	intEnvConfig(&conf.GZipCompression, "IMGPROXY_GZIP_COMPRESSION")
-	boolEnvConfig(&conf.StripMetadata, "IMGPROXY_STRIP_METADATA")
+	config.BoolEnv(&conf.ETagEnabled, "IMGPROXY_USE_ETAG")
+	config.StringEnv(&conf.ETagMode, "IMGPROXY_ETAG_MODE")
+	config.BoolEnv(&conf.ETagRevalidate, "IMGPROXY_ETAG_REVALIDATE")
+	config.BoolEnv(&conf.BufferResponse, "IMGPROXY_BUFFER_RESPONSE")
 
-	boolEnvConfig(&conf.EnableWebpDetection, "IMGPROXY_ENABLE_WEBP_DETECTION")
-	boolEnvConfig(&conf.EnforceWebp, "IMGPROXY_ENFORCE_WEBP")
-	boolEnvConfig(&conf.EnableClientHints, "IMGPROXY_ENABLE_CLIENT_HINTS")
+	config.StringEnv(&conf.ResultCacheDir, "IMGPROXY_RESULT_CACHE_DIR")
+	config.IntEnv(&conf.ResultCacheMaxSize, "IMGPROXY_RESULT_CACHE_MAX_SIZE")
 
-	imageTypesEnvConfig(&conf.SkipProcessingFormats, "IMGPROXY_SKIP_PROCESSING_FORMATS")
+	config.StringEnv(&conf.BaseURL, "IMGPROXY_BASE_URL")
 
-	boolEnvConfig(&conf.UseLinearColorspace, "IMGPROXY_USE_LINEAR_COLORSPACE")
-	boolEnvConfig(&conf.DisableShrinkOnLoad, "IMGPROXY_DISABLE_SHRINK_ON_LOAD")
+	config.StringSliceEnv(&conf.SourceStripQueryParams, "IMGPROXY_SOURCE_STRIP_QUERY_PARAMS")
+	config.BoolEnv(&conf.SourceSortQueryParams, "IMGPROXY_SOURCE_SORT_QUERY_PARAMS")
 
-	if err := hexEnvConfig(&conf.Keys, "IMGPROXY_KEY"); err != nil {
-		return err
+	config.StringEnv(&conf.PresetsPath, "IMGPROXY_PRESETS_PATH")
+	if len(*presetsPath) > 0 {
+		conf.PresetsPath = *presetsPath
 	}
-	if err := hexEnvConfig(&conf.Salts, "IMGPROXY_SALT"); err != nil {
-		return err
+	config.IntEnv(&conf.PresetsWatchInterval, "IMGPROXY_PRESETS_WATCH_INTERVAL")
+	if p, err := loadPresets(); err != nil {
+		errs = append(errs, err)
+	} else {
+		options.SetPresets(p)
 	}
-	intEnvConfig(&conf.SignatureSize, "IMGPROXY_SIGNATURE_SIZE")
+	config.BoolEnv(&conf.OnlyPresets, "IMGPROXY_ONLY_PRESETS")
 
-	if err := hexFileConfig(&conf.Keys, *keyPath); err != nil {
-		return err
-	}
-	if err := hexFileConfig(&conf.Salts, *saltPath); err != nil {
-		return err
-	}
+	config.StringEnv(&conf.TenantsPath, "IMGPROXY_TENANTS_PATH")
 
-	strEnvConfig(&conf.Secret, "IMGPROXY_SECRET")
+	config.BoolEnv(&conf.UsageAccounting, "IMGPROXY_USAGE_ACCOUNTING")
+	config.IntEnv(&conf.UsageQuotaRequests, "IMGPROXY_USAGE_QUOTA_REQUESTS")
+	config.IntEnv(&conf.UsageQuotaBytes, "IMGPROXY_USAGE_QUOTA_BYTES")
+	config.IntEnv(&conf.UsageQuotaPeriod, "IMGPROXY_USAGE_QUOTA_PERIOD")
+
+	config.StringEnv(&conf.WatermarkData, "IMGPROXY_WATERMARK_DATA")
+	config.StringEnv(&conf.WatermarkPath, "IMGPROXY_WATERMARK_PATH")
+	config.StringEnv(&conf.WatermarkURL, "IMGPROXY_WATERMARK_URL")
+	config.FloatEnv(&conf.WatermarkOpacity, "IMGPROXY_WATERMARK_OPACITY")
 
-	strEnvConfig(&conf.AllowOrigin, "IMGPROXY_ALLOW_ORIGIN")
+	config.StringSliceEnv(&conf.FontsPaths, "IMGPROXY_FONTS_PATHS")
+	config.StringEnv(&conf.CaptionFont, "IMGPROXY_CAPTION_FONT")
 
-	strEnvConfig(&conf.UserAgent, "IMGPROXY_USER_AGENT")
+	config.IntEnv(&conf.LqipMaxSize, "IMGPROXY_LQIP_MAX_SIZE")
+	config.IntEnv(&conf.LqipQuality, "IMGPROXY_LQIP_QUALITY")
+	config.IntEnv(&conf.LqipMaxBytes, "IMGPROXY_LQIP_MAX_BYTES")
+	config.FloatEnv(&conf.LqipBlur, "IMGPROXY_LQIP_BLUR")
 
-	boolEnvConfig(&conf.IgnoreSslVerification, "IMGPROXY_IGNORE_SSL_VERIFICATION")
-	boolEnvConfig(&conf.DevelopmentErrorsMode, "IMGPROXY_DEVELOPMENT_ERRORS_MODE")
+	config.StringEnv(&conf.FallbackImageData, "IMGPROXY_FALLBACK_IMAGE_DATA")
+	config.StringEnv(&conf.FallbackImagePath, "IMGPROXY_FALLBACK_IMAGE_PATH")
+	config.StringEnv(&conf.FallbackImageURL, "IMGPROXY_FALLBACK_IMAGE_URL")
+	config.IntEnv(&conf.FallbackImageRefreshInterval, "IMGPROXY_FALLBACK_IMAGE_REFRESH_INTERVAL")
+	config.IntEnv(&conf.FallbackImageHTTPCode, "IMGPROXY_FALLBACK_IMAGE_HTTP_CODE")
+	config.IntEnv(&conf.FallbackImageTTL, "IMGPROXY_FALLBACK_IMAGE_TTL")
+	config.StringSliceEnv(&conf.FallbackImageErrors, "IMGPROXY_FALLBACK_IMAGE_ERRORS")
+	config.BoolEnv(&conf.EnableFallbackImageHeader, "IMGPROXY_ENABLE_FALLBACK_IMAGE_HEADER")
+	config.BoolEnv(&conf.EnableAverageColorHeader, "IMGPROXY_ENABLE_AVERAGE_COLOR_HEADER")
 
-	strEnvConfig(&conf.LocalFileSystemRoot, "IMGPROXY_LOCAL_FILESYSTEM_ROOT")
+	if err := errorResponsesEnvConfig(&conf.ErrorResponses, "IMGPROXY_ERROR_RESPONSES"); err != nil {
+		errs = append(errs, err)
+	}
+
+	config.BoolEnv(&conf.DegradeOnAssetsFailure, "IMGPROXY_DEGRADE_ON_ASSETS_FAILURE")
+	config.IntEnv(&conf.AssetsRetryInterval, "IMGPROXY_ASSETS_RETRY_INTERVAL")
 
-	boolEnvConfig(&conf.S3Enabled, "IMGPROXY_USE_S3")
-	strEnvConfig(&conf.S3Region, "IMGPROXY_S3_REGION")
-	strEnvConfig(&conf.S3Endpoint, "IMGPROXY_S3_ENDPOINT")
+	config.StringEnv(&conf.NewRelicAppName, "IMGPROXY_NEW_RELIC_APP_NAME")
+	config.StringEnv(&conf.NewRelicKey, "IMGPROXY_NEW_RELIC_KEY")
 
-	boolEnvConfig(&conf.GCSEnabled, "IMGPROXY_USE_GCS")
-	strEnvConfig(&conf.GCSKey, "IMGPROXY_GCS_KEY")
+	config.StringEnv(&conf.PrometheusBind, "IMGPROXY_PROMETHEUS_BIND")
+	config.StringEnv(&conf.PrometheusNamespace, "IMGPROXY_PROMETHEUS_NAMESPACE")
 
-	boolEnvConfig(&conf.ETagEnabled, "IMGPROXY_USE_ETAG")
+	config.StringEnv(&conf.AdminBind, "IMGPROXY_ADMIN_BIND")
+	config.StringEnv(&conf.AdminSecret, "IMGPROXY_ADMIN_SECRET")
 
-	strEnvConfig(&conf.BaseURL, "IMGPROXY_BASE_URL")
+	config.BoolEnv(&conf.XRayEnable, "IMGPROXY_XRAY_ENABLE")
+	config.StringEnv(&conf.XRayName, "IMGPROXY_XRAY_NAME")
+	config.StringEnv(&conf.XRayDaemonAddress, "IMGPROXY_XRAY_DAEMON_ADDRESS")
 
-	if err := presetEnvConfig(conf.Presets, "IMGPROXY_PRESETS"); err != nil {
-		return err
-	}
-	if err := presetFileConfig(conf.Presets, *presetsPath); err != nil {
-		return err
+	config.StringEnv(&conf.BugsnagKey, "IMGPROXY_BUGSNAG_KEY")
+	config.StringEnv(&conf.BugsnagStage, "IMGPROXY_BUGSNAG_STAGE")
+	config.StringEnv(&conf.HoneybadgerKey, "IMGPROXY_HONEYBADGER_KEY")
+	config.StringEnv(&conf.HoneybadgerEnv, "IMGPROXY_HONEYBADGER_ENV")
+	config.StringEnv(&conf.SentryDSN, "IMGPROXY_SENTRY_DSN")
+	config.StringEnv(&conf.SentryEnvironment, "IMGPROXY_SENTRY_ENVIRONMENT")
+	config.StringEnv(&conf.SentryRelease, "IMGPROXY_SENTRY_RELEASE")
+	config.BoolEnv(&conf.ReportDownloadingErrors, "IMGPROXY_REPORT_DOWNLOADING_ERRORS")
+
+	config.IntEnv(&conf.FreeMemoryInterval, "IMGPROXY_FREE_MEMORY_INTERVAL")
+	config.IntEnv(&conf.MemoryRestartThreshold, "IMGPROXY_MEMORY_RESTART_THRESHOLD")
+	config.IntEnv(&conf.DownloadBufferSize, "IMGPROXY_DOWNLOAD_BUFFER_SIZE")
+	config.IntEnv(&conf.GZipBufferSize, "IMGPROXY_GZIP_BUFFER_SIZE")
+	config.IntEnv(&conf.ResponseBufferSize, "IMGPROXY_RESPONSE_BUFFER_SIZE")
+	config.IntEnv(&conf.BufferPoolCalibrationThreshold, "IMGPROXY_BUFFER_POOL_CALIBRATION_THRESHOLD")
+
+	config.IntEnv(&conf.VipsConcurrency, "IMGPROXY_VIPS_CONCURRENCY")
+	config.BoolEnv(&conf.VipsVectorEnabled, "IMGPROXY_VIPS_VECTOR_ENABLE")
+	config.IntEnv(&conf.VipsCacheMax, "IMGPROXY_VIPS_CACHE_MAX")
+	config.IntEnv(&conf.VipsCacheMaxMem, "IMGPROXY_VIPS_CACHE_MAX_MEM")
+	config.IntEnv(&conf.VipsCacheMaxFiles, "IMGPROXY_VIPS_CACHE_MAX_FILES")
+
+	// Limit libvips cache to a tenth of the container memory
+	// if the cache is enabled but its memory limit isn't set
+	if _, ok := os.LookupEnv("IMGPROXY_VIPS_CACHE_MAX_MEM"); !ok && conf.VipsCacheMax > 0 && containerMemLimit > 0 {
+		conf.VipsCacheMaxMem = int(containerMemLimit / 10)
+	}
+
+	switch conf.KeysProvider {
+	case "aws_secrets_manager":
+		if len(conf.KeysAWSSecretID) == 0 {
+			errs = append(errs, fmt.Errorf("IMGPROXY_KEYS_AWS_SECRET_ID must be set when IMGPROXY_KEYS_PROVIDER is aws_secrets_manager"))
+		}
+	case "vault":
+		if len(conf.KeysVaultAddress) == 0 || len(conf.KeysVaultPath) == 0 {
+			errs = append(errs, fmt.Errorf("IMGPROXY_KEYS_VAULT_ADDRESS and IMGPROXY_KEYS_VAULT_PATH must be set when IMGPROXY_KEYS_PROVIDER is vault"))
+		}
 	}
-	boolEnvConfig(&conf.OnlyPresets, "IMGPROXY_ONLY_PRESETS")
 
-	strEnvConfig(&conf.WatermarkData, "IMGPROXY_WATERMARK_DATA")
-	strEnvConfig(&conf.WatermarkPath, "IMGPROXY_WATERMARK_PATH")
-	strEnvConfig(&conf.WatermarkURL, "IMGPROXY_WATERMARK_URL")
-	floatEnvConfig(&conf.WatermarkOpacity, "IMGPROXY_WATERMARK_OPACITY")
+	if conf.PresetsWatchInterval < 0 {
+		errs = append(errs, fmt.Errorf("Presets watch interval should be greater than or equal to 0, now - %d\n", conf.PresetsWatchInterval))
+	}
 
-	strEnvConfig(&conf.FallbackImageData, "IMGPROXY_FALLBACK_IMAGE_DATA")
-	strEnvConfig(&conf.FallbackImagePath, "IMGPROXY_FALLBACK_IMAGE_PATH")
-	strEnvConfig(&conf.FallbackImageURL, "IMGPROXY_FALLBACK_IMAGE_URL")
+	if conf.UsageQuotaRequests < 0 {
+		errs = append(errs, fmt.Errorf("Usage requests quota should be greater than or equal to 0, now - %d\n", conf.UsageQuotaRequests))
+	}
 
-	strEnvConfig(&conf.NewRelicAppName, "IMGPROXY_NEW_RELIC_APP_NAME")
-	strEnvConfig(&conf.NewRelicKey, "IMGPROXY_NEW_RELIC_KEY")
+	if conf.UsageQuotaBytes < 0 {
+		errs = append(errs, fmt.Errorf("Usage bytes quota should be greater than or equal to 0, now - %d\n", conf.UsageQuotaBytes))
+	}
 
-	strEnvConfig(&conf.PrometheusBind, "IMGPROXY_PROMETHEUS_BIND")
-	strEnvConfig(&conf.PrometheusNamespace, "IMGPROXY_PROMETHEUS_NAMESPACE")
+	if conf.UsageQuotaPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("Usage quota period should be greater than 0, now - %d\n", conf.UsageQuotaPeriod))
+	}
 
-	strEnvConfig(&conf.BugsnagKey, "IMGPROXY_BUGSNAG_KEY")
-	strEnvConfig(&conf.BugsnagStage, "IMGPROXY_BUGSNAG_STAGE")
-	strEnvConfig(&conf.HoneybadgerKey, "IMGPROXY_HONEYBADGER_KEY")
-	strEnvConfig(&conf.HoneybadgerEnv, "IMGPROXY_HONEYBADGER_ENV")
-	strEnvConfig(&conf.SentryDSN, "IMGPROXY_SENTRY_DSN")
-	strEnvConfig(&conf.SentryEnvironment, "IMGPROXY_SENTRY_ENVIRONMENT")
-	strEnvConfig(&conf.SentryRelease, "IMGPROXY_SENTRY_RELEASE")
-	boolEnvConfig(&conf.ReportDownloadingErrors, "IMGPROXY_REPORT_DOWNLOADING_ERRORS")
+	if conf.KeysRefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("Keys refresh interval should be greater than or equal to 0, now - %d\n", conf.KeysRefreshInterval))
+	}
 
-	intEnvConfig(&conf.FreeMemoryInterval, "IMGPROXY_FREE_MEMORY_INTERVAL")
-	intEnvConfig(&conf.DownloadBufferSize, "IMGPROXY_DOWNLOAD_BUFFER_SIZE")
-	intEnvConfig(&conf.GZipBufferSize, "IMGPROXY_GZIP_BUFFER_SIZE")
-	intEnvConfig(&conf.BufferPoolCalibrationThreshold, "IMGPROXY_BUFFER_POOL_CALIBRATION_THRESHOLD")
+	if p, err := newKeysProvider(); err != nil {
+		errs = append(errs, err)
+	} else if p != nil {
+		if err = loadKeys(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
 	if len(conf.Keys) != len(conf.Salts) {
-		return fmt.Errorf("Number of keys and number of salts should be equal. Keys: %d, salts: %d", len(conf.Keys), len(conf.Salts))
+		errs = append(errs, fmt.Errorf("Number of keys and number of salts should be equal. Keys: %d, salts: %d", len(conf.Keys), len(conf.Salts)))
+	}
+	if len(conf.KeySources) > 0 && len(conf.KeySources) != len(conf.Keys) {
+		errs = append(errs, fmt.Errorf("Number of key sources and number of keys should be equal. Keys: %d, key sources: %d", len(conf.Keys), len(conf.KeySources)))
+	}
+	if len(conf.RemoteSignatureKeys) > 0 {
+		if conf.KeysProvider != "aws_secrets_manager" && conf.KeysProvider != "vault" {
+			errs = append(errs, fmt.Errorf("IMGPROXY_KEYS_PROVIDER must be aws_secrets_manager or vault when IMGPROXY_REMOTE_SIGNATURE_KEYS is set"))
+		}
+		if conf.RemoteSignatureConcurrency <= 0 {
+			errs = append(errs, fmt.Errorf("Remote signature concurrency should be greater than 0, now - %d\n", conf.RemoteSignatureConcurrency))
+		}
+		if conf.RemoteSignatureWait < 0 {
+			errs = append(errs, fmt.Errorf("Remote signature wait should be greater than or equal to 0, now - %d\n", conf.RemoteSignatureWait))
+		}
+		if conf.RemoteSignatureCacheSize <= 0 {
+			errs = append(errs, fmt.Errorf("Remote signature cache size should be greater than 0, now - %d\n", conf.RemoteSignatureCacheSize))
+		}
+		if conf.RemoteSignatureCacheTTL <= 0 {
+			errs = append(errs, fmt.Errorf("Remote signature cache TTL should be greater than 0, now - %d\n", conf.RemoteSignatureCacheTTL))
+		}
+	} else {
+		if len(conf.Keys) == 0 {
+			logWarning("No keys defined, so signature checking is disabled")
+			conf.AllowInsecure = true
+		}
+		if len(conf.Salts) == 0 {
+			logWarning("No salts defined, so signature checking is disabled")
+			conf.AllowInsecure = true
+		}
 	}
-	if len(conf.Keys) == 0 {
-		logWarning("No keys defined, so signature checking is disabled")
-		conf.AllowInsecure = true
+
+	if len(conf.SignatureAlgorithms) == 0 {
+		conf.SignatureAlgorithms = []string{"sha256"}
 	}
-	if len(conf.Salts) == 0 {
-		logWarning("No salts defined, so signature checking is disabled")
-		conf.AllowInsecure = true
+	for _, alg := range conf.SignatureAlgorithms {
+		if _, ok := signatureAlgorithms[alg]; !ok {
+			errs = append(errs, fmt.Errorf("Unknown signature algorithm: %s", alg))
+		}
 	}
 
 	if conf.SignatureSize < 1 || conf.SignatureSize > 32 {
-		return fmt.Errorf("Signature size should be within 1 and 32, now - %d\n", conf.SignatureSize)
+		errs = append(errs, fmt.Errorf("Signature size should be within 1 and 32, now - %d\n", conf.SignatureSize))
 	}
 
 	if len(conf.Bind) == 0 {
-		return fmt.Errorf("Bind address is not defined")
+		errs = append(errs, fmt.Errorf("Bind address is not defined"))
 	}
 
 	if conf.ReadTimeout <= 0 {
-		return fmt.Errorf("Read timeout should be greater than 0, now - %d\n", conf.ReadTimeout)
+		errs = append(errs, fmt.Errorf("Read timeout should be greater than 0, now - %d\n", conf.ReadTimeout))
 	}
 
 	if conf.WriteTimeout <= 0 {
-		return fmt.Errorf("Write timeout should be greater than 0, now - %d\n", conf.WriteTimeout)
+		errs = append(errs, fmt.Errorf("Write timeout should be greater than 0, now - %d\n", conf.WriteTimeout))
+	}
+
+	if conf.MaxTimeout == 0 {
+		conf.MaxTimeout = conf.WriteTimeout
+	} else if conf.MaxTimeout < conf.WriteTimeout {
+		errs = append(errs, fmt.Errorf("Max timeout should be greater than or equal to write timeout, now - %d\n", conf.MaxTimeout))
 	}
+
 	if conf.KeepAliveTimeout < 0 {
-		return fmt.Errorf("KeepAlive timeout should be greater than or equal to 0, now - %d\n", conf.KeepAliveTimeout)
+		errs = append(errs, fmt.Errorf("KeepAlive timeout should be greater than or equal to 0, now - %d\n", conf.KeepAliveTimeout))
 	}
 
 	if conf.DownloadTimeout <= 0 {
-		return fmt.Errorf("Download timeout should be greater than 0, now - %d\n", conf.DownloadTimeout)
+		errs = append(errs, fmt.Errorf("Download timeout should be greater than 0, now - %d\n", conf.DownloadTimeout))
+	}
+
+	if conf.DownloadRetries < 0 {
+		errs = append(errs, fmt.Errorf("Download retries number should be greater than or equal to 0, now - %d\n", conf.DownloadRetries))
+	}
+
+	if conf.DownloadRetryBackoff < 0 {
+		errs = append(errs, fmt.Errorf("Download retry backoff should be greater than or equal to 0, now - %d\n", conf.DownloadRetryBackoff))
+	}
+
+	if conf.MaxDownloadsPerHost < 0 {
+		errs = append(errs, fmt.Errorf("Max downloads per host should be greater than or equal to 0, now - %d\n", conf.MaxDownloadsPerHost))
+	}
+
+	if conf.MaxHostDownloadWait < 0 {
+		errs = append(errs, fmt.Errorf("Max host download wait should be greater than or equal to 0, now - %d\n", conf.MaxHostDownloadWait))
 	}
 
 	if conf.Concurrency <= 0 {
-		return fmt.Errorf("Concurrency should be greater than 0, now - %d\n", conf.Concurrency)
+		errs = append(errs, fmt.Errorf("Concurrency should be greater than 0, now - %d\n", conf.Concurrency))
 	}
 
 	if conf.MaxClients <= 0 {
 		conf.MaxClients = conf.Concurrency * 10
 	}
 
+	if conf.LowPriorityConcurrency == 0 {
+		conf.LowPriorityConcurrency = maxInt(conf.Concurrency/2, 1)
+	} else if conf.LowPriorityConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("Low priority concurrency should be greater than 0, now - %d\n", conf.LowPriorityConcurrency))
+	} else if conf.Concurrency > 0 && conf.LowPriorityConcurrency > conf.Concurrency {
+		errs = append(errs, fmt.Errorf("Low priority concurrency can't be greater than concurrency, now - %d\n", conf.LowPriorityConcurrency))
+	}
+
+	if conf.ETagMode != "body" && conf.ETagMode != "headers" {
+		errs = append(errs, fmt.Errorf("Unknown ETag mode: %s", conf.ETagMode))
+	}
+
+	if conf.ETagRevalidate && conf.ETagMode != "headers" {
+		logWarning("ETag revalidation works only in the headers ETag mode")
+	}
+
+	if len(conf.ResultCacheDir) > 0 && conf.ResultCacheMaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("Result cache max size should be greater than 0, now - %d\n", conf.ResultCacheMaxSize))
+	}
+
+	if conf.MaxQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("Max queue size should be greater than or equal to 0, now - %d\n", conf.MaxQueueSize))
+	}
+
+	if conf.MaxQueueWait < 0 {
+		errs = append(errs, fmt.Errorf("Max queue wait should be greater than or equal to 0, now - %d\n", conf.MaxQueueWait))
+	}
+
+	if conf.QueueRetryAfter <= 0 {
+		errs = append(errs, fmt.Errorf("Queue retry after should be greater than 0, now - %d\n", conf.QueueRetryAfter))
+	}
+
+	if err := validateRoutes(conf.BindRoutes); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, b := range conf.AdditionalBinds {
+		if err := validateRoutes(b.Routes); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if conf.BatchMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("Batch max size should be greater than or equal to 0, now - %d\n", conf.BatchMaxSize))
+	}
+
+	if conf.CollageMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("Collage max size should be greater than or equal to 0, now - %d\n", conf.CollageMaxSize))
+	}
+
+	if conf.UploadEnabled && len(conf.Secret) == 0 {
+		errs = append(errs, fmt.Errorf("IMGPROXY_SECRET must be set when IMGPROXY_ENABLE_UPLOAD is true"))
+	}
+
 	if conf.TTL <= 0 {
-		return fmt.Errorf("TTL should be greater than 0, now - %d\n", conf.TTL)
+		errs = append(errs, fmt.Errorf("TTL should be greater than 0, now - %d\n", conf.TTL))
 	}
 
 	if conf.MaxSrcDimension < 0 {
-		return fmt.Errorf("Max src dimension should be greater than or equal to 0, now - %d\n", conf.MaxSrcDimension)
+		errs = append(errs, fmt.Errorf("Max src dimension should be greater than or equal to 0, now - %d\n", conf.MaxSrcDimension))
 	} else if conf.MaxSrcDimension > 0 {
 		logWarning("IMGPROXY_MAX_SRC_DIMENSION is deprecated and can be removed in future versions. Use IMGPROXY_MAX_SRC_RESOLUTION")
 	}
 
 	if conf.MaxSrcResolution <= 0 {
-		return fmt.Errorf("Max src resolution should be greater than 0, now - %d\n", conf.MaxSrcResolution)
+		errs = append(errs, fmt.Errorf("Max src resolution should be greater than 0, now - %d\n", conf.MaxSrcResolution))
+	}
+
+	if conf.NonceTTL <= 0 {
+		errs = append(errs, fmt.Errorf("Nonce TTL should be greater than 0, now - %d\n", conf.NonceTTL))
+	}
+
+	if conf.NonceMemoryStoreSize <= 0 {
+		errs = append(errs, fmt.Errorf("Nonce memory store size should be greater than 0, now - %d\n", conf.NonceMemoryStoreSize))
+	}
+
+	if len(conf.TLSCertPath) > 0 && len(conf.TLSKeyPath) == 0 {
+		errs = append(errs, fmt.Errorf("IMGPROXY_TLS_KEY_PATH must be set when IMGPROXY_TLS_CERT_PATH is set"))
+	}
+	if len(conf.TLSKeyPath) > 0 && len(conf.TLSCertPath) == 0 {
+		errs = append(errs, fmt.Errorf("IMGPROXY_TLS_CERT_PATH must be set when IMGPROXY_TLS_KEY_PATH is set"))
+	}
+
+	if conf.CORSMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("CORS max age should be greater than or equal to 0, now - %d\n", conf.CORSMaxAge))
+	}
+
+	if conf.MaxResultDimension < 0 {
+		errs = append(errs, fmt.Errorf("Max result dimension should be greater than or equal to 0, now - %d\n", conf.MaxResultDimension))
+	}
+
+	if conf.MaxResultResolution < 0 {
+		errs = append(errs, fmt.Errorf("Max result resolution should be greater than or equal to 0, now - %d\n", conf.MaxResultResolution))
 	}
 
 	if conf.MaxSrcFileSize < 0 {
-		return fmt.Errorf("Max src file size should be greater than or equal to 0, now - %d\n", conf.MaxSrcFileSize)
+		errs = append(errs, fmt.Errorf("Max src file size should be greater than or equal to 0, now - %d\n", conf.MaxSrcFileSize))
 	}
 
 	if conf.MaxAnimationFrames <= 0 {
-		return fmt.Errorf("Max animation frames should be greater than 0, now - %d\n", conf.MaxAnimationFrames)
+		errs = append(errs, fmt.Errorf("Max animation frames should be greater than 0, now - %d\n", conf.MaxAnimationFrames))
+	}
+
+	if conf.MaxAnimationFramesMode != "truncate" && conf.MaxAnimationFramesMode != "reject" {
+		errs = append(errs, fmt.Errorf("Unknown max animation frames mode: %s", conf.MaxAnimationFramesMode))
+	}
+
+	if conf.MaxDecodeMemory < 0 {
+		errs = append(errs, fmt.Errorf("Max decode memory should be greater than or equal to 0, now - %d\n", conf.MaxDecodeMemory))
 	}
 
 	if conf.PngQuantizationColors < 2 {
-		return fmt.Errorf("Png quantization colors should be greater than 1, now - %d\n", conf.PngQuantizationColors)
+		errs = append(errs, fmt.Errorf("Png quantization colors should be greater than 1, now - %d\n", conf.PngQuantizationColors))
 	} else if conf.PngQuantizationColors > 256 {
-		return fmt.Errorf("Png quantization colors can't be greater than 256, now - %d\n", conf.PngQuantizationColors)
+		errs = append(errs, fmt.Errorf("Png quantization colors can't be greater than 256, now - %d\n", conf.PngQuantizationColors))
 	}
 
 	if conf.Quality <= 0 {
-		return fmt.Errorf("Quality should be greater than 0, now - %d\n", conf.Quality)
+		errs = append(errs, fmt.Errorf("Quality should be greater than 0, now - %d\n", conf.Quality))
 	} else if conf.Quality > 100 {
-		return fmt.Errorf("Quality can't be greater than 100, now - %d\n", conf.Quality)
+		errs = append(errs, fmt.Errorf("Quality can't be greater than 100, now - %d\n", conf.Quality))
+	}
+
+	if conf.PhotoQuality < 0 {
+		errs = append(errs, fmt.Errorf("Photo quality should be greater than or equal to 0, now - %d\n", conf.PhotoQuality))
+	} else if conf.PhotoQuality > 100 {
+		errs = append(errs, fmt.Errorf("Photo quality can't be greater than 100, now - %d\n", conf.PhotoQuality))
+	}
+
+	if conf.GraphicQuality < 0 {
+		errs = append(errs, fmt.Errorf("Graphic quality should be greater than or equal to 0, now - %d\n", conf.GraphicQuality))
+	} else if conf.GraphicQuality > 100 {
+		errs = append(errs, fmt.Errorf("Graphic quality can't be greater than 100, now - %d\n", conf.GraphicQuality))
+	}
+
+	if conf.PhotoFormat == imagetype.SVG || conf.PhotoFormat == imagetype.ICO {
+		errs = append(errs, fmt.Errorf("Photo format can't be %s\n", conf.PhotoFormat))
+	}
+
+	if conf.GraphicFormat == imagetype.SVG || conf.GraphicFormat == imagetype.ICO {
+		errs = append(errs, fmt.Errorf("Graphic format can't be %s\n", conf.GraphicFormat))
 	}
 
 	if conf.GZipCompression < 0 {
-		return fmt.Errorf("GZip compression should be greater than or equal to 0, now - %d\n", conf.GZipCompression)
+		errs = append(errs, fmt.Errorf("GZip compression should be greater than or equal to 0, now - %d\n", conf.GZipCompression))
 	} else if conf.GZipCompression > 9 {
-		return fmt.Errorf("GZip compression can't be greater than 9, now - %d\n", conf.GZipCompression)
+		errs = append(errs, fmt.Errorf("GZip compression can't be greater than 9, now - %d\n", conf.GZipCompression))
 	}
 
 	if conf.GZipCompression > 0 {
 		logWarning("GZip compression is deprecated and can be removed in future versions")
 	}
 
+	for name, path := range conf.ColorProfiles {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("Can't use %s color profile: %s", name, err))
+		}
+	}
+
+	if len(conf.ColorProfile) > 0 && !options.IsKnownColorProfile(conf.ColorProfile) {
+		errs = append(errs, fmt.Errorf("Unknown color profile: %s", conf.ColorProfile))
+	}
+
 	if conf.IgnoreSslVerification {
 		logWarning("Ignoring SSL verification is very unsafe")
 	}
@@ -522,11 +775,9 @@ func configure() error {
 		stat, err := os.Stat(conf.LocalFileSystemRoot)
 
 		if err != nil {
-			return fmt.Errorf("Cannot use local directory: %s", err)
-		}
-
-		if !stat.IsDir() {
-			return fmt.Errorf("Cannot use local directory: not a directory")
+			errs = append(errs, fmt.Errorf("Cannot use local directory: %s", err))
+		} else if !stat.IsDir() {
+			errs = append(errs, fmt.Errorf("Cannot use local directory: not a directory"))
 		}
 
 		if conf.LocalFileSystemRoot == "/" {
@@ -539,35 +790,132 @@ func configure() error {
 		conf.GCSEnabled = true
 	}
 
+	if conf.MaxDataURISize <= 0 {
+		errs = append(errs, fmt.Errorf("Max data URI size should be greater than 0, now - %d\n", conf.MaxDataURISize))
+	}
+
+	if conf.SFTPEnabled {
+		if len(conf.SFTPUser) == 0 {
+			errs = append(errs, fmt.Errorf("SFTP user is not defined"))
+		}
+		if len(conf.SFTPKeyPath) == 0 {
+			errs = append(errs, fmt.Errorf("SFTP key path is not defined"))
+		}
+		if len(conf.SFTPKnownHostsPath) == 0 && !conf.SFTPInsecureSkipHostKeyCheck {
+			errs = append(errs, fmt.Errorf("SFTP known hosts path is not defined"))
+		}
+	}
+
 	if conf.WatermarkOpacity <= 0 {
-		return fmt.Errorf("Watermark opacity should be greater than 0")
+		errs = append(errs, fmt.Errorf("Watermark opacity should be greater than 0"))
 	} else if conf.WatermarkOpacity > 1 {
-		return fmt.Errorf("Watermark opacity should be less than or equal to 1")
+		errs = append(errs, fmt.Errorf("Watermark opacity should be less than or equal to 1"))
+	}
+
+	if len(conf.CaptionFont) == 0 {
+		errs = append(errs, fmt.Errorf("Caption font can't be empty"))
+	}
+
+	if conf.LqipMaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("LQIP max size should be greater than 0, now - %d\n", conf.LqipMaxSize))
+	}
+
+	if conf.LqipQuality <= 0 {
+		errs = append(errs, fmt.Errorf("LQIP quality should be greater than 0, now - %d\n", conf.LqipQuality))
+	} else if conf.LqipQuality > 100 {
+		errs = append(errs, fmt.Errorf("LQIP quality can't be greater than 100, now - %d\n", conf.LqipQuality))
+	}
+
+	if conf.LqipMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("LQIP max bytes should be greater than or equal to 0, now - %d\n", conf.LqipMaxBytes))
+	}
+
+	if conf.LqipBlur < 0 {
+		errs = append(errs, fmt.Errorf("LQIP blur should be greater than or equal to 0, now - %f\n", conf.LqipBlur))
+	}
+
+	if conf.FallbackImageRefreshInterval < 0 {
+		errs = append(errs, fmt.Errorf("Fallback image refresh interval should be greater than or equal to 0, now - %d\n", conf.FallbackImageRefreshInterval))
+	}
+
+	if conf.FallbackImageHTTPCode != 0 && (conf.FallbackImageHTTPCode < 100 || conf.FallbackImageHTTPCode > 599) {
+		errs = append(errs, fmt.Errorf("Fallback image HTTP code should be between 100 and 599 or 0, now - %d\n", conf.FallbackImageHTTPCode))
+	}
+
+	if conf.FallbackImageTTL < 0 {
+		errs = append(errs, fmt.Errorf("Fallback image TTL should be greater than or equal to 0, now - %d\n", conf.FallbackImageTTL))
+	}
+
+	for _, class := range conf.FallbackImageErrors {
+		switch class {
+		case "4xx", "5xx", "timeout", "network", "too_big", "unsupported", "error":
+		default:
+			errs = append(errs, fmt.Errorf("Unknown fallback image error class: %s", class))
+		}
+	}
+
+	if conf.AssetsRetryInterval <= 0 {
+		errs = append(errs, fmt.Errorf("Assets retry interval should be greater than 0, now - %d\n", conf.AssetsRetryInterval))
 	}
 
 	if len(conf.PrometheusBind) > 0 && conf.PrometheusBind == conf.Bind {
-		return fmt.Errorf("Can't use the same binding for the main server and Prometheus")
+		errs = append(errs, fmt.Errorf("Can't use the same binding for the main server and Prometheus"))
+	}
+
+	if len(conf.AdminBind) > 0 {
+		if len(conf.AdminSecret) == 0 {
+			errs = append(errs, fmt.Errorf("IMGPROXY_ADMIN_SECRET must be set when IMGPROXY_ADMIN_BIND is set"))
+		}
+		if conf.AdminBind == conf.Bind || conf.AdminBind == conf.PrometheusBind {
+			errs = append(errs, fmt.Errorf("Can't use the same binding for the admin server and the main server or Prometheus"))
+		}
 	}
 
 	if conf.FreeMemoryInterval <= 0 {
-		return fmt.Errorf("Free memory interval should be greater than zero")
+		errs = append(errs, fmt.Errorf("Free memory interval should be greater than zero"))
+	}
+
+	if conf.MemoryRestartThreshold < 0 {
+		errs = append(errs, fmt.Errorf("Memory restart threshold should be greater than or equal to 0, now - %d\n", conf.MemoryRestartThreshold))
 	}
 
 	if conf.DownloadBufferSize < 0 {
-		return fmt.Errorf("Download buffer size should be greater than or equal to 0")
+		errs = append(errs, fmt.Errorf("Download buffer size should be greater than or equal to 0"))
 	} else if conf.DownloadBufferSize > math.MaxInt32 {
-		return fmt.Errorf("Download buffer size can't be greater than %d", math.MaxInt32)
+		errs = append(errs, fmt.Errorf("Download buffer size can't be greater than %d", math.MaxInt32))
 	}
 
 	if conf.GZipBufferSize < 0 {
-		return fmt.Errorf("GZip buffer size should be greater than or equal to 0")
+		errs = append(errs, fmt.Errorf("GZip buffer size should be greater than or equal to 0"))
 	} else if conf.GZipBufferSize > math.MaxInt32 {
-		return fmt.Errorf("GZip buffer size can't be greater than %d", math.MaxInt32)
+		errs = append(errs, fmt.Errorf("GZip buffer size can't be greater than %d", math.MaxInt32))
+	}
+
+	if conf.ResponseBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("Response buffer size should be greater than or equal to 0"))
+	} else if conf.ResponseBufferSize > math.MaxInt32 {
+		errs = append(errs, fmt.Errorf("Response buffer size can't be greater than %d", math.MaxInt32))
 	}
 
 	if conf.BufferPoolCalibrationThreshold < 64 {
-		return fmt.Errorf("Buffer pool calibration threshold should be greater than or equal to 64")
+		errs = append(errs, fmt.Errorf("Buffer pool calibration threshold should be greater than or equal to 64"))
 	}
 
-	return nil
+	if conf.VipsConcurrency <= 0 {
+		errs = append(errs, fmt.Errorf("Vips concurrency should be greater than 0, now - %d\n", conf.VipsConcurrency))
+	}
+
+	if conf.VipsCacheMax < 0 {
+		errs = append(errs, fmt.Errorf("Vips cache max should be greater than or equal to 0, now - %d\n", conf.VipsCacheMax))
+	}
+
+	if conf.VipsCacheMaxMem < 0 {
+		errs = append(errs, fmt.Errorf("Vips cache max mem should be greater than or equal to 0, now - %d\n", conf.VipsCacheMaxMem))
+	}
+
+	if conf.VipsCacheMaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("Vips cache max files should be greater than or equal to 0, now - %d\n", conf.VipsCacheMaxFiles))
+	}
+
+	return
 }
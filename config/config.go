@@ -0,0 +1,325 @@
+// Package config holds imgproxy's runtime configuration.
+package config
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+)
+
+// Version is the imgproxy version.
+const Version = "2.15.0"
+
+// SecurityKey is a key or a salt used for URL signing.
+type SecurityKey []byte
+
+// BindConfig describes an additional address imgproxy listens on and the
+// routes served there.
+type BindConfig struct {
+	Address string
+	Routes  []string
+}
+
+// Config is imgproxy's configuration.
+type Config struct {
+	Network                string
+	Bind                   string
+	ReadTimeout            int
+	WriteTimeout           int
+	MaxTimeout             int
+	KeepAliveTimeout       int
+	DownloadTimeout        int
+	DownloadRetries        int
+	DownloadRetryBackoff   int
+	MaxDownloadsPerHost    int
+	MaxHostDownloadWait    int
+	Concurrency            int
+	MaxClients             int
+	LowPriorityConcurrency int
+	MaxQueueSize           int
+	MaxQueueWait           int
+	QueueRetryAfter        int
+	BatchMaxSize           int
+	CollageMaxSize         int
+	UploadEnabled          bool
+	InfoEnabled            bool
+	PlaceholdersEnabled    bool
+	InfoExif               bool
+	InfoExifGPS            bool
+	InfoPerceptualHashes   bool
+
+	TTL                     int
+	CacheControlPassthrough bool
+	SurrogateKeyHeaders     []string
+
+	SoReuseport bool
+
+	BindRoutes      []string
+	AdditionalBinds []BindConfig
+
+	TLSCertPath string
+	TLSKeyPath  string
+
+	PathPrefix string
+
+	MaxSrcDimension        int
+	MaxSrcResolution       int
+	MaxSrcFileSize         int
+	MaxAnimationFrames     int
+	MaxAnimationFramesMode string
+	MaxSvgCheckBytes       int
+	MaxDecodeMemory        int
+
+	MaxResultDimension  int
+	MaxResultResolution int
+
+	JpegProgressive       bool
+	PngInterlaced         bool
+	PngQuantize           bool
+	PngQuantizationColors int
+	Quality               int
+	GZipCompression       int
+	StripMetadata         bool
+
+	EnableWebpDetection bool
+	EnforceWebp         bool
+	EnableClientHints   bool
+
+	EnableContentClassification bool
+	PhotoQuality                int
+	PhotoFormat                 imagetype.Type
+	GraphicQuality              int
+	GraphicFormat               imagetype.Type
+
+	SkipProcessingFormats []imagetype.Type
+
+	UseLinearColorspace bool
+	DisableShrinkOnLoad bool
+
+	ColorProfiles map[string]string
+	ColorProfile  string
+
+	Keys          []SecurityKey
+	Salts         []SecurityKey
+	KeySources    [][]string
+	AllowInsecure bool
+	SignatureSize int
+
+	SignatureAlgorithms   []string
+	AllowSignatureInQuery bool
+	EnableQueryOptions    bool
+
+	RemoteSignatureKeys        map[string]string
+	RemoteSignatureConcurrency int
+	RemoteSignatureWait        int
+	RemoteSignatureCacheSize   int
+	RemoteSignatureCacheTTL    int
+
+	KeyPath             string
+	SaltPath            string
+	KeysProvider        string
+	KeysAWSSecretID     string
+	KeysVaultAddress    string
+	KeysVaultToken      string
+	KeysVaultPath       string
+	KeysRefreshInterval int
+
+	JWTSecret        string
+	JWTPublicKeyPath string
+
+	NonceStore           string
+	NonceRequired        bool
+	NonceTTL             int
+	NonceMemoryStoreSize int
+	NonceRedisURL        string
+
+	AuditLogEnabled bool
+	AuditLogPath    string
+
+	Secret       string
+	HealthSecret string
+
+	AllowedReferers   []string
+	AllowEmptyReferer bool
+
+	AllowOrigin  []string
+	AllowMethods string
+	AllowHeaders string
+	CORSMaxAge   int
+
+	UserAgent string
+
+	IgnoreSslVerification bool
+	DevelopmentErrorsMode bool
+
+	AllowedSources             []string
+	AllowedSourceContentTypes  []string
+	DenyPrivateSourceAddresses bool
+	AllowedSourceNetworks      []*net.IPNet
+
+	LocalFileSystemRoot          string
+	S3Enabled                    bool
+	S3Region                     string
+	S3Endpoint                   string
+	GCSEnabled                   bool
+	GCSKey                       string
+	DataURIEnabled               bool
+	MaxDataURISize               int
+	SFTPEnabled                  bool
+	SFTPUser                     string
+	SFTPKeyPath                  string
+	SFTPKnownHostsPath           string
+	SFTPInsecureSkipHostKeyCheck bool
+	SFTPAllowedHosts             []string
+
+	ETagEnabled    bool
+	ETagMode       string
+	ETagRevalidate bool
+	BufferResponse bool
+
+	ResultCacheDir     string
+	ResultCacheMaxSize int
+
+	BaseURL string
+
+	SourceStripQueryParams []string
+	SourceSortQueryParams  bool
+
+	PresetsPath          string
+	PresetsWatchInterval int
+	OnlyPresets          bool
+
+	TenantsPath string
+
+	UsageAccounting    bool
+	UsageQuotaRequests int
+	UsageQuotaBytes    int
+	UsageQuotaPeriod   int
+
+	WatermarkData    string
+	WatermarkPath    string
+	WatermarkURL     string
+	WatermarkOpacity float64
+
+	FontsPaths  []string
+	CaptionFont string
+
+	LqipMaxSize  int
+	LqipQuality  int
+	LqipMaxBytes int
+	LqipBlur     float64
+
+	FallbackImageData string
+	FallbackImagePath string
+	FallbackImageURL  string
+
+	FallbackImageRefreshInterval int
+	FallbackImageHTTPCode        int
+	FallbackImageTTL             int
+	FallbackImageErrors          []string
+
+	EnableFallbackImageHeader bool
+	EnableAverageColorHeader  bool
+
+	ErrorResponses map[string]string
+
+	DegradeOnAssetsFailure bool
+	AssetsRetryInterval    int
+
+	NewRelicAppName string
+	NewRelicKey     string
+
+	PrometheusBind      string
+	PrometheusNamespace string
+
+	AdminBind   string
+	AdminSecret string
+
+	XRayEnable        bool
+	XRayName          string
+	XRayDaemonAddress string
+
+	BugsnagKey        string
+	BugsnagStage      string
+	HoneybadgerKey    string
+	HoneybadgerEnv    string
+	SentryDSN         string
+	SentryEnvironment string
+	SentryRelease     string
+
+	ReportDownloadingErrors bool
+
+	FreeMemoryInterval             int
+	MemoryRestartThreshold         int
+	DownloadBufferSize             int
+	GZipBufferSize                 int
+	ResponseBufferSize             int
+	BufferPoolCalibrationThreshold int
+
+	VipsConcurrency   int
+	VipsVectorEnabled bool
+	VipsCacheMax      int
+	VipsCacheMaxMem   int
+	VipsCacheMaxFiles int
+
+	DetectContainerLimits bool
+}
+
+// Conf is the configuration in use. It starts with the defaults.
+var Conf = Config{
+	Network:                        "tcp",
+	AllowMethods:                   "GET, OPTIONS",
+	AllowEmptyReferer:              true,
+	NonceTTL:                       86400,
+	RemoteSignatureConcurrency:     8,
+	RemoteSignatureWait:            1000,
+	RemoteSignatureCacheSize:       10000,
+	RemoteSignatureCacheTTL:        3600,
+	NonceMemoryStoreSize:           100000,
+	NonceRedisURL:                  "redis://localhost:6379/0",
+	Bind:                           ":8080",
+	ReadTimeout:                    10,
+	WriteTimeout:                   10,
+	KeepAliveTimeout:               10,
+	DownloadTimeout:                5,
+	DownloadRetryBackoff:           100,
+	MaxHostDownloadWait:            1000,
+	Concurrency:                    runtime.NumCPU() * 2,
+	QueueRetryAfter:                1,
+	TTL:                            3600,
+	MaxSrcResolution:               16800000,
+	MaxAnimationFrames:             1,
+	MaxAnimationFramesMode:         "truncate",
+	MaxSvgCheckBytes:               32 * 1024,
+	MaxDataURISize:                 64 * 1024,
+	SignatureSize:                  32,
+	PngQuantizationColors:          256,
+	Quality:                        80,
+	StripMetadata:                  true,
+	UserAgent:                      fmt.Sprintf("imgproxy/%s", Version),
+	ETagMode:                       "body",
+	ResultCacheMaxSize:             1024,
+	PresetsWatchInterval:           5,
+	UsageQuotaPeriod:               86400,
+	WatermarkOpacity:               1,
+	CaptionFont:                    "sans",
+	LqipMaxSize:                    32,
+	LqipQuality:                    30,
+	LqipMaxBytes:                   0,
+	LqipBlur:                       1.5,
+	XRayName:                       "imgproxy",
+	BugsnagStage:                   "production",
+	HoneybadgerEnv:                 "production",
+	SentryEnvironment:              "production",
+	SentryRelease:                  fmt.Sprintf("imgproxy/%s", Version),
+	ReportDownloadingErrors:        true,
+	FallbackImageHTTPCode:          200,
+	AssetsRetryInterval:            30,
+	FreeMemoryInterval:             10,
+	BufferPoolCalibrationThreshold: 1024,
+	VipsConcurrency:                1,
+	VipsCacheMaxFiles:              100,
+	DetectContainerLimits:          true,
+}
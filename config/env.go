@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/sirupsen/logrus"
+)
+
+// IntEnv sets i to the integer value of the env var if it is set and valid
+func IntEnv(i *int, name string) {
+	if env, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		*i = env
+	}
+}
+
+// FloatEnv sets i to the float value of the env var if it is set and valid
+func FloatEnv(i *float64, name string) {
+	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		*i = env
+	}
+}
+
+// MegaIntEnv sets f to the value of the env var multiplied by a million.
+// Used for the resolution options that are set in megapixels
+func MegaIntEnv(f *int, name string) {
+	if env, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		*f = int(env * 1000000)
+	}
+}
+
+// StringEnv sets s to the value of the env var if it is not empty
+func StringEnv(s *string, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		*s = env
+	}
+}
+
+// StringSliceEnv sets s to the comma-separated values of the env var.
+// s is emptied if the env var is not set
+func StringSliceEnv(s *[]string, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		for i, p := range parts {
+			parts[i] = strings.TrimSpace(p)
+		}
+
+		*s = parts
+
+		return
+	}
+
+	*s = []string{}
+}
+
+// CIDRSliceEnv sets s to the comma-separated CIDR notated networks of the env var
+func CIDRSliceEnv(s *[]*net.IPNet, name string) error {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		nets := make([]*net.IPNet, len(parts))
+
+		for i, part := range parts {
+			_, n, err := net.ParseCIDR(strings.TrimSpace(part))
+			if err != nil {
+				return fmt.Errorf("%s expected to be CIDR notated networks. Invalid: %s\n", name, part)
+			}
+			nets[i] = n
+		}
+
+		*s = nets
+	}
+
+	return nil
+}
+
+// KeySourcesEnv sets s to the allowed sources of the signature keys. The keys
+// are separated by commas, and the sources of a key are separated by pipes
+func KeySourcesEnv(s *[][]string, name string) {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		sources := make([][]string, len(parts))
+
+		for i, part := range parts {
+			sources[i] = []string{}
+
+			for _, src := range strings.Split(part, "|") {
+				if src = strings.TrimSpace(src); len(src) > 0 {
+					sources[i] = append(sources[i], src)
+				}
+			}
+		}
+
+		*s = sources
+	}
+}
+
+// BindsEnv sets s to the additional binds of the env var. The binds are
+// separated by commas and look like `address=route1|route2`
+func BindsEnv(s *[]BindConfig, name string) error {
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		binds := make([]BindConfig, len(parts))
+
+		for i, part := range parts {
+			kv := strings.SplitN(part, "=", 2)
+
+			binds[i].Address = strings.TrimSpace(kv[0])
+			binds[i].Routes = []string{}
+
+			if len(binds[i].Address) == 0 {
+				return fmt.Errorf("Invalid bind: %s", part)
+			}
+
+			if len(kv) < 2 {
+				continue
+			}
+
+			for _, route := range strings.Split(kv[1], "|") {
+				if route = strings.TrimSpace(route); len(route) > 0 {
+					binds[i].Routes = append(binds[i].Routes, route)
+				}
+			}
+		}
+
+		*s = binds
+	}
+
+	return nil
+}
+
+// BoolEnv sets b to the boolean value of the env var if it is set and valid
+func BoolEnv(b *bool, name string) {
+	if env, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		*b = env
+	}
+}
+
+// ImageTypesEnv sets it to the comma-separated image formats of the env var.
+// Unknown formats are skipped with a warning
+func ImageTypesEnv(it *[]imagetype.Type, name string) {
+	*it = []imagetype.Type{}
+
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		for _, p := range parts {
+			pt := strings.TrimSpace(p)
+			if t, ok := imagetype.Types[pt]; ok {
+				*it = append(*it, t)
+			} else {
+				logrus.Warningf("Unknown image format to skip: %s", pt)
+			}
+		}
+	}
+}
+
+func ImageTypeEnv(it *imagetype.Type, name string) error {
+	if env := strings.TrimSpace(os.Getenv(name)); len(env) > 0 {
+		t, ok := imagetype.Types[env]
+		if !ok {
+			return fmt.Errorf("%s expected to be an image format. Invalid: %s\n", name, env)
+		}
+
+		*it = t
+	}
+
+	return nil
+}
+
+// HexEnv sets b to the comma-separated hex-encoded keys of the env var
+func HexEnv(b *[]SecurityKey, name string) error {
+	var err error
+
+	if env := os.Getenv(name); len(env) > 0 {
+		parts := strings.Split(env, ",")
+
+		keys := make([]SecurityKey, len(parts))
+
+		for i, part := range parts {
+			if keys[i], err = hex.DecodeString(part); err != nil {
+				return fmt.Errorf("%s expected to be hex-encoded strings. Invalid: %s\n", name, part)
+			}
+		}
+
+		*b = keys
+	}
+
+	return nil
+}
+
+// HexFile sets b to the hex-encoded keys read line by line from the file
+func HexFile(b *[]SecurityKey, filepath string) error {
+	if len(filepath) == 0 {
+		return nil
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return fmt.Errorf("Can't open file %s\n", filepath)
+	}
+	defer f.Close()
+
+	keys := []SecurityKey{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		part := scanner.Text()
+
+		if len(part) == 0 {
+			continue
+		}
+
+		if key, err := hex.DecodeString(part); err == nil {
+			keys = append(keys, key)
+		} else {
+			return fmt.Errorf("%s expected to contain hex-encoded strings. Invalid: %s\n", filepath, part)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Failed to read file %s: %s", filepath, err)
+	}
+
+	*b = keys
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const testEnvName = "IMGPROXY_TEST_ENV"
+
+type EnvTestSuite struct{ suite.Suite }
+
+func (s *EnvTestSuite) TearDownTest() {
+	os.Unsetenv(testEnvName)
+}
+
+func (s *EnvTestSuite) TestIntEnv() {
+	i := 10
+
+	IntEnv(&i, testEnvName)
+	assert.Equal(s.T(), 10, i)
+
+	os.Setenv(testEnvName, "invalid")
+	IntEnv(&i, testEnvName)
+	assert.Equal(s.T(), 10, i)
+
+	os.Setenv(testEnvName, "20")
+	IntEnv(&i, testEnvName)
+	assert.Equal(s.T(), 20, i)
+}
+
+func (s *EnvTestSuite) TestMegaIntEnv() {
+	i := 0
+
+	os.Setenv(testEnvName, "16.8")
+	MegaIntEnv(&i, testEnvName)
+
+	assert.Equal(s.T(), 16800000, i)
+}
+
+func (s *EnvTestSuite) TestBoolEnv() {
+	b := true
+
+	os.Setenv(testEnvName, "false")
+	BoolEnv(&b, testEnvName)
+
+	assert.False(s.T(), b)
+}
+
+func (s *EnvTestSuite) TestStringSliceEnv() {
+	sl := []string{"old"}
+
+	os.Setenv(testEnvName, "a, b ,c")
+	StringSliceEnv(&sl, testEnvName)
+	assert.Equal(s.T(), []string{"a", "b", "c"}, sl)
+
+	os.Unsetenv(testEnvName)
+	StringSliceEnv(&sl, testEnvName)
+	assert.Empty(s.T(), sl)
+}
+
+func (s *EnvTestSuite) TestCIDRSliceEnv() {
+	var nets []*net.IPNet
+
+	os.Setenv(testEnvName, "10.0.0.0/8, 192.168.0.0/16")
+	err := CIDRSliceEnv(&nets, testEnvName)
+
+	require.Nil(s.T(), err)
+	require.Len(s.T(), nets, 2)
+	assert.Equal(s.T(), "10.0.0.0/8", nets[0].String())
+	assert.Equal(s.T(), "192.168.0.0/16", nets[1].String())
+}
+
+func (s *EnvTestSuite) TestCIDRSliceEnvInvalid() {
+	var nets []*net.IPNet
+
+	os.Setenv(testEnvName, "10.0.0.0/8,10.0.0.1")
+	err := CIDRSliceEnv(&nets, testEnvName)
+
+	assert.Error(s.T(), err)
+	assert.Nil(s.T(), nets)
+}
+
+func (s *EnvTestSuite) TestKeySourcesEnv() {
+	var sources [][]string
+
+	os.Setenv(testEnvName, "http://a.dev/|s3://b/,,local://")
+	KeySourcesEnv(&sources, testEnvName)
+
+	assert.Equal(s.T(), [][]string{
+		{"http://a.dev/", "s3://b/"},
+		{},
+		{"local://"},
+	}, sources)
+}
+
+func (s *EnvTestSuite) TestBindsEnv() {
+	var binds []BindConfig
+
+	os.Setenv(testEnvName, ":8081=/health|/metrics, :8082")
+	err := BindsEnv(&binds, testEnvName)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), []BindConfig{
+		{Address: ":8081", Routes: []string{"/health", "/metrics"}},
+		{Address: ":8082", Routes: []string{}},
+	}, binds)
+}
+
+func (s *EnvTestSuite) TestBindsEnvEmptyAddress() {
+	var binds []BindConfig
+
+	os.Setenv(testEnvName, "=/health")
+	err := BindsEnv(&binds, testEnvName)
+
+	assert.Error(s.T(), err)
+}
+
+func (s *EnvTestSuite) TestImageTypesEnv() {
+	var types []imagetype.Type
+
+	os.Setenv(testEnvName, "png, unknown,webp")
+	ImageTypesEnv(&types, testEnvName)
+
+	assert.Equal(s.T(), []imagetype.Type{imagetype.PNG, imagetype.WEBP}, types)
+}
+
+func (s *EnvTestSuite) TestHexEnv() {
+	var keys []SecurityKey
+
+	os.Setenv(testEnvName, "74657374,6b6579")
+	err := HexEnv(&keys, testEnvName)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), []SecurityKey{SecurityKey("test"), SecurityKey("key")}, keys)
+}
+
+func (s *EnvTestSuite) TestHexEnvInvalid() {
+	var keys []SecurityKey
+
+	os.Setenv(testEnvName, "74657374,key")
+	err := HexEnv(&keys, testEnvName)
+
+	assert.Error(s.T(), err)
+	assert.Nil(s.T(), keys)
+}
+
+func (s *EnvTestSuite) TestHexFile() {
+	f, err := ioutil.TempFile("", "keys")
+	require.Nil(s.T(), err)
+	defer os.Remove(f.Name())
+
+	f.WriteString("74657374\n\n6b6579\n")
+	f.Close()
+
+	var keys []SecurityKey
+
+	err = HexFile(&keys, f.Name())
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), []SecurityKey{SecurityKey("test"), SecurityKey("key")}, keys)
+}
+
+func (s *EnvTestSuite) TestHexFileNotFound() {
+	var keys []SecurityKey
+
+	err := HexFile(&keys, "/nonexistent/keys")
+
+	assert.Error(s.T(), err)
+}
+
+func TestEnv(t *testing.T) {
+	suite.Run(t, new(EnvTestSuite))
+}
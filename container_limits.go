@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// Values above this are used by cgroup v1 to mark the absence of a limit
+const cgroupUnlimited = int64(1) << 60
+
+func readCgroupFile(path ...string) (string, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(append([]string{cgroupRoot}, path...)...))
+	if err != nil {
+		return "", false
+	}
+
+	return strings.TrimSpace(string(data)), true
+}
+
+func calcCPULimit(quota, period int64) int {
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+
+	// Round up, so 1.5 CPUs quota gives us 2 CPUs
+	return int((quota + period - 1) / period)
+}
+
+// containerCPULimit returns the number of CPUs available to the container
+// according to the cgroup CPU quota. Returns 0 if there's no limit
+func containerCPULimit() int {
+	// cgroup v2
+	if data, ok := readCgroupFile("cpu.max"); ok {
+		fields := strings.Fields(data)
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+
+		quota, _ := strconv.ParseInt(fields[0], 10, 64)
+		period, _ := strconv.ParseInt(fields[1], 10, 64)
+
+		return calcCPULimit(quota, period)
+	}
+
+	// cgroup v1
+	quotaData, ok := readCgroupFile("cpu", "cpu.cfs_quota_us")
+	if !ok {
+		return 0
+	}
+
+	periodData, ok := readCgroupFile("cpu", "cpu.cfs_period_us")
+	if !ok {
+		return 0
+	}
+
+	quota, _ := strconv.ParseInt(quotaData, 10, 64)
+	period, _ := strconv.ParseInt(periodData, 10, 64)
+
+	return calcCPULimit(quota, period)
+}
+
+// containerMemoryLimit returns the memory limit of the container in bytes.
+// Returns 0 if there's no limit
+func containerMemoryLimit() int64 {
+	data, ok := readCgroupFile("memory.max")
+	if !ok {
+		data, ok = readCgroupFile("memory", "memory.limit_in_bytes")
+	}
+	if !ok || data == "max" {
+		return 0
+	}
+
+	limit, err := strconv.ParseInt(data, 10, 64)
+	if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+		return 0
+	}
+
+	return limit
+}
+
+// applyContainerLimits adjusts GOMAXPROCS and default concurrency to the container
+// CPU limit, since the number of CPUs reported by the runtime is the number of host CPUs.
+// Returns the container memory limit
+func applyContainerLimits() int64 {
+	if cpus := containerCPULimit(); cpus > 0 && cpus < runtime.NumCPU() {
+		if len(os.Getenv("GOMAXPROCS")) == 0 {
+			runtime.GOMAXPROCS(cpus)
+		}
+
+		conf.Concurrency = cpus * 2
+
+		logNotice("Container CPU limit detected: %d CPUs", cpus)
+	}
+
+	memLimit := containerMemoryLimit()
+	if memLimit > 0 {
+		logNotice("Container memory limit detected: %d MB", memLimit/1024/1024)
+	}
+
+	return memLimit
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+const (
+	contentClassSampleSize = 128
+
+	// Images with this number of distinct colors or less are treated
+	// as graphics
+	contentClassGraphicMaxColors = 512
+	// Flat images with this number of distinct colors or less are treated
+	// as graphics. Flat images have most of the neighbour pixels equal
+	contentClassFlatMaxColors = 2048
+	contentClassFlatMinRatio  = 0.6
+)
+
+// classifyImageData checks if the source image is a photo or a flat graphic.
+// The second returned value shows if the image has an alpha channel.
+// Animated and vector images are not classified
+func classifyImageData(imgdata *imagedata.ImageData) (options.ContentClass, bool, error) {
+	if imgdata.Type == imagetype.SVG || imgdata.Type == imagetype.ICO || !vips.SupportsLoad(imgdata.Type) {
+		return options.ContentClassUnknown, false, nil
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	pages := 1
+	if vips.SupportsAnimation(imgdata.Type) {
+		pages = -1
+	}
+
+	shrink := 1
+	if imgdata.Type == imagetype.JPEG {
+		// The sample is small, so we can use the fastest shrink-on-load
+		shrink = 8
+	}
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	if err := img.Load(imgdata.Data, imgdata.Type, shrink, 1.0, pages); err != nil {
+		return options.ContentClassUnknown, false, err
+	}
+
+	if img.IsAnimated() {
+		return options.ContentClassUnknown, false, nil
+	}
+
+	pixels, width, height, err := img.RGBSample(contentClassSampleSize)
+	if err != nil {
+		return options.ContentClassUnknown, false, err
+	}
+
+	return classifyPixels(pixels, width, height), img.HasAlpha(), nil
+}
+
+// classifyPixels classifies the RGB pixels by the number of distinct colors
+// and the ratio of pixels equal to their right neighbour. Photos have
+// a lot of colors and noise, while flat graphics have large solid areas
+func classifyPixels(pixels []byte, width, height int) options.ContentClass {
+	if width < 2 || height < 1 || len(pixels) < width*height*3 {
+		return options.ContentClassUnknown
+	}
+
+	// Colors are reduced to 15 bits, so the slight noise of the lossy
+	// compressed graphics doesn't make them look like photos
+	var colors [1 << 15]bool
+
+	distinct := 0
+	flat := 0
+
+	for y := 0; y < height; y++ {
+		row := pixels[y*width*3 : (y+1)*width*3]
+
+		for x := 0; x < width; x++ {
+			p := row[x*3 : x*3+3]
+
+			c := int(p[0]>>3)<<10 | int(p[1]>>3)<<5 | int(p[2]>>3)
+			if !colors[c] {
+				colors[c] = true
+				distinct++
+			}
+
+			if x > 0 && p[0] == row[x*3-3] && p[1] == row[x*3-2] && p[2] == row[x*3-1] {
+				flat++
+			}
+		}
+	}
+
+	flatRatio := float64(flat) / float64((width-1)*height)
+
+	// Small images can't have a lot of colors, so the limits are lowered for them
+	total := width * height
+
+	if distinct <= minInt(contentClassGraphicMaxColors, total/8) ||
+		(flatRatio >= contentClassFlatMinRatio && distinct <= minInt(contentClassFlatMaxColors, total/2)) {
+		return options.ContentClassGraphic
+	}
+
+	return options.ContentClassPhoto
+}
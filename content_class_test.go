@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ContentClassTestSuite struct{ MainTestSuite }
+
+func (s *ContentClassTestSuite) TestClassifyFlatGraphic() {
+	pixels := testutil.GenPixels(128, 128, 3, func(x, y int) []byte {
+		if x < 64 {
+			return []byte{255, 255, 255}
+		}
+		return []byte{200, 30, 30}
+	})
+
+	assert.Equal(s.T(), options.ContentClassGraphic, classifyPixels(pixels, 128, 128))
+}
+
+func (s *ContentClassTestSuite) TestClassifyGradientGraphic() {
+	// A smooth gradient has a lot of colors, but neighbour pixels are mostly equal
+	pixels := testutil.GenPixels(128, 128, 3, func(x, y int) []byte {
+		return []byte{byte(y * 2), byte(x / 16 * 30), 128}
+	})
+
+	assert.Equal(s.T(), options.ContentClassGraphic, classifyPixels(pixels, 128, 128))
+}
+
+func (s *ContentClassTestSuite) TestClassifyNoisyPhoto() {
+	seed := uint32(42)
+
+	pixels := testutil.GenPixels(128, 128, 3, func(x, y int) []byte {
+		seed = seed*1664525 + 1013904223
+		return []byte{byte(seed >> 24), byte(seed >> 16), byte(seed >> 8)}
+	})
+
+	assert.Equal(s.T(), options.ContentClassPhoto, classifyPixels(pixels, 128, 128))
+}
+
+func (s *ContentClassTestSuite) TestClassifyTooSmall() {
+	pixels := testutil.GenPixels(1, 10, 3, func(x, y int) []byte { return []byte{0, 0, 0} })
+
+	assert.Equal(s.T(), options.ContentClassUnknown, classifyPixels(pixels, 1, 10))
+}
+
+func TestContentClass(t *testing.T) {
+	suite.Run(t, new(ContentClassTestSuite))
+}
@@ -3,8 +3,14 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"errors"
+	"hash"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"golang.org/x/crypto/blake2b"
 )
 
 var (
@@ -12,30 +18,107 @@ var (
 	errInvalidSignatureEncoding = errors.New("Invalid signature encoding")
 )
 
-type securityKey []byte
+func newBlake2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+var signatureAlgorithms = map[string]func() hash.Hash{
+	"sha256":     sha256.New,
+	"sha512/256": sha512.New512_256,
+	"blake2b":    newBlake2b256,
+}
+
+// validatePath checks the signature and returns the index of the matched key/salt pair.
+// Signatures calculated with remote keys are prefixed with the key ID: "%key_id.%signature".
+// They have no key/salt pair, so -1 is returned for them.
+func validatePath(signature, path string) (int, error) {
+	var keyID string
+
+	// Base64 URL-safe alphabet has no dots, so local signatures can't be
+	// mistaken for the remote ones
+	if ind := strings.IndexByte(signature, '.'); ind >= 0 {
+		keyID, signature = signature[:ind], signature[ind+1:]
+	}
+
+	messageMAC, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return -1, errInvalidSignatureEncoding
+	}
+
+	if len(keyID) > 0 {
+		return -1, validateRemoteSignature(keyID, messageMAC, path)
+	}
+
+	if pairInd := findKeyPair(messageMAC, path); pairInd >= 0 {
+		return pairInd, nil
+	}
+
+	return -1, errInvalidSignature
+}
 
-func validatePath(signature, path string) error {
+// validateTenantPath checks the signature with the tenant keys.
+// Global keys and remote signatures aren't used for tenants with their own keys
+func validateTenantPath(t *tenant, signature, path string) error {
 	messageMAC, err := base64.RawURLEncoding.DecodeString(signature)
 	if err != nil {
 		return errInvalidSignatureEncoding
 	}
 
-	for i := 0; i < len(conf.Keys); i++ {
-		if hmac.Equal(messageMAC, signatureFor(path, i)) {
-			return nil
+	for _, alg := range conf.SignatureAlgorithms {
+		for i := range t.Keys {
+			if hmac.Equal(messageMAC, calcSignature(path, t.Keys[i], t.Salts[i], signatureAlgorithms[alg], conf.SignatureSize)) {
+				return nil
+			}
 		}
 	}
 
 	return errInvalidSignature
 }
 
-func signatureFor(str string, pairInd int) []byte {
-	mac := hmac.New(sha256.New, conf.Keys[pairInd])
-	mac.Write(conf.Salts[pairInd])
+func findKeyPair(messageMAC []byte, path string) int {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	for _, alg := range conf.SignatureAlgorithms {
+		for i := 0; i < len(conf.Keys); i++ {
+			if hmac.Equal(messageMAC, signatureFor(path, i, signatureAlgorithms[alg])) {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func isAllowedSourceForKey(pairInd int, imageURL string) bool {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	if pairInd < 0 || pairInd >= len(conf.KeySources) || len(conf.KeySources[pairInd]) == 0 {
+		return true
+	}
+
+	for _, val := range conf.KeySources[pairInd] {
+		if strings.HasPrefix(imageURL, val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func signatureFor(str string, pairInd int, h func() hash.Hash) []byte {
+	return calcSignature(str, conf.Keys[pairInd], conf.Salts[pairInd], h, conf.SignatureSize)
+}
+
+func calcSignature(str string, key, salt config.SecurityKey, h func() hash.Hash, size int) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(salt)
 	mac.Write([]byte(str))
 	expectedMAC := mac.Sum(nil)
-	if conf.SignatureSize < 32 {
-		return expectedMAC[:conf.SignatureSize]
+	if size < len(expectedMAC) {
+		return expectedMAC[:size]
 	}
 	return expectedMAC
 }
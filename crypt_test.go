@@ -2,7 +2,10 @@ package main
 
 import (
 	"testing"
+	"time"
 
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
@@ -12,41 +15,193 @@ type CryptTestSuite struct{ MainTestSuite }
 func (s *CryptTestSuite) SetupTest() {
 	s.MainTestSuite.SetupTest()
 
-	conf.Keys = []securityKey{securityKey("test-key")}
-	conf.Salts = []securityKey{securityKey("test-salt")}
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
 }
 
 func (s *CryptTestSuite) TestValidatePath() {
-	err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	_, err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
 	assert.Nil(s.T(), err)
 }
 
 func (s *CryptTestSuite) TestValidatePathTruncated() {
 	conf.SignatureSize = 8
 
-	err := validatePath("dtLwhdnPPis", "asd")
+	_, err := validatePath("dtLwhdnPPis", "asd")
 	assert.Nil(s.T(), err)
 }
 
 func (s *CryptTestSuite) TestValidatePathInvalid() {
-	err := validatePath("dtLwhdnPPis", "asd")
+	_, err := validatePath("dtLwhdnPPis", "asd")
 	assert.Error(s.T(), err)
 }
 
 func (s *CryptTestSuite) TestValidatePathMultiplePairs() {
-	conf.Keys = append(conf.Keys, securityKey("test-key2"))
-	conf.Salts = append(conf.Salts, securityKey("test-salt2"))
+	conf.Keys = append(conf.Keys, config.SecurityKey("test-key2"))
+	conf.Salts = append(conf.Salts, config.SecurityKey("test-salt2"))
 
-	err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	_, err := validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
 	assert.Nil(s.T(), err)
 
-	err = validatePath("jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
+	_, err = validatePath("jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
 	assert.Nil(s.T(), err)
 
-	err = validatePath("dtLwhdnPPis", "asd")
+	_, err = validatePath("dtLwhdnPPis", "asd")
 	assert.Error(s.T(), err)
 }
 
+func (s *CryptTestSuite) TestValidatePathAlgorithms() {
+	conf.SignatureAlgorithms = []string{"sha512/256", "blake2b"}
+
+	_, err := validatePath("Tu-OJDxhrHM72rt-bNBZz-9id6ImaYOHsT0Ca8UUAvI", "asd")
+	assert.Nil(s.T(), err)
+
+	_, err = validatePath("9X7nBxB4UFbND1ExxnWxKLiIH4vn6X3zBkeoi2Dq1Is", "asd")
+	assert.Nil(s.T(), err)
+
+	_, err = validatePath("dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "asd")
+	assert.Error(s.T(), err)
+}
+
+type testRemoteSigner struct {
+	sig   []byte
+	calls int
+}
+
+func (s *testRemoteSigner) Sign(key string, data []byte) ([]byte, error) {
+	s.calls++
+	return s.sig, nil
+}
+
+func (s *CryptTestSuite) TestValidatePathRemote() {
+	conf.Keys = []config.SecurityKey{}
+	conf.Salts = []config.SecurityKey{}
+	conf.RemoteSignatureKeys = map[string]string{"main": "alias/imgproxy"}
+
+	signer := &testRemoteSigner{sig: []byte("01234567890123456789012345678901")}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(10)
+	remoteSignatureSem = make(chan struct{}, 1)
+	defer func() { remoteSignatureSigner, remoteSignatureCache, remoteSignatureSem = nil, nil, nil }()
+
+	ind, err := validatePath("main.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), -1, ind)
+
+	_, err = validatePath("main.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, signer.calls)
+
+	_, err = validatePath("main.dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "qwe")
+	assert.Equal(s.T(), errInvalidSignature, err)
+
+	_, err = validatePath("main.dtLwhdnPPiu_epMl1LrzheLpvHas-4mwvY6L3Z8WwlY", "qwe")
+	assert.Equal(s.T(), errInvalidSignature, err)
+
+	_, err = validatePath("main.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "qwe")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, signer.calls)
+}
+
+func (s *CryptTestSuite) TestValidatePathRemoteInvalidSize() {
+	conf.RemoteSignatureKeys = map[string]string{"main": "alias/imgproxy"}
+
+	signer := &testRemoteSigner{sig: []byte("01234567890123456789012345678901")}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(10)
+	remoteSignatureSem = make(chan struct{}, 1)
+	defer func() { remoteSignatureSigner, remoteSignatureCache, remoteSignatureSem = nil, nil, nil }()
+
+	_, err := validatePath("main.Z2FyYmFnZQ", "asd")
+	assert.Equal(s.T(), errInvalidSignature, err)
+
+	assert.Equal(s.T(), 0, signer.calls)
+}
+
+func (s *CryptTestSuite) TestValidatePathRemoteUnknownKey() {
+	conf.RemoteSignatureKeys = map[string]string{"main": "alias/imgproxy"}
+
+	signer := &testRemoteSigner{sig: []byte("01234567890123456789012345678901")}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(10)
+	remoteSignatureSem = make(chan struct{}, 1)
+	defer func() { remoteSignatureSigner, remoteSignatureCache, remoteSignatureSem = nil, nil, nil }()
+
+	_, err := validatePath("other.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	assert.Equal(s.T(), errInvalidSignature, err)
+
+	_, err = validatePath("MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	assert.Equal(s.T(), errInvalidSignature, err)
+
+	assert.Equal(s.T(), 0, signer.calls)
+}
+
+func (s *CryptTestSuite) TestValidatePathRemoteBusy() {
+	conf.RemoteSignatureKeys = map[string]string{"main": "alias/imgproxy"}
+
+	signer := &testRemoteSigner{sig: []byte("01234567890123456789012345678901")}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(10)
+	remoteSignatureSem = make(chan struct{}, 1)
+	defer func() { remoteSignatureSigner, remoteSignatureCache, remoteSignatureSem = nil, nil, nil }()
+
+	conf.RemoteSignatureWait = 10
+
+	remoteSignatureSem <- struct{}{}
+
+	_, err := validatePath("main.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	if assert.IsType(s.T(), &ierrors.Error{}, err) {
+		assert.Equal(s.T(), 503, err.(*ierrors.Error).StatusCode)
+	}
+
+	assert.Equal(s.T(), 0, signer.calls)
+}
+
+func (s *CryptTestSuite) TestValidatePathRemoteWait() {
+	conf.RemoteSignatureKeys = map[string]string{"main": "alias/imgproxy"}
+	conf.RemoteSignatureWait = 1000
+
+	signer := &testRemoteSigner{sig: []byte("01234567890123456789012345678901")}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(10)
+	remoteSignatureSem = make(chan struct{}, 1)
+	defer func() { remoteSignatureSigner, remoteSignatureCache, remoteSignatureSem = nil, nil, nil }()
+
+	remoteSignatureSem <- struct{}{}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-remoteSignatureSem
+	}()
+
+	_, err := validatePath("main.MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, signer.calls)
+}
+
+func (s *CryptTestSuite) TestValidatePathPairIndex() {
+	conf.Keys = append(conf.Keys, config.SecurityKey("test-key2"))
+	conf.Salts = append(conf.Salts, config.SecurityKey("test-salt2"))
+
+	ind, err := validatePath("jbDffNPt1-XBgDccsaE-XJB9lx8JIJqdeYIZKgOqZpg", "asd")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, ind)
+}
+
+func (s *CryptTestSuite) TestIsAllowedSourceForKey() {
+	conf.KeySources = [][]string{{"s3://tenant-a/", "https://a.example.com/"}, {}}
+
+	assert.True(s.T(), isAllowedSourceForKey(0, "s3://tenant-a/image.jpg"))
+	assert.True(s.T(), isAllowedSourceForKey(0, "https://a.example.com/image.jpg"))
+	assert.False(s.T(), isAllowedSourceForKey(0, "s3://tenant-b/image.jpg"))
+	assert.True(s.T(), isAllowedSourceForKey(1, "s3://tenant-b/image.jpg"))
+}
+
 func TestCrypt(t *testing.T) {
 	suite.Run(t, new(CryptTestSuite))
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var errDataURITooBig = errors.New("Data URI is too big")
+
+// dataTransport implements RoundTripper for the 'data' protocol.
+type dataTransport struct{}
+
+func newDataTransport() dataTransport {
+	return dataTransport{}
+}
+
+func (t dataTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	opaque := req.URL.Opaque
+
+	sep := strings.IndexByte(opaque, ',')
+	if sep < 0 {
+		return nil, errors.New("Invalid data URI")
+	}
+
+	mediaType, encoded := opaque[:sep], opaque[sep+1:]
+
+	var data []byte
+
+	if strings.HasSuffix(mediaType, ";base64") {
+		mediaType = strings.TrimSuffix(mediaType, ";base64")
+
+		if base64.StdEncoding.DecodedLen(len(encoded)) > conf.MaxDataURISize {
+			return nil, errDataURITooBig
+		}
+
+		if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "=")); err != nil {
+				return nil, fmt.Errorf("Invalid data URI encoding: %s", err)
+			}
+		}
+	} else {
+		unescaped, err := url.PathUnescape(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid data URI encoding: %s", err)
+		}
+
+		data = []byte(unescaped)
+	}
+
+	if len(data) > conf.MaxDataURISize {
+		return nil, errDataURITooBig
+	}
+
+	header := make(http.Header)
+	if len(mediaType) > 0 {
+		header.Set("Content-Type", mediaType)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        header,
+		ContentLength: int64(len(data)),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		Close:         true,
+		Request:       req,
+	}, nil
+}
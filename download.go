@@ -6,26 +6,45 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
 	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/imgproxy/imgproxy/v2/vips"
 )
 
 var (
 	downloadClient *http.Client
 
-	errSourceDimensionsTooBig      = newError(422, "Source image dimensions are too big", "Invalid source image")
-	errSourceResolutionTooBig      = newError(422, "Source image resolution is too big", "Invalid source image")
-	errSourceFileTooBig            = newError(422, "Source image file is too big", "Invalid source image")
-	errSourceImageTypeNotSupported = newError(422, "Source image type not supported", "Invalid source image")
+	errSourceFileTooBig            = ierrors.New(422, "Source image file is too big", "Invalid source image")
+	errSourceContentTypeNotAllowed = ierrors.New(422, "Source content type is not allowed", "Invalid source image")
 )
 
 const msgSourceImageIsUnreachable = "Source image is unreachable"
 
 var downloadBufPool *bufPool
 
+// hostDownloadSlots limits the number of in-flight downloads from a single source host
+type hostDownloadSlots struct {
+	sem chan struct{}
+	// refs is the number of requests holding or waiting for a slot
+	refs int
+}
+
+var (
+	hostDownloads      = make(map[string]*hostDownloadSlots)
+	hostDownloadsMutex sync.Mutex
+)
+
 type limitReader struct {
 	r    io.Reader
 	left int
@@ -43,12 +62,22 @@ func (lr *limitReader) Read(p []byte) (n int, err error) {
 }
 
 func initDownloading() error {
+	dialer := &net.Dialer{KeepAlive: 600 * time.Second}
+
+	if conf.DenyPrivateSourceAddresses {
+		if name := sourceProxyEnv(); len(name) > 0 {
+			return fmt.Errorf("IMGPROXY_DENY_PRIVATE_SOURCE_ADDRESSES can't be used with %s since source addresses are resolved by the proxy", name)
+		}
+
+		dialer.Control = checkSourceAddress
+	}
+
 	transport := &http.Transport{
 		Proxy:               http.ProxyFromEnvironment,
 		MaxIdleConns:        conf.Concurrency,
 		MaxIdleConnsPerHost: conf.Concurrency,
 		DisableCompression:  true,
-		Dial:                (&net.Dialer{KeepAlive: 600 * time.Second}).Dial,
+		Dial:                dialer.Dial,
 	}
 
 	if conf.IgnoreSslVerification {
@@ -75,6 +104,18 @@ func initDownloading() error {
 		}
 	}
 
+	if conf.SFTPEnabled {
+		if t, err := newSFTPTransport(); err != nil {
+			return err
+		} else {
+			transport.RegisterProtocol("sftp", t)
+		}
+	}
+
+	if conf.DataURIEnabled {
+		transport.RegisterProtocol("data", newDataTransport())
+	}
+
 	downloadClient = &http.Client{
 		Timeout:   time.Duration(conf.DownloadTimeout) * time.Second,
 		Transport: transport,
@@ -87,40 +128,55 @@ func initDownloading() error {
 	return nil
 }
 
-func checkDimensions(width, height int) error {
-	if conf.MaxSrcDimension > 0 && (width > conf.MaxSrcDimension || height > conf.MaxSrcDimension) {
-		return errSourceDimensionsTooBig
+func imageTypeLoadSupport(imgtype imagetype.Type) bool {
+	return imgtype == imagetype.SVG ||
+		imgtype == imagetype.ICO ||
+		vips.SupportsLoad(imgtype)
+}
+
+func isAllowedSourceContentType(contentType string) bool {
+	// Some sources (local files, S3, GCS) may not provide content type,
+	// so we rely on the magic bytes check in this case
+	if len(conf.AllowedSourceContentTypes) == 0 || len(contentType) == 0 {
+		return true
 	}
 
-	if width*height > conf.MaxSrcResolution {
-		return errSourceResolutionTooBig
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
 	}
 
-	return nil
+	for _, t := range conf.AllowedSourceContentTypes {
+		if t == mimeType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, t[:len(t)-1])) {
+			return true
+		}
+	}
+
+	return false
 }
 
-func checkTypeAndDimensions(r io.Reader) (imageType, error) {
+func checkTypeAndDimensions(r io.Reader) (imagetype.Type, error) {
 	meta, err := imagemeta.DecodeMeta(r)
 	if err == imagemeta.ErrFormat {
-		return imageTypeUnknown, errSourceImageTypeNotSupported
+		return imagetype.Unknown, processing.ErrSourceImageTypeNotSupported
 	}
 	if err != nil {
-		return imageTypeUnknown, newUnexpectedError(err.Error(), 0)
+		return imagetype.Unknown, ierrors.NewUnexpected(err.Error(), 0)
 	}
 
-	imgtype, imgtypeOk := imageTypes[meta.Format()]
+	imgtype, imgtypeOk := imagetype.Types[meta.Format()]
 	if !imgtypeOk || !imageTypeLoadSupport(imgtype) {
-		return imageTypeUnknown, errSourceImageTypeNotSupported
+		return imagetype.Unknown, processing.ErrSourceImageTypeNotSupported
 	}
 
-	if err = checkDimensions(meta.Width(), meta.Height()); err != nil {
-		return imageTypeUnknown, err
+	if err = processing.CheckDimensions(meta.Width(), meta.Height()); err != nil {
+		return imagetype.Unknown, err
 	}
 
 	return imgtype, nil
 }
 
-func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
+func readAndCheckImage(r io.Reader, contentLength int) (*imagedata.ImageData, error) {
 	if conf.MaxSrcFileSize > 0 && contentLength > conf.MaxSrcFileSize {
 		return nil, errSourceFileTooBig
 	}
@@ -140,44 +196,167 @@ func readAndCheckImage(r io.Reader, contentLength int) (*imageData, error) {
 
 	if _, err = buf.ReadFrom(r); err != nil {
 		cancel()
-		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable)
+
+		if err == errSourceFileTooBig {
+			return nil, err
+		}
+
+		return nil, ierrors.New(404, err.Error(), msgSourceImageIsUnreachable).SetSourceTimeout(isTimeoutError(err))
 	}
 
-	return &imageData{buf.Bytes(), imgtype, cancel}, nil
+	return imagedata.New(buf.Bytes(), imgtype, cancel), nil
+}
+
+func isTimeoutError(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
 }
 
 func requestImage(imageURL string) (*http.Response, error) {
 	req, err := http.NewRequest("GET", imageURL, nil)
 	if err != nil {
-		return nil, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return nil, ierrors.New(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
 	}
 
 	req.Header.Set("User-Agent", conf.UserAgent)
 
 	res, err := downloadClient.Do(req)
 	if err != nil {
-		return res, newError(404, err.Error(), msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return res, ierrors.New(404, err.Error(), msgSourceImageIsUnreachable).
+			SetUnexpected(conf.ReportDownloadingErrors).
+			SetSourceTimeout(isTimeoutError(err))
 	}
 
 	if res.StatusCode != 200 {
 		body, _ := ioutil.ReadAll(res.Body)
 		msg := fmt.Sprintf("Can't download image; Status: %d; %s", res.StatusCode, string(body))
-		return res, newError(404, msg, msgSourceImageIsUnreachable).SetUnexpected(conf.ReportDownloadingErrors)
+		return res, ierrors.New(404, msg, msgSourceImageIsUnreachable).
+			SetUnexpected(conf.ReportDownloadingErrors).
+			SetSourceStatusCode(res.StatusCode)
+	}
+
+	if !isAllowedSourceContentType(res.Header.Get("Content-Type")) {
+		return res, errSourceContentTypeNotAllowed
 	}
 
 	return res, nil
 }
 
-func downloadImage(ctx context.Context, imageURL string) (d *imageData, cacheControl, expires string, done context.CancelFunc, err error) {
+// revalidateSource sends a conditional HEAD request to the source using the source
+// ETag and Last-Modified headers. Returns true if the source responds that
+// the image wasn't modified
+func revalidateSource(ctx context.Context, imageURL, sourceETag, sourceLastModified string) bool {
+	req, err := http.NewRequest("HEAD", imageURL, nil)
+	if err != nil {
+		return false
+	}
+
+	req.Header.Set("User-Agent", conf.UserAgent)
+
+	if len(sourceETag) > 0 {
+		req.Header.Set("If-None-Match", sourceETag)
+	}
+	if len(sourceLastModified) > 0 {
+		req.Header.Set("If-Modified-Since", sourceLastModified)
+	}
+
+	res, err := downloadClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+
+	return res.StatusCode == 304
+}
+
+// acquireHostDownloadSlot waits for a free download slot for the source host so the number
+// of in-flight downloads from it doesn't exceed IMGPROXY_MAX_DOWNLOADS_PER_HOST.
+// The request holds a processing slot while waiting, so the wait is bounded
+// by IMGPROXY_MAX_HOST_DOWNLOAD_WAIT to prevent a slow host from starving
+// requests to other hosts
+func acquireHostDownloadSlot(ctx context.Context, imageURL string) (func(), error) {
+	if conf.MaxDownloadsPerHost == 0 {
+		return func() {}, nil
+	}
+
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return func() {}, nil
+	}
+
+	host := u.Host
+
+	hostDownloadsMutex.Lock()
+	slots, ok := hostDownloads[host]
+	if !ok {
+		slots = &hostDownloadSlots{sem: make(chan struct{}, conf.MaxDownloadsPerHost)}
+		hostDownloads[host] = slots
+	}
+	slots.refs++
+	hostDownloadsMutex.Unlock()
+
+	unref := func() {
+		hostDownloadsMutex.Lock()
+		defer hostDownloadsMutex.Unlock()
+
+		if slots.refs--; slots.refs == 0 {
+			delete(hostDownloads, host)
+		}
+	}
+
+	timer := time.NewTimer(time.Duration(conf.MaxHostDownloadWait) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case slots.sem <- struct{}{}:
+		return func() {
+			<-slots.sem
+			unref()
+		}, nil
+	case <-ctx.Done():
+		unref()
+		return func() {}, ierrors.New(499, "Request was cancelled before downloading", "Cancelled")
+	case <-timer.C:
+		unref()
+		return func() {}, ierrors.New(
+			429,
+			fmt.Sprintf("Too many downloads from %s", host),
+			"Too many requests",
+		).SetRetryAfter(conf.QueueRetryAfter)
+	}
+}
+
+func downloadImage(ctx context.Context, imageURL string) (*imagedata.ImageData, string, string, context.CancelFunc, error) {
+	releaseHostSlot, err := acquireHostDownloadSlot(ctx, imageURL)
+	defer releaseHostSlot()
+	if err != nil {
+		return nil, "", "", func() {}, err
+	}
+
+	return fetchImage(ctx, imageURL)
+}
+
+func fetchImage(ctx context.Context, imageURL string) (d *imagedata.ImageData, cacheControl, expires string, done context.CancelFunc, err error) {
 	if newRelicEnabled {
 		newRelicCancel := startNewRelicSegment(ctx, "Downloading image")
 		defer newRelicCancel()
 	}
 
+	if xrayEnabled {
+		var xrayCancel context.CancelFunc
+		ctx, xrayCancel = startXRaySubsegment(ctx, "Downloading image")
+		defer xrayCancel()
+	}
+
 	if prometheusEnabled {
 		defer startPrometheusDuration(prometheusDownloadDuration)()
 	}
 
+	defer startTiming(ctx, "download")()
+
+	trackDownload := startDownloadStats()
+	defer func() { trackDownload(err) }()
+
 	res, err := requestImage(imageURL)
 	if res != nil {
 		defer res.Body.Close()
@@ -191,5 +370,82 @@ func downloadImage(ctx context.Context, imageURL string) (d *imageData, cacheCon
 		return nil, "", "", func() {}, err
 	}
 
+	if err = checkTenantLimits(ctx, imgdata); err != nil {
+		imgdata.Close()
+		return nil, "", "", func() {}, err
+	}
+
+	if prometheusEnabled {
+		prometheusDownloadSize.Observe(float64(len(imgdata.Data)))
+	}
+
+	imgdata.SourceETag = res.Header.Get("ETag")
+	imgdata.SourceLastModified = res.Header.Get("Last-Modified")
+
 	return imgdata, res.Header.Get("Cache-Control"), res.Header.Get("Expires"), imgdata.Close, err
 }
+
+// downloadImageWithRetries downloads the image retrying up to IMGPROXY_DOWNLOAD_RETRIES
+// times if downloading fails because of a timeout, a network error, or a 5xx response.
+// The host download slot is held between the retries, so retries count against
+// the per-host limit and don't let a failing host get more simultaneous downloads
+func downloadImageWithRetries(ctx context.Context, imageURL string) (*imagedata.ImageData, string, string, context.CancelFunc, error) {
+	releaseHostSlot, err := acquireHostDownloadSlot(ctx, imageURL)
+	defer releaseHostSlot()
+	if err != nil {
+		return nil, "", "", func() {}, err
+	}
+
+	imgdata, cacheControl, expires, done, err := fetchImage(ctx, imageURL)
+
+	backoff := time.Duration(conf.DownloadRetryBackoff) * time.Millisecond
+
+	for retries := 0; err != nil && retries < conf.DownloadRetries && ctx.Err() == nil; retries++ {
+		if class := downloadErrorClass(err); class != "timeout" && class != "network" && class != "5xx" {
+			break
+		}
+
+		logWarning("Can't download image, retrying in %s: %s", backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return imgdata, cacheControl, expires, done, err
+		}
+
+		backoff *= 2
+
+		imgdata, cacheControl, expires, done, err = fetchImage(ctx, imageURL)
+	}
+
+	return imgdata, cacheControl, expires, done, err
+}
+
+// downloadErrorClass returns the class of the download error. Classes are used
+// to decide if the download should be retried or the fallback image should be used
+func downloadErrorClass(err error) string {
+	switch err {
+	case processing.ErrSourceDimensionsTooBig, processing.ErrSourceResolutionTooBig, errSourceFileTooBig:
+		return "too_big"
+	case processing.ErrSourceImageTypeNotSupported, errSourceContentTypeNotAllowed:
+		return "unsupported"
+	}
+
+	ierr, ok := err.(*ierrors.Error)
+	if !ok {
+		return "error"
+	}
+
+	switch {
+	case ierr.SourceTimeout:
+		return "timeout"
+	case ierr.SourceStatusCode >= 500:
+		return "5xx"
+	case ierr.SourceStatusCode >= 400:
+		return "4xx"
+	case ierr.SourceStatusCode == 0 && ierr.StatusCode == 404:
+		return "network"
+	}
+
+	return "error"
+}
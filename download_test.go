@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type DownloadTestSuite struct{ MainTestSuite }
+
+func (s *DownloadTestSuite) TestDownloadErrorClass() {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{errSourceFileTooBig, "too_big"},
+		{processing.ErrSourceResolutionTooBig, "too_big"},
+		{processing.ErrSourceImageTypeNotSupported, "unsupported"},
+		{errSourceContentTypeNotAllowed, "unsupported"},
+		{ierrors.New(404, "timeout", "").SetSourceTimeout(true), "timeout"},
+		{ierrors.New(404, "bad gateway", "").SetSourceStatusCode(502), "5xx"},
+		{ierrors.New(404, "not found", "").SetSourceStatusCode(404), "4xx"},
+		{ierrors.New(404, "connection refused", ""), "network"},
+		{ierrors.New(422, "invalid", ""), "error"},
+		{errors.New("unknown"), "error"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(s.T(), tt.class, downloadErrorClass(tt.err), tt.err.Error())
+	}
+}
+
+func (s *DownloadTestSuite) TestShouldUseFallbackImage() {
+	tests := []struct {
+		classes []string
+		err     error
+		use     bool
+	}{
+		{nil, errSourceFileTooBig, true},
+		{nil, errors.New("unknown"), true},
+		{[]string{"4xx"}, ierrors.New(404, "not found", "").SetSourceStatusCode(404), true},
+		{[]string{"4xx"}, ierrors.New(404, "bad gateway", "").SetSourceStatusCode(502), false},
+		{[]string{"5xx", "timeout"}, ierrors.New(404, "timeout", "").SetSourceTimeout(true), true},
+		{[]string{"too_big"}, processing.ErrSourceImageTypeNotSupported, false},
+	}
+
+	for _, tt := range tests {
+		conf.FallbackImageErrors = tt.classes
+		assert.Equal(s.T(), tt.use, shouldUseFallbackImage(tt.err), "%v %s", tt.classes, tt.err)
+	}
+}
+
+func (s *DownloadTestSuite) TestLocalFileNotFoundIs4xx() {
+	dir, err := ioutil.TempDir("", "local")
+	require.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	conf.LocalFileSystemRoot = dir
+
+	transport := &http.Transport{}
+	transport.RegisterProtocol("local", newFsTransport())
+
+	oldClient := downloadClient
+	defer func() { downloadClient = oldClient }()
+
+	downloadClient = &http.Client{Transport: transport}
+
+	_, err = requestImage("local:///missing.jpg")
+	require.Error(s.T(), err)
+
+	assert.Equal(s.T(), "4xx", downloadErrorClass(err))
+
+	conf.FallbackImageErrors = []string{"4xx"}
+	assert.True(s.T(), shouldUseFallbackImage(err))
+}
+
+func (s *DownloadTestSuite) TestHostDownloadSlotWait() {
+	conf.MaxDownloadsPerHost = 1
+	conf.MaxHostDownloadWait = 1000
+
+	release, err := acquireHostDownloadSlot(context.Background(), "http://example.com/a.png")
+	require.Nil(s.T(), err)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+
+	release, err = acquireHostDownloadSlot(context.Background(), "http://example.com/b.png")
+	require.Nil(s.T(), err)
+	release()
+
+	assert.Empty(s.T(), hostDownloads)
+}
+
+func (s *DownloadTestSuite) TestHostDownloadSlotWaitTimeout() {
+	conf.MaxDownloadsPerHost = 1
+	conf.MaxHostDownloadWait = 10
+
+	release, err := acquireHostDownloadSlot(context.Background(), "http://example.com/a.png")
+	require.Nil(s.T(), err)
+	defer release()
+
+	_, err = acquireHostDownloadSlot(context.Background(), "http://example.com/b.png")
+	require.NotNil(s.T(), err)
+	assert.Equal(s.T(), 429, err.(*ierrors.Error).StatusCode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conf.MaxHostDownloadWait = 1000
+
+	_, err = acquireHostDownloadSlot(ctx, "http://example.com/b.png")
+	require.NotNil(s.T(), err)
+	assert.Equal(s.T(), 499, err.(*ierrors.Error).StatusCode)
+
+	release2, err := acquireHostDownloadSlot(context.Background(), "http://example.org/a.png")
+	require.Nil(s.T(), err)
+	release2()
+}
+
+func TestDownload(t *testing.T) {
+	suite.Run(t, new(DownloadTestSuite))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+)
+
+// errorResponse is a custom response body that is sent instead of
+// the error message for the specific status code
+type errorResponse struct {
+	Data        []byte
+	ContentType string
+}
+
+var (
+	errorResponses      map[string]*errorResponse
+	errorResponsesMutex sync.RWMutex
+)
+
+func initErrorResponses() error {
+	responses, err := loadErrorResponses()
+	if err != nil {
+		return err
+	}
+
+	errorResponsesMutex.Lock()
+	defer errorResponsesMutex.Unlock()
+
+	errorResponses = responses
+
+	return nil
+}
+
+func loadErrorResponses() (map[string]*errorResponse, error) {
+	responses := make(map[string]*errorResponse, len(conf.ErrorResponses))
+
+	for status, path := range conf.ErrorResponses {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Can't read error response for %s: %s", status, err)
+		}
+
+		contentType := http.DetectContentType(data)
+		if imgtype, err := checkTypeAndDimensions(bytes.NewReader(data)); err == nil {
+			contentType = imgtype.Mime()
+		}
+
+		responses[status] = &errorResponse{Data: data, ContentType: contentType}
+	}
+
+	return responses, nil
+}
+
+// getErrorResponse returns the error response for the exact status code
+// or for its class (like 4xx). Returns nil if there's no such response
+func getErrorResponse(status int) *errorResponse {
+	errorResponsesMutex.RLock()
+	defer errorResponsesMutex.RUnlock()
+
+	if resp, ok := errorResponses[strconv.Itoa(status)]; ok {
+		return resp
+	}
+
+	return errorResponses[fmt.Sprintf("%dxx", status/100)]
+}
+
+func respondWithErrorResponse(rw http.ResponseWriter, ierr *ierrors.Error, resp *errorResponse) {
+	if ierr.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(ierr.RetryAfter))
+	}
+
+	rw.Header().Set("Content-Type", resp.ContentType)
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(ierr.StatusCode)
+	rw.Write(resp.Data)
+}
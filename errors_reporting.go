@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -8,6 +9,8 @@ import (
 	"github.com/bugsnag/bugsnag-go"
 	"github.com/getsentry/sentry-go"
 	"github.com/honeybadger-io/honeybadger-go"
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
 )
 
 var (
@@ -24,6 +27,7 @@ func initErrorsReporting() {
 		bugsnag.Configure(bugsnag.Configuration{
 			APIKey:       conf.BugsnagKey,
 			ReleaseStage: conf.BugsnagStage,
+			AppVersion:   config.Version,
 		})
 		bugsnagEnabled = true
 	}
@@ -47,9 +51,28 @@ func initErrorsReporting() {
 	}
 }
 
+// bugsnagError passes the stack trace of ierrors.Error to Bugsnag
+type bugsnagError struct {
+	err *ierrors.Error
+}
+
+func (e bugsnagError) Error() string {
+	return e.err.Error()
+}
+
+func (e bugsnagError) Callers() []uintptr {
+	return e.err.StackTrace()
+}
+
 func reportError(err error, req *http.Request) {
 	if bugsnagEnabled {
-		bugsnag.Notify(err, req)
+		if ierr, ok := err.(*ierrors.Error); ok && len(ierr.StackTrace()) > 0 {
+			// Report the stack trace of the place where the error occurred
+			// instead of the stack trace of the reporter
+			bugsnag.Notify(bugsnagError{ierr}, req, bugsnag.ErrorClass{Name: fmt.Sprintf("%T", ierr)})
+		} else {
+			bugsnag.Notify(err, req)
+		}
 	}
 
 	if honeybadgerEnabled {
@@ -73,3 +96,10 @@ func reportError(err error, req *http.Request) {
 		}
 	}
 }
+
+// flushErrorsReporting waits for the errors that are reported asynchronously to be sent
+func flushErrorsReporting() {
+	if honeybadgerEnabled {
+		honeybadger.Flush()
+	}
+}
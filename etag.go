@@ -2,10 +2,16 @@ package main
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"hash"
+	"strings"
 	"sync"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/options"
 )
 
 type eTagCalc struct {
@@ -25,7 +31,11 @@ var eTagCalcPool = sync.Pool{
 	},
 }
 
-func calcETag(imgdata *imageData, po *processingOptions) string {
+func calcETag(imgdata *imagedata.ImageData, po *options.ProcessingOptions) string {
+	if conf.ETagMode == "headers" && (len(imgdata.SourceETag) > 0 || len(imgdata.SourceLastModified) > 0) {
+		return calcETagFromHeaders(imgdata, po)
+	}
+
 	c := eTagCalcPool.Get().(*eTagCalc)
 	defer eTagCalcPool.Put(c)
 
@@ -35,9 +45,78 @@ func calcETag(imgdata *imageData, po *processingOptions) string {
 
 	c.hash.Reset()
 	c.hash.Write(footprint)
-	c.hash.Write([]byte(version))
-	c.enc.Encode(conf)
+	c.hash.Write([]byte(config.Version))
+	encodeConf(c.enc)
 	c.enc.Encode(po)
 
 	return hex.EncodeToString(c.hash.Sum(nil))
 }
+
+// calcETagFromHeaders calculates weak ETag using the source response headers
+// instead of hashing the whole source image.
+// When ETag revalidation is enabled, the source headers are encoded into the ETag
+// so they can be used to revalidate the source when the client revalidates the result
+func calcETagFromHeaders(imgdata *imagedata.ImageData, po *options.ProcessingOptions) string {
+	c := eTagCalcPool.Get().(*eTagCalc)
+	defer eTagCalcPool.Put(c)
+
+	c.hash.Reset()
+	c.hash.Write([]byte(imgdata.SourceETag))
+	c.hash.Write([]byte(imgdata.SourceLastModified))
+	c.hash.Write([]byte(config.Version))
+	encodeConf(c.enc)
+	c.enc.Encode(po)
+
+	eTag := hex.EncodeToString(c.hash.Sum(nil))
+
+	if conf.ETagRevalidate {
+		headers := imgdata.SourceETag + "\n" + imgdata.SourceLastModified
+		eTag += "." + base64.RawURLEncoding.EncodeToString([]byte(headers))
+	}
+
+	return `W/"` + eTag + `"`
+}
+
+// parseETagSourceHeaders extracts the source ETag and Last-Modified headers
+// from the ETag calculated by calcETagFromHeaders. Returns false if the ETag
+// doesn't contain them or wasn't calculated for the provided processing options
+func parseETagSourceHeaders(eTag string, po *options.ProcessingOptions) (string, string, bool) {
+	if !strings.HasPrefix(eTag, `W/"`) || !strings.HasSuffix(eTag, `"`) {
+		return "", "", false
+	}
+
+	dot := strings.LastIndexByte(eTag, '.')
+	if dot < 0 {
+		return "", "", false
+	}
+
+	headers, err := base64.RawURLEncoding.DecodeString(eTag[dot+1 : len(eTag)-1])
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(headers), "\n", 2)
+	if len(parts) < 2 {
+		return "", "", false
+	}
+
+	imgdata := imagedata.ImageData{SourceETag: parts[0], SourceLastModified: parts[1]}
+
+	// The hash part protects us from forged headers and ensures that
+	// the ETag was calculated for the same processing options and config
+	if calcETagFromHeaders(&imgdata, po) != eTag {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// encodeConf encodes the config and the presets holding the locks of
+// the config parts that can be changed while the server is running
+func encodeConf(enc *json.Encoder) {
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	enc.Encode(conf)
+	enc.Encode(options.AllPresets())
+}
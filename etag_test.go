@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ETagTestSuite struct{ MainTestSuite }
+
+func (s *ETagTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.ETagMode = "headers"
+	conf.ETagRevalidate = true
+}
+
+func (s *ETagTestSuite) TestParseETagSourceHeaders() {
+	po := options.NewProcessingOptions()
+	imgdata := imagedata.ImageData{SourceETag: `"abc"`, SourceLastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}
+
+	eTag := calcETagFromHeaders(&imgdata, po)
+
+	srcETag, srcLastModified, ok := parseETagSourceHeaders(eTag, po)
+
+	require.True(s.T(), ok)
+	assert.Equal(s.T(), imgdata.SourceETag, srcETag)
+	assert.Equal(s.T(), imgdata.SourceLastModified, srcLastModified)
+}
+
+func (s *ETagTestSuite) TestParseETagSourceHeadersOtherOptions() {
+	po := options.NewProcessingOptions()
+	imgdata := imagedata.ImageData{SourceETag: `"abc"`}
+
+	eTag := calcETagFromHeaders(&imgdata, po)
+
+	po.Width = 100
+
+	_, _, ok := parseETagSourceHeaders(eTag, po)
+
+	assert.False(s.T(), ok)
+}
+
+func (s *ETagTestSuite) TestParseETagSourceHeadersForged() {
+	po := options.NewProcessingOptions()
+	imgdata := imagedata.ImageData{SourceETag: `"abc"`}
+
+	eTag := calcETagFromHeaders(&imgdata, po)
+
+	// Replace encoded headers with "\"xyz\"\n"
+	forged := eTag[:len(`W/"`)+64] + ".Inh5eiIK" + `"`
+
+	_, _, ok := parseETagSourceHeaders(forged, po)
+
+	assert.False(s.T(), ok)
+}
+
+func TestETag(t *testing.T) {
+	suite.Run(t, new(ETagTestSuite))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const fontconfigBaseConfig = "/etc/fonts/fonts.conf"
+
+var fontsDir string
+
+// initFonts registers the custom fonts in fontconfig. Fontconfig reads its config
+// on the first use, so this should be done before libvips is initialized.
+// Since fontconfig config can contain only directories, custom font files
+// are linked to a temporary directory
+func initFonts() error {
+	if len(conf.FontsPaths) == 0 {
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "imgproxy-fonts")
+	if err != nil {
+		return fmt.Errorf("Can't create fonts directory: %s", err)
+	}
+
+	fontsDir = dir
+
+	dirs := []string{dir}
+
+	for _, path := range conf.FontsPaths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("Invalid fonts path %s: %s", path, err)
+		}
+
+		stat, err := os.Stat(absPath)
+		if err != nil {
+			return fmt.Errorf("Can't load fonts from %s: %s", path, err)
+		}
+
+		if stat.IsDir() {
+			dirs = append(dirs, absPath)
+			continue
+		}
+
+		if err = os.Symlink(absPath, filepath.Join(dir, filepath.Base(absPath))); err != nil {
+			return fmt.Errorf("Can't load font %s: %s", path, err)
+		}
+	}
+
+	configPath := filepath.Join(dir, "fonts.conf")
+
+	if err = ioutil.WriteFile(configPath, fontconfigConfig(dirs), 0644); err != nil {
+		return fmt.Errorf("Can't write fontconfig config: %s", err)
+	}
+
+	return os.Setenv("FONTCONFIG_FILE", configPath)
+}
+
+// fontconfigConfig builds the fontconfig config that includes the current one
+// and adds the provided font directories
+func fontconfigConfig(dirs []string) []byte {
+	base := os.Getenv("FONTCONFIG_FILE")
+	if len(base) == 0 {
+		base = fontconfigBaseConfig
+	}
+
+	var buf bytes.Buffer
+
+	buf.WriteString("<?xml version=\"1.0\"?>\n")
+	buf.WriteString("<!DOCTYPE fontconfig SYSTEM \"fonts.dtd\">\n")
+	buf.WriteString("<fontconfig>\n")
+
+	buf.WriteString("  <include ignore_missing=\"yes\">")
+	xml.EscapeText(&buf, []byte(base))
+	buf.WriteString("</include>\n")
+
+	for _, d := range dirs {
+		buf.WriteString("  <dir>")
+		xml.EscapeText(&buf, []byte(d))
+		buf.WriteString("</dir>\n")
+	}
+
+	buf.WriteString("</fontconfig>\n")
+
+	return buf.Bytes()
+}
+
+func shutdownFonts() {
+	if len(fontsDir) > 0 {
+		os.RemoveAll(fontsDir)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type FontsTestSuite struct {
+	MainTestSuite
+
+	fontconfigFile string
+}
+
+func (s *FontsTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	s.fontconfigFile = os.Getenv("FONTCONFIG_FILE")
+	os.Unsetenv("FONTCONFIG_FILE")
+}
+
+func (s *FontsTestSuite) TearDownTest() {
+	shutdownFonts()
+	fontsDir = ""
+
+	if len(s.fontconfigFile) > 0 {
+		os.Setenv("FONTCONFIG_FILE", s.fontconfigFile)
+	} else {
+		os.Unsetenv("FONTCONFIG_FILE")
+	}
+
+	s.MainTestSuite.TearDownTest()
+}
+
+func (s *FontsTestSuite) TestFontconfigConfig() {
+	config := string(fontconfigConfig([]string{"/fonts/a&b"}))
+
+	assert.Contains(s.T(), config, "<include ignore_missing=\"yes\">/etc/fonts/fonts.conf</include>")
+	assert.Contains(s.T(), config, "<dir>/fonts/a&amp;b</dir>")
+}
+
+func (s *FontsTestSuite) TestInitFonts() {
+	dir, err := ioutil.TempDir("", "imgproxy-fonts-test")
+	require.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	fontPath := filepath.Join(dir, "brand.ttf")
+	require.Nil(s.T(), ioutil.WriteFile(fontPath, []byte("font"), 0644))
+
+	conf.FontsPaths = []string{fontPath}
+
+	require.Nil(s.T(), initFonts())
+
+	configPath := os.Getenv("FONTCONFIG_FILE")
+	assert.Equal(s.T(), filepath.Join(fontsDir, "fonts.conf"), configPath)
+
+	_, err = os.Lstat(filepath.Join(fontsDir, "brand.ttf"))
+	assert.Nil(s.T(), err)
+}
+
+func (s *FontsTestSuite) TestInitFontsMissingPath() {
+	conf.FontsPaths = []string{"/nonexistent/fonts"}
+
+	assert.Error(s.T(), initFonts())
+}
+
+func TestFonts(t *testing.T) {
+	suite.Run(t, new(FontsTestSuite))
+}
@@ -7,3 +7,8 @@ import "runtime/debug"
 func freeMemory() {
 	debug.FreeOSMemory()
 }
+
+// getRSS returns 0 since resident set size is available on Linux only
+func getRSS() uint64 {
+	return 0
+}
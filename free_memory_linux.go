@@ -11,10 +11,36 @@ void malloc_trim(size_t pad){}
 #endif
 */
 import "C"
-import "runtime/debug"
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"strconv"
+)
 
 func freeMemory() {
 	debug.FreeOSMemory()
 
 	C.malloc_trim(0)
 }
+
+// getRSS returns the resident set size of the process in bytes
+func getRSS() uint64 {
+	data, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+
+	fields := bytes.Fields(data)
+	if len(fields) < 2 {
+		return 0
+	}
+
+	pages, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return pages * uint64(os.Getpagesize())
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 )
 
 type fsTransport struct {
@@ -16,6 +19,12 @@ func newFsTransport() fsTransport {
 func (t fsTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	f, err := t.fs.Open(req.URL.Path)
 
+	// Missing files are reported as 404 responses, so they can be told apart
+	// from other errors
+	if os.IsNotExist(err) {
+		return notFoundResponse(req, err), nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -42,3 +51,19 @@ func (t fsTransport) RoundTrip(req *http.Request) (resp *http.Response, err erro
 		Request:       req,
 	}, nil
 }
+
+// notFoundResponse reports a missing file of a non-HTTP source
+// as a 404 response
+func notFoundResponse(req *http.Request, err error) *http.Response {
+	return &http.Response{
+		Status:     "404 Not Found",
+		StatusCode: 404,
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		ProtoMinor: 0,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(err.Error())),
+		Close:      true,
+		Request:    req,
+	}
+}
@@ -2,29 +2,77 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+
+	"github.com/imgproxy/imgproxy/v2/config"
 )
 
 func healthcheck() int {
 	network := conf.Network
 	bind := conf.Bind
+	routes := conf.BindRoutes
+	pathPrefix := conf.PathPrefix
+
+	var (
+		tlsCertPath     string
+		additionalBinds []config.BindConfig
+	)
+
+	if port := os.Getenv("PORT"); len(port) > 0 {
+		bind = fmt.Sprintf(":%s", port)
+	}
+
+	config.StringEnv(&network, "IMGPROXY_NETWORK")
+	config.StringEnv(&bind, "IMGPROXY_BIND")
+	config.StringSliceEnv(&routes, "IMGPROXY_BIND_ROUTES")
+	config.StringEnv(&pathPrefix, "IMGPROXY_PATH_PREFIX")
+	config.StringEnv(&tlsCertPath, "IMGPROXY_TLS_CERT_PATH")
+
+	if err := config.BindsEnv(&additionalBinds, "IMGPROXY_ADDITIONAL_BINDS"); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	// The health route may be disabled on the main bind,
+	// so we look for an additional bind that serves it
+	if !isRouteEnabled(routes, routeHealth) {
+		found := false
+
+		for _, b := range additionalBinds {
+			if isRouteEnabled(b.Routes, routeHealth) {
+				bind = b.Address
+				found = true
+				break
+			}
+		}
 
-	strEnvConfig(&network, "IMGPROXY_NETWORK")
-	strEnvConfig(&bind, "IMGPROXY_BIND")
+		if !found {
+			fmt.Fprintln(os.Stderr, "The health route is not enabled on any bind")
+			return 1
+		}
+	}
+
+	scheme := "http"
+	if len(tlsCertPath) > 0 {
+		scheme = "https"
+	}
 
 	httpc := http.Client{
 		Transport: &http.Transport{
 			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
 				return net.Dial(network, bind)
 			},
+			// We connect to ourselves, so there's no need to verify the certificate
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
 	}
 
-	res, err := httpc.Get("http://imgproxy/health")
+	res, err := httpc.Get(scheme + "://imgproxy" + pathPrefix + "/health")
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return 1
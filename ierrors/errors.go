@@ -0,0 +1,96 @@
+// Package ierrors defines the error type that carries the HTTP status of the response.
+package ierrors
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+type Error struct {
+	StatusCode    int
+	Message       string
+	PublicMessage string
+	Unexpected    bool
+	// RetryAfter is the number of seconds sent in the Retry-After header
+	RetryAfter int
+	// SourceStatusCode is the status code of the source image response
+	// if downloading failed because of it
+	SourceStatusCode int
+	// SourceTimeout is true if downloading failed because of a timeout
+	SourceTimeout bool
+
+	stack []uintptr
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) FormatStack() string {
+	if e.stack == nil {
+		return ""
+	}
+
+	return formatStack(e.stack)
+}
+
+func (e *Error) StackTrace() []uintptr {
+	return e.stack
+}
+
+func (e *Error) SetUnexpected(u bool) *Error {
+	e.Unexpected = u
+	return e
+}
+
+func (e *Error) SetRetryAfter(seconds int) *Error {
+	e.RetryAfter = seconds
+	return e
+}
+
+func (e *Error) SetSourceStatusCode(status int) *Error {
+	e.SourceStatusCode = status
+	return e
+}
+
+func (e *Error) SetSourceTimeout(timeout bool) *Error {
+	e.SourceTimeout = timeout
+	return e
+}
+
+func New(status int, msg string, pub string) *Error {
+	return &Error{
+		StatusCode:    status,
+		Message:       msg,
+		PublicMessage: pub,
+	}
+}
+
+func NewUnexpected(msg string, skip int) *Error {
+	return &Error{
+		StatusCode:    500,
+		Message:       msg,
+		PublicMessage: "Internal error",
+		Unexpected:    true,
+
+		stack: callers(skip + 3),
+	}
+}
+
+func callers(skip int) []uintptr {
+	stack := make([]uintptr, 10)
+	n := runtime.Callers(skip, stack)
+	return stack[:n]
+}
+
+func formatStack(stack []uintptr) string {
+	lines := make([]string, len(stack))
+	for i, pc := range stack {
+		f := runtime.FuncForPC(pc)
+		file, line := f.FileLine(pc)
+		lines[i] = fmt.Sprintf("%s:%d %s", file, line, f.Name())
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -2,26 +2,14 @@ package main
 
 import (
 	"bytes"
-	"context"
 	"encoding/base64"
 	"fmt"
 	"os"
-)
-
-type imageData struct {
-	Data []byte
-	Type imageType
-
-	cancel context.CancelFunc
-}
 
-func (d *imageData) Close() {
-	if d.cancel != nil {
-		d.cancel()
-	}
-}
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+)
 
-func getWatermarkData() (*imageData, error) {
+func getWatermarkData() (*imagedata.ImageData, error) {
 	if len(conf.WatermarkData) > 0 {
 		return base64ImageData(conf.WatermarkData, "watermark")
 	}
@@ -37,7 +25,7 @@ func getWatermarkData() (*imageData, error) {
 	return nil, nil
 }
 
-func getFallbackImageData() (*imageData, error) {
+func getFallbackImageData() (*imagedata.ImageData, error) {
 	if len(conf.FallbackImageData) > 0 {
 		return base64ImageData(conf.FallbackImageData, "fallback image")
 	}
@@ -53,7 +41,7 @@ func getFallbackImageData() (*imageData, error) {
 	return nil, nil
 }
 
-func base64ImageData(encoded, desc string) (*imageData, error) {
+func base64ImageData(encoded, desc string) (*imagedata.ImageData, error) {
 	data, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
 		return nil, fmt.Errorf("Can't decode %s data: %s", desc, err)
@@ -64,10 +52,10 @@ func base64ImageData(encoded, desc string) (*imageData, error) {
 		return nil, fmt.Errorf("Can't decode %s: %s", desc, err)
 	}
 
-	return &imageData{Data: data, Type: imgtype}, nil
+	return &imagedata.ImageData{Data: data, Type: imgtype}, nil
 }
 
-func fileImageData(path, desc string) (*imageData, error) {
+func fileImageData(path, desc string) (*imagedata.ImageData, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("Can't read %s: %s", desc, err)
@@ -86,7 +74,7 @@ func fileImageData(path, desc string) (*imageData, error) {
 	return imgdata, err
 }
 
-func remoteImageData(imageURL, desc string) (*imageData, error) {
+func remoteImageData(imageURL, desc string) (*imagedata.ImageData, error) {
 	res, err := requestImage(imageURL)
 	if res != nil {
 		defer res.Body.Close()
@@ -0,0 +1,33 @@
+// Package imagedata holds source images loaded into memory.
+package imagedata
+
+import (
+	"context"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+)
+
+// ImageData is an image loaded into memory along with its detected type.
+type ImageData struct {
+	Data []byte
+	Type imagetype.Type
+
+	// ETag and Last-Modified headers of the source response
+	SourceETag         string
+	SourceLastModified string
+
+	cancel context.CancelFunc
+}
+
+// New returns image data that calls cancel when it's closed. cancel may be
+// nil if there's nothing to release.
+func New(data []byte, imgtype imagetype.Type, cancel context.CancelFunc) *ImageData {
+	return &ImageData{Data: data, Type: imgtype, cancel: cancel}
+}
+
+// Close releases the resources held by the image data
+func (d *ImageData) Close() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
@@ -0,0 +1,39 @@
+package imagedata
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ImageDataTestSuite struct{ suite.Suite }
+
+func (s *ImageDataTestSuite) TestNew() {
+	data := []byte("test")
+
+	imgdata := New(data, imagetype.PNG, nil)
+
+	assert.Equal(s.T(), data, imgdata.Data)
+	assert.Equal(s.T(), imagetype.PNG, imgdata.Type)
+}
+
+func (s *ImageDataTestSuite) TestClose() {
+	cancelled := 0
+
+	imgdata := New([]byte("test"), imagetype.PNG, func() { cancelled++ })
+	imgdata.Close()
+
+	assert.Equal(s.T(), 1, cancelled)
+}
+
+func (s *ImageDataTestSuite) TestCloseWithoutCancel() {
+	imgdata := New([]byte("test"), imagetype.PNG, nil)
+
+	assert.NotPanics(s.T(), imgdata.Close)
+}
+
+func TestImageData(t *testing.T) {
+	suite.Run(t, new(ImageDataTestSuite))
+}
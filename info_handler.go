@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+type imageInfo struct {
+	Format string            `json:"format"`
+	Width  int               `json:"width"`
+	Height int               `json:"height"`
+	Size   int               `json:"size"`
+	Exif   map[string]string `json:"exif,omitempty"`
+	PHash  string            `json:"phash,omitempty"`
+	DHash  string            `json:"dhash,omitempty"`
+
+	Animation *animationInfo `json:"animation,omitempty"`
+
+	Result *resultInfo `json:"result,omitempty"`
+}
+
+// resultInfo describes the processing result when the info URL contains
+// processing options
+type resultInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+}
+
+// animationInfo describes an animated image. Delays and duration are in milliseconds.
+// Loop is the number of animation repetitions, 0 means infinite looping
+type animationInfo struct {
+	Frames   int   `json:"frames"`
+	Delays   []int `json:"delays"`
+	Duration int   `json:"duration"`
+	Loop     int   `json:"loop"`
+}
+
+// exifImageTypes are the image types that can contain EXIF data
+var exifImageTypes = map[imagetype.Type]bool{
+	imagetype.JPEG: true,
+	imagetype.PNG:  true,
+	imagetype.WEBP: true,
+	imagetype.HEIC: true,
+	imagetype.AVIF: true,
+	imagetype.TIFF: true,
+}
+
+func handleInfo(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if newRelicEnabled {
+		var newRelicCancel context.CancelFunc
+		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
+		defer newRelicCancel()
+	}
+
+	if prometheusEnabled {
+		prometheusRequestsTotal.Inc()
+		defer startPrometheusDuration(prometheusRequestDuration)()
+	}
+
+	imgURL, po, err := parseInfoPath(r)
+	if err != nil {
+		panic(err)
+	}
+
+	priority, timeout := options.PriorityNormal, conf.WriteTimeout
+	if po != nil {
+		priority, timeout = po.Priority, po.Timeout
+	}
+
+	if err = acquireProcessingSem(ctx, priority); err != nil {
+		panic(err)
+	}
+	defer releaseProcessingSem(priority)
+
+	if po != nil {
+		if err = useNonce(r, po.Nonce); err != nil {
+			panic(err)
+		}
+	}
+
+	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer timeoutCancel()
+
+	imgdata, _, _, downloadcancel, err := downloadImageWithRetries(ctx, imgURL)
+	defer downloadcancel()
+	if err != nil {
+		if prometheusEnabled {
+			incrementPrometheusErrorsTotal("download")
+		}
+		panic(err)
+	}
+
+	checkTimeout(ctx)
+
+	info, err := getImageInfo(imgdata)
+	if err != nil {
+		panic(err)
+	}
+
+	checkTimeout(ctx)
+
+	if po != nil {
+		if info.Result, err = probeProcessing(ctx, po, imgdata); err != nil {
+			if prometheusEnabled {
+				incrementPrometheusErrorsTotal("processing")
+			}
+			panic(err)
+		}
+
+		checkTimeout(ctx)
+	}
+
+	body, err := json.Marshal(info)
+	if err != nil {
+		panic(err)
+	}
+
+	logResponse(reqID, r, 200, nil, &imgURL, po)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", conf.TTL))
+	rw.WriteHeader(200)
+	rw.Write(body)
+}
+
+// parseInfoPath parses the info URL and returns the source image URL.
+// The info URL has the same format as the processing URL:
+// /info/%signature/%processing_options/%source_url
+// Processing options are optional. When they are present, processing options
+// are returned as well, otherwise returned processing options are nil
+func parseInfoPath(r *http.Request) (string, *options.ProcessingOptions, error) {
+	path := trimAfter(r.RequestURI, '?')
+	path = strings.TrimPrefix(path, conf.PathPrefix)
+	path = strings.TrimPrefix(path, "/info")
+	path = strings.TrimPrefix(path, "/")
+
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 {
+		return "", nil, ierrors.New(404, fmt.Sprintf("Invalid path: %s", path), msgInvalidURL)
+	}
+
+	signature, signedPath := parts[0], strings.TrimPrefix(path, parts[0])
+
+	var (
+		claims *jwtClaims
+		err    error
+	)
+
+	pairInd := -1
+
+	t := tenantFromContext(r.Context())
+
+	if jwtEnabled {
+		if claims, err = validateJWT(signature); err != nil {
+			logAuditEvent(r, err.Error(), -1)
+			return "", nil, ierrors.New(403, err.Error(), msgForbidden)
+		}
+	} else if t != nil && len(t.Keys) > 0 {
+		if err = validateTenantPath(t, signature, signedPath); err != nil {
+			logAuditEvent(r, err.Error(), -1)
+			return "", nil, ierrors.New(403, err.Error(), msgForbidden)
+		}
+	} else if !conf.AllowInsecure {
+		if pairInd, err = validatePath(signature, tenantSignedPath(t, signedPath)); err != nil {
+			logAuditEvent(r, err.Error(), -1)
+
+			if ierr, ok := err.(*ierrors.Error); ok {
+				return "", nil, ierr
+			}
+			return "", nil, ierrors.New(403, err.Error(), msgForbidden)
+		}
+	}
+
+	var urlOpts options.URLOptions
+
+	urlParts := parts[1:]
+
+	if conf.OnlyPresets {
+		if len(urlParts) > 1 && isPresetsList(tenantName(t), urlParts[0]) {
+			urlOpts = options.URLOptions{options.URLOption{Name: "preset", Args: strings.Split(urlParts[0], ":")}}
+			urlParts = urlParts[1:]
+		}
+	} else {
+		urlOpts, urlParts = options.ParseURLOptions(urlParts)
+	}
+
+	imageURL, extension, err := options.DecodeURL(urlParts, sourceBaseURL(tenantName(t)))
+	if err != nil {
+		return "", nil, ierrors.New(404, err.Error(), msgInvalidURL)
+	}
+
+	if !options.IsAllowedSource(imageURL) || !isAllowedSourceForTenant(t, imageURL) {
+		return "", nil, ierrors.New(404, "Invalid source", msgInvalidSource)
+	}
+
+	if !isAllowedSourceForKey(pairInd, imageURL) {
+		logAuditEvent(r, "Source is not allowed for the signature key", pairInd)
+		return "", nil, ierrors.New(403, "Source is not allowed for the signature key", msgForbidden)
+	}
+
+	if claims != nil {
+		if err = claims.check(imageURL, urlOptionNames(urlOpts)); err != nil {
+			logAuditEvent(r, err.Error(), -1)
+			return "", nil, ierrors.New(403, err.Error(), msgForbidden)
+		}
+	}
+
+	if err = setUsageSubject(r.Context(), t, pairInd); err != nil {
+		return "", nil, err
+	}
+
+	imageURL = options.NormalizeSourceURL(imageURL)
+
+	if len(urlOpts) == 0 && len(extension) == 0 {
+		return imageURL, nil, nil
+	}
+
+	headers := &options.Headers{
+		Accept:        r.Header.Get("Accept"),
+		Width:         r.Header.Get("Width"),
+		ViewportWidth: r.Header.Get("Viewport-Width"),
+		DPR:           r.Header.Get("DPR"),
+		Tenant:        tenantName(t),
+	}
+
+	po, err := options.DefaultProcessingOptions(headers)
+	if err != nil {
+		return "", nil, ierrors.New(404, err.Error(), msgInvalidURL)
+	}
+
+	if err = options.ApplyProcessingOptions(po, urlOpts); err != nil {
+		return "", nil, ierrors.New(404, err.Error(), msgInvalidURL)
+	}
+
+	if len(extension) > 0 {
+		if err = options.ApplyFormatOption(po, []string{extension}); err != nil {
+			return "", nil, ierrors.New(404, err.Error(), msgInvalidURL)
+		}
+	}
+
+	if err = checkNonce(po.Nonce); err != nil {
+		logAuditEvent(r, err.Error(), pairInd)
+		return "", nil, ierrors.New(403, err.Error(), msgForbidden)
+	}
+
+	return imageURL, po, nil
+}
+
+// isPresetsList checks if the string is a colon-separated list of known presets
+func isPresetsList(tenantName, str string) bool {
+	for _, name := range strings.Split(str, ":") {
+		if _, ok := getTenantPreset(tenantName, name); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getImageInfo(imgdata *imagedata.ImageData) (*imageInfo, error) {
+	meta, err := imagemeta.DecodeMeta(bytes.NewReader(imgdata.Data))
+	if err != nil {
+		return nil, ierrors.NewUnexpected(err.Error(), 0)
+	}
+
+	info := imageInfo{
+		Format: imgdata.Type.String(),
+		Width:  meta.Width(),
+		Height: meta.Height(),
+		Size:   len(imgdata.Data),
+	}
+
+	if (conf.InfoExif && exifImageTypes[imgdata.Type]) || conf.InfoPerceptualHashes || vips.SupportsAnimation(imgdata.Type) {
+		if err = analyzeImage(imgdata, &info); err != nil {
+			return nil, err
+		}
+	}
+
+	return &info, nil
+}
+
+// analyzeImage loads the image with libvips and fills the info fields
+// that require the decoded image
+func analyzeImage(imgdata *imagedata.ImageData, info *imageInfo) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	exif := conf.InfoExif && exifImageTypes[imgdata.Type]
+
+	if imgdata.Type == imagetype.SVG && !vips.SupportsLoad(imagetype.SVG) {
+		return processing.ErrSourceImageTypeNotSupported
+	}
+
+	if imgdata.Type == imagetype.ICO {
+		icodata, err := processing.GetIcoData(imgdata)
+		if err != nil {
+			return err
+		}
+
+		imgdata = icodata
+	}
+
+	animationSupport := vips.SupportsAnimation(imgdata.Type)
+
+	pages := 1
+	if animationSupport {
+		pages = -1
+	}
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	if err := img.Load(imgdata.Data, imgdata.Type, 1, 1.0, pages); err != nil {
+		return err
+	}
+
+	if exif {
+		info.Exif = img.Exif(conf.InfoExifGPS)
+	}
+
+	if animationSupport && img.IsAnimated() {
+		animation, err := getAnimationInfo(img)
+		if err != nil {
+			return err
+		}
+
+		if animation.Frames > 1 {
+			info.Animation = animation
+		}
+
+		// Perceptual hashes are calculated for the first frame
+		if err = img.Crop(0, 0, img.Width(), img.Height()/animation.Frames); err != nil {
+			return err
+		}
+	}
+
+	if conf.InfoPerceptualHashes {
+		var err error
+		if info.PHash, info.DHash, err = calcPerceptualHashes(img); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getAnimationInfo(img *vips.Image) (*animationInfo, error) {
+	frameHeight, err := img.GetInt("page-height")
+	if err != nil {
+		return nil, err
+	}
+
+	animation := animationInfo{Frames: img.Height() / frameHeight}
+
+	// libvips 8.9+ provides per-frame delays, older versions provide
+	// only the delay of the first frame in centiseconds
+	if delays, err := img.FrameDelays(); err == nil && len(delays) == animation.Frames {
+		animation.Delays = delays
+	} else {
+		delay, err := img.GetInt("gif-delay")
+		if err != nil {
+			return nil, err
+		}
+
+		animation.Delays = make([]int, animation.Frames)
+		for i := range animation.Delays {
+			animation.Delays[i] = delay * 10
+		}
+	}
+
+	for _, d := range animation.Delays {
+		animation.Duration += d
+	}
+
+	if animation.Loop, err = img.GetInt("loop"); err != nil {
+		if animation.Loop, err = img.GetInt("gif-loop"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &animation, nil
+}
+
+// probeProcessing processes the image and returns the result info.
+// The result itself is discarded
+func probeProcessing(ctx context.Context, po *options.ProcessingOptions, imgdata *imagedata.ImageData) (*resultInfo, error) {
+	if shouldSkipProcessing(po, imgdata) {
+		po.Format = imgdata.Type
+		return getResultInfo(po.Format, imgdata.Data)
+	}
+
+	resolveResultFormat(po, imgdata)
+
+	// SVG is never processed, so the result is the source image
+	if po.Format == imagetype.SVG && imgdata.Type == imagetype.SVG {
+		return getResultInfo(po.Format, imgdata.Data)
+	}
+
+	buf := responseBufPool.Get(0)
+	defer responseBufPool.Put(buf)
+
+	processcancel, err := processing.ProcessImage(ctx, buf, po, imgdata)
+	defer processcancel()
+	if err != nil {
+		return nil, err
+	}
+
+	return getResultInfo(po.Format, buf.Bytes())
+}
+
+func getResultInfo(format imagetype.Type, data []byte) (*resultInfo, error) {
+	meta, err := imagemeta.DecodeMeta(bytes.NewReader(data))
+	if err != nil {
+		return nil, ierrors.NewUnexpected(err.Error(), 0)
+	}
+
+	return &resultInfo{
+		Format: format.String(),
+		Width:  meta.Width(),
+		Height: meta.Height(),
+		Size:   len(data),
+	}, nil
+}
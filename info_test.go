@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type InfoTestSuite struct{ MainTestSuite }
+
+func (s *InfoTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.InfoEnabled = true
+}
+
+func (s *InfoTestSuite) TestResponseBufPool() {
+	oldPool := responseBufPool
+	defer func() { responseBufPool = oldPool }()
+
+	responseBufPool = nil
+
+	require.Nil(s.T(), initProcessingHandler())
+	assert.NotNil(s.T(), responseBufPool)
+}
+
+func TestInfo(t *testing.T) {
+	suite.Run(t, new(InfoTestSuite))
+}
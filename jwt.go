@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/options"
+)
+
+var (
+	jwtEnabled   = false
+	jwtPublicKey *rsa.PublicKey
+
+	errInvalidToken            = errors.New("Invalid token")
+	errInvalidTokenSignature   = errors.New("Invalid token signature")
+	errUnsupportedTokenAlg     = errors.New("Unsupported token algorithm")
+	errTokenExpired            = errors.New("Token is expired")
+	errTokenNotValidYet        = errors.New("Token is not valid yet")
+	errTokenSourceNotAllowed   = errors.New("Source is not allowed by the token")
+	errTokenOptionsNotAllowed  = errors.New("Processing options are not allowed by the token")
+	errTokenExpirationRequired = errors.New("Token has no expiration time")
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Source    string   `json:"src"`
+	Options   []string `json:"opts"`
+}
+
+func initJWT() error {
+	if len(conf.JWTSecret) == 0 && len(conf.JWTPublicKeyPath) == 0 {
+		return nil
+	}
+
+	if len(conf.JWTPublicKeyPath) > 0 {
+		data, err := ioutil.ReadFile(conf.JWTPublicKeyPath)
+		if err != nil {
+			return fmt.Errorf("Can't read JWT public key: %s", err)
+		}
+
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.New("Can't parse JWT public key: no PEM data found")
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("Can't parse JWT public key: %s", err)
+		}
+
+		var ok bool
+		if jwtPublicKey, ok = key.(*rsa.PublicKey); !ok {
+			return errors.New("JWT public key is not an RSA key")
+		}
+	}
+
+	jwtEnabled = true
+
+	logNotice("JWT authorization is enabled; URL signatures are not checked")
+
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errInvalidToken
+	}
+
+	if err = json.Unmarshal(data, v); err != nil {
+		return errInvalidToken
+	}
+
+	return nil
+}
+
+func validateJWT(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(conf.JWTSecret) == 0 {
+			return nil, errUnsupportedTokenAlg
+		}
+
+		mac := hmac.New(sha256.New, []byte(conf.JWTSecret))
+		mac.Write(signed)
+
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, errInvalidTokenSignature
+		}
+	case "RS256":
+		if jwtPublicKey == nil {
+			return nil, errUnsupportedTokenAlg
+		}
+
+		digest := sha256.Sum256(signed)
+
+		if err := rsa.VerifyPKCS1v15(jwtPublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, errInvalidTokenSignature
+		}
+	default:
+		return nil, errUnsupportedTokenAlg
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+
+	if claims.ExpiresAt == 0 {
+		return nil, errTokenExpirationRequired
+	}
+	if now >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore > 0 && now < claims.NotBefore {
+		return nil, errTokenNotValidYet
+	}
+
+	return &claims, nil
+}
+
+// jwtOptionNames returns names of the processing options used in the path
+func jwtOptionNames(parts []string) []string {
+	if conf.OnlyPresets {
+		return []string{"preset"}
+	}
+
+	if _, ok := options.ResizeTypes[parts[0]]; ok {
+		return []string{"resize", "gravity"}
+	}
+
+	urlOpts, _ := options.ParseURLOptions(parts)
+
+	return urlOptionNames(urlOpts)
+}
+
+func urlOptionNames(urlOpts options.URLOptions) []string {
+	names := make([]string, len(urlOpts))
+	for i, opt := range urlOpts {
+		names[i] = opt.Name
+	}
+
+	return names
+}
+
+func (c *jwtClaims) check(imageURL string, optionNames []string) error {
+	if !strings.HasPrefix(imageURL, c.Source) {
+		return errTokenSourceNotAllowed
+	}
+
+	if c.Options == nil {
+		return nil
+	}
+
+	for _, name := range optionNames {
+		allowed := false
+
+		for _, opt := range c.Options {
+			if opt == name {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return errTokenOptionsNotAllowed
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type JWTTestSuite struct{ MainTestSuite }
+
+func (s *JWTTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.JWTSecret = "test-secret"
+}
+
+func (s *JWTTestSuite) token(alg string, claims jwtClaims) string {
+	header, _ := json.Marshal(jwtHeader{Alg: alg})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte("test-secret"))
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *JWTTestSuite) TestValidateJWT() {
+	token := s.token("HS256", jwtClaims{
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Source:    "s3://bucket/",
+	})
+
+	claims, err := validateJWT(token)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "s3://bucket/", claims.Source)
+}
+
+func (s *JWTTestSuite) TestValidateJWTInvalidSignature() {
+	token := s.token("HS256", jwtClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	conf.JWTSecret = "other-secret"
+
+	_, err := validateJWT(token)
+	assert.Equal(s.T(), errInvalidTokenSignature, err)
+}
+
+func (s *JWTTestSuite) TestValidateJWTExpired() {
+	token := s.token("HS256", jwtClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+
+	_, err := validateJWT(token)
+	assert.Equal(s.T(), errTokenExpired, err)
+}
+
+func (s *JWTTestSuite) TestValidateJWTNoExpiration() {
+	token := s.token("HS256", jwtClaims{})
+
+	_, err := validateJWT(token)
+	assert.Equal(s.T(), errTokenExpirationRequired, err)
+}
+
+func (s *JWTTestSuite) TestValidateJWTUnsupportedAlg() {
+	token := s.token("none", jwtClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	_, err := validateJWT(token)
+	assert.Equal(s.T(), errUnsupportedTokenAlg, err)
+}
+
+func (s *JWTTestSuite) TestClaimsCheck() {
+	claims := jwtClaims{Source: "s3://bucket/", Options: []string{"resize", "quality"}}
+
+	assert.Nil(s.T(), claims.check("s3://bucket/image.jpg", []string{"resize"}))
+	assert.Equal(s.T(), errTokenSourceNotAllowed, claims.check("s3://other/image.jpg", []string{"resize"}))
+	assert.Equal(s.T(), errTokenOptionsNotAllowed, claims.check("s3://bucket/image.jpg", []string{"resize", "blur"}))
+}
+
+func TestJWT(t *testing.T) {
+	suite.Run(t, new(JWTTestSuite))
+}
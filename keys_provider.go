@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/imgproxy/imgproxy/v2/config"
+)
+
+// keysMutex guards conf.Keys and conf.Salts since they can be refreshed
+// while the server is running. conf.KeySources is read under it as well
+// since its items match the key/salt pairs by index
+var keysMutex sync.RWMutex
+
+// keysProvider loads key/salt pairs from an external storage.
+// Nil keys or salts mean they are not provided and should stay the same.
+type keysProvider interface {
+	Load() (keys, salts []config.SecurityKey, err error)
+}
+
+func newKeysProvider() (keysProvider, error) {
+	switch conf.KeysProvider {
+	case "":
+		if len(conf.KeyPath) > 0 || len(conf.SaltPath) > 0 {
+			return fileKeysProvider{}, nil
+		}
+		return nil, nil
+	case "aws_secrets_manager":
+		return newAWSKeysProvider()
+	case "vault":
+		return newVaultKeysProvider(), nil
+	default:
+		return nil, fmt.Errorf("Unknown keys provider: %s", conf.KeysProvider)
+	}
+}
+
+func loadKeys(p keysProvider) error {
+	keys, salts, err := p.Load()
+	if err != nil {
+		return err
+	}
+
+	keysMutex.Lock()
+	defer keysMutex.Unlock()
+
+	if keys == nil {
+		keys = conf.Keys
+	}
+	if salts == nil {
+		salts = conf.Salts
+	}
+
+	if len(keys) != len(salts) {
+		return fmt.Errorf("Number of keys and number of salts should be equal. Keys: %d, salts: %d", len(keys), len(salts))
+	}
+	if len(conf.KeySources) > 0 && len(conf.KeySources) != len(keys) {
+		return fmt.Errorf("Number of key sources and number of keys should be equal. Keys: %d, key sources: %d", len(keys), len(conf.KeySources))
+	}
+
+	conf.Keys, conf.Salts = keys, salts
+
+	return nil
+}
+
+func startKeysRefreshing() {
+	p, err := newKeysProvider()
+	if err != nil || p == nil || conf.KeysRefreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(time.Duration(conf.KeysRefreshInterval) * time.Second) {
+			if err := loadKeys(p); err != nil {
+				logError("Can't refresh keys: %s", err)
+			}
+		}
+	}()
+}
+
+// fileKeysProvider loads keys and salts from IMGPROXY_KEY_PATH and IMGPROXY_SALT_PATH
+type fileKeysProvider struct{}
+
+func (fileKeysProvider) Load() (keys, salts []config.SecurityKey, err error) {
+	if err = config.HexFile(&keys, conf.KeyPath); err != nil {
+		return
+	}
+
+	err = config.HexFile(&salts, conf.SaltPath)
+
+	return
+}
+
+type secretKeys struct {
+	Key  string `json:"key"`
+	Salt string `json:"salt"`
+}
+
+func (s secretKeys) decode() (keys, salts []config.SecurityKey, err error) {
+	if keys, err = decodeHexKeys(s.Key); err != nil {
+		return
+	}
+
+	salts, err = decodeHexKeys(s.Salt)
+
+	return
+}
+
+func decodeHexKeys(str string) ([]config.SecurityKey, error) {
+	parts := strings.Split(str, ",")
+	keys := make([]config.SecurityKey, 0, len(parts))
+
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); len(part) == 0 {
+			continue
+		}
+
+		key, err := hex.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("Keys expected to be hex-encoded strings. Invalid: %s", part)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// awsKeysProvider loads keys and salts from AWS Secrets Manager
+// and calculates remote signatures with AWS KMS
+type awsKeysProvider struct {
+	svc *secretsmanager.SecretsManager
+	kms *kms.KMS
+}
+
+func newAWSKeysProvider() (keysProvider, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("Can't create AWS session: %s", err)
+	}
+
+	if sess.Config.Region == nil || len(*sess.Config.Region) == 0 {
+		sess.Config.Region = aws.String("us-west-1")
+	}
+
+	return awsKeysProvider{secretsmanager.New(sess), kms.New(sess)}, nil
+}
+
+func (p awsKeysProvider) Load() ([]config.SecurityKey, []config.SecurityKey, error) {
+	out, err := p.svc.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(conf.KeysAWSSecretID),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't get keys from AWS Secrets Manager: %s", err)
+	}
+
+	var s secretKeys
+	if err := json.Unmarshal([]byte(aws.StringValue(out.SecretString)), &s); err != nil {
+		return nil, nil, fmt.Errorf("Can't parse keys from AWS Secrets Manager: %s", err)
+	}
+
+	return s.decode()
+}
+
+// vaultKeysProvider loads keys and salts from HashiCorp Vault KV secrets engine
+// and calculates remote signatures with Vault transit secrets engine
+type vaultKeysProvider struct {
+	client *http.Client
+}
+
+func newVaultKeysProvider() keysProvider {
+	return vaultKeysProvider{&http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p vaultKeysProvider) Load() ([]config.SecurityKey, []config.SecurityKey, error) {
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(conf.KeysVaultAddress, "/"), strings.TrimPrefix(conf.KeysVaultPath, "/"))
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("X-Vault-Token", conf.KeysVaultToken)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't get keys from Vault: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("Can't get keys from Vault: status %d", res.StatusCode)
+	}
+
+	// KV version 2 nests the secret into one more "data" object
+	var body struct {
+		Data struct {
+			secretKeys
+			Data *secretKeys `json:"data"`
+		} `json:"data"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, nil, fmt.Errorf("Can't parse keys from Vault: %s", err)
+	}
+
+	if body.Data.Data != nil {
+		return body.Data.Data.decode()
+	}
+
+	return body.Data.secretKeys.decode()
+}
@@ -1,15 +1,38 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/options"
 	logrus "github.com/sirupsen/logrus"
 )
 
+const (
+	accessLogFormatCommon   = `{remote_addr} - - [{time}] "{method} {uri} {proto}" {status} -`
+	accessLogFormatCombined = accessLogFormatCommon + ` "{referer}" "{user_agent}"`
+)
+
+var (
+	accessLogEnabled = true
+	// accessLogFormat is the template of access log lines.
+	// When blank, requests and responses are logged with fields.
+	accessLogFormat string
+	// slowRequestThreshold is the duration in seconds above which
+	// the request timing breakdown is logged. 0 disables the feature.
+	slowRequestThreshold float64
+)
+
 func initLog() error {
 	logFormat := "pretty"
-	strEnvConfig(&logFormat, "IMGPROXY_LOG_FORMAT")
+	config.StringEnv(&logFormat, "IMGPROXY_LOG_FORMAT")
 
 	switch logFormat {
 	case "structured":
@@ -21,7 +44,7 @@ func initLog() error {
 	}
 
 	logLevel := "info"
-	strEnvConfig(&logLevel, "IMGPROXY_LOG_LEVEL")
+	config.StringEnv(&logLevel, "IMGPROXY_LOG_LEVEL")
 
 	levelLogLevel, err := logrus.ParseLevel(logLevel)
 	if err != nil {
@@ -30,6 +53,18 @@ func initLog() error {
 
 	logrus.SetLevel(levelLogLevel)
 
+	config.BoolEnv(&accessLogEnabled, "IMGPROXY_ACCESS_LOG_ENABLE")
+	config.StringEnv(&accessLogFormat, "IMGPROXY_ACCESS_LOG_FORMAT")
+
+	config.FloatEnv(&slowRequestThreshold, "IMGPROXY_SLOW_REQUEST_THRESHOLD")
+
+	switch accessLogFormat {
+	case "common":
+		accessLogFormat = accessLogFormatCommon
+	case "combined":
+		accessLogFormat = accessLogFormatCombined
+	}
+
 	if isSyslogEnabled() {
 		slHook, err := newSyslogHook()
 		if err != nil {
@@ -43,6 +78,10 @@ func initLog() error {
 }
 
 func logRequest(reqID string, r *http.Request) {
+	if !accessLogEnabled || len(accessLogFormat) > 0 {
+		return
+	}
+
 	path := r.RequestURI
 
 	logrus.WithFields(logrus.Fields{
@@ -51,7 +90,12 @@ func logRequest(reqID string, r *http.Request) {
 	}).Infof("Started %s", path)
 }
 
-func logResponse(reqID string, r *http.Request, status int, err *imgproxyError, imageURL *string, po *processingOptions) {
+func logResponse(reqID string, r *http.Request, status int, err *ierrors.Error, imageURL *string, po *options.ProcessingOptions) {
+	// Errors are logged even when access log is disabled
+	if !accessLogEnabled && err == nil {
+		return
+	}
+
 	var level logrus.Level
 
 	switch {
@@ -63,11 +107,7 @@ func logResponse(reqID string, r *http.Request, status int, err *imgproxyError,
 		level = logrus.InfoLevel
 	}
 
-	fields := logrus.Fields{
-		"request_id": reqID,
-		"method":     r.Method,
-		"status":     status,
-	}
+	fields := logrus.Fields{}
 
 	if err != nil {
 		fields["error"] = err
@@ -77,6 +117,15 @@ func logResponse(reqID string, r *http.Request, status int, err *imgproxyError,
 		}
 	}
 
+	if accessLogEnabled && len(accessLogFormat) > 0 {
+		logrus.WithFields(fields).Log(level, formatAccessLog(reqID, r, status, imageURL))
+		return
+	}
+
+	fields["request_id"] = reqID
+	fields["method"] = r.Method
+	fields["status"] = status
+
 	if imageURL != nil {
 		fields["image_url"] = *imageURL
 	}
@@ -91,6 +140,32 @@ func logResponse(reqID string, r *http.Request, status int, err *imgproxyError,
 	)
 }
 
+func formatAccessLog(reqID string, r *http.Request, status int, imageURL *string) string {
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	imgURL := "-"
+	if imageURL != nil {
+		imgURL = *imageURL
+	}
+
+	return strings.NewReplacer(
+		"{remote_addr}", remoteAddr,
+		"{time}", time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		"{method}", r.Method,
+		"{uri}", r.RequestURI,
+		"{proto}", r.Proto,
+		"{status}", strconv.Itoa(status),
+		"{referer}", r.Referer(),
+		"{user_agent}", r.UserAgent(),
+		"{duration}", strconv.FormatFloat(getTimerSince(r.Context()).Seconds(), 'f', 3, 64),
+		"{request_id}", reqID,
+		"{image_url}", imgURL,
+	).Replace(accessLogFormat)
+}
+
 func logNotice(f string, args ...interface{}) {
 	logrus.Infof(f, args...)
 }
@@ -110,3 +185,23 @@ func logFatal(f string, args ...interface{}) {
 func logDebug(f string, args ...interface{}) {
 	logrus.Debugf(f, args...)
 }
+
+func logSlowRequest(ctx context.Context, reqID string, r *http.Request) {
+	d := getTimerSince(ctx)
+	if d.Seconds() < slowRequestThreshold {
+		return
+	}
+
+	timings, _ := ctx.Value(timingsCtxKey).(requestTimings)
+
+	fields := logrus.Fields{
+		"request_id": reqID,
+		"total":      d.String(),
+	}
+
+	for _, stage := range timingStages {
+		fields[stage] = timings[stage].String()
+	}
+
+	logrus.WithFields(fields).Warnf("Slow request %s", r.RequestURI)
+}
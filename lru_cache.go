@@ -0,0 +1,115 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type lruCacheItem struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// lruCache is a cache of values with TTL. When the cache is full,
+// the least recently used value is evicted.
+type lruCache struct {
+	mutex sync.Mutex
+	size  int
+	list  *list.List
+	items map[string]*list.Element
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		list:  list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored with the key if it's not expired
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.get(key, time.Now()); ok {
+		return el.Value.(*lruCacheItem).value, true
+	}
+
+	return nil, false
+}
+
+// Add stores the value if the key is not stored yet or is expired.
+// Returns false if the key is already stored
+func (c *lruCache) Add(key string, value interface{}, ttl time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+
+	if _, ok := c.get(key, now); ok {
+		return false
+	}
+
+	c.push(key, value, now.Add(ttl))
+
+	return true
+}
+
+// Set stores the value replacing the previously stored one
+func (c *lruCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.remove(key)
+	c.push(key, value, time.Now().Add(ttl))
+}
+
+// Delete removes the key from the cache
+func (c *lruCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.remove(key)
+}
+
+func (c *lruCache) get(key string, now time.Time) (*list.Element, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !el.Value.(*lruCacheItem).expiresAt.After(now) {
+		c.list.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.list.MoveToFront(el)
+
+	return el, true
+}
+
+func (c *lruCache) push(key string, value interface{}, expiresAt time.Time) {
+	if c.list.Len() >= c.size {
+		if el := c.list.Back(); el != nil {
+			c.list.Remove(el)
+			delete(c.items, el.Value.(*lruCacheItem).key)
+		}
+	}
+
+	c.items[key] = c.list.PushFront(&lruCacheItem{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+}
+
+func (c *lruCache) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.list.Remove(el)
+		delete(c.items, key)
+	}
+}
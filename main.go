@@ -6,12 +6,12 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"runtime"
 	"syscall"
-	"time"
-)
 
-const version = "2.15.0"
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
 
 type ctxKey string
 
@@ -26,24 +26,71 @@ func initialize() error {
 		return err
 	}
 
+	if err := initAuditLog(); err != nil {
+		return err
+	}
+
+	if err := initRemoteSigner(); err != nil {
+		return err
+	}
+
+	if err := initJWT(); err != nil {
+		return err
+	}
+
+	if err := initNonceStore(); err != nil {
+		return err
+	}
+
 	if err := initNewrelic(); err != nil {
 		return err
 	}
 
 	initPrometheus()
 
+	if err := initXRay(); err != nil {
+		return err
+	}
+
 	if err := initDownloading(); err != nil {
 		return err
 	}
 
+	if err := initResultCache(); err != nil {
+		return err
+	}
+
+	if err := initErrorResponses(); err != nil {
+		return err
+	}
+
 	initErrorsReporting()
 
-	if err := initVips(); err != nil {
+	if err := initFonts(); err != nil {
+		shutdownFonts()
 		return err
 	}
 
-	if err := checkPresets(conf.Presets); err != nil {
-		shutdownVips()
+	if err := vips.Init(); err != nil {
+		shutdownFonts()
+		return err
+	}
+
+	if err := watermark.Init(); err != nil {
+		vips.Shutdown()
+		shutdownFonts()
+		return fmt.Errorf("Can't load watermark: %s", err)
+	}
+
+	if err := options.CheckPresets(options.AllPresets()); err != nil {
+		vips.Shutdown()
+		shutdownFonts()
+		return err
+	}
+
+	if err := initTenants(); err != nil {
+		vips.Shutdown()
+		shutdownFonts()
 		return err
 	}
 
@@ -55,21 +102,20 @@ func run() error {
 		return err
 	}
 
-	defer shutdownVips()
+	defer shutdownFonts()
+	defer vips.Shutdown()
+	defer flushErrorsReporting()
 
-	go func() {
-		var logMemStats = len(os.Getenv("IMGPROXY_LOG_MEM_STATS")) > 0
+	memRestart := startMemoryWatchdog()
 
-		for range time.Tick(time.Duration(conf.FreeMemoryInterval) * time.Second) {
-			freeMemory()
+	startKeysRefreshing()
+	startPresetsWatching()
 
-			if logMemStats {
-				var m runtime.MemStats
-				runtime.ReadMemStats(&m)
-				logDebug("MEMORY USAGE: Sys=%d HeapIdle=%d HeapInuse=%d", m.Sys/1024/1024, m.HeapIdle/1024/1024, m.HeapInuse/1024/1024)
-			}
-		}
-	}()
+	if err := initProcessingHandler(); err != nil {
+		return err
+	}
+
+	startFallbackImageRefreshing()
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -79,21 +125,67 @@ func run() error {
 		}
 	}
 
-	s, err := startServer(cancel)
+	if len(conf.AdminBind) > 0 {
+		s, err := startAdminServer(cancel)
+		if err != nil {
+			return err
+		}
+		defer shutdownServer(s)
+	}
+
+	s, err := startServer(cancel, "server", conf.Bind, conf.BindRoutes)
 	if err != nil {
 		return err
 	}
 	defer shutdownServer(s)
 
+	for i, b := range conf.AdditionalBinds {
+		s, err := startServer(cancel, fmt.Sprintf("server%d", i+1), b.Address, b.Routes)
+		if err != nil {
+			return err
+		}
+		defer shutdownServer(s)
+	}
+
+	notifyUpgradeReady()
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	select {
-	case <-ctx.Done():
-	case <-stop:
-	}
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stop:
+			return nil
+		case <-reload:
+			reloadConfig()
+		case <-upgrade:
+			logNotice("Upgrading...")
+
+			if err := upgradeProcess(); err != nil {
+				logError("Can't upgrade: %s", err)
+				continue
+			}
 
-	return nil
+			return nil
+		case <-memRestart:
+			logNotice("Restarting due to high memory usage...")
+
+			if err := upgradeProcess(); err != nil {
+				logError("Can't restart: %s", err)
+				continue
+			}
+
+			return nil
+		}
+	}
 }
 
 func main() {
@@ -101,8 +193,14 @@ func main() {
 		switch os.Args[1] {
 		case "health":
 			os.Exit(healthcheck())
+		case "bench":
+			os.Exit(benchmark(os.Args[2:]))
+		case "url":
+			os.Exit(urlCommand(os.Args[2:]))
+		case "validate-config":
+			os.Exit(validateConfigCommand())
 		case "version":
-			fmt.Println(version)
+			fmt.Println(config.Version)
 			os.Exit(0)
 		}
 	}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"net/http"
 	"os"
 	"testing"
 
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/options"
 	"github.com/stretchr/testify/suite"
 )
 
 type MainTestSuite struct {
 	suite.Suite
 
-	oldConf config
+	oldConf    config.Config
+	oldPresets options.Presets
 }
 
 func TestMain(m *testing.M) {
@@ -19,9 +23,15 @@ func TestMain(m *testing.M) {
 }
 
 func (s *MainTestSuite) SetupTest() {
-	s.oldConf = conf
+	s.oldConf = *conf
+	s.oldPresets = options.AllPresets()
 }
 
 func (s *MainTestSuite) TearDownTest() {
-	conf = s.oldConf
+	*conf = s.oldConf
+	options.SetPresets(s.oldPresets)
+}
+
+func (s *MainTestSuite) getRequest(uri string) *http.Request {
+	return &http.Request{Method: "GET", RequestURI: uri, Header: make(http.Header)}
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+// startMemoryWatchdog periodically returns unused memory to the OS and checks
+// the memory usage. When IMGPROXY_MEMORY_RESTART_THRESHOLD is set and the memory
+// usage exceeds it, the returned channel receives a signal to restart the process.
+func startMemoryWatchdog() <-chan struct{} {
+	restart := make(chan struct{}, 1)
+
+	logMemStats := len(os.Getenv("IMGPROXY_LOG_MEM_STATS")) > 0
+	threshold := uint64(conf.MemoryRestartThreshold) * 1024 * 1024
+
+	go func() {
+		for range time.Tick(time.Duration(conf.FreeMemoryInterval) * time.Second) {
+			freeMemory()
+
+			rss := getRSS()
+			vipsMem := uint64(vips.GetMem())
+
+			if logMemStats {
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				logDebug(
+					"MEMORY USAGE: RSS=%d Sys=%d HeapIdle=%d HeapInuse=%d Vips=%d",
+					rss/1024/1024, m.Sys/1024/1024, m.HeapIdle/1024/1024, m.HeapInuse/1024/1024, vipsMem/1024/1024,
+				)
+			}
+
+			if threshold == 0 {
+				continue
+			}
+
+			// RSS is not available on all platforms,
+			// so we also check the memory tracked by libvips
+			if rss > threshold || vipsMem > threshold {
+				logWarning(
+					"Memory usage exceeds the restart threshold: RSS=%dMB Vips=%dMB Threshold=%dMB",
+					rss/1024/1024, vipsMem/1024/1024, conf.MemoryRestartThreshold,
+				)
+
+				select {
+				case restart <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return restart
+}
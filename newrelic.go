@@ -53,6 +53,11 @@ func startNewRelicSegment(ctx context.Context, name string) context.CancelFunc {
 	return func() { segment.End() }
 }
 
+func setNewRelicAttribute(ctx context.Context, key string, value interface{}) {
+	txn := ctx.Value(newRelicTransactionCtxKey).(newrelic.Transaction)
+	txn.AddAttribute(key, value)
+}
+
 func sendErrorToNewRelic(ctx context.Context, err error) {
 	txn := ctx.Value(newRelicTransactionCtxKey).(newrelic.Transaction)
 	txn.NoticeError(err)
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+)
+
+var (
+	nonces nonceStore
+
+	errNonceRequired = errors.New("Nonce is required")
+	errNonceUsed     = errors.New("Nonce has already been used")
+)
+
+// nonceStore remembers used nonces
+type nonceStore interface {
+	// Use marks the nonce as used and returns false if it was used before
+	Use(nonce string) (bool, error)
+}
+
+func initNonceStore() error {
+	switch conf.NonceStore {
+	case "":
+		return nil
+	case "memory":
+		nonces = newMemoryNonceStore(conf.NonceMemoryStoreSize)
+	case "redis":
+		opts, err := redis.ParseURL(conf.NonceRedisURL)
+		if err != nil {
+			return fmt.Errorf("Can't parse nonce Redis URL: %s", err)
+		}
+
+		nonces = redisNonceStore{redis.NewClient(opts)}
+	default:
+		return fmt.Errorf("Unknown nonce store: %s", conf.NonceStore)
+	}
+
+	return nil
+}
+
+// checkNonce checks if the nonce is provided when it's required.
+// The nonce is marked as used later by useNonce
+func checkNonce(nonce string) error {
+	if nonces == nil || len(nonce) > 0 || !conf.NonceRequired {
+		return nil
+	}
+
+	return errNonceRequired
+}
+
+// useNonce marks the nonce as used. It should be called only when the request
+// is accepted for processing, so rejected requests don't use up their nonces
+func useNonce(r *http.Request, nonce string) error {
+	if nonces == nil || len(nonce) == 0 {
+		return nil
+	}
+
+	ok, err := nonces.Use(nonce)
+	if err != nil {
+		return ierrors.New(503, err.Error(), "Can't check nonce").SetUnexpected(true)
+	}
+	if !ok {
+		logAuditEvent(r, errNonceUsed.Error(), -1)
+		return ierrors.New(403, errNonceUsed.Error(), msgForbidden)
+	}
+
+	return nil
+}
+
+// memoryNonceStore is an LRU store of nonces. When the store is full,
+// the least recently used nonce is evicted.
+type memoryNonceStore struct {
+	cache *lruCache
+}
+
+func newMemoryNonceStore(size int) *memoryNonceStore {
+	return &memoryNonceStore{newLRUCache(size)}
+}
+
+func (s *memoryNonceStore) Use(nonce string) (bool, error) {
+	return s.cache.Add(nonce, nil, time.Duration(conf.NonceTTL)*time.Second), nil
+}
+
+type redisNonceStore struct {
+	client *redis.Client
+}
+
+func (s redisNonceStore) Use(nonce string) (bool, error) {
+	ok, err := s.client.SetNX("imgproxy:nonce:"+nonce, 1, time.Duration(conf.NonceTTL)*time.Second).Result()
+	if err != nil {
+		return false, fmt.Errorf("Can't check nonce: %s", err)
+	}
+
+	return ok, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type NonceStoreTestSuite struct{ MainTestSuite }
+
+func (s *NonceStoreTestSuite) TestMemoryNonceStore() {
+	store := newMemoryNonceStore(2)
+
+	ok, _ := store.Use("a")
+	assert.True(s.T(), ok)
+
+	ok, _ = store.Use("a")
+	assert.False(s.T(), ok)
+}
+
+func (s *NonceStoreTestSuite) TestMemoryNonceStoreEviction() {
+	store := newMemoryNonceStore(2)
+
+	store.Use("a")
+	store.Use("b")
+	store.Use("c")
+
+	ok, _ := store.Use("a")
+	assert.True(s.T(), ok)
+
+	ok, _ = store.Use("c")
+	assert.False(s.T(), ok)
+}
+
+func (s *NonceStoreTestSuite) TestCheckNonce() {
+	oldNonces := nonces
+	defer func() { nonces = oldNonces }()
+
+	nonces = newMemoryNonceStore(10)
+
+	assert.Nil(s.T(), checkNonce(""))
+	assert.Nil(s.T(), checkNonce("a"))
+	assert.Nil(s.T(), checkNonce("a"))
+
+	conf.NonceRequired = true
+
+	assert.Equal(s.T(), errNonceRequired, checkNonce(""))
+}
+
+func (s *NonceStoreTestSuite) TestUseNonce() {
+	oldNonces := nonces
+	defer func() { nonces = oldNonces }()
+
+	nonces = newMemoryNonceStore(10)
+
+	req := httptest.NewRequest("GET", "/", nil)
+
+	assert.Nil(s.T(), useNonce(req, ""))
+	assert.Nil(s.T(), useNonce(req, "a"))
+
+	err := useNonce(req, "a")
+	if assert.IsType(s.T(), &ierrors.Error{}, err) {
+		assert.Equal(s.T(), 403, err.(*ierrors.Error).StatusCode)
+	}
+}
+
+type failingNonceStore struct{}
+
+func (failingNonceStore) Use(nonce string) (bool, error) {
+	return false, errors.New("Can't check nonce: connection refused")
+}
+
+func (s *NonceStoreTestSuite) TestUseNonceStoreError() {
+	oldNonces := nonces
+	defer func() { nonces = oldNonces }()
+
+	nonces = failingNonceStore{}
+
+	err := useNonce(httptest.NewRequest("GET", "/", nil), "a")
+	if assert.IsType(s.T(), &ierrors.Error{}, err) {
+		assert.Equal(s.T(), 503, err.(*ierrors.Error).StatusCode)
+	}
+}
+
+func TestNonceStore(t *testing.T) {
+	suite.Run(t, new(NonceStoreTestSuite))
+}
@@ -0,0 +1,28 @@
+package options
+
+import "github.com/imgproxy/imgproxy/v2/config"
+
+// srgbColorProfile is the name of the sRGB profile built into libvips 8.8+.
+// It can be used without being set in IMGPROXY_COLOR_PROFILES
+const srgbColorProfile = "srgb"
+
+// IsKnownColorProfile checks if the color profile is set in
+// IMGPROXY_COLOR_PROFILES or is built into libvips
+func IsKnownColorProfile(name string) bool {
+	if name == srgbColorProfile {
+		return true
+	}
+
+	_, ok := config.Conf.ColorProfiles[name]
+	return ok
+}
+
+// ColorProfilePath returns the path to the ICC file of the color profile
+// or the name of the libvips built-in profile
+func ColorProfilePath(name string) string {
+	if path, ok := config.Conf.ColorProfiles[name]; ok {
+		return path
+	}
+
+	return name
+}
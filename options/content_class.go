@@ -0,0 +1,20 @@
+package options
+
+// ContentClass is the class of the source image content
+type ContentClass int
+
+const (
+	ContentClassUnknown ContentClass = iota
+	ContentClassPhoto
+	ContentClassGraphic
+)
+
+var contentClassNames = map[ContentClass]string{
+	ContentClassUnknown: "unknown",
+	ContentClassPhoto:   "photo",
+	ContentClassGraphic: "graphic",
+}
+
+func (c ContentClass) String() string {
+	return contentClassNames[c]
+}
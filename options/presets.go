@@ -0,0 +1,110 @@
+package options
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Presets are named sets of processing options
+type Presets map[string]URLOptions
+
+var (
+	// loadedPresets are the global presets set with SetPresets
+	loadedPresets = make(Presets)
+	// presetsMutex guards loadedPresets since they can be reloaded
+	// while the server is running
+	presetsMutex sync.RWMutex
+)
+
+// GetPreset returns the global preset by its name
+func GetPreset(name string) (URLOptions, bool) {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+
+	p, ok := loadedPresets[name]
+	return p, ok
+}
+
+// SetPresets replaces the global presets
+func SetPresets(p Presets) {
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+
+	loadedPresets = p
+}
+
+// AllPresets returns a copy of the loaded presets
+func AllPresets() Presets {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+
+	p := make(Presets, len(loadedPresets))
+	for name, opts := range loadedPresets {
+		p[name] = opts
+	}
+
+	return p
+}
+
+// ParsePreset parses the `name=option1/option2` preset string and adds
+// the preset to p. Empty strings and comments are ignored
+func ParsePreset(p Presets, presetStr string) error {
+	presetStr = strings.Trim(presetStr, " ")
+
+	if len(presetStr) == 0 || strings.HasPrefix(presetStr, "#") {
+		return nil
+	}
+
+	parts := strings.Split(presetStr, "=")
+
+	if len(parts) != 2 {
+		return fmt.Errorf("Invalid preset string: %s", presetStr)
+	}
+
+	name := strings.Trim(parts[0], " ")
+	if len(name) == 0 {
+		return fmt.Errorf("Empty preset name: %s", presetStr)
+	}
+
+	value := strings.Trim(parts[1], " ")
+	if len(value) == 0 {
+		return fmt.Errorf("Empty preset value: %s", presetStr)
+	}
+
+	optsStr := strings.Split(value, "/")
+
+	opts, rest := ParseURLOptions(optsStr)
+
+	if len(rest) > 0 {
+		return fmt.Errorf("Invalid preset value: %s", presetStr)
+	}
+
+	p[name] = opts
+
+	return nil
+}
+
+// FormatPreset formats the preset options the way ParsePreset reads them
+func FormatPreset(opts URLOptions) string {
+	parts := make([]string, len(opts))
+
+	for i, opt := range opts {
+		parts[i] = strings.Join(append([]string{opt.Name}, opt.Args...), ":")
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// CheckPresets checks that the options of the presets are valid
+func CheckPresets(p Presets) error {
+	var po ProcessingOptions
+
+	for name, opts := range p {
+		if err := ApplyProcessingOptions(&po, opts); err != nil {
+			return fmt.Errorf("Error in preset `%s`: %s", name, err)
+		}
+	}
+
+	return nil
+}
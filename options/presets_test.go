@@ -0,0 +1,113 @@
+package options
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type PresetsTestSuite struct{ suite.Suite }
+
+func (s *PresetsTestSuite) TestParsePreset() {
+	p := make(Presets)
+
+	err := ParsePreset(p, "test=resize:fit:100:200/sharpen:2")
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), URLOptions{
+		URLOption{Name: "resize", Args: []string{"fit", "100", "200"}},
+		URLOption{Name: "sharpen", Args: []string{"2"}},
+	}, p["test"])
+}
+
+func (s *PresetsTestSuite) TestParsePresetInvalidString() {
+	p := make(Presets)
+
+	presetStr := "resize:fit:100:200/sharpen:2"
+	err := ParsePreset(p, presetStr)
+
+	assert.Equal(s.T(), fmt.Errorf("Invalid preset string: %s", presetStr), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestParsePresetEmptyName() {
+	p := make(Presets)
+
+	presetStr := "=resize:fit:100:200/sharpen:2"
+	err := ParsePreset(p, presetStr)
+
+	assert.Equal(s.T(), fmt.Errorf("Empty preset name: %s", presetStr), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestParsePresetEmptyValue() {
+	p := make(Presets)
+
+	presetStr := "test="
+	err := ParsePreset(p, presetStr)
+
+	assert.Equal(s.T(), fmt.Errorf("Empty preset value: %s", presetStr), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestParsePresetInvalidValue() {
+	p := make(Presets)
+
+	presetStr := "test=resize:fit:100:200/sharpen:2/blur"
+	err := ParsePreset(p, presetStr)
+
+	assert.Equal(s.T(), fmt.Errorf("Invalid preset value: %s", presetStr), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestParsePresetEmptyString() {
+	p := make(Presets)
+
+	err := ParsePreset(p, "  ")
+
+	assert.Nil(s.T(), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestParsePresetComment() {
+	p := make(Presets)
+
+	err := ParsePreset(p, "#  test=resize:fit:100:200/sharpen:2")
+
+	assert.Nil(s.T(), err)
+	assert.Empty(s.T(), p)
+}
+
+func (s *PresetsTestSuite) TestCheckPresets() {
+	p := Presets{
+		"test": URLOptions{
+			URLOption{Name: "resize", Args: []string{"fit", "100", "200"}},
+			URLOption{Name: "sharpen", Args: []string{"2"}},
+		},
+	}
+
+	err := CheckPresets(p)
+
+	assert.Nil(s.T(), err)
+}
+
+func (s *PresetsTestSuite) TestCheckPresetsInvalid() {
+	p := Presets{
+		"test": URLOptions{
+			URLOption{Name: "resize", Args: []string{"fit", "-1", "-2"}},
+			URLOption{Name: "sharpen", Args: []string{"2"}},
+		},
+	}
+
+	err := CheckPresets(p)
+
+	assert.Error(s.T(), err)
+}
+
+func TestPresets(t *testing.T) {
+	suite.Run(t, new(PresetsTestSuite))
+}
@@ -0,0 +1,1712 @@
+// Package options parses imgproxy processing options and source URLs.
+package options
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/structdiff"
+	"github.com/imgproxy/imgproxy/v2/vips"
+	"github.com/sirupsen/logrus"
+)
+
+// URLOption is a processing option with its arguments as they're set in the URL
+type URLOption struct {
+	Name string
+	Args []string
+}
+
+type URLOptions []URLOption
+
+// Headers are the request headers that affect the processing options
+type Headers struct {
+	Accept        string
+	Width         string
+	ViewportWidth string
+	DPR           string
+	Tenant        string
+}
+
+type GravityType int
+
+const (
+	GravityUnknown GravityType = iota
+	GravityCenter
+	GravityNorth
+	GravityEast
+	GravitySouth
+	GravityWest
+	GravityNorthWest
+	GravityNorthEast
+	GravitySouthWest
+	GravitySouthEast
+	GravitySmart
+	GravityFocusPoint
+)
+
+var gravityTypes = map[string]GravityType{
+	"ce":   GravityCenter,
+	"no":   GravityNorth,
+	"ea":   GravityEast,
+	"so":   GravitySouth,
+	"we":   GravityWest,
+	"nowe": GravityNorthWest,
+	"noea": GravityNorthEast,
+	"sowe": GravitySouthWest,
+	"soea": GravitySouthEast,
+	"sm":   GravitySmart,
+	"fp":   GravityFocusPoint,
+}
+
+type ResizeType int
+
+const (
+	ResizeFit ResizeType = iota
+	ResizeFill
+	ResizeCrop
+	ResizeAuto
+	ResizeLiquid
+)
+
+var ResizeTypes = map[string]ResizeType{
+	"fit":    ResizeFit,
+	"fill":   ResizeFill,
+	"crop":   ResizeCrop,
+	"auto":   ResizeAuto,
+	"liquid": ResizeLiquid,
+}
+
+type PriorityType int
+
+const (
+	PriorityNormal PriorityType = iota
+	PriorityLow
+)
+
+var priorityTypes = map[string]PriorityType{
+	"normal": PriorityNormal,
+	"low":    PriorityLow,
+}
+
+var hexColorRegex = regexp.MustCompile("^([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$")
+
+const (
+	hexColorLongFormat  = "%02x%02x%02x"
+	hexColorShortFormat = "%1x%1x%1x"
+)
+
+type GravityOptions struct {
+	Type GravityType
+	X, Y float64
+}
+
+type ExtendOptions struct {
+	Enabled bool
+	Gravity GravityOptions
+}
+
+type CropOptions struct {
+	Width   int
+	Height  int
+	Gravity GravityOptions
+}
+
+type PaddingOptions struct {
+	Enabled bool
+	Top     int
+	Right   int
+	Bottom  int
+	Left    int
+}
+
+type TrimOptions struct {
+	Enabled   bool
+	Threshold float64
+	Smart     bool
+	Color     vips.Color
+	EqualHor  bool
+	EqualVer  bool
+}
+
+type WatermarkOptions struct {
+	Enabled   bool
+	Opacity   float64
+	Replicate bool
+	Gravity   GravityOptions
+	Scale     float64
+}
+
+type CaptionOptions struct {
+	Enabled bool
+	Text    string
+	Gravity GravityOptions
+	Font    string
+	Size    int
+	Color   vips.Color
+	Padding int
+	Shadow  bool
+}
+
+type OverlayOptions struct {
+	URL     string
+	Gravity GravityOptions
+	Scale   float64
+}
+
+// ProcessingOptions describe how the source image should be processed
+type ProcessingOptions struct {
+	ResizingType  ResizeType
+	Width         int
+	Height        int
+	Dpr           float64
+	Gravity       GravityOptions
+	Enlarge       bool
+	Extend        ExtendOptions
+	Crop          CropOptions
+	Padding       PaddingOptions
+	Trim          TrimOptions
+	Format        imagetype.Type
+	Quality       int
+	QualitySet    bool
+	MaxBytes      int
+	Flatten       bool
+	Background    vips.Color
+	Blur          float32
+	Sharpen       float32
+	Enhance       bool
+	Equalize      bool
+	Posterize     int
+	Lqip          bool
+	StripMetadata bool
+	MaxFPS        float64
+	KeepProfile   bool
+	ColorProfile  string
+	KeepBitDepth  bool
+
+	// Frame is the index of the animation frame to use, -1 means all frames
+	Frame int
+
+	CacheBuster string
+	Nonce       string
+
+	Timeout  int
+	Priority PriorityType
+
+	Watermark WatermarkOptions
+	Overlays  []OverlayOptions
+	Caption   CaptionOptions
+
+	PreferWebP  bool
+	EnforceWebP bool
+
+	// ContentClass is the class of the source image content.
+	// It's resolved along with the result format
+	ContentClass ContentClass
+	// Lossless enables lossless compression for the formats that support it
+	Lossless bool
+
+	Filename string
+
+	Tenant      string
+	UsedPresets []string
+}
+
+const urlTokenPlain = "plain"
+
+const maxClientHintDPR = 8
+
+// The caption size is multiplied by DPR and is limited by the result
+// image height as well
+const maxCaptionSize = 512
+
+const MaxCaptionTextLength = 1024
+
+// Hooks that let the parsers resolve the tenant specific presets and source
+// base URLs and report the parsed options
+var (
+	// LookupPreset finds the preset by the tenant and the preset name.
+	// By default only the global presets are used
+	LookupPreset = func(tenant, name string) (URLOptions, bool) {
+		return GetPreset(name)
+	}
+
+	// SourceBaseURL returns the base URL of the tenant source URLs.
+	// By default IMGPROXY_BASE_URL is used
+	SourceBaseURL = func(tenant string) string {
+		return config.Conf.BaseURL
+	}
+
+	// OptionParsed, if set, is called with the name of every option
+	// of the successfully parsed advanced and query mode URLs
+	OptionParsed func(name string)
+)
+
+func (gt GravityType) String() string {
+	for k, v := range gravityTypes {
+		if v == gt {
+			return k
+		}
+	}
+	return ""
+}
+
+func (gt GravityType) MarshalJSON() ([]byte, error) {
+	for k, v := range gravityTypes {
+		if v == gt {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (rt ResizeType) String() string {
+	for k, v := range ResizeTypes {
+		if v == rt {
+			return k
+		}
+	}
+	return ""
+}
+
+func (pt PriorityType) String() string {
+	for k, v := range priorityTypes {
+		if v == pt {
+			return k
+		}
+	}
+	return ""
+}
+
+func (pt PriorityType) MarshalJSON() ([]byte, error) {
+	for k, v := range priorityTypes {
+		if v == pt {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+func (rt ResizeType) MarshalJSON() ([]byte, error) {
+	for k, v := range ResizeTypes {
+		if v == rt {
+			return []byte(fmt.Sprintf("%q", k)), nil
+		}
+	}
+	return []byte("null"), nil
+}
+
+var (
+	_newProcessingOptions    ProcessingOptions
+	newProcessingOptionsOnce sync.Once
+)
+
+// NewProcessingOptions returns the processing options with the default values
+func NewProcessingOptions() *ProcessingOptions {
+	newProcessingOptionsOnce.Do(func() {
+		_newProcessingOptions = ProcessingOptions{
+			ResizingType:  ResizeFit,
+			Width:         0,
+			Height:        0,
+			Gravity:       GravityOptions{Type: GravityCenter},
+			Enlarge:       false,
+			Extend:        ExtendOptions{Enabled: false, Gravity: GravityOptions{Type: GravityCenter}},
+			Padding:       PaddingOptions{Enabled: false},
+			Trim:          TrimOptions{Enabled: false, Threshold: 10, Smart: true},
+			Quality:       config.Conf.Quality,
+			MaxBytes:      0,
+			Format:        imagetype.Unknown,
+			Background:    vips.Color{R: 255, G: 255, B: 255},
+			Blur:          0,
+			Sharpen:       0,
+			Dpr:           1,
+			Watermark:     WatermarkOptions{Opacity: 1, Replicate: false, Gravity: GravityOptions{Type: GravityCenter}},
+			Caption:       CaptionOptions{Gravity: GravityOptions{Type: GravitySouth}, Font: config.Conf.CaptionFont, Size: 24, Color: vips.Color{R: 0, G: 0, B: 0}},
+			StripMetadata: config.Conf.StripMetadata,
+			ColorProfile:  config.Conf.ColorProfile,
+			Timeout:       config.Conf.WriteTimeout,
+			Priority:      PriorityNormal,
+			Frame:         -1,
+		}
+	})
+
+	po := _newProcessingOptions
+	po.UsedPresets = make([]string, 0)
+
+	return &po
+}
+
+// IsPresetUsed checks if the preset was already applied
+func (po *ProcessingOptions) IsPresetUsed(name string) bool {
+	for _, usedName := range po.UsedPresets {
+		if usedName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PresetUsed marks the preset as applied
+func (po *ProcessingOptions) PresetUsed(name string) {
+	po.UsedPresets = append(po.UsedPresets, name)
+}
+
+func (po *ProcessingOptions) Diff() structdiff.Entries {
+	return structdiff.Diff(NewProcessingOptions(), po)
+}
+
+func (po *ProcessingOptions) String() string {
+	return po.Diff().String()
+}
+
+func (po *ProcessingOptions) MarshalJSON() ([]byte, error) {
+	return po.Diff().MarshalJSON()
+}
+
+// ColorFromHex parses the RRGGBB or RGB hex color
+func ColorFromHex(hexcolor string) (vips.Color, error) {
+	c := vips.Color{}
+
+	if !hexColorRegex.MatchString(hexcolor) {
+		return c, fmt.Errorf("Invalid hex color: %s", hexcolor)
+	}
+
+	if len(hexcolor) == 3 {
+		fmt.Sscanf(hexcolor, hexColorShortFormat, &c.R, &c.G, &c.B)
+		c.R *= 17
+		c.G *= 17
+		c.B *= 17
+	} else {
+		fmt.Sscanf(hexcolor, hexColorLongFormat, &c.R, &c.G, &c.B)
+	}
+
+	return c, nil
+}
+
+func decodeBase64URL(parts []string, baseURL string) (string, string, error) {
+	var format string
+
+	encoded := strings.Join(parts, "")
+	urlParts := strings.Split(encoded, ".")
+
+	if len(urlParts[0]) == 0 {
+		return "", "", errors.New("Image URL is empty")
+	}
+
+	if len(urlParts) > 2 {
+		return "", "", fmt.Errorf("Multiple formats are specified: %s", encoded)
+	}
+
+	if len(urlParts) == 2 && len(urlParts[1]) > 0 {
+		format = urlParts[1]
+	}
+
+	imageURL, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(urlParts[0], "="))
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid url encoding: %s", encoded)
+	}
+
+	fullURL := fmt.Sprintf("%s%s", baseURL, string(imageURL))
+
+	return fullURL, format, nil
+}
+
+func decodePlainURL(parts []string, baseURL string) (string, string, error) {
+	var format string
+
+	encoded := strings.Join(parts, "/")
+	urlParts := strings.Split(encoded, "@")
+
+	if len(urlParts[0]) == 0 {
+		return "", "", errors.New("Image URL is empty")
+	}
+
+	if len(urlParts) > 2 {
+		return "", "", fmt.Errorf("Multiple formats are specified: %s", encoded)
+	}
+
+	if len(urlParts) == 2 && len(urlParts[1]) > 0 {
+		format = urlParts[1]
+	}
+
+	unescaped, err := url.PathUnescape(urlParts[0])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid url encoding: %s", encoded)
+	}
+
+	fullURL := fmt.Sprintf("%s%s", baseURL, unescaped)
+
+	return fullURL, format, nil
+}
+
+// DecodeURL decodes the plain or base64-encoded source URL from the path
+// parts and returns it along with the requested extension
+func DecodeURL(parts []string, baseURL string) (string, string, error) {
+	if len(parts) == 0 {
+		return "", "", errors.New("Image URL is empty")
+	}
+
+	if parts[0] == urlTokenPlain && len(parts) > 1 {
+		return decodePlainURL(parts[1:], baseURL)
+	}
+
+	return decodeBase64URL(parts, baseURL)
+}
+
+func parseDimension(d *int, name, arg string) error {
+	if v, err := strconv.Atoi(arg); err == nil && v >= 0 {
+		*d = v
+	} else {
+		return fmt.Errorf("Invalid %s: %s", name, arg)
+	}
+
+	return nil
+}
+
+func parseBoolOption(str string) bool {
+	b, err := strconv.ParseBool(str)
+
+	if err != nil {
+		logrus.Warningf("`%s` is not a valid boolean value. Treated as false", str)
+	}
+
+	return b
+}
+
+func isGravityOffcetValid(gravity GravityType, offset float64) bool {
+	if gravity == GravityCenter {
+		return true
+	}
+
+	return offset >= 0 && (gravity != GravityFocusPoint || offset <= 1)
+}
+
+func parseGravity(g *GravityOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs > 3 {
+		return fmt.Errorf("Invalid gravity arguments: %v", args)
+	}
+
+	if t, ok := gravityTypes[args[0]]; ok {
+		g.Type = t
+	} else {
+		return fmt.Errorf("Invalid gravity: %s", args[0])
+	}
+
+	if g.Type == GravitySmart && nArgs > 1 {
+		return fmt.Errorf("Invalid gravity arguments: %v", args)
+	} else if g.Type == GravityFocusPoint && nArgs != 3 {
+		return fmt.Errorf("Invalid gravity arguments: %v", args)
+	}
+
+	if nArgs > 1 {
+		if x, err := strconv.ParseFloat(args[1], 64); err == nil && isGravityOffcetValid(g.Type, x) {
+			g.X = x
+		} else {
+			return fmt.Errorf("Invalid gravity X: %s", args[1])
+		}
+	}
+
+	if nArgs > 2 {
+		if y, err := strconv.ParseFloat(args[2], 64); err == nil && isGravityOffcetValid(g.Type, y) {
+			g.Y = y
+		} else {
+			return fmt.Errorf("Invalid gravity Y: %s", args[2])
+		}
+	}
+
+	return nil
+}
+
+func applyWidthOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid width arguments: %v", args)
+	}
+
+	return parseDimension(&po.Width, "width", args[0])
+}
+
+func applyHeightOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid height arguments: %v", args)
+	}
+
+	return parseDimension(&po.Height, "height", args[0])
+}
+
+func applyEnlargeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid enlarge arguments: %v", args)
+	}
+
+	po.Enlarge = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyExtendOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 4 {
+		return fmt.Errorf("Invalid extend arguments: %v", args)
+	}
+
+	po.Extend.Enabled = parseBoolOption(args[0])
+
+	if len(args) > 1 {
+		if err := parseGravity(&po.Extend.Gravity, args[1:]); err != nil {
+			return err
+		}
+
+		if po.Extend.Gravity.Type == GravitySmart {
+			return errors.New("extend doesn't support smart gravity")
+		}
+	}
+
+	return nil
+}
+
+func applySizeOption(po *ProcessingOptions, args []string) (err error) {
+	if len(args) > 7 {
+		return fmt.Errorf("Invalid size arguments: %v", args)
+	}
+
+	if len(args) >= 1 && len(args[0]) > 0 {
+		if err = applyWidthOption(po, args[0:1]); err != nil {
+			return
+		}
+	}
+
+	if len(args) >= 2 && len(args[1]) > 0 {
+		if err = applyHeightOption(po, args[1:2]); err != nil {
+			return
+		}
+	}
+
+	if len(args) >= 3 && len(args[2]) > 0 {
+		if err = applyEnlargeOption(po, args[2:3]); err != nil {
+			return
+		}
+	}
+
+	if len(args) >= 4 && len(args[3]) > 0 {
+		if err = applyExtendOption(po, args[3:]); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func applyResizingTypeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid resizing type arguments: %v", args)
+	}
+
+	if r, ok := ResizeTypes[args[0]]; ok {
+		po.ResizingType = r
+	} else {
+		return fmt.Errorf("Invalid resize type: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyResizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 8 {
+		return fmt.Errorf("Invalid resize arguments: %v", args)
+	}
+
+	if len(args[0]) > 0 {
+		if err := applyResizingTypeOption(po, args[0:1]); err != nil {
+			return err
+		}
+	}
+
+	if len(args) > 1 {
+		if err := applySizeOption(po, args[1:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyDprOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid dpr arguments: %v", args)
+	}
+
+	if d, err := strconv.ParseFloat(args[0], 64); err == nil && d > 0 {
+		po.Dpr = d
+	} else {
+		return fmt.Errorf("Invalid dpr: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyGravityOption(po *ProcessingOptions, args []string) error {
+	return parseGravity(&po.Gravity, args)
+}
+
+func applyCropOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 5 {
+		return fmt.Errorf("Invalid crop arguments: %v", args)
+	}
+
+	if err := parseDimension(&po.Crop.Width, "crop width", args[0]); err != nil {
+		return err
+	}
+
+	if len(args) > 1 {
+		if err := parseDimension(&po.Crop.Height, "crop height", args[1]); err != nil {
+			return err
+		}
+	}
+
+	if len(args) > 2 {
+		return parseGravity(&po.Crop.Gravity, args[2:])
+	}
+
+	return nil
+}
+
+// applyCropRectOption sets the crop area by absolute coordinates of the source image.
+// The area is defined as a crop with the north-west gravity and offsets
+func applyCropRectOption(po *ProcessingOptions, args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("Invalid crop rect arguments: %v", args)
+	}
+
+	var rect [4]int
+
+	for i, arg := range args {
+		if v, err := strconv.Atoi(arg); err == nil && v >= 0 && (i < 2 || v > 0) {
+			rect[i] = v
+		} else {
+			return fmt.Errorf("Invalid crop rect: %s", strings.Join(args, ":"))
+		}
+	}
+
+	po.Crop.Width = rect[2]
+	po.Crop.Height = rect[3]
+	po.Crop.Gravity = GravityOptions{Type: GravityNorthWest, X: float64(rect[0]), Y: float64(rect[1])}
+
+	return nil
+}
+
+func applyPaddingOption(po *ProcessingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs < 1 || nArgs > 4 {
+		return fmt.Errorf("Invalid padding arguments: %v", args)
+	}
+
+	po.Padding.Enabled = true
+
+	if nArgs > 0 && len(args[0]) > 0 {
+		if err := parseDimension(&po.Padding.Top, "padding top (+all)", args[0]); err != nil {
+			return err
+		}
+		po.Padding.Right = po.Padding.Top
+		po.Padding.Bottom = po.Padding.Top
+		po.Padding.Left = po.Padding.Top
+	}
+
+	if nArgs > 1 && len(args[1]) > 0 {
+		if err := parseDimension(&po.Padding.Right, "padding right (+left)", args[1]); err != nil {
+			return err
+		}
+		po.Padding.Left = po.Padding.Right
+	}
+
+	if nArgs > 2 && len(args[2]) > 0 {
+		if err := parseDimension(&po.Padding.Bottom, "padding bottom", args[2]); err != nil {
+			return err
+		}
+	}
+
+	if nArgs > 3 && len(args[3]) > 0 {
+		if err := parseDimension(&po.Padding.Left, "padding left", args[3]); err != nil {
+			return err
+		}
+	}
+
+	if po.Padding.Top == 0 && po.Padding.Right == 0 && po.Padding.Bottom == 0 && po.Padding.Left == 0 {
+		po.Padding.Enabled = false
+	}
+
+	return nil
+}
+
+func applyTrimOption(po *ProcessingOptions, args []string) error {
+	nArgs := len(args)
+
+	if nArgs > 4 {
+		return fmt.Errorf("Invalid trim arguments: %v", args)
+	}
+
+	if t, err := strconv.ParseFloat(args[0], 64); err == nil && t >= 0 {
+		po.Trim.Enabled = true
+		po.Trim.Threshold = t
+	} else {
+		return fmt.Errorf("Invalid trim threshold: %s", args[0])
+	}
+
+	if nArgs > 1 && len(args[1]) > 0 {
+		if c, err := ColorFromHex(args[1]); err == nil {
+			po.Trim.Color = c
+			po.Trim.Smart = false
+		} else {
+			return fmt.Errorf("Invalid trim color: %s", args[1])
+		}
+	}
+
+	if nArgs > 2 && len(args[2]) > 0 {
+		po.Trim.EqualHor = parseBoolOption(args[2])
+	}
+
+	if nArgs > 3 && len(args[3]) > 0 {
+		po.Trim.EqualVer = parseBoolOption(args[3])
+	}
+
+	return nil
+}
+
+func applyQualityOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid quality arguments: %v", args)
+	}
+
+	if q, err := strconv.Atoi(args[0]); err == nil && q > 0 && q <= 100 {
+		po.Quality = q
+		po.QualitySet = true
+	} else {
+		return fmt.Errorf("Invalid quality: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyMaxBytesOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max_bytes arguments: %v", args)
+	}
+
+	if max, err := strconv.Atoi(args[0]); err == nil && max >= 0 {
+		po.MaxBytes = max
+	} else {
+		return fmt.Errorf("Invalid max_bytes: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyMaxFPSOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid max fps arguments: %v", args)
+	}
+
+	if fps, err := strconv.ParseFloat(args[0], 64); err == nil && fps >= 0 {
+		po.MaxFPS = fps
+	} else {
+		return fmt.Errorf("Invalid max fps: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyFrameOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid frame arguments: %v", args)
+	}
+
+	if f, err := strconv.Atoi(args[0]); err == nil && f >= 0 {
+		po.Frame = f
+	} else {
+		return fmt.Errorf("Invalid frame: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyBackgroundOption(po *ProcessingOptions, args []string) error {
+	switch len(args) {
+	case 1:
+		if len(args[0]) == 0 {
+			po.Flatten = false
+		} else if c, err := ColorFromHex(args[0]); err == nil {
+			po.Flatten = true
+			po.Background = c
+		} else {
+			return fmt.Errorf("Invalid background argument: %s", err)
+		}
+
+	case 3:
+		po.Flatten = true
+
+		if r, err := strconv.ParseUint(args[0], 10, 8); err == nil && r <= 255 {
+			po.Background.R = uint8(r)
+		} else {
+			return fmt.Errorf("Invalid background red channel: %s", args[0])
+		}
+
+		if g, err := strconv.ParseUint(args[1], 10, 8); err == nil && g <= 255 {
+			po.Background.G = uint8(g)
+		} else {
+			return fmt.Errorf("Invalid background green channel: %s", args[1])
+		}
+
+		if b, err := strconv.ParseUint(args[2], 10, 8); err == nil && b <= 255 {
+			po.Background.B = uint8(b)
+		} else {
+			return fmt.Errorf("Invalid background blue channel: %s", args[2])
+		}
+
+	default:
+		return fmt.Errorf("Invalid background arguments: %v", args)
+	}
+
+	return nil
+}
+
+func applyBlurOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid blur arguments: %v", args)
+	}
+
+	if b, err := strconv.ParseFloat(args[0], 32); err == nil && b >= 0 {
+		po.Blur = float32(b)
+	} else {
+		return fmt.Errorf("Invalid blur: %s", args[0])
+	}
+
+	return nil
+}
+
+func applySharpenOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid sharpen arguments: %v", args)
+	}
+
+	if s, err := strconv.ParseFloat(args[0], 32); err == nil && s >= 0 {
+		po.Sharpen = float32(s)
+	} else {
+		return fmt.Errorf("Invalid sharpen: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyPresetOption(po *ProcessingOptions, args []string) error {
+	for _, preset := range args {
+		if p, ok := LookupPreset(po.Tenant, preset); ok {
+			if po.IsPresetUsed(preset) {
+				logrus.Warningf("Recursive preset usage is detected: %s", preset)
+				continue
+			}
+
+			po.PresetUsed(preset)
+
+			if err := ApplyProcessingOptions(po, p); err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("Unknown preset: %s", preset)
+		}
+	}
+
+	return nil
+}
+
+func applyEnhanceOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid enhance arguments: %v", args)
+	}
+
+	po.Enhance = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyEqualizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid equalize arguments: %v", args)
+	}
+
+	po.Equalize = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyPosterizeOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid posterize arguments: %v", args)
+	}
+
+	if l, err := strconv.Atoi(args[0]); err == nil && (l == 0 || (l >= 2 && l <= 256)) {
+		po.Posterize = l
+	} else {
+		return fmt.Errorf("Invalid posterize levels: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyLqipOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid lqip arguments: %v", args)
+	}
+
+	po.Lqip = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyWatermarkOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 7 {
+		return fmt.Errorf("Invalid watermark arguments: %v", args)
+	}
+
+	if o, err := strconv.ParseFloat(args[0], 64); err == nil && o >= 0 && o <= 1 {
+		po.Watermark.Enabled = o > 0
+		po.Watermark.Opacity = o
+	} else {
+		return fmt.Errorf("Invalid watermark opacity: %s", args[0])
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if args[1] == "re" {
+			po.Watermark.Replicate = true
+		} else if g, ok := gravityTypes[args[1]]; ok && g != GravityFocusPoint && g != GravitySmart {
+			po.Watermark.Gravity.Type = g
+		} else {
+			return fmt.Errorf("Invalid watermark position: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if x, err := strconv.Atoi(args[2]); err == nil {
+			po.Watermark.Gravity.X = float64(x)
+		} else {
+			return fmt.Errorf("Invalid watermark X offset: %s", args[2])
+		}
+	}
+
+	if len(args) > 3 && len(args[3]) > 0 {
+		if y, err := strconv.Atoi(args[3]); err == nil {
+			po.Watermark.Gravity.Y = float64(y)
+		} else {
+			return fmt.Errorf("Invalid watermark Y offset: %s", args[3])
+		}
+	}
+
+	if len(args) > 4 && len(args[4]) > 0 {
+		if s, err := strconv.ParseFloat(args[4], 64); err == nil && s >= 0 {
+			po.Watermark.Scale = s
+		} else {
+			return fmt.Errorf("Invalid watermark scale: %s", args[4])
+		}
+	}
+
+	return nil
+}
+
+func applyOverlayOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 5 {
+		return fmt.Errorf("Invalid overlay arguments: %v", args)
+	}
+
+	if len(args[0]) == 0 {
+		return errors.New("Overlay URL is empty")
+	}
+
+	overlayURL, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(args[0], "="))
+	if err != nil {
+		return fmt.Errorf("Invalid overlay URL encoding: %s", args[0])
+	}
+
+	o := OverlayOptions{
+		URL:     fmt.Sprintf("%s%s", SourceBaseURL(po.Tenant), string(overlayURL)),
+		Gravity: GravityOptions{Type: GravityCenter},
+	}
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if g, ok := gravityTypes[args[1]]; ok && g != GravityFocusPoint && g != GravitySmart {
+			o.Gravity.Type = g
+		} else {
+			return fmt.Errorf("Invalid overlay position: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if x, err := strconv.Atoi(args[2]); err == nil {
+			o.Gravity.X = float64(x)
+		} else {
+			return fmt.Errorf("Invalid overlay X offset: %s", args[2])
+		}
+	}
+
+	if len(args) > 3 && len(args[3]) > 0 {
+		if y, err := strconv.Atoi(args[3]); err == nil {
+			o.Gravity.Y = float64(y)
+		} else {
+			return fmt.Errorf("Invalid overlay Y offset: %s", args[3])
+		}
+	}
+
+	if len(args) > 4 && len(args[4]) > 0 {
+		if s, err := strconv.ParseFloat(args[4], 64); err == nil && s >= 0 {
+			o.Scale = s
+		} else {
+			return fmt.Errorf("Invalid overlay scale: %s", args[4])
+		}
+	}
+
+	po.Overlays = append(po.Overlays, o)
+
+	return nil
+}
+
+func applyCaptionOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 7 {
+		return fmt.Errorf("Invalid caption arguments: %v", args)
+	}
+
+	text, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(args[0], "="))
+	if err != nil {
+		return fmt.Errorf("Invalid caption text encoding: %s", args[0])
+	}
+
+	if utf8.RuneCount(text) > MaxCaptionTextLength {
+		return fmt.Errorf("Caption text is too long: %d characters, max - %d", utf8.RuneCount(text), MaxCaptionTextLength)
+	}
+
+	po.Caption.Enabled = len(text) > 0
+	po.Caption.Text = string(text)
+
+	if len(args) > 1 && len(args[1]) > 0 {
+		if g, ok := gravityTypes[args[1]]; ok && g != GravityFocusPoint && g != GravitySmart {
+			po.Caption.Gravity.Type = g
+		} else {
+			return fmt.Errorf("Invalid caption position: %s", args[1])
+		}
+	}
+
+	if len(args) > 2 && len(args[2]) > 0 {
+		if f, err := url.PathUnescape(args[2]); err == nil {
+			po.Caption.Font = f
+		} else {
+			return fmt.Errorf("Invalid caption font: %s", args[2])
+		}
+	}
+
+	if len(args) > 3 && len(args[3]) > 0 {
+		if s, err := strconv.Atoi(args[3]); err == nil && s > 0 && s <= maxCaptionSize {
+			po.Caption.Size = s
+		} else {
+			return fmt.Errorf("Invalid caption size: %s", args[3])
+		}
+	}
+
+	if len(args) > 4 && len(args[4]) > 0 {
+		if c, err := ColorFromHex(args[4]); err == nil {
+			po.Caption.Color = c
+		} else {
+			return fmt.Errorf("Invalid caption color: %s", args[4])
+		}
+	}
+
+	if len(args) > 5 && len(args[5]) > 0 {
+		if p, err := strconv.Atoi(args[5]); err == nil && p >= 0 {
+			po.Caption.Padding = p
+		} else {
+			return fmt.Errorf("Invalid caption padding: %s", args[5])
+		}
+	}
+
+	if len(args) > 6 && len(args[6]) > 0 {
+		po.Caption.Shadow = parseBoolOption(args[6])
+	}
+
+	return nil
+}
+
+// FormatSupported checks if images of the type can be saved
+func FormatSupported(imgtype imagetype.Type) bool {
+	return imgtype == imagetype.SVG || vips.SupportsSave(imgtype)
+}
+
+// ApplyFormatOption sets the result format
+func ApplyFormatOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid format arguments: %v", args)
+	}
+
+	if f, ok := imagetype.Types[args[0]]; ok {
+		po.Format = f
+	} else {
+		return fmt.Errorf("Invalid image format: %s", args[0])
+	}
+
+	if !FormatSupported(po.Format) {
+		return fmt.Errorf("Resulting image format is not supported: %s", po.Format)
+	}
+
+	return nil
+}
+
+func applyCacheBusterOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid cache buster arguments: %v", args)
+	}
+
+	po.CacheBuster = args[0]
+
+	return nil
+}
+
+func applyNonceOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid nonce arguments: %v", args)
+	}
+
+	po.Nonce = args[0]
+
+	return nil
+}
+
+func applyTimeoutOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid timeout arguments: %v", args)
+	}
+
+	if t, err := strconv.Atoi(args[0]); err == nil && t > 0 {
+		if t > config.Conf.MaxTimeout {
+			return fmt.Errorf("Timeout can't be greater than %d: %d", config.Conf.MaxTimeout, t)
+		}
+		po.Timeout = t
+	} else {
+		return fmt.Errorf("Invalid timeout: %s", args[0])
+	}
+
+	return nil
+}
+
+func applyFilenameOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid filename arguments: %v", args)
+	}
+
+	filename, err := url.PathUnescape(args[0])
+	if err != nil {
+		return fmt.Errorf("Invalid filename: %s", args[0])
+	}
+
+	po.Filename = filename
+
+	return nil
+}
+
+func applyStripMetadataOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid strip metadata arguments: %v", args)
+	}
+
+	po.StripMetadata = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyKeepProfileOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid keep profile arguments: %v", args)
+	}
+
+	po.KeepProfile = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyKeepBitDepthOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid keep bit depth arguments: %v", args)
+	}
+
+	po.KeepBitDepth = parseBoolOption(args[0])
+
+	return nil
+}
+
+func applyColorProfileOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid color profile arguments: %v", args)
+	}
+
+	if len(args[0]) > 0 && !IsKnownColorProfile(args[0]) {
+		return fmt.Errorf("Unknown color profile: %s", args[0])
+	}
+
+	po.ColorProfile = args[0]
+
+	return nil
+}
+
+func applyPriorityOption(po *ProcessingOptions, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("Invalid priority arguments: %v", args)
+	}
+
+	if p, ok := priorityTypes[args[0]]; ok {
+		po.Priority = p
+	} else {
+		return fmt.Errorf("Invalid priority: %s", args[0])
+	}
+
+	return nil
+}
+
+// ApplyProcessingOption applies the option by its full or short name
+func ApplyProcessingOption(po *ProcessingOptions, name string, args []string) error {
+	switch name {
+	case "format", "f", "ext":
+		return ApplyFormatOption(po, args)
+	case "resize", "rs":
+		return applyResizeOption(po, args)
+	case "resizing_type", "rt":
+		return applyResizingTypeOption(po, args)
+	case "size", "s":
+		return applySizeOption(po, args)
+	case "width", "w":
+		return applyWidthOption(po, args)
+	case "height", "h":
+		return applyHeightOption(po, args)
+	case "enlarge", "el":
+		return applyEnlargeOption(po, args)
+	case "extend", "ex":
+		return applyExtendOption(po, args)
+	case "dpr":
+		return applyDprOption(po, args)
+	case "gravity", "g":
+		return applyGravityOption(po, args)
+	case "crop", "c":
+		return applyCropOption(po, args)
+	case "crop_rect", "cr":
+		return applyCropRectOption(po, args)
+	case "trim", "t":
+		return applyTrimOption(po, args)
+	case "padding", "pd":
+		return applyPaddingOption(po, args)
+	case "quality", "q":
+		return applyQualityOption(po, args)
+	case "max_bytes", "mb":
+		return applyMaxBytesOption(po, args)
+	case "max_fps", "mfps":
+		return applyMaxFPSOption(po, args)
+	case "frame", "fr":
+		return applyFrameOption(po, args)
+	case "background", "bg":
+		return applyBackgroundOption(po, args)
+	case "blur", "bl":
+		return applyBlurOption(po, args)
+	case "sharpen", "sh":
+		return applySharpenOption(po, args)
+	case "enhance", "eh":
+		return applyEnhanceOption(po, args)
+	case "equalize", "eq":
+		return applyEqualizeOption(po, args)
+	case "posterize", "pst":
+		return applyPosterizeOption(po, args)
+	case "lqip", "lq":
+		return applyLqipOption(po, args)
+	case "watermark", "wm":
+		return applyWatermarkOption(po, args)
+	case "overlay", "ov":
+		return applyOverlayOption(po, args)
+	case "caption", "cap":
+		return applyCaptionOption(po, args)
+	case "preset", "pr":
+		return applyPresetOption(po, args)
+	case "cachebuster", "cb":
+		return applyCacheBusterOption(po, args)
+	case "strip_metadata", "sm":
+		return applyStripMetadataOption(po, args)
+	case "keep_profile", "kp":
+		return applyKeepProfileOption(po, args)
+	case "color_profile", "cp":
+		return applyColorProfileOption(po, args)
+	case "keep_bit_depth", "kbd":
+		return applyKeepBitDepthOption(po, args)
+	case "filename", "fn":
+		return applyFilenameOption(po, args)
+	case "nonce", "nc":
+		return applyNonceOption(po, args)
+	case "timeout", "tm":
+		return applyTimeoutOption(po, args)
+	case "priority", "prt":
+		return applyPriorityOption(po, args)
+	}
+
+	return fmt.Errorf("Unknown processing option: %s", name)
+}
+
+// ApplyProcessingOptions applies the options in order
+func ApplyProcessingOptions(po *ProcessingOptions, options URLOptions) error {
+	for _, opt := range options {
+		if err := ApplyProcessingOption(po, opt.Name, opt.Args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NormalizeSourceURL removes the query params listed in IMGPROXY_SOURCE_STRIP_QUERY_PARAMS
+// from the source URL and sorts the rest when IMGPROXY_SOURCE_SORT_QUERY_PARAMS is enabled,
+// so variants of the same source URL are downloaded and cached as the same image
+func NormalizeSourceURL(imageURL string) string {
+	if len(config.Conf.SourceStripQueryParams) == 0 && !config.Conf.SourceSortQueryParams {
+		return imageURL
+	}
+
+	qInd := strings.IndexByte(imageURL, '?')
+	if qInd < 0 {
+		return imageURL
+	}
+
+	base, rawQuery := imageURL[:qInd], imageURL[qInd+1:]
+
+	fragment := ""
+	if fInd := strings.IndexByte(rawQuery, '#'); fInd >= 0 {
+		rawQuery, fragment = rawQuery[:fInd], rawQuery[fInd:]
+	}
+
+	type queryParam struct {
+		name string
+		raw  string
+	}
+
+	params := make([]queryParam, 0)
+
+	for _, raw := range strings.Split(rawQuery, "&") {
+		if len(raw) == 0 {
+			continue
+		}
+
+		name := raw
+		if eqInd := strings.IndexByte(raw, '='); eqInd >= 0 {
+			name = raw[:eqInd]
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		strip := false
+		for _, n := range config.Conf.SourceStripQueryParams {
+			if n == name {
+				strip = true
+				break
+			}
+		}
+
+		if !strip {
+			params = append(params, queryParam{name, raw})
+		}
+	}
+
+	if config.Conf.SourceSortQueryParams {
+		sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+	}
+
+	raws := make([]string, len(params))
+	for i, p := range params {
+		raws[i] = p.raw
+	}
+
+	if len(raws) == 0 {
+		return base + fragment
+	}
+
+	return base + "?" + strings.Join(raws, "&") + fragment
+}
+
+// IsAllowedSource checks the source URL against IMGPROXY_ALLOWED_SOURCES
+func IsAllowedSource(imageURL string) bool {
+	if len(config.Conf.AllowedSources) == 0 {
+		return true
+	}
+	for _, val := range config.Conf.AllowedSources {
+		if strings.HasPrefix(imageURL, string(val)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseURLOptions parses the options from the path parts. The parts that
+// follow the options are returned as well
+func ParseURLOptions(opts []string) (URLOptions, []string) {
+	parsed := make(URLOptions, 0, len(opts))
+	urlStart := len(opts) + 1
+
+	for i, opt := range opts {
+		args := strings.Split(opt, ":")
+
+		if len(args) == 1 {
+			urlStart = i
+			break
+		}
+
+		parsed = append(parsed, URLOption{Name: args[0], Args: args[1:]})
+	}
+
+	var rest []string
+
+	if urlStart < len(opts) {
+		rest = opts[urlStart:]
+	} else {
+		rest = []string{}
+	}
+
+	return parsed, rest
+}
+
+// DefaultProcessingOptions returns the processing options for the request
+// headers with the tenant's default preset applied
+func DefaultProcessingOptions(headers *Headers) (*ProcessingOptions, error) {
+	po := NewProcessingOptions()
+
+	if strings.Contains(headers.Accept, "image/webp") {
+		po.PreferWebP = config.Conf.EnableWebpDetection || config.Conf.EnforceWebp
+		po.EnforceWebP = config.Conf.EnforceWebp
+	}
+
+	if config.Conf.EnableClientHints && len(headers.ViewportWidth) > 0 {
+		if vw, err := strconv.Atoi(headers.ViewportWidth); err == nil {
+			po.Width = vw
+		}
+	}
+	if config.Conf.EnableClientHints && len(headers.Width) > 0 {
+		if w, err := strconv.Atoi(headers.Width); err == nil {
+			po.Width = w
+		}
+	}
+	if config.Conf.EnableClientHints && len(headers.DPR) > 0 {
+		if dpr, err := strconv.ParseFloat(headers.DPR, 64); err == nil && (dpr > 0 && dpr <= maxClientHintDPR) {
+			po.Dpr = dpr
+		}
+	}
+	po.Tenant = headers.Tenant
+
+	if _, ok := LookupPreset(po.Tenant, "default"); ok {
+		if err := applyPresetOption(po, []string{"default"}); err != nil {
+			return po, err
+		}
+	}
+
+	return po, nil
+}
+
+func parsePathAdvanced(parts []string, headers *Headers) (string, *ProcessingOptions, error) {
+	po, err := DefaultProcessingOptions(headers)
+	if err != nil {
+		return "", po, err
+	}
+
+	options, urlParts := ParseURLOptions(parts)
+
+	if err = ApplyProcessingOptions(po, options); err != nil {
+		return "", po, err
+	}
+
+	if OptionParsed != nil {
+		for _, opt := range options {
+			OptionParsed(opt.Name)
+		}
+	}
+
+	url, extension, err := DecodeURL(urlParts, SourceBaseURL(po.Tenant))
+	if err != nil {
+		return "", po, err
+	}
+
+	if len(extension) > 0 {
+		if err = ApplyFormatOption(po, []string{extension}); err != nil {
+			return "", po, err
+		}
+	}
+
+	return url, po, nil
+}
+
+func parsePathPresets(parts []string, headers *Headers) (string, *ProcessingOptions, error) {
+	po, err := DefaultProcessingOptions(headers)
+	if err != nil {
+		return "", po, err
+	}
+
+	presets := strings.Split(parts[0], ":")
+	urlParts := parts[1:]
+
+	if err = applyPresetOption(po, presets); err != nil {
+		return "", nil, err
+	}
+
+	url, extension, err := DecodeURL(urlParts, SourceBaseURL(po.Tenant))
+	if err != nil {
+		return "", po, err
+	}
+
+	if len(extension) > 0 {
+		if err = ApplyFormatOption(po, []string{extension}); err != nil {
+			return "", po, err
+		}
+	}
+
+	return url, po, nil
+}
+
+// ParseQueryOptions parses the query string of the query mode URL. The source URL
+// is taken from the `url` parameter, other parameters are processing options
+// with colon-separated arguments. Presets are applied first, other options are
+// applied in the alphabetical order, so the result doesn't depend on the order
+// of the parameters. The canonical query string is used to sign the URL
+func ParseQueryOptions(rawQuery, baseURL string) (string, URLOptions, string, error) {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("Invalid query string: %s", rawQuery)
+	}
+
+	var (
+		imageURL string
+		presets  URLOptions
+		options  URLOptions
+	)
+
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values := query[name]
+
+		if len(values) > 1 {
+			return "", nil, "", fmt.Errorf("Query parameter is specified multiple times: %s", name)
+		}
+
+		switch name {
+		case "url":
+			imageURL = values[0]
+		case "preset", "pr":
+			presets = append(presets, URLOption{Name: name, Args: strings.Split(values[0], ":")})
+		default:
+			options = append(options, URLOption{Name: name, Args: strings.Split(values[0], ":")})
+		}
+	}
+
+	if len(imageURL) == 0 {
+		return "", nil, "", errors.New("Image URL is empty")
+	}
+
+	return baseURL + imageURL, append(presets, options...), query.Encode(), nil
+}
+
+// ParsePathQuery applies the options parsed by ParseQueryOptions
+func ParsePathQuery(imageURL string, options URLOptions, headers *Headers) (string, *ProcessingOptions, error) {
+	po, err := DefaultProcessingOptions(headers)
+	if err != nil {
+		return "", po, err
+	}
+
+	if config.Conf.OnlyPresets {
+		for _, opt := range options {
+			if opt.Name != "preset" && opt.Name != "pr" {
+				return "", po, fmt.Errorf("Only presets are allowed: %s", opt.Name)
+			}
+		}
+	}
+
+	if err = ApplyProcessingOptions(po, options); err != nil {
+		return "", po, err
+	}
+
+	if OptionParsed != nil {
+		for _, opt := range options {
+			OptionParsed(opt.Name)
+		}
+	}
+
+	return imageURL, po, nil
+}
+
+func parsePathBasic(parts []string, headers *Headers) (string, *ProcessingOptions, error) {
+	if len(parts) < 6 {
+		return "", nil, fmt.Errorf("Invalid basic URL format arguments: %s", strings.Join(parts, "/"))
+	}
+
+	po, err := DefaultProcessingOptions(headers)
+	if err != nil {
+		return "", po, err
+	}
+
+	po.ResizingType = ResizeTypes[parts[0]]
+
+	if err = applyWidthOption(po, parts[1:2]); err != nil {
+		return "", po, err
+	}
+
+	if err = applyHeightOption(po, parts[2:3]); err != nil {
+		return "", po, err
+	}
+
+	if err = applyGravityOption(po, strings.Split(parts[3], ":")); err != nil {
+		return "", po, err
+	}
+
+	if err = applyEnlargeOption(po, parts[4:5]); err != nil {
+		return "", po, err
+	}
+
+	url, extension, err := DecodeURL(parts[5:], SourceBaseURL(po.Tenant))
+	if err != nil {
+		return "", po, err
+	}
+
+	if len(extension) > 0 {
+		if err := ApplyFormatOption(po, []string{extension}); err != nil {
+			return "", po, err
+		}
+	}
+
+	return url, po, nil
+}
+
+// ParsePath parses the processing options and the source URL from the path
+// parts that follow the signature. The presets-only, basic and advanced URL
+// formats are supported
+func ParsePath(parts []string, headers *Headers) (string, *ProcessingOptions, error) {
+	if len(parts) == 0 {
+		return "", nil, errors.New("Empty path")
+	}
+
+	if config.Conf.OnlyPresets {
+		return parsePathPresets(parts, headers)
+	}
+
+	if _, ok := ResizeTypes[parts[0]]; ok {
+		return parsePathBasic(parts, headers)
+	}
+
+	return parsePathAdvanced(parts, headers)
+}
@@ -0,0 +1,795 @@
+package options
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/vips"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessingOptionsTestSuite struct {
+	suite.Suite
+
+	oldConf    config.Config
+	oldPresets Presets
+}
+
+func TestMain(m *testing.M) {
+	if err := vips.Init(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	vips.Shutdown()
+	os.Exit(code)
+}
+
+func (s *ProcessingOptionsTestSuite) SetupTest() {
+	s.oldConf = config.Conf
+	s.oldPresets = AllPresets()
+}
+
+func (s *ProcessingOptionsTestSuite) TearDownTest() {
+	config.Conf = s.oldConf
+	SetPresets(s.oldPresets)
+}
+
+func (s *ProcessingOptionsTestSuite) parsePath(path string, headers *Headers) (string, *ProcessingOptions, error) {
+	return ParsePath(strings.Split(strings.TrimPrefix(path, "/"), "/"), headers)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParseBase64URL() {
+	imageURL := "http://images.dev/lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/size:100:100/%s.png", base64.RawURLEncoding.EncodeToString([]byte(imageURL)))
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imageURL, imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParseBase64URLWithoutExtension() {
+	imageURL := "http://images.dev/lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/size:100:100/%s", base64.RawURLEncoding.EncodeToString([]byte(imageURL)))
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imageURL, imgURL)
+	assert.Equal(s.T(), imagetype.Unknown, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParseBase64URLWithBase() {
+	config.Conf.BaseURL = "http://images.dev/"
+
+	imageURL := "lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/size:100:100/%s.png", base64.RawURLEncoding.EncodeToString([]byte(imageURL)))
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), fmt.Sprintf("%s%s", config.Conf.BaseURL, imageURL), imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePlainURL() {
+	imageURL := "http://images.dev/lorem/ipsum.jpg"
+	path := fmt.Sprintf("/size:100:100/plain/%s@png", imageURL)
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imageURL, imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePlainURLWithoutExtension() {
+	imageURL := "http://images.dev/lorem/ipsum.jpg"
+	path := fmt.Sprintf("/size:100:100/plain/%s", imageURL)
+
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imageURL, imgURL)
+	assert.Equal(s.T(), imagetype.Unknown, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePlainURLEscaped() {
+	imageURL := "http://images.dev/lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/size:100:100/plain/%s@png", url.PathEscape(imageURL))
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imageURL, imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePlainURLWithBase() {
+	config.Conf.BaseURL = "http://images.dev/"
+
+	imageURL := "lorem/ipsum.jpg"
+	path := fmt.Sprintf("/size:100:100/plain/%s@png", imageURL)
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), fmt.Sprintf("%s%s", config.Conf.BaseURL, imageURL), imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePlainURLEscapedWithBase() {
+	config.Conf.BaseURL = "http://images.dev/"
+
+	imageURL := "lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/size:100:100/plain/%s@png", url.PathEscape(imageURL))
+	imgURL, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), fmt.Sprintf("%s%s", config.Conf.BaseURL, imageURL), imgURL)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathBasic() {
+	path := "/fill/100/200/noea/1/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeFill, po.ResizingType)
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.Equal(s.T(), GravityNorthEast, po.Gravity.Type)
+	assert.True(s.T(), po.Enlarge)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFormat() {
+	path := "/format:webp/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), imagetype.WEBP, po.Format)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResize() {
+	path := "/resize:fill:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeFill, po.ResizingType)
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResizingType() {
+	path := "/resizing_type:fill/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeFill, po.ResizingType)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedResizingTypeLiquid() {
+	path := "/rt:liquid/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeLiquid, po.ResizingType)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSize() {
+	path := "/size:100:200:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWidth() {
+	path := "/width:100/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 100, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedHeight() {
+	path := "/height:100/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 100, po.Height)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedEnlarge() {
+	path := "/enlarge:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Enlarge)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedExtend() {
+	path := "/extend:1:so:10:20/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), true, po.Extend.Enabled)
+	assert.Equal(s.T(), GravitySouth, po.Extend.Gravity.Type)
+	assert.Equal(s.T(), 10.0, po.Extend.Gravity.X)
+	assert.Equal(s.T(), 20.0, po.Extend.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravity() {
+	path := "/gravity:soea/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), GravitySouthEast, po.Gravity.Type)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedGravityFocuspoint() {
+	path := "/gravity:fp:0.5:0.75/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), GravityFocusPoint, po.Gravity.Type)
+	assert.Equal(s.T(), 0.5, po.Gravity.X)
+	assert.Equal(s.T(), 0.75, po.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedQuality() {
+	path := "/quality:55/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 55, po.Quality)
+	assert.True(s.T(), po.QualitySet)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDefaultQuality() {
+	path := "/width:100/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), config.Conf.Quality, po.Quality)
+	assert.False(s.T(), po.QualitySet)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackground() {
+	path := "/background:128:129:130/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Flatten)
+	assert.Equal(s.T(), uint8(128), po.Background.R)
+	assert.Equal(s.T(), uint8(129), po.Background.G)
+	assert.Equal(s.T(), uint8(130), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundHex() {
+	path := "/background:ffddee/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Flatten)
+	assert.Equal(s.T(), uint8(0xff), po.Background.R)
+	assert.Equal(s.T(), uint8(0xdd), po.Background.G)
+	assert.Equal(s.T(), uint8(0xee), po.Background.B)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBackgroundDisable() {
+	path := "/background:fff/background:/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.False(s.T(), po.Flatten)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedBlur() {
+	path := "/blur:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedSharpen() {
+	path := "/sharpen:0.2/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), float32(0.2), po.Sharpen)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedDpr() {
+	path := "/dpr:2/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 2.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedWatermark() {
+	path := "/watermark:0.5:soea:10:20:0.6/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Watermark.Enabled)
+	assert.Equal(s.T(), GravitySouthEast, po.Watermark.Gravity.Type)
+	assert.Equal(s.T(), 10.0, po.Watermark.Gravity.X)
+	assert.Equal(s.T(), 20.0, po.Watermark.Gravity.Y)
+	assert.Equal(s.T(), 0.6, po.Watermark.Scale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPreset() {
+	SetPresets(Presets{
+		"test1": URLOptions{
+			URLOption{Name: "resizing_type", Args: []string{"fill"}},
+		},
+		"test2": URLOptions{
+			URLOption{Name: "blur", Args: []string{"0.2"}},
+			URLOption{Name: "quality", Args: []string{"50"}},
+		},
+	})
+
+	path := "/preset:test1:test2/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeFill, po.ResizingType)
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+	assert.Equal(s.T(), 50, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPresetDefault() {
+	SetPresets(Presets{
+		"default": URLOptions{
+			URLOption{Name: "resizing_type", Args: []string{"fill"}},
+			URLOption{Name: "blur", Args: []string{"0.2"}},
+			URLOption{Name: "quality", Args: []string{"50"}},
+		},
+	})
+
+	path := "/quality:70/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), ResizeFill, po.ResizingType)
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+	assert.Equal(s.T(), 70, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPresetLoopDetection() {
+	SetPresets(Presets{
+		"test1": URLOptions{
+			URLOption{Name: "resizing_type", Args: []string{"fill"}},
+		},
+		"test2": URLOptions{
+			URLOption{Name: "blur", Args: []string{"0.2"}},
+			URLOption{Name: "quality", Args: []string{"50"}},
+		},
+	})
+
+	path := "/preset:test1:test2:test1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	require.ElementsMatch(s.T(), po.UsedPresets, []string{"test1", "test2"})
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedCachebuster() {
+	path := "/cachebuster:123/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), "123", po.CacheBuster)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedStripMetadata() {
+	path := "/strip_metadata:true/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.StripMetadata)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathKeepProfile() {
+	path := "/keep_profile:true/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.KeepProfile)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCropRect() {
+	path := "/crop_rect:10:20:300:200/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 300, po.Crop.Width)
+	assert.Equal(s.T(), 200, po.Crop.Height)
+	assert.Equal(s.T(), GravityNorthWest, po.Crop.Gravity.Type)
+	assert.Equal(s.T(), 10.0, po.Crop.Gravity.X)
+	assert.Equal(s.T(), 20.0, po.Crop.Gravity.Y)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCropRectInvalid() {
+	path := "/crop_rect:10:20:0:200/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathOverlay() {
+	path := "/overlay:aHR0cDovL2ltYWdlcy5kZXYvYmFkZ2UucG5n:soea:10:20:0.2/ov:aHR0cDovL2ltYWdlcy5kZXYvYmFkZ2UucG5n/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+	require.Len(s.T(), po.Overlays, 2)
+
+	assert.Equal(s.T(), "http://images.dev/badge.png", po.Overlays[0].URL)
+	assert.Equal(s.T(), GravitySouthEast, po.Overlays[0].Gravity.Type)
+	assert.Equal(s.T(), 10.0, po.Overlays[0].Gravity.X)
+	assert.Equal(s.T(), 20.0, po.Overlays[0].Gravity.Y)
+	assert.Equal(s.T(), 0.2, po.Overlays[0].Scale)
+
+	assert.Equal(s.T(), GravityCenter, po.Overlays[1].Gravity.Type)
+	assert.Equal(s.T(), 0.0, po.Overlays[1].Scale)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCaption() {
+	path := "/caption:SGVsbG8sIHdvcmxk:no:Open%20Sans:32:ff0000:10:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Caption.Enabled)
+	assert.Equal(s.T(), "Hello, world", po.Caption.Text)
+	assert.Equal(s.T(), GravityNorth, po.Caption.Gravity.Type)
+	assert.Equal(s.T(), "Open Sans", po.Caption.Font)
+	assert.Equal(s.T(), 32, po.Caption.Size)
+	assert.Equal(s.T(), vips.Color{R: 255, G: 0, B: 0}, po.Caption.Color)
+	assert.Equal(s.T(), 10, po.Caption.Padding)
+	assert.True(s.T(), po.Caption.Shadow)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCaptionDefaults() {
+	path := "/caption:SGVsbG8sIHdvcmxk/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Caption.Enabled)
+	assert.Equal(s.T(), GravitySouth, po.Caption.Gravity.Type)
+	assert.Equal(s.T(), "sans", po.Caption.Font)
+	assert.Equal(s.T(), 24, po.Caption.Size)
+	assert.False(s.T(), po.Caption.Shadow)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathCaptionTooBig() {
+	path := "/caption:SGVsbG8sIHdvcmxk::sans:513/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+
+	text := base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat("a", MaxCaptionTextLength+1)))
+
+	path = fmt.Sprintf("/caption:%s/plain/http://images.dev/lorem/ipsum.jpg", text)
+	_, _, err = s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathEnhance() {
+	path := "/enhance:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Enhance)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathEqualize() {
+	path := "/equalize:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Equalize)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPosterize() {
+	path := "/posterize:4/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 4, po.Posterize)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathLqip() {
+	path := "/lqip:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.Lqip)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathPosterizeInvalid() {
+	path := "/posterize:1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathColorProfile() {
+	config.Conf.ColorProfiles = map[string]string{"p3": "/profiles/p3.icc"}
+
+	path := "/color_profile:p3/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), "p3", po.ColorProfile)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathColorProfileUnknown() {
+	path := "/color_profile:p3/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathKeepBitDepth() {
+	path := "/kbd:1/plain/http://images.dev/lorem/ipsum.png"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.True(s.T(), po.KeepBitDepth)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTimeout() {
+	config.Conf.MaxTimeout = 60
+
+	path := "/timeout:30/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 30, po.Timeout)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedTimeoutTooBig() {
+	config.Conf.MaxTimeout = 60
+
+	path := "/timeout:120/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedPriority() {
+	path := "/priority:low/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), PriorityLow, po.Priority)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxFPS() {
+	path := "/max_fps:12.5/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 12.5, po.MaxFPS)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedMaxFPSInvalid() {
+	path := "/max_fps:-1/plain/http://images.dev/lorem/ipsum.jpg"
+	_, _, err := s.parsePath(path, &Headers{})
+
+	require.Error(s.T(), err)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathAdvancedFrame() {
+	path := "/frame:3/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 3, po.Frame)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWebpDetection() {
+	config.Conf.EnableWebpDetection = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg"
+	_, po, err := s.parsePath(path, &Headers{Accept: "image/webp"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), true, po.PreferWebP)
+	assert.Equal(s.T(), false, po.EnforceWebP)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWebpEnforce() {
+	config.Conf.EnforceWebp = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{Accept: "image/webp"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), true, po.PreferWebP)
+	assert.Equal(s.T(), true, po.EnforceWebP)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWidthHeader() {
+	config.Conf.EnableClientHints = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{Width: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 100, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWidthHeaderDisabled() {
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{Width: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 0, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathWidthHeaderRedefine() {
+	config.Conf.EnableClientHints = true
+
+	path := "/width:150/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{Width: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 150, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathViewportWidthHeader() {
+	config.Conf.EnableClientHints = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{ViewportWidth: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 100, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathViewportWidthHeaderDisabled() {
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{ViewportWidth: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 0, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathViewportWidthHeaderRedefine() {
+	config.Conf.EnableClientHints = true
+
+	path := "/width:150/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{ViewportWidth: "100"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 150, po.Width)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDprHeader() {
+	config.Conf.EnableClientHints = true
+
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{DPR: "2"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 2.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathDprHeaderDisabled() {
+	path := "/plain/http://images.dev/lorem/ipsum.jpg@png"
+	_, po, err := s.parsePath(path, &Headers{DPR: "2"})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), 1.0, po.Dpr)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParsePathOnlyPresets() {
+	config.Conf.OnlyPresets = true
+	SetPresets(Presets{
+		"test1": URLOptions{
+			URLOption{Name: "blur", Args: []string{"0.2"}},
+		},
+		"test2": URLOptions{
+			URLOption{Name: "quality", Args: []string{"50"}},
+		},
+	})
+
+	path := "/test1:test2/plain/http://images.dev/lorem/ipsum.jpg"
+
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+	assert.Equal(s.T(), 50, po.Quality)
+}
+
+func (s *ProcessingOptionsTestSuite) TestParseBase64URLOnlyPresets() {
+	config.Conf.OnlyPresets = true
+	SetPresets(Presets{
+		"test1": URLOptions{
+			URLOption{Name: "blur", Args: []string{"0.2"}},
+		},
+		"test2": URLOptions{
+			URLOption{Name: "quality", Args: []string{"50"}},
+		},
+	})
+
+	imageURL := "http://images.dev/lorem/ipsum.jpg?param=value"
+	path := fmt.Sprintf("/test1:test2/%s.png", base64.RawURLEncoding.EncodeToString([]byte(imageURL)))
+
+	_, po, err := s.parsePath(path, &Headers{})
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), float32(0.2), po.Blur)
+	assert.Equal(s.T(), 50, po.Quality)
+}
+
+func TestProcessingOptions(t *testing.T) {
+	suite.Run(t, new(ProcessingOptionsTestSuite))
+}
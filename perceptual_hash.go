@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+const (
+	pHashSize        = 32
+	pHashLowFreqSize = 8
+)
+
+// calcPerceptualHashes calculates pHash and dHash of the image.
+// Hashes are returned as hex strings of 64 bits
+func calcPerceptualHashes(img *vips.Image) (string, string, error) {
+	pixels, err := img.GrayscalePixels(pHashSize, pHashSize)
+	if err != nil {
+		return "", "", err
+	}
+
+	phash := calcPHash(pixels)
+
+	if pixels, err = img.GrayscalePixels(9, 8); err != nil {
+		return "", "", err
+	}
+
+	dhash := calcDHash(pixels)
+
+	return formatHash(phash), formatHash(dhash), nil
+}
+
+// calcPHash calculates the DCT-based hash of a 32x32 grayscale image.
+// Each bit shows if the low frequency coefficient is greater than their median
+func calcPHash(pixels []byte) uint64 {
+	var coeffs [pHashLowFreqSize * pHashLowFreqSize]float64
+
+	for v := 0; v < pHashLowFreqSize; v++ {
+		for u := 0; u < pHashLowFreqSize; u++ {
+			var sum float64
+
+			for y := 0; y < pHashSize; y++ {
+				cy := math.Cos(float64((2*y+1)*v) * math.Pi / (2 * pHashSize))
+
+				for x := 0; x < pHashSize; x++ {
+					cx := math.Cos(float64((2*x+1)*u) * math.Pi / (2 * pHashSize))
+					sum += float64(pixels[y*pHashSize+x]) * cx * cy
+				}
+			}
+
+			coeffs[v*pHashLowFreqSize+u] = sum
+		}
+	}
+
+	// The DC coefficient represents the average brightness and doesn't
+	// describe the image structure, so it's excluded from the median
+	sorted := make([]float64, len(coeffs)-1)
+	copy(sorted, coeffs[1:])
+	sort.Float64s(sorted)
+
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+
+	for i, c := range coeffs {
+		if c > median {
+			hash |= 1 << uint(len(coeffs)-1-i)
+		}
+	}
+
+	return hash
+}
+
+// calcDHash calculates the gradient hash of a 9x8 grayscale image.
+// Each bit shows if the pixel is brighter than its right neighbour
+func calcDHash(pixels []byte) uint64 {
+	var hash uint64
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type PerceptualHashTestSuite struct{ MainTestSuite }
+
+func (s *PerceptualHashTestSuite) TestDHashUniform() {
+	pixels := testutil.GenPixels(9, 8, 1, func(x, y int) []byte { return []byte{128} })
+
+	assert.Equal(s.T(), uint64(0), calcDHash(pixels))
+}
+
+func (s *PerceptualHashTestSuite) TestDHashGradient() {
+	pixels := testutil.GenPixels(9, 8, 1, func(x, y int) []byte { return []byte{byte(255 - x*20)} })
+
+	assert.Equal(s.T(), uint64(0xffffffffffffffff), calcDHash(pixels))
+}
+
+func (s *PerceptualHashTestSuite) TestPHashBrightness() {
+	pattern := func(x, y int) byte { return byte((x*37 + y*y*11 + x*y*5) % 200) }
+
+	pixels := testutil.GenPixels(pHashSize, pHashSize, 1, func(x, y int) []byte { return []byte{pattern(x, y)} })
+	brighter := testutil.GenPixels(pHashSize, pHashSize, 1, func(x, y int) []byte { return []byte{pattern(x, y) + 40} })
+
+	assert.Equal(s.T(), calcPHash(pixels), calcPHash(brighter))
+}
+
+func (s *PerceptualHashTestSuite) TestPHashInverted() {
+	pattern := func(x, y int) byte { return byte((x*37 + y*y*11 + x*y*5) % 200) }
+
+	pixels := testutil.GenPixels(pHashSize, pHashSize, 1, func(x, y int) []byte { return []byte{pattern(x, y)} })
+	inverted := testutil.GenPixels(pHashSize, pHashSize, 1, func(x, y int) []byte { return []byte{200 - pattern(x, y)} })
+
+	assert.NotEqual(s.T(), calcPHash(pixels), calcPHash(inverted))
+}
+
+func (s *PerceptualHashTestSuite) TestFormatHash() {
+	assert.Equal(s.T(), "00000000000000ff", formatHash(0xff))
+}
+
+func TestPerceptualHash(t *testing.T) {
+	suite.Run(t, new(PerceptualHashTestSuite))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/processing"
+)
+
+// init connects the processing pipeline to the tracing, metrics, request
+// timings, watermark and overlays of the server
+func init() {
+	processing.StartProcessing = startProcessingTrace
+	processing.StartSaving = startSavingTrace
+	processing.StartTiming = startTiming
+	processing.CheckTimeout = timeoutError
+	processing.Watermark = watermark.Get
+	processing.DownloadOverlay = downloadOverlay
+}
+
+func startProcessingTrace(ctx context.Context) (context.Context, func()) {
+	var ends []func()
+
+	if newRelicEnabled {
+		ends = append(ends, startNewRelicSegment(ctx, "Processing image"))
+	}
+
+	if xrayEnabled {
+		var xrayCancel context.CancelFunc
+		ctx, xrayCancel = startXRaySubsegment(ctx, "Processing image")
+		ends = append(ends, xrayCancel)
+	}
+
+	if prometheusEnabled {
+		ends = append(ends, startPrometheusDuration(prometheusProcessingDuration))
+	}
+
+	return ctx, func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+	}
+}
+
+func startSavingTrace(ctx context.Context, format imagetype.Type) func() {
+	var ends []func()
+
+	if newRelicEnabled {
+		ends = append(ends, startNewRelicSegment(ctx, "Saving image"))
+	}
+
+	if xrayEnabled {
+		_, xrayCancel := startXRaySubsegment(ctx, "Saving image")
+		ends = append(ends, xrayCancel)
+	}
+
+	if prometheusEnabled {
+		ends = append(ends, startPrometheusEncodeDuration(format))
+	}
+
+	return func() {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i]()
+		}
+	}
+}
+
+func downloadOverlay(ctx context.Context, imageURL string) (*imagedata.ImageData, context.CancelFunc, error) {
+	imgdata, _, _, done, err := downloadImage(ctx, imageURL)
+	return imgdata, done, err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+const (
+	placeholderSolid        = "solid"
+	placeholderCheckerboard = "checkerboard"
+	placeholderBlur         = "blur"
+)
+
+type placeholderOptions struct {
+	Type   string
+	Width  int
+	Height int
+	Colors []vips.Color
+	Format imagetype.Type
+}
+
+var placeholderDefaultColors = map[string][]vips.Color{
+	placeholderSolid:        {{R: 204, G: 204, B: 204}},
+	placeholderCheckerboard: {{R: 204, G: 204, B: 204}, {R: 255, G: 255, B: 255}},
+	placeholderBlur:         {{R: 204, G: 204, B: 204}},
+}
+
+// parsePlaceholderPath parses the placeholder path:
+// /placeholder/%type/%width/%height[/%color1[:%color2]][.%extension]
+func parsePlaceholderPath(r *http.Request) (*placeholderOptions, error) {
+	path := trimAfter(r.RequestURI, '?')
+	path = strings.TrimPrefix(path, conf.PathPrefix)
+	path = strings.TrimPrefix(path, "/placeholder")
+	path = strings.Trim(path, "/")
+
+	po := placeholderOptions{Format: imagetype.PNG}
+
+	if ind := strings.LastIndexByte(path, '.'); ind >= 0 {
+		ext := path[ind+1:]
+		path = path[:ind]
+
+		f, ok := imagetype.Types[ext]
+		if !ok || !options.FormatSupported(f) || f == imagetype.SVG {
+			return nil, ierrors.New(404, fmt.Sprintf("Invalid placeholder format: %s", ext), msgInvalidURL)
+		}
+
+		po.Format = f
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, ierrors.New(404, fmt.Sprintf("Invalid placeholder path: %s", path), msgInvalidURL)
+	}
+
+	po.Type = parts[0]
+
+	defaultColors, ok := placeholderDefaultColors[po.Type]
+	if !ok {
+		return nil, ierrors.New(404, fmt.Sprintf("Invalid placeholder type: %s", po.Type), msgInvalidURL)
+	}
+
+	var err error
+
+	if po.Width, err = strconv.Atoi(parts[1]); err != nil || po.Width <= 0 {
+		return nil, ierrors.New(404, fmt.Sprintf("Invalid placeholder width: %s", parts[1]), msgInvalidURL)
+	}
+
+	if po.Height, err = strconv.Atoi(parts[2]); err != nil || po.Height <= 0 {
+		return nil, ierrors.New(404, fmt.Sprintf("Invalid placeholder height: %s", parts[2]), msgInvalidURL)
+	}
+
+	if po.Width*po.Height > conf.MaxSrcResolution {
+		return nil, ierrors.New(422, fmt.Sprintf("Placeholder resolution is too big: %dx%d", po.Width, po.Height), "Placeholder resolution is too big")
+	}
+
+	po.Colors = append([]vips.Color(nil), defaultColors...)
+
+	if len(parts) > 3 {
+		colors := strings.Split(parts[3], ":")
+		if len(colors) > len(po.Colors) {
+			return nil, ierrors.New(404, fmt.Sprintf("Too many placeholder colors: %s", parts[3]), msgInvalidURL)
+		}
+
+		for i, hex := range colors {
+			if len(hex) == 0 {
+				continue
+			}
+
+			if po.Colors[i], err = options.ColorFromHex(hex); err != nil {
+				return nil, ierrors.New(404, err.Error(), msgInvalidURL)
+			}
+		}
+	}
+
+	return &po, nil
+}
+
+func handlePlaceholder(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if newRelicEnabled {
+		var newRelicCancel context.CancelFunc
+		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
+		defer newRelicCancel()
+	}
+
+	po, err := parsePlaceholderPath(r)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = acquireProcessingSem(ctx, options.PriorityNormal); err != nil {
+		panic(err)
+	}
+	defer releaseProcessingSem(options.PriorityNormal)
+
+	buf := responseBufPool.Get(0)
+	defer responseBufPool.Put(buf)
+
+	if err = generatePlaceholder(po, buf); err != nil {
+		panic(err)
+	}
+
+	rw.Header().Set("Content-Type", po.Format.Mime())
+	rw.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	rw.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", conf.TTL))
+	rw.Header().Set("Expires", time.Now().Add(time.Second*time.Duration(conf.TTL)).Format(http.TimeFormat))
+	rw.WriteHeader(200)
+	rw.Write(buf.Bytes())
+
+	logResponse(reqID, r, 200, nil, nil, nil)
+}
+
+func generatePlaceholder(po *placeholderOptions, w io.Writer) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer vips.Cleanup()
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	var err error
+
+	switch po.Type {
+	case placeholderSolid:
+		err = img.Solid(po.Width, po.Height, po.Colors[0])
+	case placeholderCheckerboard:
+		cell := maxInt(minInt(po.Width, po.Height)/10, 8)
+		err = img.Checkerboard(po.Width, po.Height, cell, po.Colors[0], po.Colors[1])
+	case placeholderBlur:
+		err = img.SoftFill(po.Width, po.Height, po.Colors[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	cancel, err := img.Save(w, po.Format, conf.Quality, true, false, false)
+	defer cancel()
+
+	return err
+}
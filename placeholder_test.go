@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/vips"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type PlaceholderTestSuite struct{ MainTestSuite }
+
+func (s *PlaceholderTestSuite) TestParseDefaults() {
+	po, err := parsePlaceholderPath(s.getRequest("/placeholder/checkerboard/300/200"))
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), placeholderCheckerboard, po.Type)
+	assert.Equal(s.T(), 300, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.Equal(s.T(), []vips.Color{{R: 204, G: 204, B: 204}, {R: 255, G: 255, B: 255}}, po.Colors)
+	assert.Equal(s.T(), imagetype.PNG, po.Format)
+}
+
+func (s *PlaceholderTestSuite) TestParseColorsAndFormat() {
+	po, err := parsePlaceholderPath(s.getRequest("/placeholder/checkerboard/300/200/ff0000:.jpg?v=1"))
+
+	require.Nil(s.T(), err)
+
+	assert.Equal(s.T(), []vips.Color{{R: 255, G: 0, B: 0}, {R: 255, G: 255, B: 255}}, po.Colors)
+	assert.Equal(s.T(), imagetype.JPEG, po.Format)
+}
+
+func (s *PlaceholderTestSuite) TestParseTooManyColors() {
+	_, err := parsePlaceholderPath(s.getRequest("/placeholder/solid/300/200/ff0000:00ff00"))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 404, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *PlaceholderTestSuite) TestParseInvalidType() {
+	_, err := parsePlaceholderPath(s.getRequest("/placeholder/noise/300/200"))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 404, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *PlaceholderTestSuite) TestParseTooBig() {
+	conf.MaxSrcResolution = 1000
+
+	_, err := parsePlaceholderPath(s.getRequest("/placeholder/blur/300/200"))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 422, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *PlaceholderTestSuite) TestResponseBufPoolWithoutBufferResponse() {
+	conf.BufferResponse = false
+	conf.BatchMaxSize = 0
+	conf.PlaceholdersEnabled = true
+
+	oldPool := responseBufPool
+	defer func() { responseBufPool = oldPool }()
+
+	responseBufPool = nil
+
+	require.Nil(s.T(), initProcessingHandler())
+	assert.NotNil(s.T(), responseBufPool)
+}
+
+func TestPlaceholder(t *testing.T) {
+	suite.Run(t, new(PlaceholderTestSuite))
+}
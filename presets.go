@@ -2,55 +2,88 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"sort"
 	"strings"
-)
+	"time"
 
-type presets map[string]urlOptions
+	"github.com/imgproxy/imgproxy/v2/options"
+)
 
-func parsePreset(p presets, presetStr string) error {
-	presetStr = strings.Trim(presetStr, " ")
+func loadPresets() (options.Presets, error) {
+	p := make(options.Presets)
 
-	if len(presetStr) == 0 || strings.HasPrefix(presetStr, "#") {
-		return nil
+	if err := presetEnvConfig(p, "IMGPROXY_PRESETS"); err != nil {
+		return nil, err
+	}
+	if err := presetFileConfig(p, conf.PresetsPath); err != nil {
+		return nil, err
 	}
 
-	parts := strings.Split(presetStr, "=")
+	return p, nil
+}
 
-	if len(parts) != 2 {
-		return fmt.Errorf("Invalid preset string: %s", presetStr)
+// reloadPresets re-reads presets from IMGPROXY_PRESETS and the presets file.
+// The old presets are kept if the new ones are invalid
+func reloadPresets() error {
+	p, err := loadPresets()
+	if err != nil {
+		return err
 	}
 
-	name := strings.Trim(parts[0], " ")
-	if len(name) == 0 {
-		return fmt.Errorf("Empty preset name: %s", presetStr)
+	if err = options.CheckPresets(p); err != nil {
+		return err
 	}
 
-	value := strings.Trim(parts[1], " ")
-	if len(value) == 0 {
-		return fmt.Errorf("Empty preset value: %s", presetStr)
+	options.SetPresets(p)
+
+	return nil
+}
+
+// savePresets writes presets to the presets file
+func savePresets(p options.Presets) error {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	optsStr := strings.Split(value, "/")
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%s\n", name, options.FormatPreset(p[name]))
+	}
 
-	opts, rest := parseURLOptions(optsStr)
+	return writeFileAtomic(conf.PresetsPath, []byte(b.String()))
+}
 
-	if len(rest) > 0 {
-		return fmt.Errorf("Invalid preset value: %s", presetStr)
+// startPresetsWatching reloads presets when the presets file changes
+func startPresetsWatching() {
+	if len(conf.PresetsPath) == 0 || conf.PresetsWatchInterval <= 0 {
+		return
 	}
 
-	p[name] = opts
+	stat, _ := os.Stat(conf.PresetsPath)
 
-	return nil
-}
+	go func() {
+		for range time.Tick(time.Duration(conf.PresetsWatchInterval) * time.Second) {
+			newStat, err := os.Stat(conf.PresetsPath)
+			if err != nil {
+				logError("Can't check presets file: %s", err)
+				continue
+			}
 
-func checkPresets(p presets) error {
-	var po processingOptions
+			if stat != nil && newStat.ModTime().Equal(stat.ModTime()) && newStat.Size() == stat.Size() {
+				continue
+			}
 
-	for name, opts := range p {
-		if err := applyProcessingOptions(&po, opts); err != nil {
-			return fmt.Errorf("Error in preset `%s`: %s", name, err)
-		}
-	}
+			stat = newStat
 
-	return nil
+			if err := reloadPresets(); err != nil {
+				logError("Can't reload presets: %s", err)
+				continue
+			}
+
+			logNotice("Presets reloaded")
+		}
+	}()
 }
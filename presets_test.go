@@ -1,9 +1,11 @@
 package main
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
+	"github.com/imgproxy/imgproxy/v2/options"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -11,101 +13,44 @@ import (
 
 type PresetsTestSuite struct{ MainTestSuite }
 
-func (s *PresetsTestSuite) TestParsePreset() {
-	p := make(presets)
-
-	err := parsePreset(p, "test=resize:fit:100:200/sharpen:2")
-
+func (s *PresetsTestSuite) TestReloadPresets() {
+	f, err := ioutil.TempFile("", "presets")
 	require.Nil(s.T(), err)
+	defer os.Remove(f.Name())
 
-	assert.Equal(s.T(), urlOptions{
-		urlOption{Name: "resize", Args: []string{"fit", "100", "200"}},
-		urlOption{Name: "sharpen", Args: []string{"2"}},
-	}, p["test"])
-}
-
-func (s *PresetsTestSuite) TestParsePresetInvalidString() {
-	p := make(presets)
-
-	presetStr := "resize:fit:100:200/sharpen:2"
-	err := parsePreset(p, presetStr)
-
-	assert.Equal(s.T(), fmt.Errorf("Invalid preset string: %s", presetStr), err)
-	assert.Empty(s.T(), p)
-}
-
-func (s *PresetsTestSuite) TestParsePresetEmptyName() {
-	p := make(presets)
-
-	presetStr := "=resize:fit:100:200/sharpen:2"
-	err := parsePreset(p, presetStr)
-
-	assert.Equal(s.T(), fmt.Errorf("Empty preset name: %s", presetStr), err)
-	assert.Empty(s.T(), p)
-}
-
-func (s *PresetsTestSuite) TestParsePresetEmptyValue() {
-	p := make(presets)
-
-	presetStr := "test="
-	err := parsePreset(p, presetStr)
-
-	assert.Equal(s.T(), fmt.Errorf("Empty preset value: %s", presetStr), err)
-	assert.Empty(s.T(), p)
-}
+	f.WriteString("test=resize:fit:100:200\n")
+	f.Close()
 
-func (s *PresetsTestSuite) TestParsePresetInvalidValue() {
-	p := make(presets)
+	options.SetPresets(options.Presets{"old": options.URLOptions{}})
+	conf.PresetsPath = f.Name()
 
-	presetStr := "test=resize:fit:100:200/sharpen:2/blur"
-	err := parsePreset(p, presetStr)
+	err = reloadPresets()
 
-	assert.Equal(s.T(), fmt.Errorf("Invalid preset value: %s", presetStr), err)
-	assert.Empty(s.T(), p)
-}
-
-func (s *PresetsTestSuite) TestParsePresetEmptyString() {
-	p := make(presets)
-
-	err := parsePreset(p, "  ")
-
-	assert.Nil(s.T(), err)
-	assert.Empty(s.T(), p)
-}
-
-func (s *PresetsTestSuite) TestParsePresetComment() {
-	p := make(presets)
-
-	err := parsePreset(p, "#  test=resize:fit:100:200/sharpen:2")
-
-	assert.Nil(s.T(), err)
-	assert.Empty(s.T(), p)
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), options.Presets{
+		"test": options.URLOptions{
+			options.URLOption{Name: "resize", Args: []string{"fit", "100", "200"}},
+		},
+	}, options.AllPresets())
 }
 
-func (s *PresetsTestSuite) TestCheckPresets() {
-	p := presets{
-		"test": urlOptions{
-			urlOption{Name: "resize", Args: []string{"fit", "100", "200"}},
-			urlOption{Name: "sharpen", Args: []string{"2"}},
-		},
-	}
+func (s *PresetsTestSuite) TestReloadPresetsInvalid() {
+	f, err := ioutil.TempFile("", "presets")
+	require.Nil(s.T(), err)
+	defer os.Remove(f.Name())
 
-	err := checkPresets(p)
+	f.WriteString("test=resize:fit:-1:-2\n")
+	f.Close()
 
-	assert.Nil(s.T(), err)
-}
+	oldPresets := options.Presets{"old": options.URLOptions{}}
 
-func (s *PresetsTestSuite) TestCheckPresetsInvalid() {
-	p := presets{
-		"test": urlOptions{
-			urlOption{Name: "resize", Args: []string{"fit", "-1", "-2"}},
-			urlOption{Name: "sharpen", Args: []string{"2"}},
-		},
-	}
+	options.SetPresets(oldPresets)
+	conf.PresetsPath = f.Name()
 
-	err := checkPresets(p)
+	err = reloadPresets()
 
 	assert.Error(s.T(), err)
+	assert.Equal(s.T(), oldPresets, options.AllPresets())
 }
 
 func TestPresets(t *testing.T) {
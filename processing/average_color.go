@@ -0,0 +1,50 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+// AverageColorHeader is the response header holding the average color
+// of the result image
+const AverageColorHeader = "X-Average-Color"
+
+var averageColorHeaderCtxKey = ctxKey("averageColorHeader")
+
+// WithAverageColorHeader makes ProcessImage set the average color of the result
+// image to the provided response headers. Does nothing if the average color header
+// is disabled
+func WithAverageColorHeader(ctx context.Context, header http.Header) context.Context {
+	if !config.Conf.EnableAverageColorHeader {
+		return ctx
+	}
+
+	return context.WithValue(ctx, averageColorHeaderCtxKey, header)
+}
+
+// setAverageColorHeader calculates the average color of the image and sets it
+// to the response headers. Should be called before the image is saved since
+// headers are sent with the first written bytes
+func setAverageColorHeader(ctx context.Context, img *vips.Image) error {
+	header, ok := ctx.Value(averageColorHeaderCtxKey).(http.Header)
+	if !ok {
+		return nil
+	}
+
+	color, err := img.AverageColor()
+	if err != nil {
+		return err
+	}
+
+	header.Set(AverageColorHeader, formatHexColor(color))
+
+	return nil
+}
+
+func formatHexColor(c vips.Color) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,44 @@
+package processing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type HooksTestSuite struct{ suite.Suite }
+
+func (s *HooksTestSuite) TestCheckTimeout() {
+	assert.Nil(s.T(), CheckTimeout(context.Background()))
+}
+
+func (s *HooksTestSuite) TestCheckTimeoutCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := CheckTimeout(ctx)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 499, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *HooksTestSuite) TestCheckTimeoutDeadlineExceeded() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+
+	<-ctx.Done()
+
+	err := CheckTimeout(ctx)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 503, err.(*ierrors.Error).StatusCode)
+}
+
+func TestHooks(t *testing.T) {
+	suite.Run(t, new(HooksTestSuite))
+}
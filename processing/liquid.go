@@ -0,0 +1,203 @@
+package processing
+
+import (
+	"context"
+
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+// liquidMaxSeamsRatio is the max part of the image dimension that can be
+// removed by seam carving. Seam carving distorts the image when too many seams
+// are removed, so the rest of the extra size is cropped
+const liquidMaxSeamsRatio = 0.25
+
+// liquidResize reduces the image to the given size removing the seams
+// of the least noticeable pixels
+func liquidResize(ctx context.Context, img *vips.Image, width, height int, gravity *options.GravityOptions) error {
+	imgWidth, imgHeight := img.Width(), img.Height()
+
+	seamsX := liquidSeamsCount(imgWidth, width)
+	seamsY := liquidSeamsCount(imgHeight, height)
+
+	if seamsX == 0 && seamsY == 0 {
+		return nil
+	}
+
+	cropWidth := minNonZeroInt(width, imgWidth) + seamsX
+	cropHeight := minNonZeroInt(height, imgHeight) + seamsY
+
+	if err := cropImage(img, cropWidth, cropHeight, gravity); err != nil {
+		return err
+	}
+
+	imgWidth, imgHeight = img.Width(), img.Height()
+
+	luma, err := img.GrayscalePixels(imgWidth, imgHeight)
+	if err != nil {
+		return err
+	}
+
+	index, err := carveIndex(ctx, luma, imgWidth, imgHeight, seamsX, seamsY)
+	if err != nil {
+		return err
+	}
+
+	return img.Remap(index, imgWidth-seamsX, imgHeight-seamsY)
+}
+
+func liquidSeamsCount(size, target int) int {
+	if target <= 0 || target >= size {
+		return 0
+	}
+
+	return minInt(size-target, int(float64(size)*liquidMaxSeamsRatio))
+}
+
+// carveIndex removes the vertical and the horizontal seams from the grayscale
+// pixels and returns the source coordinates of the remaining pixels
+// as x and y pairs
+func carveIndex(ctx context.Context, luma []byte, width, height, seamsX, seamsY int) ([]float32, error) {
+	luma, cols, err := carveSeams(ctx, luma, width, height, seamsX)
+	if err != nil {
+		return nil, err
+	}
+
+	newWidth := width - seamsX
+	newHeight := height - seamsY
+
+	// Horizontal seams are the vertical seams of the transposed image
+	_, rows, err := carveSeams(ctx, transposePixels(luma, newWidth, height), height, newWidth, seamsY)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make([]float32, newWidth*newHeight*2)
+
+	for y := 0; y < newHeight; y++ {
+		for x := 0; x < newWidth; x++ {
+			srcY := rows[x*newHeight+y]
+			srcX := cols[srcY*newWidth+x]
+
+			i := (y*newWidth + x) * 2
+			index[i] = float32(srcX)
+			index[i+1] = float32(srcY)
+		}
+	}
+
+	return index, nil
+}
+
+// carveSeams removes n vertical seams with the least energy from the grayscale
+// pixels. Returns the carved pixels and the source column of each of them.
+// Every seam takes a full pass over the pixels, so the timeout is checked
+// after each of them
+func carveSeams(ctx context.Context, pixels []byte, width, height, n int) ([]byte, []int, error) {
+	pixels = append([]byte(nil), pixels...)
+
+	keep := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			keep[y*width+x] = x
+		}
+	}
+
+	energy := make([]int32, width*height)
+	cost := make([]int32, width*height)
+	seam := make([]int, height)
+
+	// Rows keep the original stride while the seams are removed
+	for s := 0; s < n; s++ {
+		curWidth := width - s
+
+		for y := 0; y < height; y++ {
+			row := y * width
+			up := maxInt(y-1, 0) * width
+			down := minInt(y+1, height-1) * width
+
+			for x := 0; x < curWidth; x++ {
+				left := pixels[row+maxInt(x-1, 0)]
+				right := pixels[row+minInt(x+1, curWidth-1)]
+
+				energy[row+x] = absInt32(int32(right)-int32(left)) +
+					absInt32(int32(pixels[down+x])-int32(pixels[up+x]))
+			}
+		}
+
+		copy(cost[:curWidth], energy[:curWidth])
+
+		for y := 1; y < height; y++ {
+			row := y * width
+			prev := row - width
+
+			for x := 0; x < curWidth; x++ {
+				best := cost[prev+x]
+				if x > 0 && cost[prev+x-1] < best {
+					best = cost[prev+x-1]
+				}
+				if x < curWidth-1 && cost[prev+x+1] < best {
+					best = cost[prev+x+1]
+				}
+
+				cost[row+x] = energy[row+x] + best
+			}
+		}
+
+		last := (height - 1) * width
+		seam[height-1] = 0
+		for x := 1; x < curWidth; x++ {
+			if cost[last+x] < cost[last+seam[height-1]] {
+				seam[height-1] = x
+			}
+		}
+
+		for y := height - 2; y >= 0; y-- {
+			row := y * width
+			next := seam[y+1]
+
+			seam[y] = next
+			if next > 0 && cost[row+next-1] < cost[row+seam[y]] {
+				seam[y] = next - 1
+			}
+			if next < curWidth-1 && cost[row+next+1] < cost[row+seam[y]] {
+				seam[y] = next + 1
+			}
+		}
+
+		for y := 0; y < height; y++ {
+			row := y * width
+			x := seam[y]
+
+			copy(pixels[row+x:row+curWidth-1], pixels[row+x+1:row+curWidth])
+			copy(keep[row+x:row+curWidth-1], keep[row+x+1:row+curWidth])
+		}
+
+		if err := CheckTimeout(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	newWidth := width - n
+
+	carved := make([]byte, newWidth*height)
+	carvedKeep := make([]int, newWidth*height)
+
+	for y := 0; y < height; y++ {
+		copy(carved[y*newWidth:(y+1)*newWidth], pixels[y*width:y*width+newWidth])
+		copy(carvedKeep[y*newWidth:(y+1)*newWidth], keep[y*width:y*width+newWidth])
+	}
+
+	return carved, carvedKeep, nil
+}
+
+func transposePixels(pixels []byte, width, height int) []byte {
+	res := make([]byte, len(pixels))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			res[x*height+y] = pixels[y*width+x]
+		}
+	}
+
+	return res
+}
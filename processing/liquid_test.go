@@ -0,0 +1,88 @@
+package processing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type LiquidTestSuite struct{ suite.Suite }
+
+// genLiquidPixels generates grayscale pixels with the noisy left half
+// and the flat right half
+func genLiquidPixels(width, height int) []byte {
+	return testutil.GenPixels(width, height, 1, func(x, y int) []byte {
+		if x < width/2 {
+			return []byte{byte((x*97 + y*57) % 251)}
+		}
+		return []byte{128}
+	})
+}
+
+func (s *LiquidTestSuite) TestSeamsCount() {
+	assert.Equal(s.T(), 0, liquidSeamsCount(100, 0))
+	assert.Equal(s.T(), 0, liquidSeamsCount(100, 100))
+	assert.Equal(s.T(), 0, liquidSeamsCount(100, 120))
+	assert.Equal(s.T(), 10, liquidSeamsCount(100, 90))
+	assert.Equal(s.T(), 25, liquidSeamsCount(100, 50))
+}
+
+func (s *LiquidTestSuite) TestCarveSeamsKeepsDetails() {
+	pixels := genLiquidPixels(20, 10)
+
+	carved, keep, err := carveSeams(context.Background(), pixels, 20, 10, 5)
+
+	require.Nil(s.T(), err)
+	require.Len(s.T(), carved, 15*10)
+	require.Len(s.T(), keep, 15*10)
+
+	for y := 0; y < 10; y++ {
+		// The noisy half stays untouched
+		for x := 0; x < 10; x++ {
+			assert.Equal(s.T(), x, keep[y*15+x])
+			assert.Equal(s.T(), pixels[y*20+x], carved[y*15+x])
+		}
+	}
+}
+
+func (s *LiquidTestSuite) TestCarveIndex() {
+	pixels := genLiquidPixels(20, 16)
+
+	index, err := carveIndex(context.Background(), pixels, 20, 16, 4, 3)
+
+	require.Nil(s.T(), err)
+	require.Len(s.T(), index, 16*13*2)
+
+	for y := 0; y < 13; y++ {
+		prevX := float32(-1)
+
+		for x := 0; x < 16; x++ {
+			i := (y*16 + x) * 2
+
+			assert.True(s.T(), index[i] > prevX, "Columns should keep their order")
+			assert.True(s.T(), index[i] < 20)
+			assert.True(s.T(), index[i+1] >= 0 && index[i+1] < 16)
+
+			prevX = index[i]
+		}
+	}
+}
+
+func (s *LiquidTestSuite) TestCarveSeamsCancelled() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err := carveSeams(ctx, genLiquidPixels(20, 10), 20, 10, 5)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 499, err.(*ierrors.Error).StatusCode)
+}
+
+func TestLiquid(t *testing.T) {
+	suite.Run(t, new(LiquidTestSuite))
+}
@@ -0,0 +1,74 @@
+package processing
+
+import (
+	"context"
+
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+var overlaysCtxKey = ctxKey("overlays")
+
+// downloadOverlays downloads the overlay images of the processing options
+// and puts them to the context so transformImage can apply them
+func downloadOverlays(ctx context.Context, po *options.ProcessingOptions) (context.Context, context.CancelFunc, error) {
+	if len(po.Overlays) == 0 {
+		return ctx, func() {}, nil
+	}
+
+	overlays := make([]*imagedata.ImageData, 0, len(po.Overlays))
+	cancels := make([]context.CancelFunc, 0, len(po.Overlays))
+
+	cancel := func() {
+		for _, c := range cancels {
+			c()
+		}
+	}
+
+	for _, o := range po.Overlays {
+		imgdata, done, err := DownloadOverlay(ctx, o.URL)
+		cancels = append(cancels, done)
+		if err != nil {
+			return ctx, cancel, err
+		}
+
+		if !vips.SupportsLoad(imgdata.Type) || imgdata.Type == imagetype.ICO {
+			return ctx, cancel, ErrSourceImageTypeNotSupported
+		}
+
+		overlays = append(overlays, imgdata)
+	}
+
+	return context.WithValue(ctx, overlaysCtxKey, overlays), cancel, nil
+}
+
+// withoutOverlays removes the overlay images from the context.
+// Used to process animation frames since overlays are applied to the whole animation
+func withoutOverlays(ctx context.Context) context.Context {
+	if ctx.Value(overlaysCtxKey) == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, overlaysCtxKey, nil)
+}
+
+func applyOverlays(ctx context.Context, img *vips.Image, po *options.ProcessingOptions, framesCount int) error {
+	overlays, _ := ctx.Value(overlaysCtxKey).([]*imagedata.ImageData)
+
+	for i, data := range overlays {
+		opts := options.WatermarkOptions{
+			Enabled: true,
+			Opacity: 1,
+			Gravity: po.Overlays[i].Gravity,
+			Scale:   po.Overlays[i].Scale,
+		}
+
+		if err := applyImageOverlay(img, data, &opts, 1, framesCount); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
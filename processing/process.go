@@ -0,0 +1,1285 @@
+// Package processing contains the image processing pipeline.
+package processing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	msgSmartCropNotSupported = "Smart crop is not supported by used version of libvips"
+
+	// https://chromium.googlesource.com/webm/libwebp/+/refs/heads/master/src/webp/encode.h#529
+	webpMaxDimension = 16383.0
+)
+
+type ctxKey string
+
+var (
+	errConvertingNonSvgToSvg  = ierrors.New(422, "Converting non-SVG images to SVG is not supported", "Converting non-SVG images to SVG is not supported")
+	ErrResultDimensionsTooBig = ierrors.New(422, "Result image dimensions are too big", "Result image is too big")
+	ErrResultResolutionTooBig = ierrors.New(422, "Result image resolution is too big", "Result image is too big")
+
+	ErrSourceDimensionsTooBig      = ierrors.New(422, "Source image dimensions are too big", "Invalid source image")
+	ErrSourceResolutionTooBig      = ierrors.New(422, "Source image resolution is too big", "Invalid source image")
+	ErrSourceImageTypeNotSupported = ierrors.New(422, "Source image type not supported", "Invalid source image")
+	errSourceTooManyFrames         = ierrors.New(422, "Source image has too many animation frames", "Invalid source image")
+	errFrameOutOfRange             = ierrors.New(422, "Frame index is out of range", "Invalid frame")
+	errOverlaysNotSupported        = ierrors.New(422, "Overlays are not supported", "Overlays are not supported")
+)
+
+// Hooks that connect the pipeline to the request handling. imgproxy uses them
+// for tracing, metrics, request timings, the watermark, and overlays
+var (
+	// StartProcessing is called when the image processing starts. The returned
+	// context is used for processing, the returned function is called when
+	// the processing is finished
+	StartProcessing = func(ctx context.Context) (context.Context, func()) {
+		return ctx, func() {}
+	}
+
+	// StartSaving is called before the result image is encoded. The returned
+	// function is called when the image is saved
+	StartSaving = func(ctx context.Context, format imagetype.Type) func() {
+		return func() {}
+	}
+
+	// StartTiming is called when the decode, transform and encode stages start.
+	// The returned function is called when the stage is finished
+	StartTiming = func(ctx context.Context, stage string) func() {
+		return func() {}
+	}
+
+	// CheckTimeout is called between the processing steps. A non-nil error
+	// stops the processing and is returned by ProcessImage
+	CheckTimeout = func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				return ierrors.New(499, "Request was cancelled", "Cancelled")
+			}
+			return ierrors.New(503, "Timeout", "Timeout")
+		default:
+			return nil
+		}
+	}
+
+	// Watermark returns the watermark image. There's no watermark by default
+	Watermark = func() *imagedata.ImageData {
+		return nil
+	}
+
+	// DownloadOverlay downloads the overlay image. The returned function
+	// is called when the image isn't needed anymore
+	DownloadOverlay = func(ctx context.Context, imageURL string) (*imagedata.ImageData, context.CancelFunc, error) {
+		return nil, func() {}, errOverlaysNotSupported
+	}
+)
+
+func extractMeta(img *vips.Image) (int, int, int, bool) {
+	width := img.Width()
+	height := img.Height()
+
+	angle := vips.AngleD0
+	flip := false
+
+	orientation := img.Orientation()
+
+	if orientation >= 5 && orientation <= 8 {
+		width, height = height, width
+	}
+	if orientation == 3 || orientation == 4 {
+		angle = vips.AngleD180
+	}
+	if orientation == 5 || orientation == 6 {
+		angle = vips.AngleD90
+	}
+	if orientation == 7 || orientation == 8 {
+		angle = vips.AngleD270
+	}
+	if orientation == 2 || orientation == 4 || orientation == 5 || orientation == 7 {
+		flip = true
+	}
+
+	return width, height, angle, flip
+}
+
+// CheckResultDimensions checks the result image size against
+// IMGPROXY_MAX_RESULT_DIMENSION and IMGPROXY_MAX_RESULT_RESOLUTION
+func CheckResultDimensions(width, height, frames int) error {
+	if config.Conf.MaxResultDimension > 0 && (width > config.Conf.MaxResultDimension || height > config.Conf.MaxResultDimension) {
+		return ErrResultDimensionsTooBig
+	}
+
+	if config.Conf.MaxResultResolution > 0 && width*height*frames > config.Conf.MaxResultResolution {
+		return ErrResultResolutionTooBig
+	}
+
+	return nil
+}
+
+// CheckDimensions checks the source image size against
+// IMGPROXY_MAX_SRC_DIMENSION and IMGPROXY_MAX_SRC_RESOLUTION
+func CheckDimensions(width, height int) error {
+	if config.Conf.MaxSrcDimension > 0 && (width > config.Conf.MaxSrcDimension || height > config.Conf.MaxSrcDimension) {
+		return ErrSourceDimensionsTooBig
+	}
+
+	if width*height > config.Conf.MaxSrcResolution {
+		return ErrSourceResolutionTooBig
+	}
+
+	return nil
+}
+
+func calcScale(width, height int, po *options.ProcessingOptions, imgtype imagetype.Type) float64 {
+	var shrink float64
+
+	srcW, srcH := float64(width), float64(height)
+	dstW, dstH := float64(po.Width), float64(po.Height)
+
+	if po.Width == 0 {
+		dstW = srcW
+	}
+
+	if po.Height == 0 {
+		dstH = srcH
+	}
+
+	if dstW == srcW && dstH == srcH {
+		shrink = 1
+	} else {
+		wshrink := srcW / dstW
+		hshrink := srcH / dstH
+
+		rt := po.ResizingType
+
+		if rt == options.ResizeAuto {
+			srcD := width - height
+			dstD := po.Width - po.Height
+
+			if (srcD >= 0 && dstD >= 0) || (srcD < 0 && dstD < 0) {
+				rt = options.ResizeFill
+			} else {
+				rt = options.ResizeFit
+			}
+		}
+
+		switch {
+		case po.Width == 0:
+			shrink = hshrink
+		case po.Height == 0:
+			shrink = wshrink
+		case rt == options.ResizeFit:
+			shrink = math.Max(wshrink, hshrink)
+		default:
+			shrink = math.Min(wshrink, hshrink)
+		}
+	}
+
+	if !po.Enlarge && shrink < 1 && imgtype != imagetype.SVG {
+		shrink = 1
+	}
+
+	shrink /= po.Dpr
+
+	if shrink > srcW {
+		shrink = srcW
+	}
+
+	if shrink > srcH {
+		shrink = srcH
+	}
+
+	return 1.0 / shrink
+}
+
+// estimateDecodeMemory estimates the amount of memory (in bytes) needed to decode the image.
+// Image header is loaded lazily, so this can be called before decoding pixels
+func estimateDecodeMemory(img *vips.Image, animated bool) int64 {
+	height := img.Height()
+
+	if animated {
+		if frameHeight, err := img.GetInt("page-height"); err == nil && frameHeight > 0 {
+			height = frameHeight * minInt(img.Height()/frameHeight, config.Conf.MaxAnimationFrames)
+		}
+	}
+
+	return int64(img.Width()) * int64(height) * int64(img.Bands()) * int64(img.BandSize())
+}
+
+func checkDecodeMemory(img *vips.Image, animated bool) error {
+	if config.Conf.MaxDecodeMemory == 0 {
+		return nil
+	}
+
+	mem := estimateDecodeMemory(img, animated) / 1024 / 1024
+
+	if mem > int64(config.Conf.MaxDecodeMemory) {
+		return ierrors.New(
+			422,
+			fmt.Sprintf("Source image needs too much memory to decode: %d MB, max - %d MB", mem, config.Conf.MaxDecodeMemory),
+			"Invalid source image",
+		)
+	}
+
+	return nil
+}
+
+func canScaleOnLoad(imgtype imagetype.Type, scale float64) bool {
+	if imgtype == imagetype.SVG {
+		return true
+	}
+
+	if config.Conf.DisableShrinkOnLoad || scale >= 1 {
+		return false
+	}
+
+	return imgtype == imagetype.JPEG ||
+		imgtype == imagetype.WEBP ||
+		imgtype == imagetype.HEIC ||
+		imgtype == imagetype.AVIF
+}
+
+func canFitToBytes(imgtype imagetype.Type) bool {
+	switch imgtype {
+	case imagetype.JPEG, imagetype.WEBP, imagetype.AVIF, imagetype.TIFF:
+		return true
+	default:
+		return false
+	}
+}
+
+func calcJpegShink(scale float64, imgtype imagetype.Type) int {
+	shrink := int(1.0 / scale)
+
+	switch {
+	case shrink >= 8:
+		return 8
+	case shrink >= 4:
+		return 4
+	case shrink >= 2:
+		return 2
+	}
+
+	return 1
+}
+
+func calcPosition(width, height, innerWidth, innerHeight int, gravity *options.GravityOptions, allowOverflow bool) (left, top int) {
+	if gravity.Type == options.GravityFocusPoint {
+		pointX := scaleInt(width, gravity.X)
+		pointY := scaleInt(height, gravity.Y)
+
+		left = pointX - innerWidth/2
+		top = pointY - innerHeight/2
+	} else {
+		offX, offY := int(gravity.X), int(gravity.Y)
+
+		left = (width-innerWidth+1)/2 + offX
+		top = (height-innerHeight+1)/2 + offY
+
+		if gravity.Type == options.GravityNorth || gravity.Type == options.GravityNorthEast || gravity.Type == options.GravityNorthWest {
+			top = 0 + offY
+		}
+
+		if gravity.Type == options.GravityEast || gravity.Type == options.GravityNorthEast || gravity.Type == options.GravitySouthEast {
+			left = width - innerWidth - offX
+		}
+
+		if gravity.Type == options.GravitySouth || gravity.Type == options.GravitySouthEast || gravity.Type == options.GravitySouthWest {
+			top = height - innerHeight - offY
+		}
+
+		if gravity.Type == options.GravityWest || gravity.Type == options.GravityNorthWest || gravity.Type == options.GravitySouthWest {
+			left = 0 + offX
+		}
+	}
+
+	var minX, maxX, minY, maxY int
+
+	if allowOverflow {
+		minX, maxX = -innerWidth+1, width-1
+		minY, maxY = -innerHeight+1, height-1
+	} else {
+		minX, maxX = 0, width-innerWidth
+		minY, maxY = 0, height-innerHeight
+	}
+
+	left = maxInt(minX, minInt(left, maxX))
+	top = maxInt(minY, minInt(top, maxY))
+
+	return
+}
+
+func cropImage(img *vips.Image, cropWidth, cropHeight int, gravity *options.GravityOptions) error {
+	if cropWidth == 0 && cropHeight == 0 {
+		return nil
+	}
+
+	imgWidth, imgHeight := img.Width(), img.Height()
+
+	cropWidth = minNonZeroInt(cropWidth, imgWidth)
+	cropHeight = minNonZeroInt(cropHeight, imgHeight)
+
+	if cropWidth >= imgWidth && cropHeight >= imgHeight {
+		return nil
+	}
+
+	if gravity.Type == options.GravitySmart {
+		if err := img.CopyMemory(); err != nil {
+			return err
+		}
+		if err := img.SmartCrop(cropWidth, cropHeight); err != nil {
+			return err
+		}
+		// Applying additional modifications after smart crop causes SIGSEGV on Alpine
+		// so we have to copy memory after it
+		return img.CopyMemory()
+	}
+
+	left, top := calcPosition(imgWidth, imgHeight, cropWidth, cropHeight, gravity, false)
+	return img.Crop(left, top, cropWidth, cropHeight)
+}
+
+func prepareWatermark(wm *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, imgWidth, imgHeight int) error {
+	if err := wm.Load(wmData.Data, wmData.Type, 1, 1.0, 1); err != nil {
+		return err
+	}
+
+	po := options.NewProcessingOptions()
+	po.ResizingType = options.ResizeFit
+	po.Dpr = 1
+	po.Enlarge = true
+	po.Format = wmData.Type
+
+	if opts.Scale > 0 {
+		po.Width = maxInt(scaleInt(imgWidth, opts.Scale), 1)
+		po.Height = maxInt(scaleInt(imgHeight, opts.Scale), 1)
+	}
+
+	if err := transformImage(context.Background(), wm, wmData.Data, po, wmData.Type); err != nil {
+		return err
+	}
+
+	if err := wm.EnsureAlpha(); err != nil {
+		return nil
+	}
+
+	if opts.Replicate {
+		return wm.Replicate(imgWidth, imgHeight)
+	}
+
+	left, top := calcPosition(imgWidth, imgHeight, wm.Width(), wm.Height(), &opts.Gravity, true)
+
+	return wm.Embed(imgWidth, imgHeight, left, top, vips.Color{R: 0, G: 0, B: 0}, true)
+}
+
+func applyWatermark(img *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, framesCount int) error {
+	return applyImageOverlay(img, wmData, opts, opts.Opacity*config.Conf.WatermarkOpacity, framesCount)
+}
+
+func applyImageOverlay(img *vips.Image, wmData *imagedata.ImageData, opts *options.WatermarkOptions, opacity float64, framesCount int) error {
+	if err := img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	wm := new(vips.Image)
+	defer wm.Clear()
+
+	width := img.Width()
+	height := img.Height()
+
+	if err := prepareWatermark(wm, wmData, opts, width, height/framesCount); err != nil {
+		return err
+	}
+
+	if framesCount > 1 {
+		if err := wm.Replicate(width, height); err != nil {
+			return err
+		}
+	}
+
+	return img.ApplyWatermark(wm, opacity)
+}
+
+func applyCaption(img *vips.Image, opts *options.CaptionOptions, dpr float64, framesCount int) error {
+	if err := img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	width := img.Width()
+	height := img.Height() / framesCount
+
+	// Font size can't exceed the image height since the text wouldn't fit anyway
+	size := maxInt(minInt(scaleInt(opts.Size, dpr), height), 1)
+	padding := scaleInt(opts.Padding, dpr)
+
+	shadow := 0
+	if opts.Shadow {
+		shadow = maxInt(size/16, 1)
+	}
+
+	align := vips.CaptionAlignLow
+	switch opts.Gravity.Type {
+	case options.GravityCenter, options.GravityNorth, options.GravitySouth:
+		align = vips.CaptionAlignCentre
+	case options.GravityEast, options.GravityNorthEast, options.GravitySouthEast:
+		align = vips.CaptionAlignHigh
+	}
+
+	caption := new(vips.Image)
+	defer caption.Clear()
+
+	font := fmt.Sprintf("%s %d", opts.Font, size)
+	textWidth := maxInt(width-padding*2-shadow, 1)
+
+	if err := caption.Caption(opts.Text, font, textWidth, align, padding, opts.Color, shadow); err != nil {
+		return err
+	}
+
+	left, top := calcPosition(width, height, caption.Width(), caption.Height(), &opts.Gravity, true)
+
+	if err := caption.Embed(width, height, left, top, vips.Color{R: 0, G: 0, B: 0}, true); err != nil {
+		return err
+	}
+
+	if framesCount > 1 {
+		if err := caption.Replicate(width, img.Height()); err != nil {
+			return err
+		}
+	}
+
+	return img.ApplyWatermark(caption, 1)
+}
+
+func copyMemoryAndCheckTimeout(ctx context.Context, img *vips.Image) error {
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+	return CheckTimeout(ctx)
+}
+
+func transformImage(ctx context.Context, img *vips.Image, data []byte, po *options.ProcessingOptions, imgtype imagetype.Type) error {
+	var (
+		err     error
+		trimmed bool
+	)
+
+	if po.Trim.Enabled {
+		if err = img.Trim(po.Trim.Threshold, po.Trim.Smart, po.Trim.Color, po.Trim.EqualHor, po.Trim.EqualVer); err != nil {
+			return err
+		}
+		if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+			return err
+		}
+		trimmed = true
+	}
+
+	srcWidth, srcHeight, angle, flip := extractMeta(img)
+	cropWidth, cropHeight := po.Crop.Width, po.Crop.Height
+
+	cropGravity := po.Crop.Gravity
+	if cropGravity.Type == options.GravityUnknown {
+		cropGravity = po.Gravity
+	}
+
+	widthToScale := minNonZeroInt(cropWidth, srcWidth)
+	heightToScale := minNonZeroInt(cropHeight, srcHeight)
+
+	scale := calcScale(widthToScale, heightToScale, po, imgtype)
+
+	cropWidth = scaleInt(cropWidth, scale)
+	cropHeight = scaleInt(cropHeight, scale)
+	if cropGravity.Type != options.GravityFocusPoint {
+		cropGravity.X *= scale
+		cropGravity.Y *= scale
+	}
+
+	if !trimmed && scale != 1 && data != nil && canScaleOnLoad(imgtype, scale) {
+		jpegShrink := calcJpegShink(scale, imgtype)
+
+		switch {
+		case imgtype == imagetype.HEIC || imgtype == imagetype.AVIF:
+			// libheif can't decode HEIF at a lower resolution,
+			// but we can use the embedded thumbnail if it's big enough
+			if err = img.LoadHeifThumbnail(data, scaleInt(img.Width(), scale), scaleInt(img.Height(), scale)); err != nil {
+				return err
+			}
+		case imgtype != imagetype.JPEG || jpegShrink != 1:
+			// Do some scale-on-load
+			if err = img.Load(data, imgtype, jpegShrink, scale, 1); err != nil {
+				return err
+			}
+		}
+
+		// Update scale after scale-on-load
+		newWidth, newHeight, _, _ := extractMeta(img)
+		if srcWidth > srcHeight {
+			scale = float64(srcWidth) * scale / float64(newWidth)
+		} else {
+			scale = float64(srcHeight) * scale / float64(newHeight)
+		}
+		if srcWidth == scaleInt(srcWidth, scale) && srcHeight == scaleInt(srcHeight, scale) {
+			scale = 1.0
+		}
+	}
+
+	if err = img.Rad2Float(); err != nil {
+		return err
+	}
+
+	hasProfile := img.HasEmbeddedProfile()
+
+	// 16-bit depth is kept only when the output format supports it
+	keepBitDepth := po.KeepBitDepth && img.Is16Bit() && (po.Format == imagetype.PNG || po.Format == imagetype.TIFF)
+
+	rgbColourspace := img.SRGBColourspace
+	iccDepth := 8
+	if keepBitDepth {
+		rgbColourspace = img.Rgb16Colourspace
+		iccDepth = 16
+	}
+
+	// The source profile can be kept only when the image stays in its colorspace.
+	// Otherwise the sRGB profile is embedded after the conversion.
+	// The target color profile takes precedence
+	keepProfile := po.KeepProfile && len(po.ColorProfile) == 0 && hasProfile && img.IsSRGB()
+
+	iccImported := false
+	convertToLinear := !keepProfile && config.Conf.UseLinearColorspace && scale != 1
+
+	if convertToLinear || !img.IsSRGB() {
+		if err = img.ImportColourProfile(true); err != nil {
+			return err
+		}
+		iccImported = true
+	}
+
+	if convertToLinear {
+		if err = img.LinearColourspace(); err != nil {
+			return err
+		}
+	} else {
+		if err = rgbColourspace(); err != nil {
+			return err
+		}
+	}
+
+	hasAlpha := img.HasAlpha()
+
+	if scale != 1 {
+		if err = img.Resize(scale, hasAlpha); err != nil {
+			return err
+		}
+	}
+
+	if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+		return err
+	}
+
+	if angle != vips.AngleD0 {
+		if err = img.Rotate(angle); err != nil {
+			return err
+		}
+	}
+
+	if flip {
+		if err = img.Flip(); err != nil {
+			return err
+		}
+	}
+
+	dprWidth := scaleInt(po.Width, po.Dpr)
+	dprHeight := scaleInt(po.Height, po.Dpr)
+
+	if err = cropImage(img, cropWidth, cropHeight, &cropGravity); err != nil {
+		return err
+	}
+	if po.ResizingType == options.ResizeLiquid {
+		if err = liquidResize(ctx, img, dprWidth, dprHeight, &po.Gravity); err != nil {
+			return err
+		}
+		if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+			return err
+		}
+	}
+	if err = cropImage(img, dprWidth, dprHeight, &po.Gravity); err != nil {
+		return err
+	}
+
+	if po.Format == imagetype.WEBP {
+		webpLimitShrink := float64(maxInt(img.Width(), img.Height())) / webpMaxDimension
+
+		if webpLimitShrink > 1.0 {
+			if err = img.Resize(1.0/webpLimitShrink, hasAlpha); err != nil {
+				return err
+			}
+			logrus.Warningf("WebP dimension size is limited to %d. The image is rescaled to %dx%d", int(webpMaxDimension), img.Width(), img.Height())
+		}
+
+		if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+			return err
+		}
+	}
+
+	if !iccImported && !keepProfile {
+		if err = img.ImportColourProfile(false); err != nil {
+			return err
+		}
+	}
+
+	if len(po.ColorProfile) > 0 {
+		if err = img.ExportColourProfile(options.ColorProfilePath(po.ColorProfile), iccDepth); err != nil {
+			return err
+		}
+	} else if err = rgbColourspace(); err != nil {
+		return err
+	}
+
+	if po.KeepProfile && len(po.ColorProfile) == 0 && hasProfile && !img.HasEmbeddedProfile() {
+		if err = img.EmbedSRGBProfile(iccDepth); err != nil {
+			return err
+		}
+	}
+
+	transparentBg := po.Format.SupportsAlpha() && !po.Flatten
+
+	if hasAlpha && !transparentBg {
+		if err = img.Flatten(po.Background); err != nil {
+			return err
+		}
+	}
+
+	if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+		return err
+	}
+
+	if po.Enhance {
+		if err = img.Enhance(); err != nil {
+			return err
+		}
+	}
+
+	if po.Equalize {
+		if err = img.Equalize(); err != nil {
+			return err
+		}
+	}
+
+	if po.Posterize > 0 {
+		if err = img.Posterize(po.Posterize); err != nil {
+			return err
+		}
+	}
+
+	if po.Blur > 0 {
+		if err = img.Blur(po.Blur); err != nil {
+			return err
+		}
+	}
+
+	if po.Sharpen > 0 {
+		if err = img.Sharpen(po.Sharpen); err != nil {
+			return err
+		}
+	}
+
+	if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+		return err
+	}
+
+	if po.Extend.Enabled && (dprWidth > img.Width() || dprHeight > img.Height()) {
+		offX, offY := calcPosition(dprWidth, dprHeight, img.Width(), img.Height(), &po.Extend.Gravity, false)
+		if err = img.Embed(dprWidth, dprHeight, offX, offY, po.Background, transparentBg); err != nil {
+			return err
+		}
+	}
+
+	if po.Padding.Enabled {
+		paddingTop := scaleInt(po.Padding.Top, po.Dpr)
+		paddingRight := scaleInt(po.Padding.Right, po.Dpr)
+		paddingBottom := scaleInt(po.Padding.Bottom, po.Dpr)
+		paddingLeft := scaleInt(po.Padding.Left, po.Dpr)
+		if err = img.Embed(
+			img.Width()+paddingLeft+paddingRight,
+			img.Height()+paddingTop+paddingBottom,
+			paddingLeft,
+			paddingTop,
+			po.Background,
+			transparentBg,
+		); err != nil {
+			return err
+		}
+	}
+
+	if err = applyOverlays(ctx, img, po, 1); err != nil {
+		return err
+	}
+
+	if po.Caption.Enabled {
+		if err = applyCaption(img, &po.Caption, po.Dpr, 1); err != nil {
+			return err
+		}
+	}
+
+	if wmData := Watermark(); po.Watermark.Enabled && wmData != nil {
+		if err = applyWatermark(img, wmData, &po.Watermark, 1); err != nil {
+			return err
+		}
+	}
+
+	if keepBitDepth {
+		if err = img.Rgb16Colourspace(); err != nil {
+			return err
+		}
+
+		if err = img.CastUshort(); err != nil {
+			return err
+		}
+	} else {
+		if err = img.SRGBColourspace(); err != nil {
+			return err
+		}
+
+		if err = img.CastUchar(); err != nil {
+			return err
+		}
+	}
+
+	return copyMemoryAndCheckTimeout(ctx, img)
+}
+
+func transformAnimated(ctx context.Context, img *vips.Image, data []byte, po *options.ProcessingOptions, imgtype imagetype.Type) error {
+	if po.Trim.Enabled {
+		logrus.Warningf("Trim is not supported for animated images")
+		po.Trim.Enabled = false
+	}
+
+	imgWidth := img.Width()
+
+	frameHeight, err := img.GetInt("page-height")
+	if err != nil {
+		return err
+	}
+
+	if config.Conf.MaxAnimationFramesMode == "reject" && img.Height()/frameHeight > config.Conf.MaxAnimationFrames {
+		return errSourceTooManyFrames
+	}
+
+	framesCount := minInt(img.Height()/frameHeight, config.Conf.MaxAnimationFrames)
+
+	// Double check dimensions because animated image has many frames
+	if err = CheckDimensions(imgWidth, frameHeight*framesCount); err != nil {
+		return err
+	}
+
+	// Vips 8.8+ supports n-pages and doesn't load the whole animated image on header access
+	if nPages, _ := img.GetInt("n-pages"); nPages > framesCount {
+		// Load only the needed frames
+		if err = img.Load(data, imgtype, 1, 1.0, framesCount); err != nil {
+			return err
+		}
+	}
+
+	delay, err := img.GetInt("gif-delay")
+	if err != nil {
+		return err
+	}
+
+	loop, err := img.GetInt("gif-loop")
+	if err != nil {
+		return err
+	}
+
+	frameIndexes := make([]int, framesCount)
+	for i := range frameIndexes {
+		frameIndexes[i] = i
+	}
+
+	var frameDelays []int
+
+	if po.MaxFPS > 0 {
+		delays, err := img.FrameDelays()
+		if err != nil || len(delays) < framesCount {
+			// Old libvips provides only the delay of the first frame in centiseconds
+			delays = make([]int, framesCount)
+			for i := range delays {
+				delays[i] = delay * 10
+			}
+		}
+
+		if indexes, newDelays := reduceFrameRate(delays[:framesCount], po.MaxFPS); len(indexes) < framesCount {
+			frameIndexes, frameDelays = indexes, newDelays
+			framesCount = len(indexes)
+		}
+	}
+
+	watermarkEnabled := po.Watermark.Enabled
+	po.Watermark.Enabled = false
+	defer func() { po.Watermark.Enabled = watermarkEnabled }()
+
+	captionEnabled := po.Caption.Enabled
+	po.Caption.Enabled = false
+	defer func() { po.Caption.Enabled = captionEnabled }()
+
+	// Overlays are applied to the whole animation after the frames are joined
+	frameCtx := withoutOverlays(ctx)
+
+	frames := make([]*vips.Image, framesCount)
+	defer func() {
+		for _, frame := range frames {
+			if frame != nil {
+				frame.Clear()
+			}
+		}
+	}()
+
+	for i, ind := range frameIndexes {
+		frame := new(vips.Image)
+
+		if err = img.Extract(frame, 0, ind*frameHeight, imgWidth, frameHeight); err != nil {
+			return err
+		}
+
+		frames[i] = frame
+
+		if err = transformImage(frameCtx, frame, nil, po, imgtype); err != nil {
+			return err
+		}
+
+		if err = copyMemoryAndCheckTimeout(ctx, frame); err != nil {
+			return err
+		}
+	}
+
+	if err = CheckResultDimensions(frames[0].Width(), frames[0].Height(), framesCount); err != nil {
+		return err
+	}
+
+	if err = img.Arrayjoin(frames); err != nil {
+		return err
+	}
+
+	if err = applyOverlays(ctx, img, po, framesCount); err != nil {
+		return err
+	}
+
+	if captionEnabled {
+		if err = applyCaption(img, &po.Caption, po.Dpr, framesCount); err != nil {
+			return err
+		}
+	}
+
+	if wmData := Watermark(); watermarkEnabled && wmData != nil {
+		if err = applyWatermark(img, wmData, &po.Watermark, framesCount); err != nil {
+			return err
+		}
+	}
+
+	if err = img.CastUchar(); err != nil {
+		return err
+	}
+
+	if err = copyMemoryAndCheckTimeout(ctx, img); err != nil {
+		return err
+	}
+
+	img.SetInt("page-height", frames[0].Height())
+	img.SetInt("gif-delay", delay)
+	img.SetInt("gif-loop", loop)
+	img.SetInt("n-pages", framesCount)
+
+	if frameDelays != nil {
+		img.SetFrameDelays(frameDelays)
+
+		// Old libvips supports only the same delay for all frames,
+		// so we set the average one
+		duration := 0
+		for _, d := range frameDelays {
+			duration += d
+		}
+		img.SetInt("gif-delay", maxInt(1, int(math.Round(float64(duration)/float64(framesCount)/10))))
+	}
+
+	return nil
+}
+
+// reduceFrameRate selects the animation frames to keep so the frame rate
+// doesn't exceed fps. Delays of the dropped frames are added to the kept ones,
+// so the animation duration stays the same. Returns indexes and delays
+// of the kept frames
+func reduceFrameRate(delays []int, fps float64) ([]int, []int) {
+	interval := 1000 / fps
+
+	var (
+		indexes   []int
+		newDelays []int
+	)
+
+	// start is the start time of the current frame, next is the time
+	// when the next frame can be shown
+	var start, next float64
+
+	// Zero delays mean the frame rate is up to the viewer,
+	// so we can't reduce it
+	duration := 0
+	for _, d := range delays {
+		duration += d
+	}
+	if duration == 0 {
+		indexes = make([]int, len(delays))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, delays
+	}
+
+	for i, d := range delays {
+		if i == 0 || start >= next {
+			indexes = append(indexes, i)
+			newDelays = append(newDelays, d)
+
+			for next <= start {
+				next += interval
+			}
+		} else {
+			newDelays[len(newDelays)-1] += d
+		}
+
+		start += float64(d)
+	}
+
+	return indexes, newDelays
+}
+
+// loadFrame replaces the image with the specified frame of the animated image.
+// Only the frames up to the specified one are loaded
+func loadFrame(img *vips.Image, data []byte, imgtype imagetype.Type, frame int) error {
+	if !vips.SupportsAnimation(imgtype) {
+		return errFrameOutOfRange
+	}
+
+	// Vips 8.8+ provides the number of frames on header access
+	if nPages, err := img.GetInt("n-pages"); err == nil && frame >= nPages {
+		return errFrameOutOfRange
+	}
+
+	if err := CheckDimensions(img.Width(), img.Height()*(frame+1)); err != nil {
+		return err
+	}
+
+	if err := img.Load(data, imgtype, 1, 1.0, frame+1); err != nil {
+		return err
+	}
+
+	frameHeight := img.Height() / (frame + 1)
+
+	return img.Crop(0, frame*frameHeight, img.Width(), frameHeight)
+}
+
+// GetIcoData extracts the largest image from the ICO data
+func GetIcoData(imgdata *imagedata.ImageData) (*imagedata.ImageData, error) {
+	icoMeta, err := imagemeta.DecodeIcoMeta(bytes.NewReader(imgdata.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	offset := icoMeta.BestImageOffset()
+	size := icoMeta.BestImageSize()
+
+	data := imgdata.Data[offset : offset+size]
+
+	var format string
+
+	meta, err := imagemeta.DecodeMeta(bytes.NewReader(data))
+	if err != nil {
+		// Looks like it's BMP with an incomplete header
+		if d, err := imagemeta.FixBmpHeader(data); err == nil {
+			format = "bmp"
+			data = d
+		} else {
+			return nil, err
+		}
+	} else {
+		format = meta.Format()
+	}
+
+	if imgtype, ok := imagetype.Types[format]; ok && vips.SupportsLoad(imgtype) {
+		return &imagedata.ImageData{
+			Data: data,
+			Type: imgtype,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Can't load %s from ICO", meta.Format())
+}
+
+// func saveImageToFitBytes(po *options.ProcessingOptions, img *vips.Image) ([]byte, context.CancelFunc, error) {
+// 	var diff float64
+// 	quality := po.Quality
+
+// 	img.CopyMemory()
+
+// 	for {
+// 		result, cancel, err := img.Save(po.Format, quality, po.StripMetadata)
+// 		if len(result) <= po.MaxBytes || quality <= 10 || err != nil {
+// 			return result, cancel, err
+// 		}
+// 		cancel()
+
+// 		delta := float64(len(result)) / float64(po.MaxBytes)
+// 		switch {
+// 		case delta > 3:
+// 			diff = 0.25
+// 		case delta > 1.5:
+// 			diff = 0.5
+// 		default:
+// 			diff = 0.75
+// 		}
+// 		quality = int(float64(quality) * diff)
+// 	}
+// }
+
+// prepareLqip turns the processing options into the LQIP ones. The requested size
+// is scaled down to fit the LQIP max size, and the options that require extra work
+// or don't make sense for a tiny blurry image are disabled
+func prepareLqip(po *options.ProcessingOptions) {
+	maxSize := config.Conf.LqipMaxSize
+
+	if po.Width > 0 || po.Height > 0 {
+		width, height := scaleInt(po.Width, po.Dpr), scaleInt(po.Height, po.Dpr)
+
+		if m := maxInt(width, height); m > maxSize {
+			scale := float64(maxSize) / float64(m)
+
+			if width > 0 {
+				width = maxInt(scaleInt(width, scale), 1)
+			}
+			if height > 0 {
+				height = maxInt(scaleInt(height, scale), 1)
+			}
+		}
+
+		po.Width, po.Height = width, height
+	} else {
+		po.ResizingType = options.ResizeFit
+		po.Width, po.Height = maxSize, maxSize
+	}
+
+	po.Dpr = 1
+	po.Enlarge = false
+	po.Extend.Enabled = false
+	po.Padding.Enabled = false
+	po.Sharpen = 0
+	po.Blur = float32(config.Conf.LqipBlur)
+	po.Quality = config.Conf.LqipQuality
+	po.StripMetadata = true
+	po.KeepProfile = false
+	po.Watermark.Enabled = false
+	po.Caption.Enabled = false
+	po.Overlays = nil
+
+	// LQIP is always a still image
+	if po.Frame < 0 {
+		po.Frame = 0
+	}
+}
+
+// saveLqip saves the image reducing the quality until the result fits
+// the LQIP max bytes. LQIP images are tiny, so encoding them several times is cheap
+func saveLqip(w io.Writer, img *vips.Image, po *options.ProcessingOptions, stripMeta, keepProfile bool) (context.CancelFunc, error) {
+	var buf bytes.Buffer
+
+	quality := po.Quality
+
+	for {
+		buf.Reset()
+
+		cancel, err := img.Save(&buf, po.Format, quality, stripMeta, keepProfile, false)
+		cancel()
+		if err != nil {
+			return func() {}, err
+		}
+
+		if buf.Len() <= config.Conf.LqipMaxBytes || quality <= 5 {
+			break
+		}
+
+		quality = maxInt(quality*3/4, 5)
+	}
+
+	_, err := w.Write(buf.Bytes())
+
+	return func() {}, err
+}
+
+// ProcessImage processes the source image according to the processing options
+// and writes the result to w. The returned function releases the resources
+// held by the written data and should be called after w is used
+func ProcessImage(ctx context.Context, w io.Writer, po *options.ProcessingOptions, imgdata *imagedata.ImageData) (context.CancelFunc, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	ctx, endProcessing := StartProcessing(ctx)
+	defer endProcessing()
+
+	defer vips.Cleanup()
+
+	if po.Lqip {
+		prepareLqip(po)
+	}
+
+	if po.Format == imagetype.SVG {
+		if imgdata.Type != imagetype.SVG {
+			return func() {}, errConvertingNonSvgToSvg
+		}
+
+		return func() {}, nil
+	}
+
+	if imgdata.Type == imagetype.SVG && !vips.SupportsLoad(imagetype.SVG) {
+		return func() {}, ErrSourceImageTypeNotSupported
+	}
+
+	if imgdata.Type == imagetype.ICO {
+		icodata, err := GetIcoData(imgdata)
+		if err != nil {
+			return func() {}, err
+		}
+
+		imgdata = icodata
+	}
+
+	if !vips.SupportsSmartcrop() {
+		if po.Gravity.Type == options.GravitySmart {
+			logrus.Warningf(msgSmartCropNotSupported)
+			po.Gravity.Type = options.GravityCenter
+		}
+		if po.Crop.Gravity.Type == options.GravitySmart {
+			logrus.Warningf(msgSmartCropNotSupported)
+			po.Crop.Gravity.Type = options.GravityCenter
+		}
+	}
+
+	if po.ResizingType == options.ResizeCrop {
+		logrus.Warningf("`crop` resizing type is deprecated and will be removed in future versions. Use `crop` processing option instead")
+
+		po.Crop.Width, po.Crop.Height = po.Width, po.Height
+
+		po.ResizingType = options.ResizeFit
+		po.Width, po.Height = 0, 0
+	}
+
+	// When the frame is specified, the result is a still image
+	animationSupport := po.Frame < 0 && config.Conf.MaxAnimationFrames > 1 && vips.SupportsAnimation(imgdata.Type) && vips.SupportsAnimation(po.Format)
+
+	pages := 1
+	if animationSupport {
+		pages = -1
+	}
+
+	ctx, overlaysCancel, err := downloadOverlays(ctx, po)
+	defer overlaysCancel()
+	if err != nil {
+		return func() {}, err
+	}
+
+	img := new(vips.Image)
+	defer img.Clear()
+
+	stopTiming := StartTiming(ctx, "decode")
+	err = img.Load(imgdata.Data, imgdata.Type, 1, 1.0, pages)
+	stopTiming()
+
+	if err != nil {
+		return func() {}, err
+	}
+
+	if po.Frame > 0 {
+		if err = loadFrame(img, imgdata.Data, imgdata.Type, po.Frame); err != nil {
+			return func() {}, err
+		}
+	}
+
+	if err = checkDecodeMemory(img, animationSupport && img.IsAnimated()); err != nil {
+		return func() {}, err
+	}
+
+	stopTiming = StartTiming(ctx, "transform")
+
+	if animationSupport && img.IsAnimated() {
+		// Seams differ from frame to frame, so animations are filled instead
+		if po.ResizingType == options.ResizeLiquid {
+			po.ResizingType = options.ResizeFill
+		}
+
+		err = transformAnimated(ctx, img, imgdata.Data, po, imgdata.Type)
+	} else {
+		err = transformImage(ctx, img, imgdata.Data, po, imgdata.Type)
+	}
+
+	stopTiming()
+
+	if err != nil {
+		return func() {}, err
+	}
+
+	if !img.IsAnimated() || !animationSupport {
+		if err := CheckResultDimensions(img.Width(), img.Height(), 1); err != nil {
+			return func() {}, err
+		}
+	}
+
+	if err := copyMemoryAndCheckTimeout(ctx, img); err != nil {
+		return func() {}, err
+	}
+
+	if err := setAverageColorHeader(ctx, img); err != nil {
+		return func() {}, err
+	}
+
+	if po.MaxBytes > 0 && canFitToBytes(po.Format) {
+		// return saveImageToFitBytes(po, img)
+	}
+
+	defer StartSaving(ctx, po.Format)()
+
+	defer StartTiming(ctx, "encode")()
+
+	stripMeta := po.StripMetadata
+	keepProfile := po.KeepProfile || len(po.ColorProfile) > 0
+
+	if keepProfile && stripMeta {
+		if err := img.StripMetaKeepProfile(); err != nil {
+			return func() {}, err
+		}
+		stripMeta = false
+	}
+
+	if po.Lqip && config.Conf.LqipMaxBytes > 0 && canFitToBytes(po.Format) {
+		return saveLqip(w, img, po, stripMeta, keepProfile)
+	}
+
+	return img.Save(w, po.Format, po.Quality, stripMeta, keepProfile, po.Lossless)
+}
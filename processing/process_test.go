@@ -0,0 +1,65 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ProcessTestSuite struct{ suite.Suite }
+
+func (s *ProcessTestSuite) TestReduceFrameRate() {
+	indexes, delays := reduceFrameRate([]int{10, 10, 10, 10, 10, 10, 10, 10, 10, 10}, 40)
+
+	assert.Equal(s.T(), []int{0, 3, 5, 8}, indexes)
+	assert.Equal(s.T(), []int{30, 20, 30, 20}, delays)
+}
+
+func (s *ProcessTestSuite) TestReduceFrameRateLowFPSSource() {
+	indexes, delays := reduceFrameRate([]int{100, 200, 100}, 25)
+
+	assert.Equal(s.T(), []int{0, 1, 2}, indexes)
+	assert.Equal(s.T(), []int{100, 200, 100}, delays)
+}
+
+func (s *ProcessTestSuite) TestReduceFrameRateZeroDelays() {
+	indexes, delays := reduceFrameRate([]int{0, 0, 0}, 10)
+
+	assert.Equal(s.T(), []int{0, 1, 2}, indexes)
+	assert.Equal(s.T(), []int{0, 0, 0}, delays)
+}
+
+func (s *ProcessTestSuite) TestPrepareLqip() {
+	po := options.NewProcessingOptions()
+	po.ResizingType = options.ResizeFill
+	po.Width, po.Height = 600, 300
+	po.Dpr = 2
+	po.Watermark.Enabled = true
+
+	prepareLqip(po)
+
+	assert.Equal(s.T(), options.ResizeFill, po.ResizingType)
+	assert.Equal(s.T(), 32, po.Width)
+	assert.Equal(s.T(), 16, po.Height)
+	assert.Equal(s.T(), 1.0, po.Dpr)
+	assert.Equal(s.T(), config.Conf.LqipQuality, po.Quality)
+	assert.Equal(s.T(), 0, po.Frame)
+	assert.False(s.T(), po.Watermark.Enabled)
+}
+
+func (s *ProcessTestSuite) TestPrepareLqipWithoutSize() {
+	po := options.NewProcessingOptions()
+
+	prepareLqip(po)
+
+	assert.Equal(s.T(), options.ResizeFit, po.ResizingType)
+	assert.Equal(s.T(), 32, po.Width)
+	assert.Equal(s.T(), 32, po.Height)
+}
+
+func TestProcess(t *testing.T) {
+	suite.Run(t, new(ProcessTestSuite))
+}
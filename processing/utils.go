@@ -0,0 +1,43 @@
+package processing
+
+import "math"
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func absInt32(a int32) int32 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func minNonZeroInt(a, b int) int {
+	switch {
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	}
+
+	return minInt(a, b)
+}
+
+func scaleInt(a int, scale float64) int {
+	if a == 0 {
+		return 0
+	}
+
+	return int(math.Round(float64(a) * scale))
+}
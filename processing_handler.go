@@ -1,28 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
 )
 
 var (
 	responseGzipBufPool *bufPool
 	responseGzipPool    *gzipPool
 
+	responseBufPool *bufPool
+
 	processingSem chan struct{}
+	// lowPrioritySem limits the number of processing slots
+	// that can be occupied by low priority requests
+	lowPrioritySem chan struct{}
+
+	// processingQueueLen is the number of requests waiting for processingSem
+	processingQueueLen int64
 
 	headerVaryValue string
-	fallbackImage   *imageData
 )
 
+func imageTypeGoodForWeb(imgtype imagetype.Type) bool {
+	return imgtype != imagetype.TIFF &&
+		imgtype != imagetype.BMP
+}
+
 func initProcessingHandler() error {
 	var err error
 
 	processingSem = make(chan struct{}, conf.Concurrency)
+	lowPrioritySem = make(chan struct{}, conf.LowPriorityConcurrency)
 
 	if conf.GZipCompression > 0 {
 		responseGzipBufPool = newBufPool("gzip", conf.Concurrency, conf.GZipBufferSize)
@@ -31,6 +54,15 @@ func initProcessingHandler() error {
 		}
 	}
 
+	// Placeholders, collages, and uploads are processed to the response buffer
+	// before they are sent to the client. The info endpoint processes images
+	// to the response buffer to probe the result
+	if conf.BufferResponse || conf.BatchMaxSize > 0 || resultCache != nil ||
+		conf.PlaceholdersEnabled || conf.CollageMaxSize > 0 || conf.UploadEnabled ||
+		conf.InfoEnabled {
+		responseBufPool = newBufPool("response", conf.Concurrency, conf.ResponseBufferSize)
+	}
+
 	vary := make([]string, 0)
 
 	if conf.EnableWebpDetection || conf.EnforceWebp {
@@ -47,14 +79,323 @@ func initProcessingHandler() error {
 
 	headerVaryValue = strings.Join(vary, ", ")
 
-	if fallbackImage, err = getFallbackImageData(); err != nil {
+	if err = fallbackImage.Init(); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func prerespondWithImage(ctx context.Context, reqID string, imageURL, cacheControl, expires string, po *processingOptions, r *http.Request, rw http.ResponseWriter) (w io.Writer, flush context.CancelFunc) {
+func fallbackReason(err error) string {
+	switch err {
+	case processing.ErrSourceDimensionsTooBig, processing.ErrSourceResolutionTooBig, errSourceFileTooBig:
+		return "too_big"
+	case processing.ErrSourceImageTypeNotSupported, errSourceContentTypeNotAllowed:
+		return "unsupported"
+	}
+
+	if ierr, ok := err.(*ierrors.Error); ok && ierr.StatusCode == 404 {
+		return "unreachable"
+	}
+
+	return "error"
+}
+
+// shouldUseFallbackImage checks if the class of the download error
+// is in IMGPROXY_FALLBACK_IMAGE_ERRORS. Empty list means all errors
+func shouldUseFallbackImage(err error) bool {
+	if len(conf.FallbackImageErrors) == 0 {
+		return true
+	}
+
+	class := downloadErrorClass(err)
+
+	for _, c := range conf.FallbackImageErrors {
+		if c == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bufferedResponseWriter collects the response body so it can be sent
+// at once with the Content-Length header
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+
+	buf        *bytes.Buffer
+	statusCode int
+}
+
+func newBufferedResponseWriter(rw http.ResponseWriter) *bufferedResponseWriter {
+	return &bufferedResponseWriter{
+		ResponseWriter: rw,
+		buf:            responseBufPool.Get(0),
+		statusCode:     200,
+	}
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+// Send writes the status code and the buffered body to the underlying writer
+func (w *bufferedResponseWriter) Send() {
+	if w.statusCode != 304 {
+		w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+func (w *bufferedResponseWriter) Release() {
+	responseBufPool.Put(w.buf)
+	w.buf = nil
+}
+
+// statusResponseWriter sends the status code with the first written bytes,
+// so the panic handler can still respond with an error if processing fails
+type statusResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(w.statusCode)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func newQueueRejectedError(msg string) *ierrors.Error {
+	atomic.AddInt64(&statsQueueRejected, 1)
+
+	return ierrors.New(429, msg, "Too many requests").SetRetryAfter(conf.QueueRetryAfter)
+}
+
+// acquireProcessingSem waits for a free processing slot.
+// When the queue is full or the request waits for too long, it returns 429 error
+func acquireProcessingSem(ctx context.Context, priority options.PriorityType) error {
+	defer startTiming(ctx, "queue")()
+
+	start := time.Now()
+
+	acquired := func() error {
+		trackQueueWait(time.Since(start))
+
+		if prometheusEnabled {
+			prometheusQueueDuration.Observe(time.Since(start).Seconds())
+		}
+
+		return nil
+	}
+
+	queueLen := atomic.AddInt64(&processingQueueLen, 1)
+	defer atomic.AddInt64(&processingQueueLen, -1)
+
+	if conf.MaxQueueSize > 0 && queueLen > int64(conf.MaxQueueSize) {
+		// The queue is full, but a processing slot may have just been released
+		if !tryAcquireProcessingSem(priority) {
+			return newQueueRejectedError("Processing queue is full")
+		}
+
+		return acquired()
+	}
+
+	var deadline <-chan time.Time
+
+	if conf.MaxQueueWait > 0 {
+		timer := time.NewTimer(time.Duration(conf.MaxQueueWait) * time.Second)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	// Low priority requests take a low priority slot first,
+	// so they can't occupy all the processing slots
+	if priority == options.PriorityLow {
+		if err := waitSem(ctx, lowPrioritySem, deadline); err != nil {
+			return err
+		}
+	}
+
+	if err := waitSem(ctx, processingSem, deadline); err != nil {
+		if priority == options.PriorityLow {
+			<-lowPrioritySem
+		}
+		return err
+	}
+
+	return acquired()
+}
+
+func tryAcquireProcessingSem(priority options.PriorityType) bool {
+	if priority == options.PriorityLow {
+		select {
+		case lowPrioritySem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	select {
+	case processingSem <- struct{}{}:
+		return true
+	default:
+		if priority == options.PriorityLow {
+			<-lowPrioritySem
+		}
+		return false
+	}
+}
+
+func waitSem(ctx context.Context, sem chan struct{}, deadline <-chan time.Time) error {
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ierrors.New(499, "Request was cancelled before processing", "Cancelled")
+	case <-deadline:
+		return newQueueRejectedError("Processing queue wait timeout exceeded")
+	}
+}
+
+func releaseProcessingSem(priority options.PriorityType) {
+	<-processingSem
+
+	if priority == options.PriorityLow {
+		<-lowPrioritySem
+	}
+}
+
+// shouldSkipProcessing checks if the source image should be sent as is
+func shouldSkipProcessing(po *options.ProcessingOptions, imgdata *imagedata.ImageData) bool {
+	if imgdata.Type != po.Format && po.Format != imagetype.Unknown {
+		return false
+	}
+
+	for _, f := range conf.SkipProcessingFormats {
+		if f == imgdata.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+func resolveResultFormat(po *options.ProcessingOptions, imgdata *imagedata.ImageData) {
+	if conf.EnableContentClassification && (po.Format == imagetype.Unknown || contentClassQualityApplicable(po)) {
+		resolveByContent(po, imgdata)
+	}
+
+	if po.Format == imagetype.Unknown {
+		switch {
+		case po.PreferWebP && options.FormatSupported(imagetype.WEBP):
+			po.Format = imagetype.WEBP
+		case options.FormatSupported(imgdata.Type) && imageTypeGoodForWeb(imgdata.Type):
+			po.Format = imgdata.Type
+		default:
+			po.Format = imagetype.JPEG
+		}
+	} else if po.EnforceWebP && options.FormatSupported(imagetype.WEBP) {
+		po.Format = imagetype.WEBP
+	}
+}
+
+// contentClassQualityApplicable checks if the quality of the content class
+// can be used for the request
+func contentClassQualityApplicable(po *options.ProcessingOptions) bool {
+	return !po.QualitySet && (conf.PhotoQuality > 0 || conf.GraphicQuality > 0)
+}
+
+// resolveByContent chooses the result format and quality by the source image
+// content. Lossless formats are used for graphics and lossy ones for photos
+// unless other formats are set in the config.
+// The format is left unknown if the content can't be classified
+func resolveByContent(po *options.ProcessingOptions, imgdata *imagedata.ImageData) {
+	class, hasAlpha, err := classifyImageData(imgdata)
+	if err != nil {
+		// The image will fail to load during processing anyway
+		return
+	}
+
+	po.ContentClass = class
+
+	var (
+		quality int
+		format  imagetype.Type
+	)
+
+	switch class {
+	case options.ContentClassGraphic:
+		quality, format = conf.GraphicQuality, conf.GraphicFormat
+	case options.ContentClassPhoto:
+		quality, format = conf.PhotoQuality, conf.PhotoFormat
+	default:
+		return
+	}
+
+	if quality > 0 && !po.QualitySet {
+		po.Quality = quality
+	}
+
+	if po.Format != imagetype.Unknown {
+		return
+	}
+
+	if format != imagetype.Unknown && options.FormatSupported(format) {
+		po.Format = format
+		return
+	}
+
+	switch class {
+	case options.ContentClassGraphic:
+		if po.PreferWebP && options.FormatSupported(imagetype.WEBP) {
+			po.Format = imagetype.WEBP
+			po.Lossless = true
+		} else if options.FormatSupported(imagetype.PNG) {
+			po.Format = imagetype.PNG
+		}
+	case options.ContentClassPhoto:
+		switch {
+		case po.PreferWebP && options.FormatSupported(imagetype.WEBP):
+			po.Format = imagetype.WEBP
+		case (imgdata.Type == imagetype.JPEG || imgdata.Type == imagetype.AVIF) && options.FormatSupported(imgdata.Type):
+			po.Format = imgdata.Type
+		case !hasAlpha:
+			// Photos with alpha are left to the default logic since JPEG
+			// doesn't support transparency
+			po.Format = imagetype.JPEG
+		}
+	}
+}
+
+// countingWriter counts the number of bytes written to the underlying writer
+type countingWriter struct {
+	io.Writer
+	Count int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.Count += n
+	return n, err
+}
+
+func prerespondWithImage(ctx context.Context, reqID string, statusCode int, imageURL, cacheControl, expires string, po *options.ProcessingOptions, r *http.Request, rw http.ResponseWriter) (w io.Writer, flush context.CancelFunc) {
 
 	var contentDisposition string
 	if len(po.Filename) > 0 {
@@ -66,11 +407,6 @@ func prerespondWithImage(ctx context.Context, reqID string, imageURL, cacheContr
 	rw.Header().Set("Content-Type", po.Format.Mime())
 	rw.Header().Set("Content-Disposition", contentDisposition)
 
-	if !conf.CacheControlPassthrough {
-		cacheControl = ""
-		expires = ""
-	}
-
 	if len(cacheControl) == 0 && len(expires) == 0 {
 		cacheControl = fmt.Sprintf("max-age=%d, public", conf.TTL)
 		expires = time.Now().Add(time.Second * time.Duration(conf.TTL)).Format(http.TimeFormat)
@@ -84,10 +420,16 @@ func prerespondWithImage(ctx context.Context, reqID string, imageURL, cacheContr
 	}
 
 	if len(headerVaryValue) > 0 {
-		rw.Header().Set("Vary", headerVaryValue)
+		rw.Header().Add("Vary", headerVaryValue)
 	}
 
-	logResponse(reqID, r, 200, nil, &imageURL, po)
+	setSurrogateKeyHeaders(rw, imageURL)
+
+	logResponse(reqID, r, statusCode, nil, &imageURL, po)
+
+	if statusCode != 200 {
+		rw = &statusResponseWriter{ResponseWriter: rw, statusCode: statusCode}
+	}
 
 	if conf.GZipCompression > 0 && strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
 		buf := responseGzipBufPool.Get(0)
@@ -105,14 +447,56 @@ func prerespondWithImage(ctx context.Context, reqID string, imageURL, cacheContr
 	return rw, func() {}
 }
 
-func respondWithNotModified(ctx context.Context, reqID string, imageURL string, po *processingOptions, r *http.Request, rw http.ResponseWriter) {
+func respondWithNotModified(ctx context.Context, reqID string, imageURL string, po *options.ProcessingOptions, r *http.Request, rw http.ResponseWriter) {
 	rw.WriteHeader(304)
 	logResponse(reqID, r, 304, nil, &imageURL, po)
 }
 
+func respondWithCachedResult(ctx context.Context, reqID string, imageURL string, po *options.ProcessingOptions, res *cachedResult, r *http.Request, rw http.ResponseWriter) {
+	if len(res.ETag) > 0 {
+		rw.Header().Set("ETag", res.ETag)
+
+		if res.ETag == r.Header.Get("If-None-Match") {
+			respondWithNotModified(ctx, reqID, imageURL, po, r, rw)
+			return
+		}
+	}
+
+	po.Format = res.Format
+
+	if len(res.AverageColor) > 0 {
+		rw.Header().Set(processing.AverageColorHeader, res.AverageColor)
+	}
+
+	w, done := prerespondWithImage(ctx, reqID, 200, imageURL, "", "", po, r, rw)
+	defer done()
+	w.Write(res.Data)
+}
+
 func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	if conf.BufferResponse {
+		brw := newBufferedResponseWriter(rw)
+		defer func() {
+			// Nothing should be sent if processing failed,
+			// the panic handler will respond with the error
+			if rerr := recover(); rerr != nil {
+				brw.Release()
+				panic(rerr)
+			}
+
+			brw.Send()
+			brw.Release()
+		}()
+		rw = brw
+	}
+
+	if slowRequestThreshold > 0 {
+		ctx = setRequestTimings(ctx)
+		defer logSlowRequest(ctx, reqID, r)
+	}
+
 	if newRelicEnabled {
 		var newRelicCancel context.CancelFunc
 		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
@@ -124,14 +508,11 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		defer startPrometheusDuration(prometheusRequestDuration)()
 	}
 
-	select {
-	case processingSem <- struct{}{}:
-	case <-ctx.Done():
-		panic(newError(499, "Request was cancelled before processing", "Cancelled"))
-	}
-	defer func() { <-processingSem }()
+	// Parsing and queueing are limited by the write timeout since the timeout
+	// processing option is not known yet
+	reqCtx, reqStart := ctx, time.Now()
 
-	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(conf.WriteTimeout)*time.Second)
+	ctx, timeoutCancel := context.WithTimeout(reqCtx, time.Duration(conf.WriteTimeout)*time.Second)
 	defer timeoutCancel()
 
 	imgURL, po, err := parsePath(ctx, r)
@@ -139,8 +520,69 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
-	imgdata, cacheControl, expires, downloadcancel, err := downloadImage(ctx, imgURL)
+	var cacheKey string
+
+	if resultCache != nil {
+		cacheKey = resultCacheKey(imgURL, po)
+
+		if res := resultCache.Get(cacheKey); res != nil {
+			if err = useNonce(r, po.Nonce); err != nil {
+				panic(err)
+			}
+
+			respondWithCachedResult(ctx, reqID, imgURL, po, res, r, rw)
+			return
+		}
+	}
+
+	if err = acquireProcessingSem(ctx, po.Priority); err != nil {
+		panic(err)
+	}
+	defer releaseProcessingSem(po.Priority)
+
+	if err = useNonce(r, po.Nonce); err != nil {
+		panic(err)
+	}
+
+	if prometheusEnabled {
+		for _, preset := range po.UsedPresets {
+			incrementPrometheusPresetsTotal(preset)
+		}
+	}
+
+	if newRelicEnabled {
+		if u, err := url.Parse(imgURL); err == nil {
+			setNewRelicAttribute(ctx, "source_host", u.Host)
+		}
+		if len(po.UsedPresets) > 0 {
+			setNewRelicAttribute(ctx, "presets", strings.Join(po.UsedPresets, ","))
+		}
+	}
+
+	// The timeout processing option redefines the timeout of the whole request
+	ctx, poTimeoutCancel := context.WithDeadline(reqCtx, reqStart.Add(time.Duration(po.Timeout)*time.Second))
+	defer poTimeoutCancel()
+
+	if conf.ETagEnabled && conf.ETagMode == "headers" && conf.ETagRevalidate {
+		ifNoneMatch := r.Header.Get("If-None-Match")
+
+		if srcETag, srcLastModified, ok := parseETagSourceHeaders(ifNoneMatch, po); ok && revalidateSource(ctx, imgURL, srcETag, srcLastModified) {
+			rw.Header().Set("ETag", ifNoneMatch)
+			respondWithNotModified(ctx, reqID, imgURL, po, r, rw)
+			return
+		}
+	}
+
+	statusCode := 200
+
+	imgdata, cacheControl, expires, downloadcancel, err := downloadImageWithRetries(ctx, imgURL)
 	defer downloadcancel()
+
+	if !conf.CacheControlPassthrough {
+		cacheControl = ""
+		expires = ""
+	}
+
 	if err != nil {
 		if newRelicEnabled {
 			sendErrorToNewRelic(ctx, err)
@@ -149,16 +591,51 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 			incrementPrometheusErrorsTotal("download")
 		}
 
-		if fallbackImage == nil {
+		fallbackData := fallbackImage.Get()
+		if fallbackData == nil || !shouldUseFallbackImage(err) {
 			panic(err)
 		}
 
-		if ierr, ok := err.(*imgproxyError); !ok || ierr.Unexpected {
+		if ierr, ok := err.(*ierrors.Error); !ok || ierr.Unexpected {
 			reportError(err, r)
 		}
 
+		reason := fallbackReason(err)
+
+		if newRelicEnabled {
+			setNewRelicAttribute(ctx, "fallback_image", true)
+			setNewRelicAttribute(ctx, "fallback_image_reason", reason)
+		}
+		if prometheusEnabled {
+			incrementPrometheusFallbacksTotal(reason)
+		}
+
+		if conf.EnableFallbackImageHeader {
+			rw.Header().Set("X-Fallback", "1")
+		}
+
 		logWarning("Could not load image. Using fallback image: %s", err.Error())
-		imgdata = fallbackImage
+		imgdata = fallbackData
+
+		if statusCode = conf.FallbackImageHTTPCode; statusCode == 0 {
+			statusCode = 500
+			if ierr, ok := err.(*ierrors.Error); ok {
+				statusCode = ierr.StatusCode
+			}
+		}
+
+		// Fallback responses should be cached for a short time or shouldn't be cached
+		// at all, otherwise the fallback image would be served after the source recovers
+		if conf.FallbackImageTTL > 0 {
+			cacheControl = fmt.Sprintf("max-age=%d, public", conf.FallbackImageTTL)
+			expires = time.Now().Add(time.Second * time.Duration(conf.FallbackImageTTL)).Format(http.TimeFormat)
+		} else if statusCode != 200 {
+			cacheControl = "no-cache"
+			expires = ""
+		}
+
+		// Fallback image should not be cached, we want to retry the source next time
+		cacheKey = ""
 	}
 
 	checkTimeout(ctx)
@@ -175,37 +652,58 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 
 	checkTimeout(ctx)
 
-	if len(conf.SkipProcessingFormats) > 0 {
-		if imgdata.Type == po.Format || po.Format == imageTypeUnknown {
-			for _, f := range conf.SkipProcessingFormats {
-				if f == imgdata.Type {
-					po.Format = imgdata.Type
-					w, done := prerespondWithImage(ctx, reqID, imgURL, cacheControl, expires, po, r, rw)
-					defer done()
-					w.Write(imgdata.Data)
-					return
-				}
-			}
+	if shouldSkipProcessing(po, imgdata) {
+		po.Format = imgdata.Type
+
+		if prometheusEnabled {
+			incrementPrometheusFormatsTotal(po.Format)
 		}
-	}
 
-	if po.Format == imageTypeUnknown {
-		switch {
-		case po.PreferWebP && imageTypeSaveSupport(imageTypeWEBP):
-			po.Format = imageTypeWEBP
-		case imageTypeSaveSupport(imgdata.Type) && imageTypeGoodForWeb(imgdata.Type):
-			po.Format = imgdata.Type
-		default:
-			po.Format = imageTypeJPEG
+		if newRelicEnabled {
+			setNewRelicAttribute(ctx, "output_format", po.Format.String())
+			setNewRelicAttribute(ctx, "result_bytes", len(imgdata.Data))
 		}
-	} else if po.EnforceWebP && imageTypeSaveSupport(imageTypeWEBP) {
-		po.Format = imageTypeWEBP
+
+		w, done := prerespondWithImage(ctx, reqID, statusCode, imgURL, cacheControl, expires, po, r, rw)
+		defer done()
+		w.Write(imgdata.Data)
+
+		if len(cacheKey) > 0 {
+			resultCache.Set(cacheKey, &cachedResult{Format: po.Format, ETag: rw.Header().Get("ETag"), Data: imgdata.Data})
+		}
+
+		return
 	}
 
-	w, done := prerespondWithImage(ctx, reqID, imgURL, cacheControl, expires, po, r, rw)
+	resolveResultFormat(po, imgdata)
+
+	if prometheusEnabled {
+		incrementPrometheusFormatsTotal(po.Format)
+	}
+
+	w, done := prerespondWithImage(ctx, reqID, statusCode, imgURL, cacheControl, expires, po, r, rw)
 	defer done()
 
-	processcancel, err := processImage(ctx, w, po, imgdata)
+	if newRelicEnabled {
+		setNewRelicAttribute(ctx, "output_format", po.Format.String())
+
+		cw := &countingWriter{Writer: w}
+		w = cw
+		defer func() { setNewRelicAttribute(ctx, "result_bytes", cw.Count) }()
+	}
+
+	var cacheBuf *bytes.Buffer
+
+	if len(cacheKey) > 0 {
+		cacheBuf = responseBufPool.Get(0)
+		defer responseBufPool.Put(cacheBuf)
+
+		w = io.MultiWriter(w, cacheBuf)
+	}
+
+	ctx = processing.WithAverageColorHeader(ctx, rw.Header())
+
+	processcancel, err := processing.ProcessImage(ctx, w, po, imgdata)
 	defer processcancel()
 	if err != nil {
 		if newRelicEnabled {
@@ -219,4 +717,13 @@ func handleProcessing(reqID string, rw http.ResponseWriter, r *http.Request) {
 
 	checkTimeout(ctx)
 
+	if cacheBuf != nil {
+		resultCache.Set(cacheKey, &cachedResult{
+			Format:       po.Format,
+			ETag:         rw.Header().Get("ETag"),
+			AverageColor: rw.Header().Get(processing.AverageColorHeader),
+			Data:         cacheBuf.Bytes(),
+		})
+	}
+
 }
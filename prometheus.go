@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -15,9 +19,20 @@ var (
 
 	prometheusRequestsTotal      prometheus.Counter
 	prometheusErrorsTotal        *prometheus.CounterVec
+	prometheusFallbacksTotal     *prometheus.CounterVec
+	prometheusOptionsTotal       *prometheus.CounterVec
+	prometheusPresetsTotal       *prometheus.CounterVec
+	prometheusFormatsTotal       *prometheus.CounterVec
+	prometheusUsageRequestsTotal *prometheus.CounterVec
+	prometheusUsageBytesTotal    *prometheus.CounterVec
 	prometheusRequestDuration    prometheus.Histogram
 	prometheusDownloadDuration   prometheus.Histogram
 	prometheusProcessingDuration prometheus.Histogram
+	prometheusEncodeDuration     *prometheus.HistogramVec
+	prometheusQueueDuration      prometheus.Histogram
+	prometheusDownloadSize       prometheus.Histogram
+	prometheusRequestsInProgress prometheus.GaugeFunc
+	prometheusRequestsQueued     prometheus.GaugeFunc
 	prometheusBufferSize         *prometheus.HistogramVec
 	prometheusBufferDefaultSize  *prometheus.GaugeVec
 	prometheusBufferMaxSize      *prometheus.GaugeVec
@@ -43,6 +58,42 @@ func initPrometheus() {
 		Help:      "A counter of the occurred errors separated by type.",
 	}, []string{"type"})
 
+	prometheusFallbacksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "fallbacks_total",
+		Help:      "A counter of the responses served with the fallback image separated by reason.",
+	}, []string{"reason"})
+
+	prometheusOptionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "processing_options_total",
+		Help:      "A counter of the processing options used in URLs separated by option name.",
+	}, []string{"option"})
+
+	prometheusPresetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "presets_total",
+		Help:      "A counter of the used presets separated by preset name.",
+	}, []string{"preset"})
+
+	prometheusFormatsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "result_formats_total",
+		Help:      "A counter of the responses separated by resulting image format.",
+	}, []string{"format"})
+
+	prometheusUsageRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "usage_requests_total",
+		Help:      "A counter of the successful requests separated by usage subject.",
+	}, []string{"subject"})
+
+	prometheusUsageBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "usage_bytes_total",
+		Help:      "A counter of the sent response bytes separated by usage subject.",
+	}, []string{"subject"})
+
 	prometheusRequestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: conf.PrometheusNamespace,
 		Name:      "request_duration_seconds",
@@ -61,6 +112,41 @@ func initPrometheus() {
 		Help:      "A histogram of the image processing latency.",
 	})
 
+	prometheusEncodeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "encode_duration_seconds",
+		Help:      "A histogram of the resulting image encoding latency separated by format.",
+	}, []string{"format"})
+
+	prometheusQueueDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "queue_duration_seconds",
+		Help:      "A histogram of the time requests spend waiting for a processing slot.",
+	})
+
+	prometheusDownloadSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "download_size_bytes",
+		Help:      "A histogram of the source image size in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	prometheusRequestsInProgress = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "requests_in_progress",
+		Help:      "A gauge of the number of images being processed.",
+	}, func() float64 {
+		return float64(len(processingSem))
+	})
+
+	prometheusRequestsQueued = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: conf.PrometheusNamespace,
+		Name:      "requests_queued",
+		Help:      "A gauge of the number of requests waiting for a processing slot.",
+	}, func() float64 {
+		return float64(atomic.LoadInt64(&processingQueueLen))
+	})
+
 	prometheusBufferSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: conf.PrometheusNamespace,
 		Name:      "buffer_size_bytes",
@@ -83,26 +169,37 @@ func initPrometheus() {
 		Namespace: conf.PrometheusNamespace,
 		Name:      "vips_memory_bytes",
 		Help:      "A gauge of the vips tracked memory usage in bytes.",
-	}, vipsGetMem)
+	}, vips.GetMem)
 
 	prometheusVipsMaxMemory = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: conf.PrometheusNamespace,
 		Name:      "vips_max_memory_bytes",
 		Help:      "A gauge of the max vips tracked memory usage in bytes.",
-	}, vipsGetMemHighwater)
+	}, vips.GetMemHighwater)
 
 	prometheusVipsAllocs = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: conf.PrometheusNamespace,
 		Name:      "vips_allocs",
 		Help:      "A gauge of the number of active vips allocations.",
-	}, vipsGetAllocs)
+	}, vips.GetAllocs)
 
 	prometheus.MustRegister(
 		prometheusRequestsTotal,
 		prometheusErrorsTotal,
+		prometheusFallbacksTotal,
+		prometheusOptionsTotal,
+		prometheusPresetsTotal,
+		prometheusFormatsTotal,
+		prometheusUsageRequestsTotal,
+		prometheusUsageBytesTotal,
 		prometheusRequestDuration,
 		prometheusDownloadDuration,
 		prometheusProcessingDuration,
+		prometheusEncodeDuration,
+		prometheusQueueDuration,
+		prometheusDownloadSize,
+		prometheusRequestsInProgress,
+		prometheusRequestsQueued,
 		prometheusBufferSize,
 		prometheusBufferDefaultSize,
 		prometheusBufferMaxSize,
@@ -112,12 +209,14 @@ func initPrometheus() {
 	)
 
 	prometheusEnabled = true
+
+	options.OptionParsed = incrementPrometheusOptionsTotal
 }
 
 func startPrometheusServer(cancel context.CancelFunc) error {
 	s := http.Server{Handler: promhttp.Handler()}
 
-	l, err := listenReuseport("tcp", conf.PrometheusBind)
+	l, err := listen("prometheus", "tcp", conf.PrometheusBind)
 	if err != nil {
 		return fmt.Errorf("Can't start Prometheus metrics server: %s", err)
 	}
@@ -140,10 +239,38 @@ func startPrometheusDuration(m prometheus.Histogram) func() {
 	}
 }
 
+func startPrometheusEncodeDuration(format imagetype.Type) func() {
+	t := time.Now()
+	return func() {
+		prometheusEncodeDuration.With(prometheus.Labels{"format": format.String()}).Observe(time.Since(t).Seconds())
+	}
+}
+
 func incrementPrometheusErrorsTotal(t string) {
 	prometheusErrorsTotal.With(prometheus.Labels{"type": t}).Inc()
 }
 
+func incrementPrometheusFallbacksTotal(reason string) {
+	prometheusFallbacksTotal.With(prometheus.Labels{"reason": reason}).Inc()
+}
+
+func incrementPrometheusOptionsTotal(option string) {
+	prometheusOptionsTotal.With(prometheus.Labels{"option": option}).Inc()
+}
+
+func incrementPrometheusPresetsTotal(preset string) {
+	prometheusPresetsTotal.With(prometheus.Labels{"preset": preset}).Inc()
+}
+
+func incrementPrometheusFormatsTotal(format imagetype.Type) {
+	prometheusFormatsTotal.With(prometheus.Labels{"format": format.String()}).Inc()
+}
+
+func addPrometheusUsage(subject string, bytes int) {
+	prometheusUsageRequestsTotal.With(prometheus.Labels{"subject": subject}).Inc()
+	prometheusUsageBytesTotal.With(prometheus.Labels{"subject": subject}).Add(float64(bytes))
+}
+
 func observePrometheusBufferSize(t string, size int) {
 	prometheusBufferSize.With(prometheus.Labels{"type": t}).Observe(float64(size))
 }
@@ -0,0 +1,42 @@
+package main
+
+// reloadConfig reloads the parts of the config that are read from files
+// or external storages. In-flight requests keep using the data they've
+// already got, and the old data is kept if something can't be reloaded
+func reloadConfig() {
+	logNotice("Reloading...")
+
+	reloadTLSCertificate()
+
+	if err := reloadPresets(); err != nil {
+		logError("Can't reload presets: %s", err)
+	} else {
+		logNotice("Presets reloaded")
+	}
+
+	if err := reloadTenants(); err != nil {
+		logError("Can't reload tenants: %s", err)
+	} else if len(conf.TenantsPath) > 0 {
+		logNotice("Tenants reloaded")
+	}
+
+	if err := initErrorResponses(); err != nil {
+		logError("Can't reload error responses: %s", err)
+	}
+
+	for _, a := range assets {
+		if err := a.Reload(); err != nil {
+			logError("Can't reload %s: %s", a.desc, err)
+		}
+	}
+
+	if p, err := newKeysProvider(); err != nil {
+		logError("Can't reload keys: %s", err)
+	} else if p != nil {
+		if err := loadKeys(p); err != nil {
+			logError("Can't reload keys: %s", err)
+		} else {
+			logNotice("Keys reloaded")
+		}
+	}
+}
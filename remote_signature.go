@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+)
+
+// remoteSigner calculates signatures with keys that never leave
+// a key management service.
+// Remote signatures are calculated without a salt: the salt is a second secret
+// stored next to the local key, while the remote keys are generated by the key
+// management service and can't be read from it, so a salt kept in the config
+// wouldn't make them any harder to forge
+type remoteSigner interface {
+	// Sign returns HMAC-SHA256 digest of the data calculated with the remote key
+	Sign(key string, data []byte) ([]byte, error)
+}
+
+var (
+	remoteSignatureSigner remoteSigner
+	remoteSignatureCache  *lruCache
+	remoteSignatureSem    chan struct{}
+
+	errRemoteSignatureBusy = errors.New("Too many remote signature checks")
+)
+
+func initRemoteSigner() error {
+	if len(conf.RemoteSignatureKeys) == 0 {
+		return nil
+	}
+
+	p, err := newKeysProvider()
+	if err != nil {
+		return err
+	}
+
+	signer, ok := p.(remoteSigner)
+	if !ok {
+		return errors.New("IMGPROXY_KEYS_PROVIDER should be aws_secrets_manager or vault when IMGPROXY_REMOTE_SIGNATURE_KEYS is set")
+	}
+
+	remoteSignatureSigner = signer
+	remoteSignatureCache = newLRUCache(conf.RemoteSignatureCacheSize)
+	remoteSignatureSem = make(chan struct{}, conf.RemoteSignatureConcurrency)
+
+	return nil
+}
+
+// validateRemoteSignature checks the signature calculated with the remote key
+// having the provided ID. Only signatures of known keys having the expected size
+// are sent to the key management service. The calculated signatures are cached
+// whether they match or not, so repeated requests with invalid signatures
+// don't reach the key management service
+func validateRemoteSignature(keyID string, messageMAC []byte, path string) error {
+	key, ok := conf.RemoteSignatureKeys[keyID]
+	if !ok || remoteSignatureSigner == nil {
+		return errInvalidSignature
+	}
+
+	if len(messageMAC) != remoteSignatureSize() {
+		return errInvalidSignature
+	}
+
+	cacheKey := keyID + ":" + path
+
+	if sig, ok := remoteSignatureCache.Get(cacheKey); ok {
+		if hmac.Equal(messageMAC, sig.([]byte)) {
+			return nil
+		}
+		return errInvalidSignature
+	}
+
+	timer := time.NewTimer(time.Duration(conf.RemoteSignatureWait) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case remoteSignatureSem <- struct{}{}:
+		defer func() { <-remoteSignatureSem }()
+	case <-timer.C:
+		return ierrors.New(503, errRemoteSignatureBusy.Error(), "Can't verify signature")
+	}
+
+	sig, err := remoteSignatureSigner.Sign(key, []byte(path))
+	if err != nil {
+		return ierrors.New(503, err.Error(), "Can't verify signature").SetUnexpected(true)
+	}
+
+	if conf.SignatureSize < len(sig) {
+		sig = sig[:conf.SignatureSize]
+	}
+
+	remoteSignatureCache.Set(cacheKey, sig, time.Duration(conf.RemoteSignatureCacheTTL)*time.Second)
+
+	if !hmac.Equal(messageMAC, sig) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
+
+// remoteSignatureSize returns the size of the remote signatures.
+// Remote signatures are HMAC-SHA256 digests truncated to IMGPROXY_SIGNATURE_SIZE
+func remoteSignatureSize() int {
+	if conf.SignatureSize < sha256.Size {
+		return conf.SignatureSize
+	}
+	return sha256.Size
+}
+
+// Sign calculates signature with AWS KMS HMAC key
+func (p awsKeysProvider) Sign(key string, data []byte) ([]byte, error) {
+	out, err := p.kms.GenerateMac(&kms.GenerateMacInput{
+		KeyId:        aws.String(key),
+		MacAlgorithm: aws.String(kms.MacAlgorithmSpecHmacSha256),
+		Message:      data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Can't calculate signature with AWS KMS: %s", err)
+	}
+
+	return out.Mac, nil
+}
+
+// Sign calculates signature with HashiCorp Vault transit secrets engine key.
+// Key versions are configured as "name:version", the latest version is used when omitted.
+func (p vaultKeysProvider) Sign(key string, data []byte) ([]byte, error) {
+	name, version := key, 0
+
+	if ind := strings.LastIndexByte(key, ':'); ind > 0 {
+		v, err := strconv.Atoi(key[ind+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Vault transit key version: %s", key)
+		}
+		name, version = key[:ind], v
+	}
+
+	sig, err := p.transitHMAC(name, version, data)
+	if err != nil {
+		return nil, fmt.Errorf("Can't calculate signature with Vault: %s", err)
+	}
+
+	return sig, nil
+}
+
+func (p vaultKeysProvider) transitHMAC(name string, version int, data []byte) ([]byte, error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(data),
+		"key_version": version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1/transit/hmac/%s/sha2-256", strings.TrimSuffix(conf.KeysVaultAddress, "/"), name)
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("X-Vault-Token", conf.KeysVaultToken)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("status %d", res.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			HMAC string `json:"hmac"`
+		} `json:"data"`
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	// Vault returns HMAC in the "vault:v%version:%base64" format
+	parts := strings.Split(body.Data.HMAC, ":")
+
+	return base64.StdEncoding.DecodeString(parts[len(parts)-1])
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/imgproxy/imgproxy/v2/options"
+)
+
+const resultCacheTmpPrefix = "tmp-"
+
+var resultCache *diskResultCache
+
+type cachedResult struct {
+	Format       imagetype.Type
+	ETag         string
+	AverageColor string
+	Data         []byte
+}
+
+type resultCacheEntry struct {
+	key  string
+	size int64
+}
+
+// diskResultCache stores processing results in files and evicts
+// least recently used ones when the total size exceeds the limit
+type diskResultCache struct {
+	dir     string
+	maxSize int64
+
+	size    int64
+	entries map[string]*list.Element
+	lru     *list.List
+
+	mutex sync.Mutex
+}
+
+func initResultCache() error {
+	if len(conf.ResultCacheDir) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(conf.ResultCacheDir, 0755); err != nil {
+		return fmt.Errorf("Can't create result cache dir: %s", err)
+	}
+
+	c := &diskResultCache{
+		dir:     conf.ResultCacheDir,
+		maxSize: int64(conf.ResultCacheMaxSize) * 1024 * 1024,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	if err := c.loadIndex(); err != nil {
+		return fmt.Errorf("Can't load result cache: %s", err)
+	}
+
+	resultCache = c
+
+	return nil
+}
+
+func resultCacheKey(imageURL string, po *options.ProcessingOptions) string {
+	h := sha256.New()
+
+	enc := json.NewEncoder(h)
+	enc.SetEscapeHTML(false)
+
+	h.Write([]byte(config.Version))
+	h.Write([]byte(imageURL))
+	encodeConf(enc)
+	enc.Encode(po)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *diskResultCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// loadIndex fills the index with the files left from the previous runs.
+// Modification time is used as the last access time
+func (c *diskResultCache) loadIndex() error {
+	type fileInfo struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+
+	var files []fileInfo
+
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		// Remove files of interrupted writes
+		if strings.HasPrefix(info.Name(), resultCacheTmpPrefix) {
+			os.Remove(path)
+			return nil
+		}
+
+		// Skip files that don't look like cached results
+		if len(info.Name()) != sha256.Size*2 {
+			return nil
+		}
+
+		files = append(files, fileInfo{info.Name(), info.Size(), info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushFront(&resultCacheEntry{f.key, f.size})
+		c.size += f.size
+	}
+
+	c.evict()
+
+	return nil
+}
+
+func (c *diskResultCache) Get(key string) *cachedResult {
+	c.mutex.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	path := c.path(key)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		c.remove(key)
+		return nil
+	}
+
+	res, err := decodeCachedResult(data)
+	if err != nil {
+		logWarning("Can't read cached result %s: %s", key, err)
+		c.remove(key)
+		return nil
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return res
+}
+
+func (c *diskResultCache) Set(key string, res *cachedResult) {
+	path := c.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logWarning("Can't save result to cache: %s", err)
+		return
+	}
+
+	// Write to a temporary file first and rename it,
+	// so readers never see partially written results
+	f, err := ioutil.TempFile(filepath.Dir(path), resultCacheTmpPrefix)
+	if err != nil {
+		logWarning("Can't save result to cache: %s", err)
+		return
+	}
+
+	header := fmt.Sprintf("%d\t%s\t%s\n", res.Format, res.ETag, res.AverageColor)
+
+	w := bufio.NewWriter(f)
+	w.WriteString(header)
+	w.Write(res.Data)
+
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		logWarning("Can't save result to cache: %s", err)
+		return
+	}
+
+	size := int64(len(header) + len(res.Data))
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*resultCacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[key] = c.lru.PushFront(&resultCacheEntry{key, size})
+		c.size += size
+	}
+
+	c.evict()
+}
+
+func (c *diskResultCache) remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// evict removes the least recently used results until the cache fits the max size.
+// Should be called with the mutex locked
+func (c *diskResultCache) evict() {
+	for c.size > c.maxSize {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+
+		c.removeElement(el)
+	}
+}
+
+func (c *diskResultCache) removeElement(el *list.Element) {
+	entry := el.Value.(*resultCacheEntry)
+
+	c.lru.Remove(el)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+
+	os.Remove(c.path(entry.key))
+}
+
+func decodeCachedResult(data []byte) (*cachedResult, error) {
+	headerEnd := bytes.IndexByte(data, '\n')
+	if headerEnd < 0 {
+		return nil, fmt.Errorf("Invalid header")
+	}
+
+	// Results cached by older versions don't have the average color
+	header := strings.SplitN(string(data[:headerEnd]), "\t", 3)
+	if len(header) < 2 {
+		return nil, fmt.Errorf("Invalid header")
+	}
+
+	format, err := strconv.Atoi(header[0])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid format: %s", header[0])
+	}
+
+	res := cachedResult{
+		Format: imagetype.Type(format),
+		ETag:   header[1],
+		Data:   data[headerEnd+1:],
+	}
+
+	if len(header) == 3 {
+		res.AverageColor = header[2]
+	}
+
+	return &res, nil
+}
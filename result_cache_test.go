@@ -0,0 +1,154 @@
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ResultCacheTestSuite struct {
+	MainTestSuite
+
+	dir string
+}
+
+func (s *ResultCacheTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	dir, err := ioutil.TempDir("", "result-cache")
+	require.Nil(s.T(), err)
+
+	s.dir = dir
+}
+
+func (s *ResultCacheTestSuite) TearDownTest() {
+	os.RemoveAll(s.dir)
+
+	s.MainTestSuite.TearDownTest()
+}
+
+func (s *ResultCacheTestSuite) newCache(maxSize int64) *diskResultCache {
+	c := &diskResultCache{
+		dir:     s.dir,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	require.Nil(s.T(), c.loadIndex())
+
+	return c
+}
+
+func (s *ResultCacheTestSuite) key(c byte) string {
+	return strings.Repeat(string(c), 64)
+}
+
+func (s *ResultCacheTestSuite) TestSetGet() {
+	c := s.newCache(1024)
+
+	c.Set(s.key('a'), &cachedResult{
+		Format:       imagetype.PNG,
+		ETag:         `"etag"`,
+		AverageColor: "ff0000",
+		Data:         []byte("image\ndata"),
+	})
+
+	res := c.Get(s.key('a'))
+
+	require.NotNil(s.T(), res)
+	assert.Equal(s.T(), imagetype.PNG, res.Format)
+	assert.Equal(s.T(), `"etag"`, res.ETag)
+	assert.Equal(s.T(), "ff0000", res.AverageColor)
+	assert.Equal(s.T(), []byte("image\ndata"), res.Data)
+
+	assert.Nil(s.T(), c.Get(s.key('b')))
+}
+
+func (s *ResultCacheTestSuite) TestDecodeWithoutAverageColor() {
+	res, err := decodeCachedResult([]byte("2\t\"etag\"\ndata"))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), imagetype.Type(2), res.Format)
+	assert.Equal(s.T(), `"etag"`, res.ETag)
+	assert.Empty(s.T(), res.AverageColor)
+	assert.Equal(s.T(), []byte("data"), res.Data)
+}
+
+func (s *ResultCacheTestSuite) TestDecodeInvalidHeader() {
+	_, err := decodeCachedResult([]byte("data"))
+	assert.Error(s.T(), err)
+
+	_, err = decodeCachedResult([]byte("png\tetag\ndata"))
+	assert.Error(s.T(), err)
+}
+
+func (s *ResultCacheTestSuite) TestSetLeavesNoTmpFiles() {
+	c := s.newCache(1024)
+
+	c.Set(s.key('a'), &cachedResult{Format: imagetype.PNG, Data: []byte("data")})
+
+	files, err := ioutil.ReadDir(filepath.Dir(c.path(s.key('a'))))
+	require.Nil(s.T(), err)
+
+	require.Len(s.T(), files, 1)
+	assert.Equal(s.T(), s.key('a'), files[0].Name())
+}
+
+func (s *ResultCacheTestSuite) TestEviction() {
+	// Every result takes 10 bytes: 4 bytes of the header and 6 bytes of data
+	c := s.newCache(25)
+
+	for _, k := range []byte{'a', 'b', 'c'} {
+		c.Set(s.key(k), &cachedResult{Format: imagetype.PNG, Data: []byte("012345")})
+	}
+
+	assert.Equal(s.T(), int64(20), c.size)
+	assert.Nil(s.T(), c.Get(s.key('a')))
+	assert.NotNil(s.T(), c.Get(s.key('b')))
+	assert.NotNil(s.T(), c.Get(s.key('c')))
+
+	_, err := os.Stat(c.path(s.key('a')))
+	assert.True(s.T(), os.IsNotExist(err))
+}
+
+func (s *ResultCacheTestSuite) TestLoadIndex() {
+	now := time.Now()
+
+	for i, k := range []byte{'a', 'b', 'c'} {
+		path := filepath.Join(s.dir, s.key(k)[:2], s.key(k))
+
+		require.Nil(s.T(), os.MkdirAll(filepath.Dir(path), 0755))
+		require.Nil(s.T(), ioutil.WriteFile(path, []byte("2\t\t\n012345"), 0644))
+
+		modTime := now.Add(time.Duration(i-3) * time.Minute)
+		require.Nil(s.T(), os.Chtimes(path, modTime, modTime))
+	}
+
+	tmpPath := filepath.Join(s.dir, "aa", resultCacheTmpPrefix+"123")
+	require.Nil(s.T(), ioutil.WriteFile(tmpPath, []byte("partial"), 0644))
+
+	// The least recently modified result is evicted
+	c := s.newCache(25)
+
+	assert.Equal(s.T(), int64(20), c.size)
+	assert.Nil(s.T(), c.Get(s.key('a')))
+	assert.NotNil(s.T(), c.Get(s.key('b')))
+	assert.NotNil(s.T(), c.Get(s.key('c')))
+
+	_, err := os.Stat(tmpPath)
+	assert.True(s.T(), os.IsNotExist(err))
+}
+
+func TestResultCache(t *testing.T) {
+	suite.Run(t, new(ResultCacheTestSuite))
+}
@@ -62,6 +62,18 @@ func (r *router) GET(prefix string, handler routeHandler, exact bool) {
 	r.Add(http.MethodGet, prefix, handler, exact)
 }
 
+func (r *router) POST(prefix string, handler routeHandler, exact bool) {
+	r.Add(http.MethodPost, prefix, handler, exact)
+}
+
+func (r *router) PUT(prefix string, handler routeHandler, exact bool) {
+	r.Add(http.MethodPut, prefix, handler, exact)
+}
+
+func (r *router) DELETE(prefix string, handler routeHandler, exact bool) {
+	r.Add(http.MethodDelete, prefix, handler, exact)
+}
+
 func (r *router) OPTIONS(prefix string, handler routeHandler, exact bool) {
 	r.Add(http.MethodOptions, prefix, handler, exact)
 }
@@ -3,43 +3,102 @@ package main
 import (
 	"context"
 	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/imgproxy/imgproxy/v2/vips"
 	"golang.org/x/net/netutil"
 )
 
 var (
 	imgproxyIsRunningMsg = []byte("imgproxy is running")
 
-	errInvalidSecret = newError(403, "Invalid secret", "Forbidden")
+	errInvalidSecret  = ierrors.New(403, "Invalid secret", "Forbidden")
+	errInvalidReferer = ierrors.New(403, "Invalid referer", "Forbidden")
 )
 
-func buildRouter() *router {
+const (
+	routeProcessing = "processing"
+	routeHealth     = "health"
+	routeStatus     = "status"
+)
+
+var knownRoutes = []string{routeProcessing, routeHealth, routeStatus}
+
+// isRouteEnabled checks if the route is in the list.
+// Empty list means that all routes are enabled.
+func isRouteEnabled(routes []string, route string) bool {
+	if len(routes) == 0 {
+		return true
+	}
+
+	for _, r := range routes {
+		if r == route {
+			return true
+		}
+	}
+
+	return false
+}
+
+func buildRouter(routes []string) *router {
 	r := newRouter(conf.PathPrefix)
 
 	r.PanicHandler = handlePanic
 
-	r.GET("/", handleLanding, true)
-	r.GET("/health", handleHealth, true)
-	r.GET("/favicon.ico", handleFavicon, true)
-	r.GET("/", withCORS(withSecret(handleProcessing)), false)
-	r.HEAD("/", withCORS(handleHead), false)
-	r.OPTIONS("/", withCORS(handleHead), false)
+	if isRouteEnabled(routes, routeProcessing) {
+		r.GET("/", handleLanding, true)
+	}
+	if isRouteEnabled(routes, routeHealth) {
+		r.GET("/health", handleHealth, true)
+	}
+	if isRouteEnabled(routes, routeStatus) {
+		r.GET("/status", handleStatus, true)
+	}
+	if isRouteEnabled(routes, routeProcessing) {
+		r.GET("/favicon.ico", handleFavicon, true)
+		// Info route should be added before the processing one since routes are matched by prefix
+		if conf.InfoEnabled {
+			r.GET("/info/", withCORS(withSecret(withReferer(withUsage(handleInfo)))), false)
+		}
+		if conf.PlaceholdersEnabled {
+			r.GET("/placeholder/", withCORS(withSecret(handlePlaceholder)), false)
+		}
+		r.GET("/", withCORS(withSecret(withReferer(withUsage(handleProcessing)))), false)
+		r.HEAD("/", withCORS(handleHead), false)
+		r.OPTIONS("/", withCORS(handleHead), false)
+		if conf.BatchMaxSize > 0 {
+			r.POST("/batch", withCORS(withSecret(withReferer(withUsage(handleBatch)))), true)
+		}
+		if conf.CollageMaxSize > 0 {
+			r.POST("/collage", withCORS(withSecret(withReferer(withUsage(handleCollage)))), true)
+		}
+		if conf.UploadEnabled {
+			r.POST("/upload", withCORS(withSecret(withReferer(withUsage(handleUpload)))), false)
+		}
+	}
 
 	return r
 }
 
-func startServer(cancel context.CancelFunc) (*http.Server, error) {
-	l, err := listenReuseport(conf.Network, conf.Bind)
+func startServer(cancel context.CancelFunc, name, bind string, routes []string) (*http.Server, error) {
+	l, err := listen(name, conf.Network, bind)
 	if err != nil {
 		return nil, fmt.Errorf("Can't start server: %s", err)
 	}
 	l = netutil.LimitListener(l, conf.MaxClients)
 
 	s := &http.Server{
-		Handler:        buildRouter(),
+		Handler:        withXRay(withTenant(buildRouter(routes))),
 		ReadTimeout:    time.Duration(conf.ReadTimeout) * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
@@ -50,13 +109,24 @@ func startServer(cancel context.CancelFunc) (*http.Server, error) {
 		s.SetKeepAlivesEnabled(false)
 	}
 
-	if err := initProcessingHandler(); err != nil {
-		return nil, err
+	if isTLSEnabled() {
+		if s.TLSConfig, err = newTLSConfig(); err != nil {
+			return nil, err
+		}
 	}
 
 	go func() {
-		logNotice("Starting server at %s", conf.Bind)
-		if err := s.Serve(l); err != nil && err != http.ErrServerClosed {
+		var err error
+
+		logNotice("Starting server at %s", bind)
+
+		if isTLSEnabled() {
+			err = s.ServeTLS(l, "", "")
+		} else {
+			err = s.Serve(l)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
 			logError(err.Error())
 		}
 		cancel()
@@ -68,17 +138,58 @@ func startServer(cancel context.CancelFunc) (*http.Server, error) {
 func shutdownServer(s *http.Server) {
 	logNotice("Shutting down the server...")
 
-	ctx, close := context.WithTimeout(context.Background(), 5*time.Second)
+	// Give in-flight requests a chance to finish
+	timeout := 5 * time.Second
+	if wt := time.Duration(conf.MaxTimeout) * time.Second; wt > timeout {
+		timeout = wt
+	}
+
+	ctx, close := context.WithTimeout(context.Background(), timeout)
 	defer close()
 
 	s.Shutdown(ctx)
 }
 
+func corsAllowOrigin(origin string) string {
+	switch len(conf.AllowOrigin) {
+	case 0:
+		return ""
+	case 1:
+		return conf.AllowOrigin[0]
+	}
+
+	for _, o := range conf.AllowOrigin {
+		if o == origin {
+			return origin
+		}
+	}
+
+	return ""
+}
+
 func withCORS(h routeHandler) routeHandler {
 	return func(reqID string, rw http.ResponseWriter, r *http.Request) {
-		if len(conf.AllowOrigin) > 0 {
-			rw.Header().Set("Access-Control-Allow-Origin", conf.AllowOrigin)
-			rw.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		// When multiple origins are allowed, the response depends on the Origin header
+		if len(conf.AllowOrigin) > 1 {
+			rw.Header().Add("Vary", "Origin")
+		}
+
+		if origin := corsAllowOrigin(r.Header.Get("Origin")); len(origin) > 0 {
+			rw.Header().Set("Access-Control-Allow-Origin", origin)
+			rw.Header().Set("Access-Control-Allow-Methods", conf.AllowMethods)
+
+			if len(conf.AllowHeaders) > 0 {
+				rw.Header().Set("Access-Control-Allow-Headers", conf.AllowHeaders)
+			}
+
+			// Let browser scripts read the average color to pre-fill image containers
+			if conf.EnableAverageColorHeader {
+				rw.Header().Set("Access-Control-Expose-Headers", processing.AverageColorHeader)
+			}
+
+			if r.Method == http.MethodOptions && conf.CORSMaxAge > 0 {
+				rw.Header().Set("Access-Control-Max-Age", strconv.Itoa(conf.CORSMaxAge))
+			}
 		}
 
 		h(reqID, rw, r)
@@ -101,22 +212,90 @@ func withSecret(h routeHandler) routeHandler {
 	}
 }
 
+func isAllowedReferer(host string) bool {
+	for _, allowed := range conf.AllowedReferers {
+		if allowed == host {
+			return true
+		}
+
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withReferer(h routeHandler) routeHandler {
+	if len(conf.AllowedReferers) == 0 {
+		return h
+	}
+
+	return func(reqID string, rw http.ResponseWriter, r *http.Request) {
+		// Origin is more reliable since browsers may strip Referer
+		referer := r.Header.Get("Origin")
+		if len(referer) == 0 || referer == "null" {
+			referer = r.Header.Get("Referer")
+		}
+
+		if len(referer) == 0 {
+			if conf.AllowEmptyReferer {
+				h(reqID, rw, r)
+				return
+			}
+
+			panic(errInvalidReferer)
+		}
+
+		if u, err := url.Parse(referer); err == nil && isAllowedReferer(u.Hostname()) {
+			h(reqID, rw, r)
+		} else {
+			panic(errInvalidReferer)
+		}
+	}
+}
+
 func handlePanic(reqID string, rw http.ResponseWriter, r *http.Request, err error) {
+	ierr := handleError(reqID, r, err)
+
+	if resp := getErrorResponse(ierr.StatusCode); resp != nil {
+		respondWithErrorResponse(rw, ierr, resp)
+		return
+	}
+
+	respondWithErrorMessage(rw, ierr)
+}
+
+// handleError converts the error to ierrors.Error, reports it if it's unexpected,
+// and logs the response
+func handleError(reqID string, r *http.Request, err error) *ierrors.Error {
 	var (
-		ierr *imgproxyError
+		ierr *ierrors.Error
 		ok   bool
 	)
 
-	if ierr, ok = err.(*imgproxyError); !ok {
-		ierr = newUnexpectedError(err.Error(), 3)
+	if ierr, ok = err.(*ierrors.Error); !ok {
+		ierr = ierrors.NewUnexpected(err.Error(), 4)
 	}
 
 	if ierr.Unexpected {
 		reportError(err, r)
+
+		if xrayEnabled {
+			sendErrorToXRay(r.Context(), err)
+		}
 	}
 
 	logResponse(reqID, r, ierr.StatusCode, ierr, nil, nil)
 
+	return ierr
+}
+
+func respondWithErrorMessage(rw http.ResponseWriter, ierr *ierrors.Error) {
+	if ierr.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(ierr.RetryAfter))
+	}
+
 	rw.WriteHeader(ierr.StatusCode)
 
 	if conf.DevelopmentErrorsMode {
@@ -126,10 +305,98 @@ func handlePanic(reqID string, rw http.ResponseWriter, r *http.Request, err erro
 	}
 }
 
+type healthStatus struct {
+	Status     string            `json:"status"`
+	Version    string            `json:"version"`
+	GoVersion  string            `json:"go_version"`
+	Assets     map[string]string `json:"assets"`
+	Processing processingStats   `json:"processing"`
+	VipsMemory struct {
+		Current   float64 `json:"current"`
+		Highwater float64 `json:"highwater"`
+		Allocs    float64 `json:"allocs"`
+	} `json:"vips_memory"`
+}
+
+func getHealthStatus() *healthStatus {
+	status := healthStatus{
+		Status:    "ok",
+		Version:   config.Version,
+		GoVersion: runtime.Version(),
+		Assets:    make(map[string]string),
+	}
+
+	if len(degradedAssets()) > 0 {
+		status.Status = "degraded"
+	}
+
+	for _, a := range assets {
+		status.Assets[a.desc] = a.Status()
+	}
+
+	status.Processing = getProcessingStats()
+
+	status.VipsMemory.Current = vips.GetMem()
+	status.VipsMemory.Highwater = vips.GetMemHighwater()
+	status.VipsMemory.Allocs = vips.GetAllocs()
+
+	return &status
+}
+
+func isHealthSecretValid(r *http.Request) bool {
+	if len(conf.HealthSecret) == 0 {
+		return true
+	}
+
+	authHeader := []byte(fmt.Sprintf("Bearer %s", conf.HealthSecret))
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), authHeader) == 1
+}
+
+func isHealthDetailsAllowed(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json") && isHealthSecretValid(r)
+}
+
 func handleHealth(reqID string, rw http.ResponseWriter, r *http.Request) {
+	if isHealthDetailsAllowed(r) {
+		data, err := json.Marshal(getHealthStatus())
+		if err != nil {
+			panic(err)
+		}
+
+		logResponse(reqID, r, 200, nil, nil, nil)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(200)
+		rw.Write(data)
+		return
+	}
+
+	msg := imgproxyIsRunningMsg
+
+	if degraded := degradedAssets(); len(degraded) > 0 {
+		msg = []byte(fmt.Sprintf("imgproxy is running in degraded mode; not loaded: %s", strings.Join(degraded, ", ")))
+	}
+
+	logResponse(reqID, r, 200, nil, nil, nil)
+	rw.WriteHeader(200)
+	rw.Write(msg)
+}
+
+func handleStatus(reqID string, rw http.ResponseWriter, r *http.Request) {
+	if !isHealthSecretValid(r) {
+		panic(errInvalidSecret)
+	}
+
+	data, err := json.Marshal(getServerStats())
+	if err != nil {
+		panic(err)
+	}
+
 	logResponse(reqID, r, 200, nil, nil, nil)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
 	rw.WriteHeader(200)
-	rw.Write(imgproxyIsRunningMsg)
+	rw.Write(data)
 }
 
 func handleHead(reqID string, rw http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sftpDefaultPort = "22"
+
+// sftpTransport implements RoundTripper for the 'sftp' protocol.
+// It keeps one SFTP session per host and reuses it between requests.
+type sftpTransport struct {
+	config  *ssh.ClientConfig
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	clients map[string]*sftp.Client
+}
+
+func newSFTPTransport() (http.RoundTripper, error) {
+	key, err := ioutil.ReadFile(conf.SFTPKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read SFTP key: %s", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse SFTP key: %s", err)
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+
+	switch {
+	case len(conf.SFTPKnownHostsPath) > 0:
+		if hostKeyCallback, err = knownhosts.New(conf.SFTPKnownHostsPath); err != nil {
+			return nil, fmt.Errorf("Can't read SFTP known hosts: %s", err)
+		}
+	case conf.SFTPInsecureSkipHostKeyCheck:
+		logWarning("IMGPROXY_SFTP_INSECURE_SKIP_HOST_KEY_CHECK is set, so SFTP host keys are not verified")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.New("IMGPROXY_SFTP_KNOWN_HOSTS_PATH is not set")
+	}
+
+	timeout := time.Duration(conf.DownloadTimeout) * time.Second
+
+	return &sftpTransport{
+		config: &ssh.ClientConfig{
+			User:            conf.SFTPUser,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         timeout,
+		},
+		timeout: timeout,
+		clients: make(map[string]*sftp.Client),
+	}, nil
+}
+
+func isAllowedSFTPHost(host string) bool {
+	if len(conf.SFTPAllowedHosts) == 0 {
+		return true
+	}
+
+	for _, h := range conf.SFTPAllowedHosts {
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sftpConn extends the read deadline of the connection on every read,
+// so a stuck server can't block requests forever. Since the connection
+// is always read by SSH, idle connections are closed after the timeout
+// as well and are dialed again on the next request
+type sftpConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c sftpConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c sftpConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (t *sftpTransport) dial(ctx context.Context, addr string) (*sftp.Client, error) {
+	d := net.Dialer{Timeout: t.timeout}
+
+	if conf.DenyPrivateSourceAddresses {
+		d.Control = checkSourceAddress
+	}
+
+	netConn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(sftpConn{netConn, t.timeout}, addr, t.config)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	sshClient := ssh.NewClient(conn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (t *sftpTransport) getClient(ctx context.Context, addr string) (*sftp.Client, error) {
+	t.mutex.Lock()
+	client, ok := t.clients[addr]
+	t.mutex.Unlock()
+
+	if ok {
+		return client, nil
+	}
+
+	// Dialing can be long, so we don't hold the lock while dialing
+	client, err := t.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Another request could have dialed the host at the same time
+	if existing, ok := t.clients[addr]; ok {
+		client.Close()
+		return existing, nil
+	}
+
+	t.clients[addr] = client
+
+	return client, nil
+}
+
+func (t *sftpTransport) dropClient(addr string, client *sftp.Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.clients[addr] == client {
+		delete(t.clients, addr)
+	}
+
+	client.Close()
+}
+
+func (t *sftpTransport) open(ctx context.Context, addr, path string) (*sftp.File, error) {
+	client, err := t.getClient(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := client.Open(path)
+	if err != nil && !os.IsNotExist(err) && !os.IsPermission(err) && ctx.Err() == nil {
+		// The connection may be broken, so we drop it and try again once
+		t.dropClient(addr, client)
+
+		if client, err = t.getClient(ctx, addr); err != nil {
+			return nil, err
+		}
+
+		f, err = client.Open(path)
+	}
+
+	return f, err
+}
+
+// sftpBody stops reading the file when the request is canceled
+type sftpBody struct {
+	*sftp.File
+	ctx context.Context
+}
+
+func (b sftpBody) Read(p []byte) (int, error) {
+	if err := b.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return b.File.Read(p)
+}
+
+func (t *sftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	host, port := req.URL.Hostname(), req.URL.Port()
+
+	if !isAllowedSFTPHost(host) {
+		return nil, fmt.Errorf("SFTP host is not allowed: %s", host)
+	}
+
+	if len(port) == 0 {
+		port = sftpDefaultPort
+	}
+
+	f, err := t.open(ctx, net.JoinHostPort(host, port), req.URL.Path)
+	if os.IsNotExist(err) {
+		return notFoundResponse(req, err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", req.URL.Path)
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    200,
+		Proto:         "HTTP/1.0",
+		ProtoMajor:    1,
+		ProtoMinor:    0,
+		Header:        make(http.Header),
+		ContentLength: fi.Size(),
+		Body:          sftpBody{f, ctx},
+		Close:         true,
+		Request:       req,
+	}, nil
+}
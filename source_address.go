@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+var deniedSourceNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // RFC1122 "this" network, reaches the local host on some systems
+	"10.0.0.0/8",     // RFC1918
+	"172.16.0.0/12",  // RFC1918
+	"192.168.0.0/16", // RFC1918
+	"100.64.0.0/10",  // RFC6598 shared address space, used by some metadata services
+	"fc00::/7",       // IPv6 unique local addresses
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+
+	return nets
+}
+
+func isDeniedSourceIP(ip net.IP) bool {
+	for _, n := range conf.AllowedSourceNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if ip.IsLoopback() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() {
+		return true
+	}
+
+	for _, n := range deniedSourceNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkSourceAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Invalid source address: %s", address)
+	}
+
+	if isDeniedSourceIP(ip) {
+		return fmt.Errorf("Source address is not allowed: %s", ip)
+	}
+
+	return nil
+}
+
+// sourceProxyEnv returns the name of the environment variable that sets
+// the proxy of the source downloads. The proxy resolves and connects
+// to the source hosts by itself, so their addresses can't be checked
+func sourceProxyEnv() string {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		if len(os.Getenv(name)) > 0 {
+			return name
+		}
+	}
+
+	return ""
+}
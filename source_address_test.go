@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SourceAddressTestSuite struct{ MainTestSuite }
+
+func (s *SourceAddressTestSuite) TestCheckSourceAddressPublic() {
+	assert.Nil(s.T(), checkSourceAddress("tcp", "93.184.216.34:80", nil))
+	assert.Nil(s.T(), checkSourceAddress("tcp6", "[2606:2800:220:1:248:1893:25c8:1946]:443", nil))
+}
+
+func (s *SourceAddressTestSuite) TestCheckSourceAddressInternal() {
+	for _, addr := range []string{
+		"127.0.0.1:80",
+		"0.0.0.0:80",
+		"0.1.2.3:80",
+		"224.0.0.1:80",
+		"239.1.2.3:80",
+		"10.1.2.3:80",
+		"172.16.0.1:80",
+		"192.168.1.1:80",
+		"169.254.169.254:80",
+		"100.100.100.200:80",
+		"[::1]:80",
+		"[fe80::1]:80",
+		"[fd00:ec2::254]:80",
+		"[ff02::1]:80",
+		"[ff0e::1]:80",
+	} {
+		assert.Error(s.T(), checkSourceAddress("tcp", addr, nil), addr)
+	}
+}
+
+func (s *SourceAddressTestSuite) TestCheckSourceAddressAllowedNetwork() {
+	_, n, _ := net.ParseCIDR("10.1.0.0/16")
+	conf.AllowedSourceNetworks = []*net.IPNet{n}
+
+	assert.Nil(s.T(), checkSourceAddress("tcp", "10.1.2.3:80", nil))
+	assert.Error(s.T(), checkSourceAddress("tcp", "10.2.2.3:80", nil))
+}
+
+func (s *SourceAddressTestSuite) TestSourceProxyEnv() {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	assert.Empty(s.T(), sourceProxyEnv())
+
+	os.Setenv("https_proxy", "http://proxy.dev:3128")
+
+	assert.Equal(s.T(), "https_proxy", sourceProxyEnv())
+}
+
+func (s *SourceAddressTestSuite) TestSFTPDialDenied() {
+	conf.DenyPrivateSourceAddresses = true
+
+	t := &sftpTransport{timeout: time.Second}
+
+	_, err := t.dial(context.Background(), "127.0.0.1:22")
+
+	require.Error(s.T(), err)
+	assert.Contains(s.T(), err.Error(), "Source address is not allowed")
+}
+
+func TestSourceAddress(t *testing.T) {
+	suite.Run(t, new(SourceAddressTestSuite))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Counters are cumulative since the start, so monitoring systems
+// can calculate rates and averages over any interval
+var (
+	statsQueueWaits       int64
+	statsQueueWaitNanos   int64
+	statsQueueRejected    int64
+	statsDownloadsTotal   int64
+	statsDownloadsFailed  int64
+	statsDownloadNanos    int64
+	statsDownloadInFlight int64
+)
+
+type processingStats struct {
+	InFlight    int   `json:"in_flight"`
+	Queued      int64 `json:"queued"`
+	Concurrency int   `json:"concurrency"`
+}
+
+type queueStats struct {
+	WaitsTotal       int64   `json:"waits_total"`
+	WaitSecondsTotal float64 `json:"wait_seconds_total"`
+	RejectedTotal    int64   `json:"rejected_total"`
+}
+
+type downloadStats struct {
+	InFlight             int64   `json:"in_flight"`
+	Total                int64   `json:"total"`
+	FailedTotal          int64   `json:"failed_total"`
+	DurationSecondsTotal float64 `json:"duration_seconds_total"`
+}
+
+type serverStats struct {
+	Processing processingStats `json:"processing"`
+	Queue      queueStats      `json:"queue"`
+	Downloads  downloadStats   `json:"downloads"`
+}
+
+func getProcessingStats() processingStats {
+	return processingStats{
+		InFlight:    len(processingSem),
+		Queued:      atomic.LoadInt64(&processingQueueLen),
+		Concurrency: conf.Concurrency,
+	}
+}
+
+func getServerStats() *serverStats {
+	return &serverStats{
+		Processing: getProcessingStats(),
+		Queue: queueStats{
+			WaitsTotal:       atomic.LoadInt64(&statsQueueWaits),
+			WaitSecondsTotal: time.Duration(atomic.LoadInt64(&statsQueueWaitNanos)).Seconds(),
+			RejectedTotal:    atomic.LoadInt64(&statsQueueRejected),
+		},
+		Downloads: downloadStats{
+			InFlight:             atomic.LoadInt64(&statsDownloadInFlight),
+			Total:                atomic.LoadInt64(&statsDownloadsTotal),
+			FailedTotal:          atomic.LoadInt64(&statsDownloadsFailed),
+			DurationSecondsTotal: time.Duration(atomic.LoadInt64(&statsDownloadNanos)).Seconds(),
+		},
+	}
+}
+
+func trackQueueWait(d time.Duration) {
+	atomic.AddInt64(&statsQueueWaits, 1)
+	atomic.AddInt64(&statsQueueWaitNanos, int64(d))
+}
+
+func startDownloadStats() func(err error) {
+	start := time.Now()
+
+	atomic.AddInt64(&statsDownloadInFlight, 1)
+
+	return func(err error) {
+		atomic.AddInt64(&statsDownloadInFlight, -1)
+		atomic.AddInt64(&statsDownloadsTotal, 1)
+		atomic.AddInt64(&statsDownloadNanos, int64(time.Since(start)))
+
+		if err != nil {
+			atomic.AddInt64(&statsDownloadsFailed, 1)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/processing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsTestSuite struct{ MainTestSuite }
+
+func (s *StatsTestSuite) getStatus() *serverStats {
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/status", nil)
+	req = req.WithContext(setTimerSince(req.Context()))
+
+	handleStatus("test", rw, req)
+
+	require.Equal(s.T(), 200, rw.Code)
+
+	var stats serverStats
+	require.Nil(s.T(), json.Unmarshal(rw.Body.Bytes(), &stats))
+
+	return &stats
+}
+
+func (s *StatsTestSuite) TestStatusQueueRejected() {
+	conf.HealthSecret = ""
+
+	before := s.getStatus().Queue.RejectedTotal
+
+	newQueueRejectedError("Queue is full")
+	newQueueRejectedError("Queue is full")
+
+	assert.Equal(s.T(), before+2, s.getStatus().Queue.RejectedTotal)
+}
+
+func (s *StatsTestSuite) TestStatusDownloads() {
+	conf.HealthSecret = ""
+
+	before := s.getStatus().Downloads
+
+	startDownloadStats()(nil)
+	startDownloadStats()(processing.ErrSourceImageTypeNotSupported)
+
+	after := s.getStatus().Downloads
+
+	assert.Equal(s.T(), before.Total+2, after.Total)
+	assert.Equal(s.T(), before.FailedTotal+1, after.FailedTotal)
+	assert.Equal(s.T(), before.InFlight, after.InFlight)
+}
+
+func TestStats(t *testing.T) {
+	suite.Run(t, new(StatsTestSuite))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sourceURLSurrogateKey returns the key that identifies all the derivatives
+// of the source image. It's the first 16 hex chars of SHA-256 of the source URL
+func sourceURLSurrogateKey(imageURL string) string {
+	sum := sha256.Sum256([]byte(imageURL))
+	return "src-" + hex.EncodeToString(sum[:8])
+}
+
+func setSurrogateKeyHeaders(rw http.ResponseWriter, imageURL string) {
+	if len(conf.SurrogateKeyHeaders) == 0 {
+		return
+	}
+
+	keys := []string{sourceURLSurrogateKey(imageURL)}
+
+	if u, err := url.Parse(imageURL); err == nil && len(u.Host) > 0 {
+		keys = append(keys, "host-"+u.Host)
+	}
+
+	for _, h := range conf.SurrogateKeyHeaders {
+		// Cloudflare expects comma-separated tags while Fastly
+		// and others expect space-separated keys
+		sep := " "
+		if strings.EqualFold(h, "Cache-Tag") {
+			sep = ","
+		}
+
+		rw.Header().Set(h, strings.Join(keys, sep))
+	}
+}
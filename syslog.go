@@ -1,13 +1,24 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log/syslog"
+	"net"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/imgproxy/imgproxy/v2/config"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = time.Second
+)
+
 var (
 	syslogLevels = map[string]logrus.Level{
 		"crit":    logrus.FatalLevel,
@@ -17,14 +28,21 @@ var (
 	}
 )
 
+type syslogWriter interface {
+	Crit(m string) error
+	Err(m string) error
+	Warning(m string) error
+	Info(m string) error
+}
+
 type syslogHook struct {
-	writer    *syslog.Writer
+	writer    syslogWriter
 	levels    []logrus.Level
 	formatter logrus.Formatter
 }
 
 func isSyslogEnabled() (enabled bool) {
-	boolEnvConfig(&enabled, "IMGPROXY_SYSLOG_ENABLE")
+	config.BoolEnv(&enabled, "IMGPROXY_SYSLOG_ENABLE")
 	return
 }
 
@@ -34,13 +52,15 @@ func newSyslogHook() (*syslogHook, error) {
 		level         logrus.Level
 
 		tag      = "imgproxy"
-		levelStr = "notice"
+		levelStr = "info"
+		format   = "rfc3164"
 	)
 
-	strEnvConfig(&network, "IMGPROXY_SYSLOG_NETWORK")
-	strEnvConfig(&addr, "IMGPROXY_SYSLOG_ADDRESS")
-	strEnvConfig(&tag, "IMGPROXY_SYSLOG_TAG")
-	strEnvConfig(&levelStr, "IMGPROXY_SYSLOG_LEVEL")
+	config.StringEnv(&network, "IMGPROXY_SYSLOG_NETWORK")
+	config.StringEnv(&addr, "IMGPROXY_SYSLOG_ADDRESS")
+	config.StringEnv(&tag, "IMGPROXY_SYSLOG_TAG")
+	config.StringEnv(&levelStr, "IMGPROXY_SYSLOG_LEVEL")
+	config.StringEnv(&format, "IMGPROXY_SYSLOG_FORMAT")
 
 	if l, ok := syslogLevels[levelStr]; ok {
 		level = l
@@ -49,7 +69,19 @@ func newSyslogHook() (*syslogHook, error) {
 		logWarning("Syslog level '%s' is invalid, 'info' is used", levelStr)
 	}
 
-	w, err := syslog.Dial(network, addr, syslog.LOG_NOTICE, tag)
+	var (
+		w   syslogWriter
+		err error
+	)
+
+	switch format {
+	case "rfc3164":
+		w, err = syslog.Dial(network, addr, syslog.LOG_NOTICE, tag)
+	case "rfc5424":
+		w, err = dialRFC5424Syslog(network, addr, tag)
+	default:
+		return nil, fmt.Errorf("Unknown syslog format: %s", format)
+	}
 
 	return &syslogHook{
 		writer:    w,
@@ -82,3 +114,118 @@ func (hook *syslogHook) Fire(entry *logrus.Entry) error {
 func (hook *syslogHook) Levels() []logrus.Level {
 	return hook.levels
 }
+
+// rfc5424SyslogWriter sends messages to a remote syslog server in RFC 5424 format.
+// Messages are terminated with newlines, so they can be sent over TCP as well as UDP.
+type rfc5424SyslogWriter struct {
+	mutex sync.Mutex
+
+	network  string
+	addr     string
+	tag      string
+	hostname string
+
+	conn net.Conn
+}
+
+func dialRFC5424Syslog(network, addr, tag string) (*rfc5424SyslogWriter, error) {
+	if len(network) == 0 {
+		return nil, errors.New("RFC 5424 format requires IMGPROXY_SYSLOG_NETWORK to be set")
+	}
+
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	w := &rfc5424SyslogWriter{
+		network:  network,
+		addr:     addr,
+		tag:      tag,
+		hostname: hostname,
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *rfc5424SyslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.addr, syslogDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn != nil {
+		// Another goroutine has already reconnected
+		conn.Close()
+	} else {
+		w.conn = conn
+	}
+
+	return nil
+}
+
+func (w *rfc5424SyslogWriter) writeLine(line string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		return errors.New("Syslog connection is closed")
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+
+	if _, err := w.conn.Write([]byte(line)); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// write sends the message to the syslog server. The connection is dialed
+// outside of the mutex and writes have a deadline, so an unreachable server
+// doesn't block logging for longer than the timeouts
+func (w *rfc5424SyslogWriter) write(severity syslog.Priority, msg string) error {
+	line := fmt.Sprintf(
+		"<%d>1 %s %s %s %d - - %s\n",
+		syslog.LOG_DAEMON|severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.tag, os.Getpid(),
+		strings.TrimRight(msg, "\n"),
+	)
+
+	if err := w.writeLine(line); err == nil {
+		return nil
+	}
+
+	// The connection is lost, try to reconnect once
+	if err := w.connect(); err != nil {
+		return err
+	}
+
+	return w.writeLine(line)
+}
+
+func (w *rfc5424SyslogWriter) Crit(m string) error {
+	return w.write(syslog.LOG_CRIT, m)
+}
+
+func (w *rfc5424SyslogWriter) Err(m string) error {
+	return w.write(syslog.LOG_ERR, m)
+}
+
+func (w *rfc5424SyslogWriter) Warning(m string) error {
+	return w.write(syslog.LOG_WARNING, m)
+}
+
+func (w *rfc5424SyslogWriter) Info(m string) error {
+	return w.write(syslog.LOG_INFO, m)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type SyslogTestSuite struct{ MainTestSuite }
+
+func (s *SyslogTestSuite) TestRFC5424Write() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(s.T(), err)
+	defer l.Close()
+
+	lines := make(chan string, 1)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			select {
+			case lines <- line:
+			default:
+			}
+
+			// Drop the connection so the writer has to reconnect
+			conn.Close()
+		}
+	}()
+
+	w, err := dialRFC5424Syslog("tcp", l.Addr().String(), "imgproxy")
+	require.Nil(s.T(), err)
+
+	require.Nil(s.T(), w.Info("first\n"))
+	assert.Regexp(s.T(), `^<30>1 \S+ \S+ imgproxy \d+ - - first\n$`, <-lines)
+
+	l.Close()
+
+	// Writes to the dropped connection may succeed until the OS notices it's closed,
+	// but then the writer fails to reconnect to the closed listener
+	for i := 0; i < 100; i++ {
+		if err = w.Err("second"); err != nil {
+			break
+		}
+	}
+	assert.NotNil(s.T(), err)
+}
+
+func TestSyslog(t *testing.T) {
+	suite.Run(t, new(SyslogTestSuite))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/imagemeta"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+)
+
+var tenantCtxKey = ctxKey("tenant")
+
+type tenant struct {
+	Name             string
+	Hosts            []string
+	PathPrefix       string
+	BaseURL          string
+	Keys             []config.SecurityKey
+	Salts            []config.SecurityKey
+	AllowedSources   []string
+	Presets          options.Presets
+	MaxSrcResolution int
+	MaxSrcFileSize   int
+	QuotaRequests    int
+	QuotaBytes       int
+}
+
+// tenantConfig is the tenant description in the tenants file
+type tenantConfig struct {
+	Hosts            []string `json:"hosts"`
+	PathPrefix       string   `json:"path_prefix"`
+	BaseURL          string   `json:"base_url"`
+	Keys             []string `json:"keys"`
+	Salts            []string `json:"salts"`
+	AllowedSources   []string `json:"allowed_sources"`
+	Presets          []string `json:"presets"`
+	MaxSrcResolution float64  `json:"max_src_resolution"`
+	MaxSrcFileSize   int      `json:"max_src_file_size"`
+	QuotaRequests    int      `json:"quota_requests"`
+	QuotaBytes       int      `json:"quota_bytes"`
+}
+
+var (
+	tenants []*tenant
+
+	// tenantsMutex guards tenants since they can be reloaded
+	// while the server is running
+	tenantsMutex sync.RWMutex
+)
+
+func initTenants() error {
+	t, err := loadTenants(conf.TenantsPath)
+	if err != nil {
+		return err
+	}
+
+	tenants = t
+
+	return nil
+}
+
+// reloadTenants re-reads the tenants file.
+// The old tenants are kept if the new ones are invalid
+func reloadTenants() error {
+	t, err := loadTenants(conf.TenantsPath)
+	if err != nil {
+		return err
+	}
+
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+
+	tenants = t
+
+	return nil
+}
+
+func loadTenants(path string) ([]*tenant, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't read tenants file: %s", err)
+	}
+
+	return parseTenants(data)
+}
+
+func parseTenants(data []byte) ([]*tenant, error) {
+	var configs map[string]tenantConfig
+
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("Can't parse tenants file: %s", err)
+	}
+
+	result := make([]*tenant, 0, len(configs))
+	hosts := make(map[string]string)
+	prefixes := make(map[string]string)
+
+	for name, c := range configs {
+		t, err := newTenant(name, c)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range t.Hosts {
+			if other, ok := hosts[host]; ok {
+				return nil, fmt.Errorf("Host %s is used by tenants `%s` and `%s`", host, other, name)
+			}
+			hosts[host] = name
+		}
+
+		if len(t.PathPrefix) > 0 {
+			if other, ok := prefixes[t.PathPrefix]; ok {
+				return nil, fmt.Errorf("Path prefix %s is used by tenants `%s` and `%s`", t.PathPrefix, other, name)
+			}
+			prefixes[t.PathPrefix] = name
+		}
+
+		result = append(result, t)
+	}
+
+	return result, nil
+}
+
+func newTenant(name string, c tenantConfig) (*tenant, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("Tenant name can't be empty")
+	}
+
+	t := tenant{
+		Name:             name,
+		PathPrefix:       strings.Trim(c.PathPrefix, "/"),
+		BaseURL:          c.BaseURL,
+		AllowedSources:   c.AllowedSources,
+		Presets:          make(options.Presets),
+		MaxSrcResolution: int(c.MaxSrcResolution * 1000000),
+		MaxSrcFileSize:   c.MaxSrcFileSize,
+		QuotaRequests:    c.QuotaRequests,
+		QuotaBytes:       c.QuotaBytes,
+	}
+
+	if len(c.Hosts) == 0 && len(t.PathPrefix) == 0 {
+		return nil, fmt.Errorf("Tenant `%s` should have hosts or a path prefix", name)
+	}
+
+	if strings.Contains(t.PathPrefix, "/") {
+		return nil, fmt.Errorf("Path prefix of tenant `%s` can't contain slashes: %s", name, c.PathPrefix)
+	}
+
+	for _, host := range c.Hosts {
+		t.Hosts = append(t.Hosts, strings.ToLower(host))
+	}
+
+	var err error
+
+	if t.Keys, err = decodeTenantKeys(c.Keys); err != nil {
+		return nil, fmt.Errorf("Keys of tenant `%s` expected to be hex-encoded strings. Invalid: %s", name, err)
+	}
+	if t.Salts, err = decodeTenantKeys(c.Salts); err != nil {
+		return nil, fmt.Errorf("Salts of tenant `%s` expected to be hex-encoded strings. Invalid: %s", name, err)
+	}
+	if len(t.Keys) != len(t.Salts) {
+		return nil, fmt.Errorf("Number of keys and number of salts of tenant `%s` should be equal. Keys: %d, salts: %d", name, len(t.Keys), len(t.Salts))
+	}
+
+	if t.MaxSrcResolution < 0 {
+		return nil, fmt.Errorf("Max src resolution of tenant `%s` should be greater than or equal to 0, now - %d", name, t.MaxSrcResolution)
+	}
+	if t.MaxSrcFileSize < 0 {
+		return nil, fmt.Errorf("Max src file size of tenant `%s` should be greater than or equal to 0, now - %d", name, t.MaxSrcFileSize)
+	}
+
+	if t.QuotaRequests < 0 {
+		return nil, fmt.Errorf("Requests quota of tenant `%s` should be greater than or equal to 0, now - %d", name, t.QuotaRequests)
+	}
+	if t.QuotaBytes < 0 {
+		return nil, fmt.Errorf("Bytes quota of tenant `%s` should be greater than or equal to 0, now - %d", name, t.QuotaBytes)
+	}
+
+	for _, presetStr := range c.Presets {
+		if err = options.ParsePreset(t.Presets, presetStr); err != nil {
+			return nil, fmt.Errorf("Invalid preset of tenant `%s`: %s", name, err)
+		}
+	}
+
+	if err = checkTenantPresets(&t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// checkTenantPresets checks the tenant presets. Tenants aren't registered yet
+// at this moment, so presets used by the tenant presets are resolved here
+func checkTenantPresets(t *tenant) error {
+	for name, opts := range t.Presets {
+		if err := applyTenantPresetOptions(t, options.NewProcessingOptions(), opts); err != nil {
+			return fmt.Errorf("Error in preset `%s` of tenant `%s`: %s", name, t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyTenantPresetOptions(t *tenant, po *options.ProcessingOptions, opts options.URLOptions) error {
+	for _, opt := range opts {
+		if opt.Name != "preset" && opt.Name != "pr" {
+			if err := options.ApplyProcessingOption(po, opt.Name, opt.Args); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, name := range opt.Args {
+			if po.IsPresetUsed(name) {
+				continue
+			}
+
+			po.PresetUsed(name)
+
+			p, ok := t.Presets[name]
+			if !ok {
+				if p, ok = options.GetPreset(name); !ok {
+					return fmt.Errorf("Unknown preset: %s", name)
+				}
+			}
+
+			if err := applyTenantPresetOptions(t, po, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func decodeTenantKeys(parts []string) ([]config.SecurityKey, error) {
+	keys := make([]config.SecurityKey, len(parts))
+
+	for i, part := range parts {
+		key, err := hex.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("%s", part)
+		}
+
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+func getTenant(name string) *tenant {
+	if len(name) == 0 {
+		return nil
+	}
+
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+
+	for _, t := range tenants {
+		if t.Name == name {
+			return t
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	options.LookupPreset = getTenantPreset
+	options.SourceBaseURL = sourceBaseURL
+}
+
+// getTenantPreset looks for the preset in the tenant presets first
+// and falls back to the global presets
+func getTenantPreset(tenantName, name string) (options.URLOptions, bool) {
+	if t := getTenant(tenantName); t != nil {
+		if p, ok := t.Presets[name]; ok {
+			return p, true
+		}
+	}
+
+	return options.GetPreset(name)
+}
+
+// sourceBaseURL returns the base URL of the tenant source URLs.
+// IMGPROXY_BASE_URL is used if the tenant doesn't have its own base URL
+func sourceBaseURL(tenantName string) string {
+	if t := getTenant(tenantName); t != nil && len(t.BaseURL) > 0 {
+		return t.BaseURL
+	}
+
+	return conf.BaseURL
+}
+
+// findTenant selects the tenant by the Host header or by the path prefix.
+// If the tenant is selected by the path prefix, the matched prefix
+// is returned as well
+func findTenant(r *http.Request) (*tenant, string) {
+	tenantsMutex.RLock()
+	defer tenantsMutex.RUnlock()
+
+	if len(tenants) == 0 {
+		return nil, ""
+	}
+
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, t := range tenants {
+		for _, h := range t.Hosts {
+			if h == host {
+				return t, ""
+			}
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, conf.PathPrefix)
+
+	for _, t := range tenants {
+		if len(t.PathPrefix) == 0 {
+			continue
+		}
+
+		prefix := "/" + t.PathPrefix
+
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return t, prefix
+		}
+	}
+
+	return nil, ""
+}
+
+// withTenant selects the tenant of the request and puts it to the request context.
+// The tenant path prefix is removed from the request path, so routes
+// and handlers see the same paths as without tenants
+func withTenant(h http.Handler) http.Handler {
+	if len(conf.TenantsPath) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t, prefix := findTenant(r)
+		if t == nil {
+			h.ServeHTTP(rw, r)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), tenantCtxKey, t))
+
+		if len(prefix) > 0 {
+			u := *r.URL
+			u.Path = conf.PathPrefix + strings.TrimPrefix(strings.TrimPrefix(u.Path, conf.PathPrefix), prefix)
+			u.RawPath = ""
+			r.URL = &u
+
+			r.RequestURI = conf.PathPrefix + strings.TrimPrefix(strings.TrimPrefix(r.RequestURI, conf.PathPrefix), prefix)
+		}
+
+		h.ServeHTTP(rw, r)
+	})
+}
+
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantCtxKey).(*tenant)
+	return t
+}
+
+func tenantName(t *tenant) string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// tenantSignedPath returns the path that is signed with the global keys.
+// The tenant name is a part of the signed path, so a URL signed for one tenant
+// can't be replayed for another tenant or without a tenant
+func tenantSignedPath(t *tenant, path string) string {
+	if t == nil {
+		return path
+	}
+	return "/" + t.Name + path
+}
+
+func isAllowedSourceForTenant(t *tenant, imageURL string) bool {
+	if t == nil || len(t.AllowedSources) == 0 {
+		return true
+	}
+
+	for _, val := range t.AllowedSources {
+		if strings.HasPrefix(imageURL, val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkTenantLimits checks the downloaded image against the limits
+// of the request tenant. Tenant limits can only be stricter than the global ones
+func checkTenantLimits(ctx context.Context, imgdata *imagedata.ImageData) error {
+	t := tenantFromContext(ctx)
+	if t == nil {
+		return nil
+	}
+
+	if t.MaxSrcFileSize > 0 && len(imgdata.Data) > t.MaxSrcFileSize {
+		return errSourceFileTooBig
+	}
+
+	if t.MaxSrcResolution > 0 {
+		meta, err := imagemeta.DecodeMeta(bytes.NewReader(imgdata.Data))
+		if err != nil {
+			return ierrors.NewUnexpected(err.Error(), 0)
+		}
+
+		if meta.Width()*meta.Height() > t.MaxSrcResolution {
+			return processing.ErrSourceResolutionTooBig
+		}
+	}
+
+	return nil
+}
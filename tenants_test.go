@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TenantsTestSuite struct{ MainTestSuite }
+
+const testTenants = `{
+	"acme": {
+		"hosts": ["img.acme.com"],
+		"path_prefix": "acme",
+		"keys": ["746573742d6b6579"],
+		"salts": ["746573742d73616c74"],
+		"allowed_sources": ["http://images.dev/"],
+		"presets": ["default=quality:50", "thumb=size:100:100/preset:default"]
+	}
+}`
+
+func (s *TenantsTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	t, err := parseTenants([]byte(testTenants))
+	require.Nil(s.T(), err)
+
+	tenants = t
+	conf.TenantsPath = "tenants.json"
+}
+
+func (s *TenantsTestSuite) TearDownTest() {
+	tenants = nil
+
+	s.MainTestSuite.TearDownTest()
+}
+
+func (s *TenantsTestSuite) serveTenantRequest(host, uri string) *http.Request {
+	var res *http.Request
+
+	h := withTenant(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		res = r
+	}))
+
+	req := httptest.NewRequest("GET", uri, nil)
+	req.Host = host
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	return res
+}
+
+func (s *TenantsTestSuite) sign(key, salt, path string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(salt))
+	mac.Write([]byte(path))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *TenantsTestSuite) TestParseTenantsInvalidKeys() {
+	_, err := parseTenants([]byte(`{"acme": {"hosts": ["img.acme.com"], "keys": ["zz"], "salts": ["00"]}}`))
+
+	require.Error(s.T(), err)
+}
+
+func (s *TenantsTestSuite) TestParseTenantsKeysSaltsMismatch() {
+	_, err := parseTenants([]byte(`{"acme": {"hosts": ["img.acme.com"], "keys": ["00"]}}`))
+
+	require.Error(s.T(), err)
+}
+
+func (s *TenantsTestSuite) TestParseTenantsWithoutSelector() {
+	_, err := parseTenants([]byte(`{"acme": {"keys": ["00"], "salts": ["00"]}}`))
+
+	require.Error(s.T(), err)
+}
+
+func (s *TenantsTestSuite) TestParseTenantsInvalidPreset() {
+	_, err := parseTenants([]byte(`{"acme": {"hosts": ["img.acme.com"], "presets": ["test=unknown_option:1"]}}`))
+
+	require.Error(s.T(), err)
+}
+
+func (s *TenantsTestSuite) TestSelectByHost() {
+	req := s.serveTenantRequest("IMG.acme.com:8080", "/signature/plain/http://images.dev/lorem.jpg")
+
+	assert.Equal(s.T(), "acme", tenantName(tenantFromContext(req.Context())))
+	assert.Equal(s.T(), "/signature/plain/http://images.dev/lorem.jpg", req.URL.Path)
+}
+
+func (s *TenantsTestSuite) TestSelectByPathPrefix() {
+	req := s.serveTenantRequest("imgproxy.dev", "/acme/signature/plain/http://images.dev/lorem.jpg")
+
+	assert.Equal(s.T(), "acme", tenantName(tenantFromContext(req.Context())))
+	assert.Equal(s.T(), "/signature/plain/http://images.dev/lorem.jpg", req.URL.Path)
+	assert.Equal(s.T(), "/signature/plain/http://images.dev/lorem.jpg", req.RequestURI)
+}
+
+func (s *TenantsTestSuite) TestSelectNoTenant() {
+	req := s.serveTenantRequest("imgproxy.dev", "/acmeinc/signature/plain/http://images.dev/lorem.jpg")
+
+	assert.Nil(s.T(), tenantFromContext(req.Context()))
+	assert.Equal(s.T(), "/acmeinc/signature/plain/http://images.dev/lorem.jpg", req.URL.Path)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantKeys() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("global-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("global-salt")}
+	conf.AllowInsecure = false
+
+	req := s.serveTenantRequest("imgproxy.dev", "/acme/HcvNognEV1bW6f8zRqxNYuOkV0IUf1xloRb57CzbT4g/width:150/plain/http://images.dev/lorem/ipsum.jpg@png")
+	_, po, err := parsePath(req.Context(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "acme", po.Tenant)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantKeysGlobalSignature() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	tenants[0].Keys = []config.SecurityKey{config.SecurityKey("other-key")}
+	tenants[0].Salts = []config.SecurityKey{config.SecurityKey("other-salt")}
+
+	req := s.serveTenantRequest("imgproxy.dev", "/acme/HcvNognEV1bW6f8zRqxNYuOkV0IUf1xloRb57CzbT4g/width:150/plain/http://images.dev/lorem/ipsum.jpg@png")
+	_, _, err := parsePath(req.Context(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantGlobalKeys() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	tenants[0].Keys = nil
+	tenants[0].Salts = nil
+
+	path := "/width:150/plain/http://images.dev/lorem/ipsum.jpg@png"
+	signature := s.sign("test-key", "test-salt", "/acme"+path)
+
+	req := s.serveTenantRequest("imgproxy.dev", "/acme/"+signature+path)
+	_, po, err := parsePath(req.Context(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "acme", po.Tenant)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantGlobalKeysSignedWithoutTenant() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	tenants[0].Keys = nil
+	tenants[0].Salts = nil
+
+	path := "/width:150/plain/http://images.dev/lorem/ipsum.jpg@png"
+	signature := s.sign("test-key", "test-salt", path)
+
+	req := s.serveTenantRequest("imgproxy.dev", "/acme/"+signature+path)
+	_, _, err := parsePath(req.Context(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *TenantsTestSuite) TestParsePathGlobalKeysSignedForTenant() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+
+	path := "/width:150/plain/http://images.dev/lorem/ipsum.jpg@png"
+	signature := s.sign("test-key", "test-salt", "/acme"+path)
+
+	req := s.serveTenantRequest("imgproxy.dev", "/"+signature+path)
+	_, _, err := parsePath(req.Context(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 403, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantSourceNotAllowed() {
+	tenants[0].Keys = nil
+	tenants[0].Salts = nil
+
+	req := s.serveTenantRequest("img.acme.com", "/unsafe/width:150/plain/http://other.dev/lorem/ipsum.jpg")
+	_, _, err := parsePath(req.Context(), req)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 404, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantPresets() {
+	tenants[0].Keys = nil
+	tenants[0].Salts = nil
+
+	req := s.serveTenantRequest("img.acme.com", "/unsafe/preset:thumb/plain/http://images.dev/lorem/ipsum.jpg")
+	_, po, err := parsePath(req.Context(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 50, po.Quality)
+	assert.Equal(s.T(), 100, po.Width)
+	assert.Equal(s.T(), 100, po.Height)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantBaseURL() {
+	conf.BaseURL = "http://global.dev/"
+
+	tenants[0].Keys = nil
+	tenants[0].Salts = nil
+	tenants[0].BaseURL = "http://images.dev/"
+
+	req := s.serveTenantRequest("img.acme.com", "/unsafe/width:150/plain/lorem/ipsum.jpg")
+	imageURL, _, err := parsePath(req.Context(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "http://images.dev/lorem/ipsum.jpg", imageURL)
+
+	req = s.getRequest("/unsafe/width:150/plain/lorem/ipsum.jpg")
+	imageURL, _, err = parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "http://global.dev/lorem/ipsum.jpg", imageURL)
+}
+
+func (s *TenantsTestSuite) TestParsePathTenantBaseURLSignedForOtherTenant() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.BaseURL = "http://global.dev/"
+
+	t, err := parseTenants([]byte(`{
+		"site-a": {"hosts": ["img.site-a.com"], "base_url": "http://site-a.dev/"},
+		"site-b": {"hosts": ["img.site-b.com"], "base_url": "http://site-b.dev/"}
+	}`))
+	require.Nil(s.T(), err)
+
+	tenants = t
+
+	path := "/width:150/plain/lorem/ipsum.jpg"
+	uri := "/" + s.sign("test-key", "test-salt", "/site-a"+path) + path
+
+	req := s.serveTenantRequest("img.site-a.com", uri)
+	imageURL, _, err := parsePath(req.Context(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "http://site-a.dev/lorem/ipsum.jpg", imageURL)
+
+	for _, host := range []string{"img.site-b.com", "imgproxy.dev"} {
+		req = s.serveTenantRequest(host, uri)
+		_, _, err = parsePath(req.Context(), req)
+
+		require.Error(s.T(), err, host)
+		assert.Equal(s.T(), 403, err.(*ierrors.Error).StatusCode, host)
+	}
+}
+
+func (s *TenantsTestSuite) TestParsePathWithoutTenantIgnoresTenantPresets() {
+	req := s.getRequest("/unsafe/preset:thumb/plain/http://images.dev/lorem/ipsum.jpg")
+	_, _, err := parsePath(context.Background(), req)
+
+	require.Error(s.T(), err)
+}
+
+func TestTenants(t *testing.T) {
+	suite.Run(t, new(TenantsTestSuite))
+}
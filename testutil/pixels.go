@@ -0,0 +1,16 @@
+// Package testutil contains helpers shared by the tests of imgproxy packages.
+package testutil
+
+// GenPixels generates synthetic pixels of the given size with bands per pixel.
+// f returns the values of the pixel bands
+func GenPixels(width, height, bands int, f func(x, y int) []byte) []byte {
+	pixels := make([]byte, width*height*bands)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			copy(pixels[(y*width+x)*bands:], f(x, y)[:bands])
+		}
+	}
+
+	return pixels
+}
@@ -4,9 +4,19 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+)
+
+var (
+	timerSinceCtxKey = ctxKey("timerSince")
+	timingsCtxKey    = ctxKey("timings")
+
+	timingStages = []string{"queue", "download", "decode", "transform", "encode"}
 )
 
-var timerSinceCtxKey = ctxKey("timerSince")
+// requestTimings holds durations of the request processing stages
+type requestTimings map[string]time.Duration
 
 func setTimerSince(ctx context.Context) context.Context {
 	return context.WithValue(ctx, timerSinceCtxKey, time.Now())
@@ -17,12 +27,19 @@ func getTimerSince(ctx context.Context) time.Duration {
 }
 
 func checkTimeout(ctx context.Context) {
+	if err := timeoutError(ctx); err != nil {
+		panic(err)
+	}
+}
+
+// timeoutError returns an error if the request was cancelled or timed out
+func timeoutError(ctx context.Context) error {
 	select {
 	case <-ctx.Done():
 		d := getTimerSince(ctx)
 
 		if ctx.Err() != context.DeadlineExceeded {
-			panic(newError(499, fmt.Sprintf("Request was cancelled after %v", d), "Cancelled"))
+			return ierrors.New(499, fmt.Sprintf("Request was cancelled after %v", d), "Cancelled")
 		}
 
 		if newRelicEnabled {
@@ -33,8 +50,26 @@ func checkTimeout(ctx context.Context) {
 			incrementPrometheusErrorsTotal("timeout")
 		}
 
-		panic(newError(503, fmt.Sprintf("Timeout after %v", d), "Timeout"))
+		return ierrors.New(503, fmt.Sprintf("Timeout after %v", d), "Timeout")
 	default:
-		// Go ahead
+		return nil
+	}
+}
+
+func setRequestTimings(ctx context.Context) context.Context {
+	return context.WithValue(ctx, timingsCtxKey, requestTimings{})
+}
+
+// startTiming starts measuring the stage duration. Call the returned function
+// when the stage is finished. Does nothing if the request timings are not tracked.
+func startTiming(ctx context.Context, stage string) func() {
+	timings, ok := ctx.Value(timingsCtxKey).(requestTimings)
+	if !ok {
+		return func() {}
+	}
+
+	t := time.Now()
+	return func() {
+		timings[stage] += time.Since(t)
 	}
 }
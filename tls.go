@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+)
+
+// tlsCertificate holds the server certificate that can be reloaded
+// without restarting the server
+type tlsCertificate struct {
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+var serverCertificate tlsCertificate
+
+func isTLSEnabled() bool {
+	return len(conf.TLSCertPath) > 0
+}
+
+func (c *tlsCertificate) Load() error {
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertPath, conf.TLSKeyPath)
+	if err != nil {
+		return fmt.Errorf("Can't load TLS certificate: %s", err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cert = &cert
+
+	return nil
+}
+
+func (c *tlsCertificate) Get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.cert, nil
+}
+
+func newTLSConfig() (*tls.Config, error) {
+	if err := serverCertificate.Load(); err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: serverCertificate.Get,
+	}, nil
+}
+
+func reloadTLSCertificate() {
+	if !isTLSEnabled() {
+		return
+	}
+
+	if err := serverCertificate.Load(); err != nil {
+		logError("%s", err)
+		return
+	}
+
+	logNotice("TLS certificate reloaded")
+}
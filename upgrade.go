@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When imgproxy receives SIGUSR2, it starts a new process from the current
+// executable, passing its listening sockets to it. Once the new process is
+// ready to accept connections, the old one stops accepting and finishes
+// in-flight requests.
+
+const (
+	inheritedListenersEnv = "IMGPROXY_INHERITED_LISTENERS"
+	upgradeReadyFdEnv     = "IMGPROXY_UPGRADE_READY_FD"
+
+	upgradeReadyTimeout = 30 * time.Second
+)
+
+var (
+	inheritedListeners map[string]*os.File
+	activeListeners    = make(map[string]net.Listener)
+)
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+func parseInheritedListeners() {
+	inheritedListeners = make(map[string]*os.File)
+
+	env := os.Getenv(inheritedListenersEnv)
+	if len(env) == 0 {
+		return
+	}
+
+	// Don't pass inherited listeners to our own children
+	os.Unsetenv(inheritedListenersEnv)
+
+	for _, part := range strings.Split(env, ",") {
+		kv := strings.SplitN(part, ":", 2)
+		if len(kv) != 2 {
+			logWarning("Invalid inherited listener: %s", part)
+			continue
+		}
+
+		fd, err := strconv.Atoi(kv[1])
+		if err != nil {
+			logWarning("Invalid inherited listener: %s", part)
+			continue
+		}
+
+		inheritedListeners[kv[0]] = os.NewFile(uintptr(fd), kv[0])
+	}
+}
+
+// listen creates a listener or takes the inherited one with the same name
+func listen(name, network, address string) (net.Listener, error) {
+	if inheritedListeners == nil {
+		parseInheritedListeners()
+	}
+
+	var (
+		l   net.Listener
+		err error
+	)
+
+	if f, ok := inheritedListeners[name]; ok {
+		delete(inheritedListeners, name)
+
+		l, err = net.FileListener(f)
+		f.Close()
+
+		if err == nil {
+			logNotice("Using inherited %s listener", name)
+		}
+	} else {
+		l, err = listenReuseport(network, address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	activeListeners[name] = l
+
+	return l, nil
+}
+
+func notifyUpgradeReady() {
+	env := os.Getenv(upgradeReadyFdEnv)
+	if len(env) == 0 {
+		return
+	}
+
+	os.Unsetenv(upgradeReadyFdEnv)
+
+	fd, err := strconv.Atoi(env)
+	if err != nil {
+		logWarning("Invalid upgrade ready fd: %s", env)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade-ready")
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		logWarning("Can't notify the parent process: %s", err)
+	}
+}
+
+// upgradeProcess starts a new process that inherits listeners and waits
+// until it is ready to accept connections
+func upgradeProcess() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr, readyW}
+	listenersEnv := make([]string, 0, len(activeListeners))
+
+	for name, l := range activeListeners {
+		fl, ok := l.(fileListener)
+		if !ok {
+			readyW.Close()
+			return fmt.Errorf("Can't pass %s listener to the new process", name)
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			readyW.Close()
+			return err
+		}
+		defer f.Close()
+
+		listenersEnv = append(listenersEnv, fmt.Sprintf("%s:%d", name, len(files)))
+		files = append(files, f)
+	}
+
+	env := append(
+		os.Environ(),
+		fmt.Sprintf("%s=%s", inheritedListenersEnv, strings.Join(listenersEnv, ",")),
+		fmt.Sprintf("%s=%d", upgradeReadyFdEnv, 3),
+	)
+
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{Env: env, Files: files})
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	logNotice("Started new process %d, waiting for it to be ready", proc.Pid)
+
+	ready := make(chan error, 1)
+
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := readyR.Read(buf); err != nil {
+			ready <- errors.New("New process exited before it was ready")
+			return
+		}
+		ready <- nil
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(upgradeReadyTimeout):
+		err = errors.New("New process is not ready in time")
+	}
+
+	if err != nil {
+		proc.Kill()
+		proc.Release()
+		return err
+	}
+
+	proc.Release()
+
+	// The new process uses the same socket file, so we shouldn't remove it
+	for _, l := range activeListeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+
+	return nil
+}
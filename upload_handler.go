@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagedata"
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/processing"
+)
+
+const uploadFormField = "image"
+
+var errUploadNoImage = ierrors.New(400, "Request doesn't contain an image", "Invalid upload request")
+
+func handleUpload(reqID string, rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if newRelicEnabled {
+		var newRelicCancel context.CancelFunc
+		ctx, newRelicCancel = startNewRelicTransaction(ctx, rw, r)
+		defer newRelicCancel()
+	}
+
+	if prometheusEnabled {
+		prometheusRequestsTotal.Inc()
+		defer startPrometheusDuration(prometheusRequestDuration)()
+	}
+
+	po, err := parseUploadPath(r)
+	if err != nil {
+		panic(err)
+	}
+
+	// Uploads are not signed, so only the tenant can be the usage subject
+	if err = setUsageSubject(ctx, tenantFromContext(ctx), -1); err != nil {
+		panic(err)
+	}
+
+	imgdata, filename, err := readUploadedImage(r)
+	if err != nil {
+		panic(err)
+	}
+	defer imgdata.Close()
+
+	if err = checkTenantLimits(ctx, imgdata); err != nil {
+		panic(err)
+	}
+
+	if err = acquireProcessingSem(ctx, po.Priority); err != nil {
+		panic(err)
+	}
+	defer releaseProcessingSem(po.Priority)
+
+	ctx, timeoutCancel := context.WithTimeout(ctx, time.Duration(po.Timeout)*time.Second)
+	defer timeoutCancel()
+
+	if shouldSkipProcessing(po, imgdata) {
+		po.Format = imgdata.Type
+		prerespondWithUpload(reqID, filename, po, r, rw)
+		rw.Write(imgdata.Data)
+		return
+	}
+
+	resolveResultFormat(po, imgdata)
+
+	buf := responseBufPool.Get(0)
+	defer responseBufPool.Put(buf)
+
+	ctx = processing.WithAverageColorHeader(ctx, rw.Header())
+
+	// Respond only after the image is processed, so the panic handler
+	// can respond with the error if processing fails
+	processcancel, err := processing.ProcessImage(ctx, buf, po, imgdata)
+	defer processcancel()
+	if err != nil {
+		if prometheusEnabled {
+			incrementPrometheusErrorsTotal("processing")
+		}
+		panic(err)
+	}
+
+	checkTimeout(ctx)
+
+	prerespondWithUpload(reqID, filename, po, r, rw)
+	rw.Write(buf.Bytes())
+}
+
+// parseUploadPath parses processing options from the path after /upload.
+// The source image is in the request body, so the path contains only options
+func parseUploadPath(r *http.Request) (*options.ProcessingOptions, error) {
+	path := trimAfter(r.RequestURI, '?')
+	path = strings.TrimPrefix(path, conf.PathPrefix)
+	path = strings.TrimPrefix(path, "/upload")
+	path = strings.Trim(path, "/")
+
+	var parts []string
+	if len(path) > 0 {
+		parts = strings.Split(path, "/")
+	}
+
+	headers := &options.Headers{
+		Accept:        r.Header.Get("Accept"),
+		Width:         r.Header.Get("Width"),
+		ViewportWidth: r.Header.Get("Viewport-Width"),
+		DPR:           r.Header.Get("DPR"),
+		Tenant:        tenantName(tenantFromContext(r.Context())),
+	}
+
+	po, err := options.DefaultProcessingOptions(headers)
+	if err != nil {
+		return nil, ierrors.New(404, err.Error(), msgInvalidURL)
+	}
+
+	var urlOpts options.URLOptions
+
+	if conf.OnlyPresets {
+		if len(parts) > 1 {
+			return nil, ierrors.New(404, fmt.Sprintf("Invalid path: %s", path), msgInvalidURL)
+		}
+		if len(parts) == 1 {
+			urlOpts = options.URLOptions{options.URLOption{Name: "preset", Args: strings.Split(parts[0], ":")}}
+		}
+	} else {
+		var rest []string
+		if urlOpts, rest = options.ParseURLOptions(parts); len(rest) > 0 {
+			return nil, ierrors.New(404, fmt.Sprintf("Invalid path: %s", path), msgInvalidURL)
+		}
+	}
+
+	if err = options.ApplyProcessingOptions(po, urlOpts); err != nil {
+		return nil, ierrors.New(404, err.Error(), msgInvalidURL)
+	}
+
+	// Uploads are not signed, so there's no signature key to check the overlays against
+	if err = checkOverlaySources(r, tenantFromContext(r.Context()), -1, po); err != nil {
+		return nil, err
+	}
+
+	if prometheusEnabled {
+		for _, opt := range urlOpts {
+			incrementPrometheusOptionsTotal(opt.Name)
+		}
+	}
+
+	return po, nil
+}
+
+// readUploadedImage reads the image from the `image` field of the multipart form
+// or from the whole request body. It also returns the name of the uploaded file
+// if it's known
+func readUploadedImage(r *http.Request) (*imagedata.ImageData, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		if r.ContentLength == 0 {
+			return nil, "", errUploadNoImage
+		}
+
+		// Chunked requests don't have the content length, so the body size
+		// is checked while it's read
+		contentLength := int(r.ContentLength)
+		if contentLength < 0 {
+			contentLength = 0
+		}
+
+		imgdata, err := readAndCheckImage(r.Body, contentLength)
+		return imgdata, "", err
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", ierrors.New(400, fmt.Sprintf("Can't parse upload request: %s", err), "Invalid upload request")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", errUploadNoImage
+		}
+		if err != nil {
+			return nil, "", ierrors.New(400, fmt.Sprintf("Can't parse upload request: %s", err), "Invalid upload request")
+		}
+
+		if part.FormName() != uploadFormField {
+			part.Close()
+			continue
+		}
+
+		imgdata, err := readAndCheckImage(part, 0)
+		part.Close()
+
+		return imgdata, part.FileName(), err
+	}
+}
+
+func prerespondWithUpload(reqID, filename string, po *options.ProcessingOptions, r *http.Request, rw http.ResponseWriter) {
+	var contentDisposition string
+	if len(po.Filename) > 0 {
+		contentDisposition = po.Format.ContentDisposition(po.Filename)
+	} else {
+		contentDisposition = po.Format.ContentDispositionFromURL(filename)
+	}
+
+	rw.Header().Set("Content-Type", po.Format.Mime())
+	rw.Header().Set("Content-Disposition", contentDisposition)
+	// Uploaded images are private, so results shouldn't be cached by proxies
+	rw.Header().Set("Cache-Control", "private, no-store")
+
+	logResponse(reqID, r, 200, nil, nil, po)
+
+	rw.WriteHeader(200)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type UploadTestSuite struct{ MainTestSuite }
+
+func (s *UploadTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.UploadEnabled = true
+}
+
+func (s *UploadTestSuite) TestResponseBufPool() {
+	oldPool := responseBufPool
+	defer func() { responseBufPool = oldPool }()
+
+	responseBufPool = nil
+
+	require.Nil(s.T(), initProcessingHandler())
+	assert.NotNil(s.T(), responseBufPool)
+}
+
+func (s *UploadTestSuite) TestParseUploadPath() {
+	po, err := parseUploadPath(s.getRequest("/upload/rs:fill:300:200/q:80"))
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), 300, po.Width)
+	assert.Equal(s.T(), 200, po.Height)
+	assert.Equal(s.T(), 80, po.Quality)
+}
+
+func (s *UploadTestSuite) TestParseUploadPathOverlayNotAllowedSource() {
+	conf.AllowedSources = []string{"http://images.dev/"}
+
+	_, err := parseUploadPath(s.getRequest("/upload/overlay:aHR0cDovL290aGVyLmRldi9iYWRnZS5wbmc"))
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 404, err.(*ierrors.Error).StatusCode)
+}
+
+func (s *UploadTestSuite) TestParseUploadPathOverlayAllowedSource() {
+	conf.AllowedSources = []string{"http://images.dev/"}
+
+	po, err := parseUploadPath(s.getRequest("/upload/overlay:aHR0cDovL2ltYWdlcy5kZXYvYmFkZ2UucG5n"))
+
+	require.Nil(s.T(), err)
+	require.Len(s.T(), po.Overlays, 1)
+	assert.Equal(s.T(), "http://images.dev/badge.png", po.Overlays[0].URL)
+}
+
+func (s *UploadTestSuite) pngImage(width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+
+	buf := new(bytes.Buffer)
+	require.Nil(s.T(), png.Encode(buf, img))
+
+	return buf.Bytes()
+}
+
+func (s *UploadTestSuite) uploadRequest(data []byte, contentLength int64) *http.Request {
+	req := s.getRequest("/upload")
+	req.Method = "POST"
+	req.Header.Set("Content-Type", "image/png")
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = contentLength
+
+	return req
+}
+
+func (s *UploadTestSuite) TestReadUploadedImage() {
+	data := s.pngImage(10, 10)
+
+	imgdata, _, err := readUploadedImage(s.uploadRequest(data, int64(len(data))))
+
+	require.Nil(s.T(), err)
+	defer imgdata.Close()
+
+	assert.Equal(s.T(), imagetype.PNG, imgdata.Type)
+	assert.Equal(s.T(), data, imgdata.Data)
+}
+
+func (s *UploadTestSuite) TestReadUploadedImageUnknownContentLength() {
+	data := s.pngImage(10, 10)
+
+	imgdata, _, err := readUploadedImage(s.uploadRequest(data, -1))
+
+	require.Nil(s.T(), err)
+	defer imgdata.Close()
+
+	assert.Equal(s.T(), imagetype.PNG, imgdata.Type)
+	assert.Equal(s.T(), data, imgdata.Data)
+}
+
+func (s *UploadTestSuite) TestReadUploadedImageUnknownContentLengthTooBig() {
+	data := s.pngImage(200, 200)
+
+	conf.MaxSrcFileSize = 10000
+
+	_, _, err := readUploadedImage(s.uploadRequest(data, -1))
+
+	assert.Equal(s.T(), errSourceFileTooBig, err)
+}
+
+func (s *UploadTestSuite) TestReadUploadedImageEmpty() {
+	_, _, err := readUploadedImage(s.uploadRequest(nil, 0))
+
+	assert.Equal(s.T(), errUploadNoImage, err)
+}
+
+func TestUpload(t *testing.T) {
+	suite.Run(t, new(UploadTestSuite))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+)
+
+// urlCommand runs the `imgproxy url` command that generates signed processing URLs
+// or verifies signatures of existing ones
+func urlCommand(args []string) int {
+	fs := flag.NewFlagSet("url", flag.ContinueOnError)
+
+	keysHex := fs.String("key", os.Getenv("IMGPROXY_KEY"), "hex-encoded key, or comma-separated keys for verification")
+	saltsHex := fs.String("salt", os.Getenv("IMGPROXY_SALT"), "hex-encoded salt, or comma-separated salts for verification")
+	algorithm := fs.String("algorithm", "sha256", "signature algorithm: sha256, sha512/256, or blake2b")
+	signatureSize := fs.Int("signature-size", 32, "number of signature bytes to use")
+	pathPrefix := fs.String("prefix", os.Getenv("IMGPROXY_PATH_PREFIX"), "URL path prefix")
+	options := fs.String("options", "", "processing options separated by /, e.g. rs:fit:300:300/q:80")
+	resize := fs.String("resize", "", "resize option arguments, e.g. fit:300:300")
+	extension := fs.String("extension", "", "extension of the resulting image")
+	plain := fs.Bool("plain", false, "use plain source URL instead of base64-encoded")
+	verify := fs.String("verify", "", "verify the signature of the URL path instead of generating a URL")
+
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: imgproxy url [flags] <source URL>")
+		fmt.Fprintln(fs.Output(), "       imgproxy url [flags] -verify <URL path>")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if val, err := strconv.Atoi(os.Getenv("IMGPROXY_SIGNATURE_SIZE")); err == nil && !isFlagSet(fs, "signature-size") {
+		*signatureSize = val
+	}
+
+	h, ok := signatureAlgorithms[*algorithm]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown signature algorithm: %s\n", *algorithm)
+		return 2
+	}
+
+	var keys, salts []config.SecurityKey
+
+	if err := parseHexKeys(&keys, *keysHex); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid key: %s\n", err)
+		return 2
+	}
+	if err := parseHexKeys(&salts, *saltsHex); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid salt: %s\n", err)
+		return 2
+	}
+	if len(keys) != len(salts) {
+		fmt.Fprintf(os.Stderr, "Number of keys and number of salts should be equal. Keys: %d, salts: %d\n", len(keys), len(salts))
+		return 2
+	}
+
+	if len(*verify) > 0 {
+		return verifyURLSignature(*verify, *pathPrefix, keys, salts, h, *signatureSize)
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	var opts []string
+	if len(*resize) > 0 {
+		opts = append(opts, "rs:"+*resize)
+	}
+	if len(*options) > 0 {
+		opts = append(opts, strings.Trim(*options, "/"))
+	}
+
+	var path string
+	if len(opts) > 0 {
+		path = "/" + strings.Join(opts, "/")
+	}
+
+	if *plain {
+		path += "/plain/" + url.PathEscape(fs.Arg(0))
+		if len(*extension) > 0 {
+			path += "@" + *extension
+		}
+	} else {
+		path += "/" + base64.RawURLEncoding.EncodeToString([]byte(fs.Arg(0)))
+		if len(*extension) > 0 {
+			path += "." + *extension
+		}
+	}
+
+	signature := "insecure"
+	if len(keys) > 0 {
+		signature = base64.RawURLEncoding.EncodeToString(calcSignature(path, keys[0], salts[0], h, *signatureSize))
+	}
+
+	fmt.Printf("%s/%s%s\n", *pathPrefix, signature, path)
+
+	return 0
+}
+
+func verifyURLSignature(path, pathPrefix string, keys, salts []config.SecurityKey, h func() hash.Hash, size int) int {
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "Key and salt are required to verify the signature")
+		return 2
+	}
+
+	if u, err := url.Parse(path); err == nil && len(u.Host) > 0 {
+		path = u.EscapedPath()
+	}
+
+	path = strings.TrimPrefix(path, pathPrefix)
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 {
+		fmt.Fprintln(os.Stderr, "Invalid URL path")
+		return 2
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errInvalidSignatureEncoding.Error())
+		return 1
+	}
+
+	for i := range keys {
+		if hmac.Equal(signature, calcSignature("/"+parts[1], keys[i], salts[i], h, size)) {
+			fmt.Printf("Signature is valid (key/salt pair #%d)\n", i)
+			return 0
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, errInvalidSignature.Error())
+	return 1
+}
+
+func parseHexKeys(keys *[]config.SecurityKey, str string) error {
+	if len(str) == 0 {
+		return nil
+	}
+
+	for _, part := range strings.Split(str, ",") {
+		key, err := hex.DecodeString(part)
+		if err != nil {
+			return err
+		}
+		*keys = append(*keys, config.SecurityKey(key))
+	}
+
+	return nil
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return
+}
@@ -0,0 +1,119 @@
+// Package urlbuilder builds and signs imgproxy URLs.
+// It doesn't depend on libvips and can be used in any Go service:
+//
+//	b, err := urlbuilder.New(os.Getenv("IMGPROXY_KEY"), os.Getenv("IMGPROXY_SALT"))
+//	if err != nil {
+//		return err
+//	}
+//
+//	b.BaseURL = "https://imgproxy.example.com"
+//
+//	po := urlbuilder.NewProcessingOptions().
+//		Resize(urlbuilder.ResizeFill, 300, 400, false).
+//		Gravity(urlbuilder.GravitySmart)
+//
+//	url := b.URL("http://example.com/images/curiosity.jpg", po, "png")
+package urlbuilder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strings"
+)
+
+// Builder builds imgproxy URLs and signs them if the key and the salt are set
+type Builder struct {
+	// BaseURL is prepended to the built paths, e.g. "https://imgproxy.example.com"
+	BaseURL string
+	// PathPrefix should match IMGPROXY_PATH_PREFIX
+	PathPrefix string
+
+	Key  []byte
+	Salt []byte
+	// SignatureSize should match IMGPROXY_SIGNATURE_SIZE. Zero means 32
+	SignatureSize int
+	// Hash is the signature hash function. Nil means SHA-256
+	Hash func() hash.Hash
+
+	// PlainSourceURL makes the builder use plain source URLs instead of base64-encoded ones
+	PlainSourceURL bool
+}
+
+// New creates a builder with the hex-encoded key and salt.
+// If both are empty, the built URLs are not signed
+func New(keyHex, saltHex string) (*Builder, error) {
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("Key expected to be hex-encoded string")
+	}
+
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, fmt.Errorf("Salt expected to be hex-encoded string")
+	}
+
+	if (len(key) == 0) != (len(salt) == 0) {
+		return nil, fmt.Errorf("Both key and salt should be set")
+	}
+
+	return &Builder{Key: key, Salt: salt}, nil
+}
+
+// URL builds the full URL of the processed image
+func (b *Builder) URL(sourceURL string, po *ProcessingOptions, extension string) string {
+	return strings.TrimRight(b.BaseURL, "/") + b.Path(sourceURL, po, extension)
+}
+
+// Path builds the path of the processed image including the path prefix and the signature
+func (b *Builder) Path(sourceURL string, po *ProcessingOptions, extension string) string {
+	var path string
+
+	if po != nil && len(po.opts) > 0 {
+		path = "/" + po.String()
+	}
+
+	if b.PlainSourceURL {
+		path += "/plain/" + url.PathEscape(sourceURL)
+		if len(extension) > 0 {
+			path += "@" + extension
+		}
+	} else {
+		path += "/" + base64.RawURLEncoding.EncodeToString([]byte(sourceURL))
+		if len(extension) > 0 {
+			path += "." + extension
+		}
+	}
+
+	return b.PathPrefix + "/" + b.Sign(path) + path
+}
+
+// Sign calculates the signature of the path. The path should start with a slash
+// and shouldn't contain the path prefix and the signature.
+// If the key and the salt are not set, "insecure" is returned
+func (b *Builder) Sign(path string) string {
+	if len(b.Key) == 0 || len(b.Salt) == 0 {
+		return "insecure"
+	}
+
+	h := b.Hash
+	if h == nil {
+		h = sha256.New
+	}
+
+	mac := hmac.New(h, b.Key)
+	mac.Write(b.Salt)
+	mac.Write([]byte(path))
+
+	signature := mac.Sum(nil)
+
+	if b.SignatureSize > 0 && b.SignatureSize < len(signature) {
+		signature = signature[:b.SignatureSize]
+	}
+
+	return base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,114 @@
+package urlbuilder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type BuilderTestSuite struct{ suite.Suite }
+
+func (s *BuilderTestSuite) sign(h func() hash.Hash, key, salt, path string) string {
+	mac := hmac.New(h, []byte(key))
+	mac.Write([]byte(salt))
+	mac.Write([]byte(path))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *BuilderTestSuite) TestNew() {
+	b, err := New("74657374", "73616c74")
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), []byte("test"), b.Key)
+	assert.Equal(s.T(), []byte("salt"), b.Salt)
+}
+
+func (s *BuilderTestSuite) TestNewInvalidKey() {
+	_, err := New("test", "73616c74")
+
+	assert.Error(s.T(), err)
+}
+
+func (s *BuilderTestSuite) TestNewInvalidSalt() {
+	_, err := New("74657374", "salt")
+
+	assert.Error(s.T(), err)
+}
+
+func (s *BuilderTestSuite) TestNewKeyWithoutSalt() {
+	_, err := New("74657374", "")
+
+	assert.Error(s.T(), err)
+}
+
+func (s *BuilderTestSuite) TestPathInsecure() {
+	b := &Builder{}
+	po := NewProcessingOptions().Resize(ResizeFill, 300, 200, false)
+
+	path := b.Path("http://images.dev/lorem/ipsum.jpg", po, "png")
+
+	assert.Equal(s.T(), "/insecure/rs:fill:300:200:0/aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vaXBzdW0uanBn.png", path)
+}
+
+func (s *BuilderTestSuite) TestPathPlain() {
+	b := &Builder{PlainSourceURL: true}
+
+	path := b.Path("http://images.dev/lorem ipsum.jpg", nil, "png")
+
+	assert.Equal(s.T(), "/insecure/plain/http:%2F%2Fimages.dev%2Florem%20ipsum.jpg@png", path)
+}
+
+func (s *BuilderTestSuite) TestPathSigned() {
+	b := &Builder{Key: []byte("test-key"), Salt: []byte("test-salt")}
+	po := NewProcessingOptions().Width(100)
+
+	path := b.Path("http://images.dev/lorem/ipsum.jpg", po, "")
+
+	unsigned := "/w:100/aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vaXBzdW0uanBn"
+	assert.Equal(s.T(), "/"+s.sign(sha256.New, "test-key", "test-salt", unsigned)+unsigned, path)
+}
+
+func (s *BuilderTestSuite) TestPathPrefix() {
+	b := &Builder{PathPrefix: "/imgproxy"}
+
+	path := b.Path("http://images.dev/lorem/ipsum.jpg", nil, "")
+
+	assert.Equal(s.T(), "/imgproxy/insecure/aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vaXBzdW0uanBn", path)
+}
+
+func (s *BuilderTestSuite) TestURL() {
+	b := &Builder{BaseURL: "https://imgproxy.dev/"}
+
+	url := b.URL("http://images.dev/lorem/ipsum.jpg", nil, "")
+
+	assert.Equal(s.T(), "https://imgproxy.dev/insecure/aHR0cDovL2ltYWdlcy5kZXYvbG9yZW0vaXBzdW0uanBn", url)
+}
+
+func (s *BuilderTestSuite) TestSignSignatureSize() {
+	b := &Builder{Key: []byte("test-key"), Salt: []byte("test-salt"), SignatureSize: 8}
+
+	signature, err := base64.RawURLEncoding.DecodeString(b.Sign("/w:100/plain/local:///ipsum.jpg"))
+
+	require.Nil(s.T(), err)
+	assert.Len(s.T(), signature, 8)
+}
+
+func (s *BuilderTestSuite) TestSignHash() {
+	b := &Builder{Key: []byte("test-key"), Salt: []byte("test-salt"), Hash: sha512.New}
+
+	path := "/w:100/plain/local:///ipsum.jpg"
+
+	assert.Equal(s.T(), s.sign(sha512.New, "test-key", "test-salt", path), b.Sign(path))
+}
+
+func TestBuilder(t *testing.T) {
+	suite.Run(t, new(BuilderTestSuite))
+}
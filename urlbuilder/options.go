@@ -0,0 +1,205 @@
+package urlbuilder
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type ResizingType string
+
+const (
+	ResizeFit  ResizingType = "fit"
+	ResizeFill ResizingType = "fill"
+	ResizeCrop ResizingType = "crop"
+	ResizeAuto ResizingType = "auto"
+)
+
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "ce"
+	GravityNorth     Gravity = "no"
+	GravityEast      Gravity = "ea"
+	GravitySouth     Gravity = "so"
+	GravityWest      Gravity = "we"
+	GravityNorthWest Gravity = "nowe"
+	GravityNorthEast Gravity = "noea"
+	GravitySouthWest Gravity = "sowe"
+	GravitySouthEast Gravity = "soea"
+	GravitySmart     Gravity = "sm"
+	GravityReplicate Gravity = "re"
+)
+
+type Priority string
+
+const (
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
+// ProcessingOptions is a builder of the processing options part of the URL.
+// Options are rendered in the order they were added
+type ProcessingOptions struct {
+	opts []string
+}
+
+func NewProcessingOptions() *ProcessingOptions {
+	return &ProcessingOptions{}
+}
+
+// Option adds an arbitrary option. Use it for options that don't have
+// a dedicated method. Arguments are escaped, so they can contain slashes and colons
+func (po *ProcessingOptions) Option(name string, args ...string) *ProcessingOptions {
+	parts := make([]string, len(args)+1)
+	parts[0] = name
+
+	for i, arg := range args {
+		parts[i+1] = escapeArg(arg)
+	}
+
+	po.opts = append(po.opts, strings.Join(parts, ":"))
+	return po
+}
+
+func (po *ProcessingOptions) Resize(rt ResizingType, width, height int, enlarge bool) *ProcessingOptions {
+	return po.Option("rs", string(rt), itoa(width), itoa(height), btoa(enlarge))
+}
+
+func (po *ProcessingOptions) Size(width, height int, enlarge bool) *ProcessingOptions {
+	return po.Option("s", itoa(width), itoa(height), btoa(enlarge))
+}
+
+func (po *ProcessingOptions) ResizingType(rt ResizingType) *ProcessingOptions {
+	return po.Option("rt", string(rt))
+}
+
+func (po *ProcessingOptions) Width(width int) *ProcessingOptions {
+	return po.Option("w", itoa(width))
+}
+
+func (po *ProcessingOptions) Height(height int) *ProcessingOptions {
+	return po.Option("h", itoa(height))
+}
+
+func (po *ProcessingOptions) Enlarge(enlarge bool) *ProcessingOptions {
+	return po.Option("el", btoa(enlarge))
+}
+
+func (po *ProcessingOptions) Extend(extend bool) *ProcessingOptions {
+	return po.Option("ex", btoa(extend))
+}
+
+func (po *ProcessingOptions) Dpr(dpr float64) *ProcessingOptions {
+	return po.Option("dpr", ftoa(dpr))
+}
+
+func (po *ProcessingOptions) Gravity(g Gravity) *ProcessingOptions {
+	return po.Option("g", string(g))
+}
+
+// FocusPoint sets the focus point gravity. x and y are in the range from 0 to 1
+func (po *ProcessingOptions) FocusPoint(x, y float64) *ProcessingOptions {
+	return po.Option("g", "fp", ftoa(x), ftoa(y))
+}
+
+func (po *ProcessingOptions) Crop(width, height int, g Gravity) *ProcessingOptions {
+	if len(g) == 0 {
+		return po.Option("c", itoa(width), itoa(height))
+	}
+	return po.Option("c", itoa(width), itoa(height), string(g))
+}
+
+func (po *ProcessingOptions) Padding(top, right, bottom, left int) *ProcessingOptions {
+	return po.Option("pd", itoa(top), itoa(right), itoa(bottom), itoa(left))
+}
+
+func (po *ProcessingOptions) Trim(threshold float64) *ProcessingOptions {
+	return po.Option("t", ftoa(threshold))
+}
+
+func (po *ProcessingOptions) Quality(quality int) *ProcessingOptions {
+	return po.Option("q", itoa(quality))
+}
+
+func (po *ProcessingOptions) MaxBytes(maxBytes int) *ProcessingOptions {
+	return po.Option("mb", itoa(maxBytes))
+}
+
+// Background sets the background color as a hex string, e.g. "ff00ff"
+func (po *ProcessingOptions) Background(hexColor string) *ProcessingOptions {
+	return po.Option("bg", strings.TrimPrefix(hexColor, "#"))
+}
+
+func (po *ProcessingOptions) BackgroundRGB(r, g, b uint8) *ProcessingOptions {
+	return po.Option("bg", itoa(int(r)), itoa(int(g)), itoa(int(b)))
+}
+
+func (po *ProcessingOptions) Blur(sigma float64) *ProcessingOptions {
+	return po.Option("bl", ftoa(sigma))
+}
+
+func (po *ProcessingOptions) Sharpen(sigma float64) *ProcessingOptions {
+	return po.Option("sh", ftoa(sigma))
+}
+
+func (po *ProcessingOptions) Watermark(opacity float64, position Gravity, xOffset, yOffset int, scale float64) *ProcessingOptions {
+	return po.Option("wm", ftoa(opacity), string(position), itoa(xOffset), itoa(yOffset), ftoa(scale))
+}
+
+func (po *ProcessingOptions) Preset(presets ...string) *ProcessingOptions {
+	return po.Option("pr", presets...)
+}
+
+func (po *ProcessingOptions) CacheBuster(buster string) *ProcessingOptions {
+	return po.Option("cb", buster)
+}
+
+func (po *ProcessingOptions) StripMetadata(strip bool) *ProcessingOptions {
+	return po.Option("sm", btoa(strip))
+}
+
+func (po *ProcessingOptions) Filename(filename string) *ProcessingOptions {
+	return po.Option("fn", filename)
+}
+
+func (po *ProcessingOptions) Format(format string) *ProcessingOptions {
+	return po.Option("f", format)
+}
+
+func (po *ProcessingOptions) Nonce(nonce string) *ProcessingOptions {
+	return po.Option("nc", nonce)
+}
+
+func (po *ProcessingOptions) Timeout(seconds int) *ProcessingOptions {
+	return po.Option("tm", itoa(seconds))
+}
+
+func (po *ProcessingOptions) Priority(p Priority) *ProcessingOptions {
+	return po.Option("prt", string(p))
+}
+
+func (po *ProcessingOptions) String() string {
+	return strings.Join(po.opts, "/")
+}
+
+// escapeArg escapes the option argument. url.PathEscape doesn't escape colons
+// since they're allowed in paths, but colons separate the arguments
+func escapeArg(arg string) string {
+	return strings.Replace(url.PathEscape(arg), ":", "%3A", -1)
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func btoa(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
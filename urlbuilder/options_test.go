@@ -0,0 +1,59 @@
+package urlbuilder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type OptionsTestSuite struct{ suite.Suite }
+
+func (s *OptionsTestSuite) TestString() {
+	po := NewProcessingOptions().
+		Resize(ResizeFit, 300, 200, true).
+		Gravity(GravitySmart).
+		Quality(80)
+
+	assert.Equal(s.T(), "rs:fit:300:200:1/g:sm/q:80", po.String())
+}
+
+func (s *OptionsTestSuite) TestFloatArgs() {
+	po := NewProcessingOptions().
+		FocusPoint(0.25, 0.5).
+		Dpr(2)
+
+	assert.Equal(s.T(), "g:fp:0.25:0.5/dpr:2", po.String())
+}
+
+func (s *OptionsTestSuite) TestCropWithoutGravity() {
+	po := NewProcessingOptions().Crop(100, 50, "")
+
+	assert.Equal(s.T(), "c:100:50", po.String())
+}
+
+func (s *OptionsTestSuite) TestBackground() {
+	po := NewProcessingOptions().
+		Background("#ff00ff").
+		BackgroundRGB(255, 0, 255)
+
+	assert.Equal(s.T(), "bg:ff00ff/bg:255:0:255", po.String())
+}
+
+func (s *OptionsTestSuite) TestEscapedArgs() {
+	po := NewProcessingOptions().
+		Filename("lorem/ipsum: dolor").
+		CacheBuster("a/b:c")
+
+	assert.Equal(s.T(), "fn:lorem%2Fipsum%3A%20dolor/cb:a%2Fb%3Ac", po.String())
+}
+
+func (s *OptionsTestSuite) TestPreset() {
+	po := NewProcessingOptions().Preset("thumb", "sharp")
+
+	assert.Equal(s.T(), "pr:thumb:sharp", po.String())
+}
+
+func TestOptions(t *testing.T) {
+	suite.Run(t, new(OptionsTestSuite))
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/urlbuilder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type URLBuilderTestSuite struct{ MainTestSuite }
+
+func (s *URLBuilderTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+	conf.Salts = []config.SecurityKey{config.SecurityKey("test-salt")}
+	conf.AllowInsecure = false
+	conf.SignatureSize = 32
+	conf.SignatureAlgorithms = []string{"sha256"}
+}
+
+func (s *URLBuilderTestSuite) builder() *urlbuilder.Builder {
+	return &urlbuilder.Builder{Key: []byte("test-key"), Salt: []byte("test-salt")}
+}
+
+func (s *URLBuilderTestSuite) TestSignedPathIsValid() {
+	po := urlbuilder.NewProcessingOptions().
+		Resize(urlbuilder.ResizeFill, 300, 200, false).
+		Gravity(urlbuilder.GravitySmart)
+
+	for _, plain := range []bool{false, true} {
+		b := s.builder()
+		b.PlainSourceURL = plain
+
+		path := b.Path("http://images.dev/lorem/ipsum.jpg", po, "png")
+		parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+
+		_, err := validatePath(parts[0], "/"+parts[1])
+		assert.Nil(s.T(), err, path)
+	}
+}
+
+func (s *URLBuilderTestSuite) TestEscapedArgs() {
+	po := urlbuilder.NewProcessingOptions().
+		Filename("lorem/ipsum: dolor").
+		CacheBuster("a/b:c")
+
+	path := s.builder().Path("http://images.dev/lorem/ipsum.jpg", po, "")
+
+	req := s.getRequest(path)
+
+	imgURL, parsed, err := parsePath(context.Background(), req)
+
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), "http://images.dev/lorem/ipsum.jpg", imgURL)
+	assert.Equal(s.T(), "lorem/ipsum: dolor", parsed.Filename)
+	assert.Equal(s.T(), "a%2Fb%3Ac", parsed.CacheBuster)
+}
+
+func TestURLBuilder(t *testing.T) {
+	suite.Run(t, new(URLBuilderTestSuite))
+}
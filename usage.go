@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+)
+
+var (
+	usageCtxKey = ctxKey("usage")
+
+	usageMutex    sync.Mutex
+	usageCounters = make(map[string]*usageCounter)
+)
+
+// usageCounter holds the usage of a single subject. Requests and Bytes are
+// counted since the start or the last reset, period counters are used
+// to check quotas and are reset when the quota period ends
+type usageCounter struct {
+	Requests       int64     `json:"requests"`
+	Bytes          int64     `json:"bytes"`
+	PeriodRequests int64     `json:"period_requests"`
+	PeriodBytes    int64     `json:"period_bytes"`
+	PeriodStart    time.Time `json:"period_start"`
+}
+
+// usageRecord is put to the request context to let the path parser
+// set the usage subject of the request
+type usageRecord struct {
+	Subject string
+}
+
+// usageResponseWriter remembers the response status code and counts sent bytes
+type usageResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode int
+	bytes      int
+}
+
+func (w *usageResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *usageResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
+
+// withUsage counts successful requests and sent bytes of the request usage subject
+func withUsage(h routeHandler) routeHandler {
+	if !conf.UsageAccounting {
+		return h
+	}
+
+	return func(reqID string, rw http.ResponseWriter, r *http.Request) {
+		rec := new(usageRecord)
+		uw := &usageResponseWriter{ResponseWriter: rw, statusCode: 200}
+
+		h(reqID, uw, r.WithContext(context.WithValue(r.Context(), usageCtxKey, rec)))
+
+		if len(rec.Subject) > 0 && uw.statusCode < 400 {
+			addUsage(rec.Subject, uw.bytes)
+		}
+	}
+}
+
+// usageSubject returns the usage subject for the request tenant or the signature key.
+// Keys are identified by the hash prefix so they aren't exposed
+func usageSubject(t *tenant, pairInd int) string {
+	if t != nil {
+		return "tenant:" + t.Name
+	}
+
+	keysMutex.RLock()
+	defer keysMutex.RUnlock()
+
+	if pairInd >= 0 && pairInd < len(conf.Keys) {
+		return "key:" + usageKeyID(conf.Keys[pairInd])
+	}
+
+	return "default"
+}
+
+func usageKeyID(key config.SecurityKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:6])
+}
+
+// setUsageSubject sets the usage subject of the request and checks
+// if the subject has exceeded its quota
+func setUsageSubject(ctx context.Context, t *tenant, pairInd int) error {
+	rec, ok := ctx.Value(usageCtxKey).(*usageRecord)
+	if !ok {
+		return nil
+	}
+
+	rec.Subject = usageSubject(t, pairInd)
+
+	quotaRequests, quotaBytes := conf.UsageQuotaRequests, conf.UsageQuotaBytes
+	if t != nil && t.QuotaRequests > 0 {
+		quotaRequests = t.QuotaRequests
+	}
+	if t != nil && t.QuotaBytes > 0 {
+		quotaBytes = t.QuotaBytes
+	}
+
+	return checkUsageQuota(rec.Subject, quotaRequests, quotaBytes)
+}
+
+func checkUsageQuota(subject string, quotaRequests, quotaBytes int) error {
+	if quotaRequests <= 0 && quotaBytes <= 0 {
+		return nil
+	}
+
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	c, ok := usageCounters[subject]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now()
+	resetUsagePeriod(c, now)
+
+	if (quotaRequests > 0 && c.PeriodRequests >= int64(quotaRequests)) ||
+		(quotaBytes > 0 && c.PeriodBytes >= int64(quotaBytes)) {
+		retryAfter := c.PeriodStart.Add(usageQuotaPeriod()).Sub(now)
+
+		return ierrors.New(429, "Usage quota exceeded", "Usage quota exceeded").
+			SetRetryAfter(int(retryAfter/time.Second) + 1)
+	}
+
+	return nil
+}
+
+func addUsage(subject string, bytes int) {
+	if prometheusEnabled {
+		addPrometheusUsage(subject, bytes)
+	}
+
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	c, ok := usageCounters[subject]
+	if !ok {
+		c = new(usageCounter)
+		usageCounters[subject] = c
+	}
+
+	resetUsagePeriod(c, time.Now())
+
+	c.Requests++
+	c.Bytes += int64(bytes)
+	c.PeriodRequests++
+	c.PeriodBytes += int64(bytes)
+}
+
+func usageQuotaPeriod() time.Duration {
+	return time.Duration(conf.UsageQuotaPeriod) * time.Second
+}
+
+// resetUsagePeriod resets the period counters if the quota period has ended.
+// Periods are aligned to the Unix epoch, so all the subjects share the same periods
+func resetUsagePeriod(c *usageCounter, now time.Time) {
+	start := now.Truncate(usageQuotaPeriod())
+
+	if !c.PeriodStart.Equal(start) {
+		c.PeriodStart = start
+		c.PeriodRequests = 0
+		c.PeriodBytes = 0
+	}
+}
+
+// getUsage returns the copy of the usage counters. If reset is true,
+// total counters are reset while the period counters are kept to keep
+// checking quotas
+func getUsage(reset bool) map[string]usageCounter {
+	usageMutex.Lock()
+	defer usageMutex.Unlock()
+
+	now := time.Now()
+	res := make(map[string]usageCounter, len(usageCounters))
+
+	for subject, c := range usageCounters {
+		resetUsagePeriod(c, now)
+
+		res[subject] = *c
+
+		if reset {
+			c.Requests = 0
+			c.Bytes = 0
+		}
+	}
+
+	return res
+}
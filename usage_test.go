@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type UsageTestSuite struct{ MainTestSuite }
+
+func (s *UsageTestSuite) SetupTest() {
+	s.MainTestSuite.SetupTest()
+
+	conf.UsageAccounting = true
+	usageCounters = make(map[string]*usageCounter)
+}
+
+func (s *UsageTestSuite) serveUsageRequest(status int, body string) {
+	h := withUsage(func(reqID string, rw http.ResponseWriter, r *http.Request) {
+		if err := setUsageSubject(r.Context(), nil, -1); err != nil {
+			panic(err)
+		}
+
+		rw.WriteHeader(status)
+		rw.Write([]byte(body))
+	})
+
+	h("test", httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func (s *UsageTestSuite) TestCountUsage() {
+	s.serveUsageRequest(200, "hello")
+	s.serveUsageRequest(200, "world!")
+	s.serveUsageRequest(404, "not found")
+
+	usage := getUsage(false)
+
+	assert.Equal(s.T(), int64(2), usage["default"].Requests)
+	assert.Equal(s.T(), int64(11), usage["default"].Bytes)
+}
+
+func (s *UsageTestSuite) TestResetUsage() {
+	s.serveUsageRequest(200, "hello")
+
+	assert.Equal(s.T(), int64(1), getUsage(true)["default"].Requests)
+
+	usage := getUsage(false)
+
+	assert.Equal(s.T(), int64(0), usage["default"].Requests)
+	assert.Equal(s.T(), int64(1), usage["default"].PeriodRequests)
+}
+
+func (s *UsageTestSuite) TestQuotaExceeded() {
+	conf.UsageQuotaRequests = 2
+
+	s.serveUsageRequest(200, "hello")
+	s.serveUsageRequest(200, "hello")
+
+	ctx := context.WithValue(context.Background(), usageCtxKey, new(usageRecord))
+	err := setUsageSubject(ctx, nil, -1)
+
+	require.Error(s.T(), err)
+	assert.Equal(s.T(), 429, err.(*ierrors.Error).StatusCode)
+	assert.True(s.T(), err.(*ierrors.Error).RetryAfter > 0)
+}
+
+func (s *UsageTestSuite) TestTenantQuota() {
+	conf.UsageQuotaBytes = 1
+
+	t := &tenant{Name: "acme", QuotaBytes: 100}
+	addUsage(usageSubject(t, -1), 10)
+
+	ctx := context.WithValue(context.Background(), usageCtxKey, new(usageRecord))
+
+	require.Nil(s.T(), setUsageSubject(ctx, t, -1))
+}
+
+func (s *UsageTestSuite) TestKeySubject() {
+	conf.Keys = []config.SecurityKey{config.SecurityKey("test-key")}
+
+	assert.Regexp(s.T(), "^key:[0-9a-f]{12}$", usageSubject(nil, 0))
+}
+
+func TestUsage(t *testing.T) {
+	suite.Run(t, new(UsageTestSuite))
+}
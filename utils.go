@@ -1,7 +1,10 @@
 package main
 
 import (
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"unsafe"
 )
@@ -20,6 +23,13 @@ func minInt(a, b int) int {
 	return b
 }
 
+func absInt32(a int32) int32 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
 func minNonZeroInt(a, b int) int {
 	switch {
 	case a == 0:
@@ -54,3 +64,30 @@ func trimAfter(s string, sep byte) string {
 func ptrToBytes(ptr unsafe.Pointer, size int) []byte {
 	return (*[math.MaxInt32]byte)(ptr)[:int(size):int(size)]
 }
+
+// writeFileAtomic writes data to a temporary file and renames it,
+// so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	// Keep the permissions of the existing file
+	if stat, serr := os.Stat(path); serr == nil {
+		f.Chmod(stat.Mode())
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
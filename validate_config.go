@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/imgproxy/imgproxy/v2/options"
+	"github.com/imgproxy/imgproxy/v2/vips"
+)
+
+// validateConfigCommand runs the `imgproxy validate-config` command that checks
+// the config, presets, keys, and assets and reports all found problems at once
+func validateConfigCommand() int {
+	// Remove the command name so the flags after it can be parsed by configure
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+
+	log.SetOutput(os.Stdout)
+
+	var problems []error
+
+	if err := initLog(); err != nil {
+		problems = append(problems, err)
+	}
+
+	problems = append(problems, loadConfig()...)
+
+	if err := initAuditLog(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if err := initFonts(); err != nil {
+		problems = append(problems, err)
+	}
+	defer shutdownFonts()
+
+	if err := vips.Init(); err != nil {
+		problems = append(problems, err)
+	} else {
+		defer vips.Shutdown()
+
+		if err := options.CheckPresets(options.AllPresets()); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if _, err := loadErrorResponses(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if err := initDownloading(); err != nil {
+		problems = append(problems, err)
+	} else {
+		for _, a := range assets {
+			if _, err := a.load(); err != nil {
+				problems = append(problems, fmt.Errorf("Can't load %s: %s", a.desc, err))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Println("Config is valid")
+		return 0
+	}
+
+	for _, err := range problems {
+		fmt.Fprintln(os.Stderr, strings.TrimSpace(err.Error()))
+	}
+
+	fmt.Fprintf(os.Stderr, "Found %d problem(s)\n", len(problems))
+
+	return 1
+}
@@ -1,9 +1,11 @@
-package main
+package vips
 
 import (
 	"encoding/base64"
 	"io/ioutil"
 	"strings"
+
+	"github.com/sirupsen/logrus"
 )
 
 var _cmykProfilePath string
@@ -20058,7 +20060,7 @@ func cmykProfilePath() (string, error) {
 		f.Close()
 
 		_cmykProfilePath = f.Name()
-		logNotice("CMYK profile was written to %v", _cmykProfilePath)
+		logrus.Infof("CMYK profile was written to %v", _cmykProfilePath)
 	}
 
 	return _cmykProfilePath, nil
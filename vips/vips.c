@@ -43,6 +43,9 @@
 #define VIPS_SUPPORT_FIND_TRIM \
   (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 6))
 
+#define VIPS_SUPPORT_FRAME_DELAYS \
+  (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 9))
+
 #define EXIF_ORIENTATION "exif-ifd0-Orientation"
 
 #if (VIPS_MAJOR_VERSION > 8 || (VIPS_MAJOR_VERSION == 8 && VIPS_MINOR_VERSION >= 8))
@@ -176,9 +179,9 @@ vips_svgload_go(void *buf, size_t len, double scale, VipsImage **out) {
 }
 
 int
-vips_heifload_go(void *buf, size_t len, VipsImage **out) {
+vips_heifload_go(void *buf, size_t len, int thumbnail, VipsImage **out) {
 #if VIPS_SUPPORT_HEIF
-  return vips_heifload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, NULL);
+  return vips_heifload_buffer(buf, len, out, "access", VIPS_ACCESS_SEQUENTIAL, "thumbnail", thumbnail, NULL);
 #else
   vips_error("vips_heifload_go", "Loading HEIF is not supported (libvips 8.8+ reuired)");
   return 1;
@@ -226,6 +229,24 @@ vips_get_orientation(VipsImage *image) {
 	return 1;
 }
 
+int
+vips_get_frame_delays(VipsImage *image, int **out, int *n) {
+#if VIPS_SUPPORT_FRAME_DELAYS
+  if (vips_image_get_typeof(image, "delay") == VIPS_TYPE_ARRAY_INT)
+    return vips_image_get_array_int(image, "delay", out, n);
+#endif
+
+  vips_error("vips_get_frame_delays", "Frame delays are not available (libvips 8.9+ required)");
+  return 1;
+}
+
+void
+vips_set_frame_delays(VipsImage *image, int *delays, int n) {
+#if VIPS_SUPPORT_FRAME_DELAYS
+  vips_image_set_array_int(image, "delay", delays, n);
+#endif
+}
+
 int
 vips_support_smartcrop() {
   return VIPS_SUPPORT_SMARTCROP;
@@ -361,6 +382,20 @@ vips_icc_import_go(VipsImage *in, VipsImage **out, char *profile) {
   return 0;
 }
 
+int
+vips_icc_embed_srgb_go(VipsImage *in, VipsImage **out, int depth) {
+#if VIPS_SUPPORT_BUILTIN_ICC
+  return vips_icc_transform(in, out, "srgb", "input_profile", "srgb", "embedded", FALSE, "depth", depth, NULL);
+#else
+  return vips_copy(in, out, NULL);
+#endif
+}
+
+int
+vips_icc_export_go(VipsImage *in, VipsImage **out, char *profile, int depth) {
+  return vips_icc_export(in, out, "output_profile", profile, "depth", depth, NULL);
+}
+
 int
 vips_colourspace_go(VipsImage *in, VipsImage **out, VipsInterpretation cs) {
   return vips_colourspace(in, out, cs, NULL);
@@ -396,10 +431,174 @@ vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma) {
   return vips_sharpen(in, out, "sigma", sigma, NULL);
 }
 
+// vips_white_balance_go scales color bands so their averages become equal
+// (the gray world assumption). Gains are limited to not amplify the noise
+// of nearly absent colors
+int
+vips_white_balance_go(VipsImage *in, VipsImage **out) {
+  int color_bands = vips_image_hasalpha_go(in) ? in->Bands - 1 : in->Bands;
+
+  if (color_bands != 3)
+    return vips_copy(in, out, NULL);
+
+  double mean[3];
+
+  if (vips_average_color_go(in, &mean[0], &mean[1], &mean[2]))
+    return 1;
+
+  double avg = (mean[0] + mean[1] + mean[2]) / 3;
+
+  double a[4] = {1, 1, 1, 1};
+  double b[4] = {0, 0, 0, 0};
+
+  for (int i = 0; i < 3; i++)
+    if (mean[i] > 0)
+      a[i] = VIPS_CLIP(0.5, avg / mean[i], 2.0);
+
+  VipsImage *tmp;
+
+  if (vips_linear(in, &tmp, a, b, in->Bands, NULL))
+    return 1;
+
+  int res = vips_cast(tmp, out, in->BandFmt, NULL);
+  clear_image(&tmp);
+
+  return res;
+}
+
+// vips_brightness_stats_go calculates the average brightness and
+// the brightness values of 0.5 and 99.5 percentiles
 int
-vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b) {
+vips_brightness_stats_go(VipsImage *in, double *mean, int *lo, int *hi) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  int color_bands = vips_image_hasalpha_go(in) ? in->Bands - 1 : in->Bands;
+
+  int res =
+    vips_extract_band(in, &t[0], 0, "n", color_bands, NULL) ||
+    vips_bandmean(t[0], &t[1], NULL) ||
+    vips_cast(t[1], &t[2], in->BandFmt, NULL) ||
+    vips_avg(t[2], mean, NULL) ||
+    vips_percent(t[2], 0.5, lo, NULL) ||
+    vips_percent(t[2], 99.5, hi, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+// vips_levels_go stretches the [lo, hi] range of color bands to [0, max]
+// and applies gamma correction. Alpha is kept unchanged
+int
+vips_levels_go(VipsImage *in, VipsImage **out, double lo, double hi, double gamma, double max) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  gboolean has_alpha = vips_image_hasalpha_go(in);
+  int color_bands = has_alpha ? in->Bands - 1 : in->Bands;
+
+  double scale = max / (hi - lo);
+
+  // Casting to the source format clips values out of [0, max]
+  // so they can be raised to a power
+  int res =
+    vips_extract_band(in, &t[0], 0, "n", color_bands, NULL) ||
+    vips_linear1(t[0], &t[1], scale, -lo * scale, NULL) ||
+    vips_cast(t[1], &t[2], in->BandFmt, NULL) ||
+    vips_linear1(t[2], &t[3], 1.0 / max, 0, NULL) ||
+    vips_pow_const1(t[3], &t[4], gamma, NULL) ||
+    vips_linear1(t[4], &t[5], max, 0, NULL) ||
+    vips_cast(t[5], &t[6], in->BandFmt, NULL);
+
+  if (!res) {
+    if (has_alpha)
+      res =
+        vips_extract_band(in, &t[7], color_bands, "n", 1, NULL) ||
+        vips_bandjoin2(t[6], t[7], out, NULL);
+    else
+      res = vips_copy(t[6], out, NULL);
+  }
+
+  clear_image(&base);
+
+  return res;
+}
+
+// vips_equalize_go equalizes the brightness histogram. The same lookup table
+// is applied to all color bands, so colors don't shift. Alpha is kept unchanged
+int
+vips_equalize_go(VipsImage *in, VipsImage **out) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  gboolean has_alpha = vips_image_hasalpha_go(in);
+  int color_bands = has_alpha ? in->Bands - 1 : in->Bands;
+
+  int res =
+    vips_extract_band(in, &t[0], 0, "n", color_bands, NULL) ||
+    vips_bandmean(t[0], &t[1], NULL) ||
+    vips_cast(t[1], &t[2], in->BandFmt, NULL) ||
+    vips_hist_find(t[2], &t[3], NULL) ||
+    vips_hist_cum(t[3], &t[4], NULL) ||
+    vips_hist_norm(t[4], &t[5], NULL) ||
+    vips_maplut(t[0], &t[6], t[5], NULL);
+
+  if (!res) {
+    if (has_alpha)
+      res =
+        vips_extract_band(in, &t[7], color_bands, "n", 1, NULL) ||
+        vips_bandjoin2(t[6], t[7], out, NULL);
+    else
+      res = vips_copy(t[6], out, NULL);
+  }
+
+  clear_image(&base);
+
+  return res;
+}
+
+// vips_posterize_go reduces the number of tonal levels of each color band
+// using a lookup table. Alpha is kept unchanged
+int
+vips_posterize_go(VipsImage *in, VipsImage **out, int levels) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 8);
+
+  gboolean has_alpha = vips_image_hasalpha_go(in);
+  int color_bands = has_alpha ? in->Bands - 1 : in->Bands;
+
+  gboolean ushort = in->BandFmt == VIPS_FORMAT_USHORT;
+  double max = ushort ? 65535 : 255;
+  double step = max / (levels - 1);
+
+  int res =
+    vips_identity(&t[0], "ushort", ushort, NULL) ||
+    vips_linear1(t[0], &t[1], 1.0 / step, 0, NULL) ||
+    vips_round(t[1], &t[2], VIPS_OPERATION_ROUND_RINT, NULL) ||
+    vips_linear1(t[2], &t[3], step, 0, NULL) ||
+    vips_cast(t[3], &t[4], in->BandFmt, NULL) ||
+    vips_extract_band(in, &t[5], 0, "n", color_bands, NULL) ||
+    vips_maplut(t[5], &t[6], t[4], NULL);
+
+  if (!res) {
+    if (has_alpha)
+      res =
+        vips_extract_band(in, &t[7], color_bands, "n", 1, NULL) ||
+        vips_bandjoin2(t[6], t[7], out, NULL);
+    else
+      res = vips_copy(t[6], out, NULL);
+  }
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b, double max_alpha) {
   VipsArrayDouble *bg = vips_array_double_newv(3, r, g, b);
-  int res = vips_flatten(in, out, "background", bg, NULL);
+  int res = vips_flatten(in, out, "background", bg, "max_alpha", max_alpha, NULL);
   vips_area_unref((VipsArea *)bg);
   return res;
 }
@@ -524,6 +723,82 @@ vips_ensure_alpha(VipsImage *in, VipsImage **out) {
   return vips_bandjoin_const1(in, out, 255, NULL);
 }
 
+int
+vips_average_color_go(VipsImage *in, double *r, double *g, double *b) {
+  double *out[3] = {r, g, b};
+  VipsImage *band;
+
+  for (int i = 0; i < 3; i++) {
+    // Grayscale images have a single color band
+    int ind = in->Bands >= 3 ? i : 0;
+
+    if (vips_extract_band(in, &band, ind, NULL))
+      return 1;
+
+    int res = vips_avg(band, out[i], NULL);
+    clear_image(&band);
+
+    if (res)
+      return 1;
+  }
+
+  return 0;
+}
+
+int
+vips_grayscale_thumbnail_go(VipsImage *in, VipsImage **out, int width, int height) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  // Alpha channel is dropped by extracting the first band
+  int res =
+    vips_colourspace(in, &t[0], VIPS_INTERPRETATION_B_W, NULL) ||
+    vips_extract_band(t[0], &t[1], 0, NULL) ||
+    vips_resize(t[1], &t[2], (double)width / in->Xsize, "vscale", (double)height / in->Ysize, NULL) ||
+    vips_cast(t[2], out, VIPS_FORMAT_UCHAR, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_remap_go(VipsImage *in, VipsImage **out, float *index, int width, int height) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 1);
+
+  // The index contains the integer source coordinates of each pixel,
+  // so the nearest interpolation just copies the pixels
+  if (!(t[0] = vips_image_new_from_memory_copy(index, (size_t)width * height * 2 * sizeof(float), width, height, 2, VIPS_FORMAT_FLOAT))) {
+    clear_image(&base);
+    return 1;
+  }
+
+  int res = vips_mapim(in, out, t[0], "interpolate", vips_interpolate_nearest_static(), NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_rgb_sample_go(VipsImage *in, VipsImage **out, int factor) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  // Nearest neighbour subsampling keeps the exact pixel values,
+  // alpha channel is dropped
+  int res =
+    vips_colourspace(in, &t[0], VIPS_INTERPRETATION_sRGB, NULL) ||
+    vips_extract_band(t[0], &t[1], 0, "n", 3, NULL) ||
+    vips_subsample(t[1], &t[2], factor, factor, NULL) ||
+    vips_cast(t[2], out, VIPS_FORMAT_UCHAR, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
 int
 vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity) {
 #if VIPS_SUPPORT_COMPOSITE
@@ -560,21 +835,181 @@ vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, doubl
 #endif
 }
 
+int
+vips_caption_go(VipsImage **out, const char *text, const char *font, int width, VipsAlign align, int padding, double r, double g, double b, int shadow) {
+#if VIPS_SUPPORT_COMPOSITE
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 12);
+
+  double color[3] = {r, g, b};
+  double black[3] = {0, 0, 0};
+
+  // vips_text expects Pango markup, so the plain text should be escaped
+  char *markup = g_markup_escape_text(text, -1);
+
+  if (vips_text(&t[0], markup, "font", font, "width", width, "align", align, "dpi", 72, NULL)) {
+    g_free(markup);
+    clear_image(&base);
+    return 1;
+  }
+
+  g_free(markup);
+
+  int w = t[0]->Xsize + padding * 2 + shadow;
+  int h = t[0]->Ysize + padding * 2 + shadow;
+
+  if (
+    vips_embed(t[0], &t[1], padding, padding, w, h, NULL) ||
+    !(t[2] = vips_image_new_from_image(t[1], color, 3)) ||
+    vips_bandjoin2(t[2], t[1], &t[3], NULL) ||
+    vips_copy(t[3], &t[4], "interpretation", VIPS_INTERPRETATION_sRGB, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  if (shadow <= 0) {
+    int res = vips_copy(t[4], out, NULL);
+    clear_image(&base);
+    return res;
+  }
+
+  if (
+    vips_embed(t[0], &t[5], padding + shadow, padding + shadow, w, h, NULL) ||
+    vips_gaussblur(t[5], &t[6], shadow, NULL) ||
+    vips_linear1(t[6], &t[7], 0.6, 0, "uchar", TRUE, NULL) ||
+    !(t[8] = vips_image_new_from_image(t[7], black, 3)) ||
+    vips_bandjoin2(t[8], t[7], &t[9], NULL) ||
+    vips_copy(t[9], &t[10], "interpretation", VIPS_INTERPRETATION_sRGB, NULL) ||
+    vips_composite2(t[10], t[4], &t[11], VIPS_BLEND_MODE_OVER, NULL) ||
+    vips_cast(t[11], out, VIPS_FORMAT_UCHAR, NULL)
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  clear_image(&base);
+
+  return 0;
+#else
+  vips_error("vips_caption_go", "Captions are not supported (libvips 8.6+ reuired)");
+  return 1;
+#endif
+}
+
+int
+vips_solid_go(VipsImage **out, int width, int height, double r, double g, double b) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  double ones[3] = {1, 1, 1};
+  double color[3] = {r, g, b};
+
+  int res =
+    vips_black(&t[0], width, height, "bands", 3, NULL) ||
+    vips_linear(t[0], &t[1], ones, color, 3, "uchar", TRUE, NULL) ||
+    vips_copy(t[1], out, "interpretation", VIPS_INTERPRETATION_sRGB, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_checkerboard_go(VipsImage **out, int width, int height, int cell, double r1, double g1, double b1, double r2, double g2, double b2) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 7);
+
+  unsigned char pattern[4] = {255, 0, 0, 255};
+  double color1[3] = {r1, g1, b1};
+  double color2[3] = {r2, g2, b2};
+
+  int across = (width + cell * 2 - 1) / (cell * 2);
+  int down = (height + cell * 2 - 1) / (cell * 2);
+
+  if (
+    !(t[0] = vips_image_new_from_memory_copy(pattern, 4, 2, 2, 1, VIPS_FORMAT_UCHAR)) ||
+    vips_zoom(t[0], &t[1], cell, cell, NULL) ||
+    vips_replicate(t[1], &t[2], across, down, NULL) ||
+    vips_crop(t[2], &t[3], 0, 0, width, height, NULL) ||
+    !(t[4] = vips_image_new_from_image(t[3], color1, 3)) ||
+    !(t[5] = vips_image_new_from_image(t[3], color2, 3))
+  ) {
+    clear_image(&base);
+    return 1;
+  }
+
+  int res =
+    vips_ifthenelse(t[3], t[4], t[5], &t[6], NULL) ||
+    vips_copy(t[6], out, "interpretation", VIPS_INTERPRETATION_sRGB, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
+int
+vips_soft_fill_go(VipsImage **out, int width, int height, double r, double g, double b) {
+  VipsImage *base = vips_image_new();
+  VipsImage **t = (VipsImage **) vips_object_local_array(VIPS_OBJECT(base), 3);
+
+  // Shades of the color in a 3x3 grid, the grid is enlarged with interpolation
+  // to get the blurry look
+  double shades[9] = {1.10, 0.96, 1.04, 0.94, 1.00, 0.90, 1.06, 0.92, 0.98};
+  unsigned char pixels[27];
+
+  for (int i = 0; i < 9; i++) {
+    pixels[i * 3] = VIPS_CLIP(0, r * shades[i], 255);
+    pixels[i * 3 + 1] = VIPS_CLIP(0, g * shades[i], 255);
+    pixels[i * 3 + 2] = VIPS_CLIP(0, b * shades[i], 255);
+  }
+
+  if (!(t[0] = vips_image_new_from_memory_copy(pixels, 27, 3, 3, 3, VIPS_FORMAT_UCHAR))) {
+    clear_image(&base);
+    return 1;
+  }
+
+  int res =
+    vips_resize(t[0], &t[1], (double)width / 3, "vscale", (double)height / 3, NULL) ||
+    vips_crop(t[1], &t[2], 0, 0, VIPS_MIN(width, t[1]->Xsize), VIPS_MIN(height, t[1]->Ysize), NULL) ||
+    vips_copy(t[2], out, "interpretation", VIPS_INTERPRETATION_sRGB, NULL);
+
+  clear_image(&base);
+
+  return res;
+}
+
 int
 vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n) {
   return vips_arrayjoin(in, out, n, "across", 1, NULL);
 }
 
 int
-vips_jpegsave_go(VipsImage *in, VipsTarget *target, int quality, int interlace, gboolean strip) {
-  return vips_jpegsave_target(in, target, "profile", "none", "Q", quality, "strip", strip, "optimize_coding", TRUE, "interlace", interlace, NULL);
+vips_collage_go(VipsImage **in, VipsImage **out, int n, int across, int shim, double r, double g, double b) {
+  VipsArrayDouble *bg = vips_array_double_newv(3, r, g, b);
+  int res = vips_arrayjoin(
+    in, out, n,
+    "across", across,
+    "shim", shim,
+    "background", bg,
+    "halign", VIPS_ALIGN_CENTRE,
+    "valign", VIPS_ALIGN_CENTRE,
+    NULL);
+  vips_area_unref((VipsArea *)bg);
+  return res;
+}
+
+int
+vips_jpegsave_go(VipsImage *in, VipsTarget *target, int quality, int interlace, gboolean strip, gboolean keep_profile) {
+  // NULL profile means the embedded one is saved
+  return vips_jpegsave_target(in, target, "profile", keep_profile ? NULL : "none", "Q", quality, "strip", strip, "optimize_coding", TRUE, "interlace", interlace, NULL);
 }
 
 int
-vips_pngsave_go(VipsImage *in, VipsTarget *target, int interlace, int quantize, int colors) {
+vips_pngsave_go(VipsImage *in, VipsTarget *target, int interlace, int quantize, int colors, gboolean keep_profile) {
   return vips_pngsave_target(
     in, target,
-    "profile", "none",
+    "profile", keep_profile ? NULL : "none",
     "filter", VIPS_FOREIGN_PNG_FILTER_NONE,
     "interlace", interlace,
 #if VIPS_SUPPORT_PNG_QUANTIZATION
@@ -585,8 +1020,8 @@ vips_pngsave_go(VipsImage *in, VipsTarget *target, int interlace, int quantize,
 }
 
 int
-vips_webpsave_go(VipsImage *in, VipsTarget *target, int quality, gboolean strip) {
-  return vips_webpsave_target(in, target, "Q", quality, "strip", strip, NULL);
+vips_webpsave_go(VipsImage *in, VipsTarget *target, int quality, gboolean strip, gboolean lossless) {
+  return vips_webpsave_target(in, target, "Q", quality, "strip", strip, "lossless", lossless, NULL);
 }
 
 int
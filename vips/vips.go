@@ -0,0 +1,1175 @@
+// Package vips contains the libvips bindings used by imgproxy.
+package vips
+
+/*
+#cgo pkg-config: vips
+#cgo LDFLAGS: -s -w
+#cgo CFLAGS: -O3
+#include "vips.h"
+*/
+import "C"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"github.com/imgproxy/imgproxy/v2/config"
+	"github.com/imgproxy/imgproxy/v2/ierrors"
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/mattn/go-pointer"
+	"github.com/sirupsen/logrus"
+)
+
+// Image is a libvips image. Methods that transform the image replace
+// VipsImage and release the previous one.
+type Image struct {
+	VipsImage *C.VipsImage
+}
+
+// Color is an RGB color.
+type Color struct{ R, G, B uint8 }
+
+var (
+	supportSmartcrop bool
+	typeSupportLoad  = make(map[imagetype.Type]bool)
+	typeSupportSave  = make(map[imagetype.Type]bool)
+)
+
+var vipsConf struct {
+	JpegProgressive       C.int
+	PngInterlaced         C.int
+	PngQuantize           C.int
+	PngQuantizationColors C.int
+	WatermarkOpacity      C.double
+}
+
+// Rotation angles accepted by Image.Rotate
+const (
+	AngleD0   = C.VIPS_ANGLE_D0
+	AngleD90  = C.VIPS_ANGLE_D90
+	AngleD180 = C.VIPS_ANGLE_D180
+	AngleD270 = C.VIPS_ANGLE_D270
+)
+
+// Init starts libvips and applies the libvips related options of
+// config.Conf. It should be called once before any image is processed.
+func Init() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := C.vips_initialize(); err != 0 {
+		C.vips_shutdown()
+		return fmt.Errorf("unable to start vips!")
+	}
+
+	// libvips cache is disabled by default. Since processing pipeline is fine tuned, we won't get much profit from it.
+	// Enabled cache can cause SIGSEGV on Musl-based systems like Alpine.
+	C.vips_cache_set_max_mem(C.size_t(config.Conf.VipsCacheMaxMem))
+	C.vips_cache_set_max(C.int(config.Conf.VipsCacheMax))
+	C.vips_cache_set_max_files(C.int(config.Conf.VipsCacheMaxFiles))
+
+	C.vips_concurrency_set(C.int(config.Conf.VipsConcurrency))
+
+	// Vector calculations are disabled by default since they cause SIGSEGV sometimes
+	// when working with JPEG. It's better to disable them since profit is quite small
+	if config.Conf.VipsVectorEnabled {
+		C.vips_vector_set_enabled(1)
+	} else {
+		C.vips_vector_set_enabled(0)
+	}
+
+	if len(os.Getenv("IMGPROXY_VIPS_LEAK_CHECK")) > 0 {
+		C.vips_leak_set(C.gboolean(1))
+	}
+
+	if len(os.Getenv("IMGPROXY_VIPS_CACHE_TRACE")) > 0 {
+		C.vips_cache_set_trace(C.gboolean(1))
+	}
+
+	supportSmartcrop = C.vips_support_smartcrop() == 1
+
+	for _, imgtype := range imagetype.Types {
+		typeSupportLoad[imgtype] = int(C.vips_type_find_load_go(C.int(imgtype))) != 0
+		typeSupportSave[imgtype] = int(C.vips_type_find_save_go(C.int(imgtype))) != 0
+	}
+
+	if config.Conf.JpegProgressive {
+		vipsConf.JpegProgressive = C.int(1)
+	}
+
+	if config.Conf.PngInterlaced {
+		vipsConf.PngInterlaced = C.int(1)
+	}
+
+	if config.Conf.PngQuantize {
+		vipsConf.PngQuantize = C.int(1)
+	}
+
+	vipsConf.PngQuantizationColors = C.int(config.Conf.PngQuantizationColors)
+
+	vipsConf.WatermarkOpacity = C.double(config.Conf.WatermarkOpacity)
+
+	return nil
+}
+
+// Shutdown stops libvips
+func Shutdown() {
+	C.vips_shutdown()
+}
+
+// GetMem returns the memory tracked by libvips in bytes
+func GetMem() float64 {
+	return float64(C.vips_tracked_get_mem())
+}
+
+// GetMemHighwater returns the max memory tracked by libvips in bytes
+func GetMemHighwater() float64 {
+	return float64(C.vips_tracked_get_mem_highwater())
+}
+
+// GetAllocs returns the number of active libvips allocations
+func GetAllocs() float64 {
+	return float64(C.vips_tracked_get_allocs())
+}
+
+// Cleanup releases the libvips resources held by the calling thread
+func Cleanup() {
+	C.vips_cleanup()
+}
+
+// SupportsLoad checks if libvips can load images of the type
+func SupportsLoad(imgtype imagetype.Type) bool {
+	return typeSupportLoad[imgtype]
+}
+
+// SupportsSave checks if libvips can save images of the type
+func SupportsSave(imgtype imagetype.Type) bool {
+	return typeSupportSave[imgtype]
+}
+
+// SupportsSmartcrop checks if libvips supports smart crop
+func SupportsSmartcrop() bool {
+	return supportSmartcrop
+}
+
+func vipsError() error {
+	return ierrors.NewUnexpected(C.GoString(C.vips_error_buffer()), 1)
+}
+
+func gbool(b bool) C.gboolean {
+	if b {
+		return C.gboolean(1)
+	}
+	return C.gboolean(0)
+}
+
+func (img *Image) Width() int {
+	return int(img.VipsImage.Xsize)
+}
+
+func (img *Image) Height() int {
+	return int(img.VipsImage.Ysize)
+}
+
+func (img *Image) Bands() int {
+	return int(img.VipsImage.Bands)
+}
+
+// BandSize returns the size of a single band value in bytes
+func (img *Image) BandSize() int {
+	return int(C.vips_format_sizeof(img.VipsImage.BandFmt))
+}
+
+func (img *Image) Load(data []byte, imgtype imagetype.Type, shrink int, scale float64, pages int) error {
+	var tmp *C.VipsImage
+
+	err := C.int(0)
+
+	switch imgtype {
+	case imagetype.JPEG:
+		err = C.vips_jpegload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(shrink), &tmp)
+	case imagetype.PNG:
+		err = C.vips_pngload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
+	case imagetype.WEBP:
+		err = C.vips_webpload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.double(scale), C.int(pages), &tmp)
+	case imagetype.GIF:
+		err = C.vips_gifload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.int(pages), &tmp)
+	case imagetype.SVG:
+		err = C.vips_svgload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), C.double(scale), &tmp)
+	case imagetype.HEIC, imagetype.AVIF:
+		err = C.vips_heifload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), 0, &tmp)
+	case imagetype.BMP:
+		err = C.vips_bmpload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
+	case imagetype.TIFF:
+		err = C.vips_tiffload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), &tmp)
+	}
+	if err != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// LoadHeifThumbnail replaces the image with the thumbnail embedded into the HEIF data
+// if the thumbnail is not smaller than the provided size
+func (img *Image) LoadHeifThumbnail(data []byte, minWidth, minHeight int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_heifload_go(unsafe.Pointer(&data[0]), C.size_t(len(data)), 1, &tmp) != 0 {
+		// The image may have no thumbnail, that's fine
+		C.vips_error_clear()
+		return nil
+	}
+
+	if int(tmp.Xsize) < minWidth || int(tmp.Ysize) < minHeight {
+		C.clear_image(&tmp)
+		return nil
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// Save encodes the image to the writer. lossless is applicable to WebP only
+func (img *Image) Save(w io.Writer, imgtype imagetype.Type, quality int, stripMeta, keepProfile, lossless bool) (context.CancelFunc, error) {
+	if imgtype == imagetype.ICO {
+		return func() {}, img.SaveAsIco(w)
+	}
+
+	cancel := func() {
+		// don't think we actually need this
+	}
+
+	wp := pointer.Save(w)
+	defer pointer.Unref(wp)
+
+	target := C.imgproxy_new_writer_target(wp)
+	defer C.g_object_unref(C.gpointer(target))
+	err := C.int(0)
+
+	switch imgtype {
+	case imagetype.JPEG:
+		err = C.vips_jpegsave_go(img.VipsImage, target, C.int(quality), vipsConf.JpegProgressive, gbool(stripMeta), gbool(keepProfile))
+	case imagetype.PNG:
+		err = C.vips_pngsave_go(img.VipsImage, target, vipsConf.PngInterlaced, vipsConf.PngQuantize, vipsConf.PngQuantizationColors, gbool(keepProfile))
+	case imagetype.WEBP:
+		err = C.vips_webpsave_go(img.VipsImage, target, C.int(quality), gbool(stripMeta), gbool(lossless))
+	case imagetype.GIF:
+		err = C.vips_gifsave_go(img.VipsImage, target)
+	case imagetype.AVIF:
+		err = C.vips_avifsave_go(img.VipsImage, target, C.int(quality))
+	case imagetype.BMP:
+		err = C.vips_bmpsave_go(img.VipsImage, target)
+	case imagetype.TIFF:
+		err = C.vips_tiffsave_go(img.VipsImage, target, C.int(quality))
+	}
+	if err != 0 {
+		return cancel, vipsError()
+	}
+
+	return cancel, nil
+}
+
+func (img *Image) SaveAsIco(w io.Writer) error {
+	if img.Width() > 256 || img.Height() > 256 {
+		return errors.New("Image dimensions is too big. Max dimension size for ICO is 256")
+	}
+
+	var imgData bytes.Buffer
+	wp := pointer.Save(imgData)
+	defer pointer.Unref(wp)
+
+	target := C.imgproxy_new_writer_target(wp)
+	defer C.g_object_unref(C.gpointer(target))
+
+	if C.vips_pngsave_go(img.VipsImage, target, 0, 0, 256, C.FALSE) != 0 {
+		return vipsError()
+	}
+
+	// ICONDIR header
+	if _, err := w.Write([]byte{0, 0, 1, 0, 1, 0}); err != nil {
+		return err
+	}
+
+	// ICONDIRENTRY
+	if _, err := w.Write([]byte{
+		byte(img.Width() % 256),
+		byte(img.Height() % 256),
+	}); err != nil {
+		return err
+	}
+	// Number of colors. Not supported in our case
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	// Reserved
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	// Color planes. Always 1 in our case
+	if _, err := w.Write([]byte{1, 0}); err != nil {
+		return err
+	}
+	// Bits per pixel
+	if img.HasAlpha() {
+		if _, err := w.Write([]byte{32, 0}); err != nil {
+			return err
+		}
+	} else {
+		if _, err := w.Write([]byte{24, 0}); err != nil {
+			return err
+		}
+	}
+	// Image data size
+	if err := binary.Write(w, binary.LittleEndian, uint32(imgData.Len())); err != nil {
+		return err
+	}
+	// Image data offset. Always 22 in our case
+	if _, err := w.Write([]byte{22, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(imgData.Bytes()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (img *Image) Clear() {
+	if img.VipsImage != nil {
+		C.clear_image(&img.VipsImage)
+	}
+}
+
+func (img *Image) Arrayjoin(in []*Image) error {
+	var tmp *C.VipsImage
+
+	arr := make([]*C.VipsImage, len(in))
+	for i, im := range in {
+		arr[i] = im.VipsImage
+	}
+
+	if C.vips_arrayjoin_go(&arr[0], &tmp, C.int(len(arr))) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Solid replaces the image with the image of the provided size
+// filled with the color
+func (img *Image) Solid(width, height int, color Color) error {
+	var tmp *C.VipsImage
+
+	if C.vips_solid_go(&tmp, C.int(width), C.int(height), C.double(color.R), C.double(color.G), C.double(color.B)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Checkerboard replaces the image with the checkerboard of the provided size
+func (img *Image) Checkerboard(width, height, cell int, color1, color2 Color) error {
+	var tmp *C.VipsImage
+
+	if C.vips_checkerboard_go(
+		&tmp, C.int(width), C.int(height), C.int(cell),
+		C.double(color1.R), C.double(color1.G), C.double(color1.B),
+		C.double(color2.R), C.double(color2.G), C.double(color2.B),
+	) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// SoftFill replaces the image with the blurry fill of the color shades
+func (img *Image) SoftFill(width, height int, color Color) error {
+	var tmp *C.VipsImage
+
+	if C.vips_soft_fill_go(&tmp, C.int(width), C.int(height), C.double(color.R), C.double(color.G), C.double(color.B)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// CaptionAlign is the text alignment of captions. Values match VipsAlign
+type CaptionAlign int
+
+const (
+	CaptionAlignLow CaptionAlign = iota
+	CaptionAlignCentre
+	CaptionAlignHigh
+)
+
+// Caption replaces the image with the rendered text. The text is wrapped
+// to fit the width if it's greater than zero. If shadow is greater than zero,
+// the text gets a drop shadow with the provided offset
+func (img *Image) Caption(text, font string, width int, align CaptionAlign, padding int, color Color, shadow int) error {
+	var tmp *C.VipsImage
+
+	ctext := C.CString(text)
+	defer C.free(unsafe.Pointer(ctext))
+
+	cfont := C.CString(font)
+	defer C.free(unsafe.Pointer(cfont))
+
+	if C.vips_caption_go(
+		&tmp, ctext, cfont, C.int(width), C.VipsAlign(align), C.int(padding),
+		C.double(color.R), C.double(color.G), C.double(color.B), C.int(shadow),
+	) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Collage joins the images into a grid with the provided number of columns.
+// Images are centered in their cells, gaps are filled with the background color
+func (img *Image) Collage(in []*Image, columns, spacing int, bg Color) error {
+	var tmp *C.VipsImage
+
+	arr := make([]*C.VipsImage, len(in))
+	for i, im := range in {
+		arr[i] = im.VipsImage
+	}
+
+	if C.vips_collage_go(
+		&arr[0], &tmp, C.int(len(arr)), C.int(columns), C.int(spacing),
+		C.double(bg.R), C.double(bg.G), C.double(bg.B),
+	) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// SupportsAnimation checks if libvips can load and save animated images
+// of the type
+func SupportsAnimation(imgtype imagetype.Type) bool {
+	return imgtype == imagetype.GIF ||
+		(imgtype == imagetype.WEBP && C.vips_support_webp_animation() != 0)
+}
+
+func (img *Image) IsAnimated() bool {
+	return C.vips_is_animated(img.VipsImage) > 0
+}
+
+func (img *Image) HasAlpha() bool {
+	return C.vips_image_hasalpha_go(img.VipsImage) > 0
+}
+
+func (img *Image) GetInt(name string) (int, error) {
+	var i C.int
+
+	if C.vips_image_get_int(img.VipsImage, cachedCString(name), &i) != 0 {
+		return 0, vipsError()
+	}
+	return int(i), nil
+}
+
+// FrameDelays returns delays of the animation frames in milliseconds.
+// Requires libvips 8.9+
+func (img *Image) FrameDelays() ([]int, error) {
+	var (
+		ptr *C.int
+		n   C.int
+	)
+
+	if C.vips_get_frame_delays(img.VipsImage, &ptr, &n) != 0 {
+		return nil, vipsError()
+	}
+
+	// The array is owned by the image, so we copy it
+	cdelays := (*[1 << 20]C.int)(unsafe.Pointer(ptr))[:int(n):int(n)]
+
+	delays := make([]int, len(cdelays))
+	for i, d := range cdelays {
+		delays[i] = int(d)
+	}
+
+	return delays, nil
+}
+
+// SetFrameDelays sets delays of the animation frames in milliseconds.
+// Does nothing if libvips is older than 8.9
+func (img *Image) SetFrameDelays(delays []int) {
+	cdelays := make([]C.int, len(delays))
+	for i, d := range delays {
+		cdelays[i] = C.int(d)
+	}
+
+	C.vips_set_frame_delays(img.VipsImage, &cdelays[0], C.int(len(cdelays)))
+}
+
+func (img *Image) SetInt(name string, value int) {
+	C.vips_image_set_int(img.VipsImage, cachedCString(name), C.int(value))
+}
+
+// trimExifValue removes the raw value description that libvips appends
+// to EXIF values, e.g. "Canon (Canon, ASCII, 6 components, 6 bytes)"
+func trimExifValue(val string) string {
+	if !strings.HasSuffix(val, " bytes)") {
+		return val
+	}
+
+	if ind := strings.LastIndex(val, " ("); ind >= 0 {
+		return val[:ind]
+	}
+
+	return val
+}
+
+// Exif returns the EXIF fields of the image by their tag names. Fields of the
+// thumbnail and interoperability IFDs are skipped, GPS fields are returned
+// only when gps is true
+func (img *Image) Exif(gps bool) map[string]string {
+	fields := C.vips_image_get_fields(img.VipsImage)
+	defer C.g_strfreev(fields)
+
+	n := int(C.g_strv_length(fields))
+	names := (*[1 << 20]*C.char)(unsafe.Pointer(fields))[:n:n]
+
+	exif := make(map[string]string)
+
+	for _, cname := range names {
+		name := C.GoString(cname)
+
+		if !strings.HasPrefix(name, "exif-ifd0-") &&
+			!strings.HasPrefix(name, "exif-ifd2-") &&
+			!(gps && strings.HasPrefix(name, "exif-ifd3-")) {
+			continue
+		}
+
+		var val *C.char
+		if C.vips_image_get_string(img.VipsImage, cname, &val) != 0 {
+			C.vips_error_clear()
+			continue
+		}
+
+		exif[name[len("exif-ifd0-"):]] = trimExifValue(C.GoString(val))
+	}
+
+	return exif
+}
+
+func (img *Image) CastUchar() error {
+	var tmp *C.VipsImage
+
+	if C.vips_image_get_format(img.VipsImage) != C.VIPS_FORMAT_UCHAR {
+		if C.vips_cast_go(img.VipsImage, &tmp, C.VIPS_FORMAT_UCHAR) != 0 {
+			return vipsError()
+		}
+		C.swap_and_clear(&img.VipsImage, tmp)
+	}
+
+	return nil
+}
+
+func (img *Image) CastUshort() error {
+	var tmp *C.VipsImage
+
+	if C.vips_image_get_format(img.VipsImage) != C.VIPS_FORMAT_USHORT {
+		if C.vips_cast_go(img.VipsImage, &tmp, C.VIPS_FORMAT_USHORT) != 0 {
+			return vipsError()
+		}
+		C.swap_and_clear(&img.VipsImage, tmp)
+	}
+
+	return nil
+}
+
+func (img *Image) Rad2Float() error {
+	var tmp *C.VipsImage
+
+	if C.vips_image_get_coding(img.VipsImage) == C.VIPS_CODING_RAD {
+		if C.vips_rad2float_go(img.VipsImage, &tmp) != 0 {
+			return vipsError()
+		}
+		C.swap_and_clear(&img.VipsImage, tmp)
+	}
+
+	return nil
+}
+
+func (img *Image) Resize(scale float64, hasAlpa bool) error {
+	var tmp *C.VipsImage
+
+	if hasAlpa {
+		if C.vips_resize_with_premultiply(img.VipsImage, &tmp, C.double(scale)) != 0 {
+			return vipsError()
+		}
+	} else {
+		if C.vips_resize_go(img.VipsImage, &tmp, C.double(scale)) != 0 {
+			return vipsError()
+		}
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Orientation() int {
+	return int(C.vips_get_orientation(img.VipsImage))
+}
+
+func (img *Image) Rotate(angle int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_rot_go(img.VipsImage, &tmp, C.VipsAngle(angle)) != 0 {
+		return vipsError()
+	}
+
+	C.vips_autorot_remove_angle(tmp)
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Flip() error {
+	var tmp *C.VipsImage
+
+	if C.vips_flip_horizontal_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Crop(left, top, width, height int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_extract_area_go(img.VipsImage, &tmp, C.int(left), C.int(top), C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Extract(out *Image, left, top, width, height int) error {
+	if C.vips_extract_area_go(img.VipsImage, &out.VipsImage, C.int(left), C.int(top), C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+	return nil
+}
+
+func (img *Image) SmartCrop(width, height int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_smartcrop_go(img.VipsImage, &tmp, C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Trim(threshold float64, smart bool, color Color, equalHor bool, equalVer bool) error {
+	var tmp *C.VipsImage
+
+	if err := img.CopyMemory(); err != nil {
+		return err
+	}
+
+	if C.vips_trim(img.VipsImage, &tmp, C.double(threshold),
+		gbool(smart), C.double(color.R), C.double(color.G), C.double(color.B),
+		gbool(equalHor), gbool(equalVer)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) EnsureAlpha() error {
+	var tmp *C.VipsImage
+
+	if C.vips_ensure_alpha(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// AverageColor returns the average color of the image. Alpha channel is ignored
+func (img *Image) AverageColor() (Color, error) {
+	var r, g, b C.double
+
+	if C.vips_average_color_go(img.VipsImage, &r, &g, &b) != 0 {
+		return Color{}, vipsError()
+	}
+
+	scale := 1.0
+	if img.Is16Bit() {
+		scale = 257.0
+	}
+
+	return Color{clampUint8(float64(r) / scale), clampUint8(float64(g) / scale), clampUint8(float64(b) / scale)}, nil
+}
+
+// Remap builds the image of the given size taking each pixel from the source
+// coordinates in the index. The index contains x and y pairs
+func (img *Image) Remap(index []float32, width, height int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_remap_go(img.VipsImage, &tmp, (*C.float)(unsafe.Pointer(&index[0])), C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// RGBSample returns the RGB pixels of the image subsampled with the nearest
+// neighbour method so the biggest side is not greater than maxSize.
+// Unlike resizing, subsampling keeps the exact pixel values
+func (img *Image) RGBSample(maxSize int) ([]byte, int, int, error) {
+	var tmp *C.VipsImage
+
+	factor := maxInt((maxInt(img.Width(), img.Height())+maxSize-1)/maxSize, 1)
+
+	if C.vips_rgb_sample_go(img.VipsImage, &tmp, C.int(factor)) != 0 {
+		return nil, 0, 0, vipsError()
+	}
+	defer C.clear_image(&tmp)
+
+	var size C.size_t
+
+	ptr := C.vips_image_write_to_memory(tmp, &size)
+	if ptr == nil {
+		return nil, 0, 0, vipsError()
+	}
+	defer C.g_free_go(&ptr)
+
+	return C.GoBytes(ptr, C.int(size)), int(tmp.Xsize), int(tmp.Ysize), nil
+}
+
+// GrayscalePixels returns the pixels of the image converted to grayscale
+// and resized to the exact width and height ignoring the aspect ratio
+func (img *Image) GrayscalePixels(width, height int) ([]byte, error) {
+	var tmp *C.VipsImage
+
+	if C.vips_grayscale_thumbnail_go(img.VipsImage, &tmp, C.int(width), C.int(height)) != 0 {
+		return nil, vipsError()
+	}
+	defer C.clear_image(&tmp)
+
+	if int(tmp.Xsize) != width || int(tmp.Ysize) != height {
+		return nil, fmt.Errorf("Can't resize image to %dx%d", width, height)
+	}
+
+	var size C.size_t
+
+	ptr := C.vips_image_write_to_memory(tmp, &size)
+	if ptr == nil {
+		return nil, vipsError()
+	}
+	defer C.g_free_go(&ptr)
+
+	return C.GoBytes(ptr, C.int(size)), nil
+}
+
+func (img *Image) Flatten(bg Color) error {
+	var tmp *C.VipsImage
+
+	scale, maxAlpha := 1.0, 255.0
+	if img.Is16Bit() {
+		scale, maxAlpha = 257.0, 65535.0
+	}
+
+	if C.vips_flatten_go(
+		img.VipsImage, &tmp,
+		C.double(float64(bg.R)*scale), C.double(float64(bg.G)*scale), C.double(float64(bg.B)*scale),
+		C.double(maxAlpha),
+	) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Blur(sigma float32) error {
+	var tmp *C.VipsImage
+
+	if C.vips_gaussblur_go(img.VipsImage, &tmp, C.double(sigma)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Sharpen(sigma float32) error {
+	var tmp *C.VipsImage
+
+	if C.vips_sharpen_go(img.VipsImage, &tmp, C.double(sigma)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+// Enhance corrects the white balance, stretches the brightness histogram,
+// and adjusts gamma so the average brightness becomes mid-gray
+func (img *Image) Enhance() error {
+	var tmp *C.VipsImage
+
+	maxValue, cast := 255.0, img.CastUchar
+	if img.Is16Bit() {
+		maxValue, cast = 65535.0, img.CastUshort
+	}
+
+	if err := cast(); err != nil {
+		return err
+	}
+
+	if C.vips_white_balance_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	var (
+		mean   C.double
+		lo, hi C.int
+	)
+
+	if C.vips_brightness_stats_go(img.VipsImage, &mean, &lo, &hi) != 0 {
+		return vipsError()
+	}
+
+	low, high := float64(lo), float64(hi)
+
+	// Stretching of nearly flat images would only amplify the noise
+	if high-low < maxValue/10 {
+		low, high = 0, maxValue
+	}
+
+	gamma := 1.0
+	if m := (float64(mean) - low) / (high - low); m > 0.01 && m < 0.99 {
+		gamma = math.Max(0.5, math.Min(2, math.Log(0.5)/math.Log(m)))
+	}
+
+	if C.vips_levels_go(img.VipsImage, &tmp, C.double(low), C.double(high), C.double(gamma), C.double(maxValue)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Equalize() error {
+	var tmp *C.VipsImage
+
+	// Histograms can be calculated only for 8-bit and 16-bit images
+	cast := img.CastUchar
+	if img.Is16Bit() {
+		cast = img.CastUshort
+	}
+
+	if err := cast(); err != nil {
+		return err
+	}
+
+	if C.vips_equalize_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Posterize(levels int) error {
+	var tmp *C.VipsImage
+
+	// Lookup tables can be applied only to 8-bit and 16-bit images
+	cast := img.CastUchar
+	if img.Is16Bit() {
+		cast = img.CastUshort
+	}
+
+	if err := cast(); err != nil {
+		return err
+	}
+
+	if C.vips_posterize_go(img.VipsImage, &tmp, C.int(levels)) != 0 {
+		return vipsError()
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) ImportColourProfile(evenSRGB bool) error {
+	var tmp *C.VipsImage
+
+	if img.VipsImage.Coding != C.VIPS_CODING_NONE {
+		return nil
+	}
+
+	if img.VipsImage.BandFmt != C.VIPS_FORMAT_UCHAR && img.VipsImage.BandFmt != C.VIPS_FORMAT_USHORT {
+		return nil
+	}
+
+	profile := (*C.char)(nil)
+
+	if C.vips_has_embedded_icc(img.VipsImage) == 0 {
+		// No embedded profile
+		// If vips doesn't have built-in profile, use profile built-in to imgproxy for CMYK
+		// TODO: Remove this. Supporting built-in profiles is pain, vips does it better
+		if img.VipsImage.Type == C.VIPS_INTERPRETATION_CMYK && C.vips_support_builtin_icc() == 0 {
+			p, err := cmykProfilePath()
+			if err != nil {
+				return err
+			}
+			profile = cachedCString(p)
+		} else {
+			// imgproxy doesn't have built-in profile for other interpretations,
+			// so we can't do anything here
+			return nil
+		}
+	}
+
+	// Don't import sRGB IEC61966 2.1 unless evenSRGB
+	if img.VipsImage.Type == C.VIPS_INTERPRETATION_sRGB && !evenSRGB && C.vips_icc_is_srgb_iec61966(img.VipsImage) != 0 {
+		return nil
+	}
+
+	if C.vips_icc_import_go(img.VipsImage, &tmp, profile) == 0 {
+		C.swap_and_clear(&img.VipsImage, tmp)
+	} else {
+		logrus.Warningf("Can't import ICC profile: %s", vipsError())
+	}
+
+	return nil
+}
+
+func (img *Image) HasEmbeddedProfile() bool {
+	return C.vips_has_embedded_icc(img.VipsImage) != 0
+}
+
+// EmbedSRGBProfile attaches the built-in sRGB profile to the image.
+// Does nothing if libvips is older than 8.8
+func (img *Image) EmbedSRGBProfile(depth int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_icc_embed_srgb_go(img.VipsImage, &tmp, C.int(depth)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// StripMetaKeepProfile removes EXIF, XMP, IPTC, and Photoshop metadata
+// keeping the ICC profile. Savers strip the ICC profile along with other
+// metadata, so this is used instead of their strip option
+func (img *Image) StripMetaKeepProfile() error {
+	var tmp *C.VipsImage
+
+	if C.vips_copy_go(img.VipsImage, &tmp) != 0 {
+		return vipsError()
+	}
+
+	fields := C.vips_image_get_fields(tmp)
+	defer C.g_strfreev(fields)
+
+	n := int(C.g_strv_length(fields))
+	names := (*[1 << 20]*C.char)(unsafe.Pointer(fields))[:n:n]
+
+	for _, cname := range names {
+		name := C.GoString(cname)
+
+		if strings.HasPrefix(name, "exif-") ||
+			name == "xmp-data" ||
+			name == "iptc-data" ||
+			name == "photoshop-data" {
+			C.vips_image_remove(tmp, cname)
+		}
+	}
+
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+// ExportColourProfile converts the image to the colorspace of the ICC profile
+// and embeds the profile. The profile is either a path to the ICC file
+// or a name of the libvips built-in profile
+func (img *Image) ExportColourProfile(profile string, depth int) error {
+	// ICC export requires the image to be in PCS
+	if img.VipsImage.Type != C.VIPS_INTERPRETATION_LAB {
+		if err := img.Colorspace(C.VIPS_INTERPRETATION_XYZ); err != nil {
+			return err
+		}
+	}
+
+	var tmp *C.VipsImage
+
+	if C.vips_icc_export_go(img.VipsImage, &tmp, cachedCString(profile), C.int(depth)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) IsSRGB() bool {
+	return img.VipsImage.Type == C.VIPS_INTERPRETATION_sRGB
+}
+
+// Is16Bit checks if the image is a 16-bit RGB or grayscale one
+func (img *Image) Is16Bit() bool {
+	return img.VipsImage.Type == C.VIPS_INTERPRETATION_RGB16 ||
+		img.VipsImage.Type == C.VIPS_INTERPRETATION_GREY16
+}
+
+func (img *Image) LinearColourspace() error {
+	return img.Colorspace(C.VIPS_INTERPRETATION_scRGB)
+}
+
+// RgbColourspace converts the image to sRGB. 16-bit RGB images are kept as is
+// since they're converted to 16-bit RGB only when the depth should be kept
+func (img *Image) RgbColourspace() error {
+	if img.VipsImage.Type == C.VIPS_INTERPRETATION_RGB16 {
+		return nil
+	}
+
+	return img.SRGBColourspace()
+}
+
+// SRGBColourspace converts the image to 8-bit sRGB
+func (img *Image) SRGBColourspace() error {
+	return img.Colorspace(C.VIPS_INTERPRETATION_sRGB)
+}
+
+func (img *Image) Rgb16Colourspace() error {
+	return img.Colorspace(C.VIPS_INTERPRETATION_RGB16)
+}
+
+func (img *Image) Colorspace(colorspace C.VipsInterpretation) error {
+	if img.VipsImage.Type != colorspace {
+		var tmp *C.VipsImage
+
+		if C.vips_colourspace_go(img.VipsImage, &tmp, colorspace) != 0 {
+			return vipsError()
+		}
+		C.swap_and_clear(&img.VipsImage, tmp)
+	}
+
+	return nil
+}
+
+func (img *Image) CopyMemory() error {
+	var tmp *C.VipsImage
+	if tmp = C.vips_image_copy_memory(img.VipsImage); tmp == nil {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+	return nil
+}
+
+func (img *Image) Replicate(width, height int) error {
+	var tmp *C.VipsImage
+
+	if C.vips_replicate_go(img.VipsImage, &tmp, C.int(width), C.int(height)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) Embed(width, height int, offX, offY int, bg Color, transpBg bool) error {
+	var tmp *C.VipsImage
+
+	if err := img.RgbColourspace(); err != nil {
+		return err
+	}
+
+	var bgc []C.double
+	if transpBg {
+		if !img.HasAlpha() {
+			if C.vips_addalpha_go(img.VipsImage, &tmp) != 0 {
+				return vipsError()
+			}
+			C.swap_and_clear(&img.VipsImage, tmp)
+		}
+
+		bgc = []C.double{C.double(0)}
+	} else {
+		scale := 1.0
+		if img.Is16Bit() {
+			scale = 257.0
+		}
+
+		bgc = []C.double{C.double(float64(bg.R) * scale), C.double(float64(bg.G) * scale), C.double(float64(bg.B) * scale), 1.0}
+	}
+
+	bgn := minInt(int(img.VipsImage.Bands), len(bgc))
+
+	if C.vips_embed_go(img.VipsImage, &tmp, C.int(offX), C.int(offY), C.int(width), C.int(height), &bgc[0], C.int(bgn)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+func (img *Image) ApplyWatermark(wm *Image, opacity float64) error {
+	var tmp *C.VipsImage
+
+	if C.vips_apply_watermark(img.VipsImage, wm.VipsImage, &tmp, C.double(opacity)) != 0 {
+		return vipsError()
+	}
+	C.swap_and_clear(&img.VipsImage, tmp)
+
+	return nil
+}
+
+//export imgproxy_write
+func imgproxy_write(target *C.VipsTargetCustom, buffer unsafe.Pointer, length C.long, user unsafe.Pointer) C.long {
+	v := pointer.Restore(user).(io.Writer)
+	d := C.GoBytes(buffer, C.int(length))
+	n, err := v.Write(d)
+	if err != nil {
+		return -1
+	}
+	return C.long(n)
+}
+
+//export imgproxy_finish
+func imgproxy_finish(target *C.VipsTargetCustom, user unsafe.Pointer) {
+	u := (interface{})(user)
+	if flusher, ok := u.(interface{ Flush() }); ok {
+		flusher.Flush()
+	}
+	if closer, ok := u.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -34,11 +34,13 @@ int vips_pngload_go(void *buf, size_t len, VipsImage **out);
 int vips_webpload_go(void *buf, size_t len, double scale, int pages, VipsImage **out);
 int vips_gifload_go(void *buf, size_t len, int pages, VipsImage **out);
 int vips_svgload_go(void *buf, size_t len, double scale, VipsImage **out);
-int vips_heifload_go(void *buf, size_t len, VipsImage **out);
+int vips_heifload_go(void *buf, size_t len, int thumbnail, VipsImage **out);
 int vips_bmpload_go(void *buf, size_t len, VipsImage **out);
 int vips_tiffload_go(void *buf, size_t len, VipsImage **out);
 
 int vips_get_orientation(VipsImage *image);
+int vips_get_frame_delays(VipsImage *image, int **out, int *n);
+void vips_set_frame_delays(VipsImage *image, int *delays, int n);
 void vips_strip_meta(VipsImage *image);
 
 int vips_support_smartcrop();
@@ -63,6 +65,8 @@ int vips_icc_is_srgb_iec61966(VipsImage *in);
 int vips_has_embedded_icc(VipsImage *in);
 int vips_support_builtin_icc();
 int vips_icc_import_go(VipsImage *in, VipsImage **out, char *profile);
+int vips_icc_embed_srgb_go(VipsImage *in, VipsImage **out, int depth);
+int vips_icc_export_go(VipsImage *in, VipsImage **out, char *profile, int depth);
 int vips_colourspace_go(VipsImage *in, VipsImage **out, VipsInterpretation cs);
 
 int vips_rot_go(VipsImage *in, VipsImage **out, VipsAngle angle);
@@ -76,23 +80,38 @@ int vips_trim(VipsImage *in, VipsImage **out, double threshold,
 
 int vips_gaussblur_go(VipsImage *in, VipsImage **out, double sigma);
 int vips_sharpen_go(VipsImage *in, VipsImage **out, double sigma);
+int vips_white_balance_go(VipsImage *in, VipsImage **out);
+int vips_brightness_stats_go(VipsImage *in, double *mean, int *lo, int *hi);
+int vips_levels_go(VipsImage *in, VipsImage **out, double lo, double hi, double gamma, double max);
+int vips_equalize_go(VipsImage *in, VipsImage **out);
+int vips_posterize_go(VipsImage *in, VipsImage **out, int levels);
 
-int vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b);
+int vips_flatten_go(VipsImage *in, VipsImage **out, double r, double g, double b, double max_alpha);
 
 int vips_replicate_go(VipsImage *in, VipsImage **out, int across, int down);
 int vips_embed_go(VipsImage *in, VipsImage **out, int x, int y, int width, int height, double *bg, int bgn);
 
 int vips_ensure_alpha(VipsImage *in, VipsImage **out);
 
+int vips_average_color_go(VipsImage *in, double *r, double *g, double *b);
+int vips_grayscale_thumbnail_go(VipsImage *in, VipsImage **out, int width, int height);
+
+int vips_remap_go(VipsImage *in, VipsImage **out, float *index, int width, int height);
+int vips_rgb_sample_go(VipsImage *in, VipsImage **out, int factor);
 int vips_apply_watermark(VipsImage *in, VipsImage *watermark, VipsImage **out, double opacity);
 
+int vips_caption_go(VipsImage **out, const char *text, const char *font, int width, VipsAlign align, int padding, double r, double g, double b, int shadow);
+int vips_solid_go(VipsImage **out, int width, int height, double r, double g, double b);
+int vips_checkerboard_go(VipsImage **out, int width, int height, int cell, double r1, double g1, double b1, double r2, double g2, double b2);
+int vips_soft_fill_go(VipsImage **out, int width, int height, double r, double g, double b);
 int vips_arrayjoin_go(VipsImage **in, VipsImage **out, int n);
+int vips_collage_go(VipsImage **in, VipsImage **out, int n, int across, int shim, double r, double g, double b);
 
 VipsTarget* imgproxy_new_writer_target(void* user);
 
-int vips_jpegsave_go(VipsImage *in, VipsTarget *target, int quality, int interlace, gboolean strip);
-int vips_pngsave_go(VipsImage *in, VipsTarget *target, int interlace, int quantize, int colors);
-int vips_webpsave_go(VipsImage *in, VipsTarget *target, int quality, gboolean strip);
+int vips_jpegsave_go(VipsImage *in, VipsTarget *target, int quality, int interlace, gboolean strip, gboolean keep_profile);
+int vips_pngsave_go(VipsImage *in, VipsTarget *target, int interlace, int quantize, int colors, gboolean keep_profile);
+int vips_webpsave_go(VipsImage *in, VipsTarget *target, int quality, gboolean strip, gboolean lossless);
 int vips_gifsave_go(VipsImage *in, VipsTarget *target);
 int vips_avifsave_go(VipsImage *in, VipsTarget *target, int quality);
 int vips_bmpsave_go(VipsImage *in, VipsTarget *target);
@@ -1,4 +1,4 @@
-package main
+package vips
 
 /*
 #cgo pkg-config: vips
@@ -0,0 +1,56 @@
+package vips
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/imgproxy/imgproxy/v2/imagetype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type VipsTestSuite struct{ suite.Suite }
+
+func TestMain(m *testing.M) {
+	if err := Init(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	Shutdown()
+	os.Exit(code)
+}
+
+func (s *VipsTestSuite) TestSupportsLoadAndSave() {
+	assert.True(s.T(), SupportsLoad(imagetype.JPEG))
+	assert.True(s.T(), SupportsSave(imagetype.JPEG))
+
+	assert.False(s.T(), SupportsLoad(imagetype.Unknown))
+	assert.False(s.T(), SupportsSave(imagetype.Unknown))
+}
+
+func (s *VipsTestSuite) TestSupportsAnimation() {
+	assert.True(s.T(), SupportsAnimation(imagetype.GIF))
+	assert.False(s.T(), SupportsAnimation(imagetype.JPEG))
+	assert.False(s.T(), SupportsAnimation(imagetype.PNG))
+}
+
+func (s *VipsTestSuite) TestCmykProfilePath() {
+	path, err := cmykProfilePath()
+	require.Nil(s.T(), err)
+
+	data, err := ioutil.ReadFile(path)
+	require.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), data)
+
+	again, err := cmykProfilePath()
+	require.Nil(s.T(), err)
+	assert.Equal(s.T(), path, again)
+}
+
+func TestVips(t *testing.T) {
+	suite.Run(t, new(VipsTestSuite))
+}
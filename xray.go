@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/imgproxy/imgproxy/v2/config"
+)
+
+var xrayEnabled = false
+
+func initXRay() error {
+	if !conf.XRayEnable {
+		return nil
+	}
+
+	err := xray.Configure(xray.Config{
+		DaemonAddr:     conf.XRayDaemonAddress,
+		ServiceVersion: config.Version,
+	})
+	if err != nil {
+		return fmt.Errorf("Can't configure X-Ray: %s", err)
+	}
+
+	xrayEnabled = true
+
+	return nil
+}
+
+func withXRay(h http.Handler) http.Handler {
+	if !xrayEnabled {
+		return h
+	}
+
+	return xray.Handler(xray.NewFixedSegmentNamer(conf.XRayName), h)
+}
+
+func startXRaySubsegment(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	// Sources can be downloaded outside of requests
+	if xray.GetSegment(ctx) == nil {
+		return ctx, func() {}
+	}
+
+	ctx, seg := xray.BeginSubsegment(ctx, name)
+	return ctx, func() { seg.Close(nil) }
+}
+
+func sendErrorToXRay(ctx context.Context, err error) {
+	if seg := xray.GetSegment(ctx); seg != nil {
+		seg.AddError(err)
+	}
+}